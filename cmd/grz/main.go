@@ -10,6 +10,9 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/drunlade/go-lrzsz/internal/protocol"
+	"github.com/drunlade/go-lrzsz/internal/tcp"
+	"github.com/drunlade/go-lrzsz/internal/tty"
 	"github.com/drunlade/go-lrzsz/zmodem"
 )
 
@@ -22,6 +25,18 @@ var (
 	protect   = flag.Bool("p", false, "protect existing files")
 	escape    = flag.Bool("e", false, "escape control characters")
 	timeout   = flag.Int("t", 100, "timeout in tenths of seconds")
+	charset   = flag.String("charset", "auto", "file name charset: utf-8, latin1, cp437 or auto")
+	fallback  = flag.String("fallback", "latin1", "file name charset when -charset auto finds a name isn't UTF-8")
+	crc       = flag.Bool("c", false, "ask for CRC-16 rather than the checksum (XMODEM)")
+	streaming = flag.Bool("g", false, "ask for streaming without ACKs (XMODEM-g/YMODEM-g)")
+	tcpServer = flag.Bool("tcp-server", false, "listen for the sender on a TCP port")
+	tcpClient = flag.String("tcp-client", "", "connect to the sender at host:port")
+
+	useXModem     = flag.Bool("X", false, "receive with XMODEM")
+	useXModemLong = flag.Bool("xmodem", false, "receive with XMODEM")
+	useYModem     = flag.Bool("Y", false, "receive with YMODEM")
+	useYModemLong = flag.Bool("ymodem", false, "receive with YMODEM")
+
 	help      = flag.Bool("h", false, "show help")
 	version   = flag.Bool("version", false, "show version")
 )
@@ -29,6 +44,12 @@ var (
 const versionString = "grz version 0.1.0"
 
 func main() {
+	os.Exit(run())
+}
+
+// run does the work of main and returns the exit status, so deferred
+// cleanup like restoring the terminal happens before exiting.
+func run() int {
 	flag.Parse()
 
 	if *help {
@@ -37,13 +58,36 @@ func main() {
 
 	if *version {
 		fmt.Println(versionString)
-		os.Exit(0)
+		return 0
+	}
+
+	filenameCharset, err := zmodem.ParseCharset(*charset)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		return 1
+	}
+	fallbackCharset, err := zmodem.ParseCharset(*fallback)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		return 1
+	}
+
+	// Put the terminal in raw mode when the transfer runs over it, and
+	// put it back however we exit
+	var terminal *tty.State
+	if !*tcpServer && *tcpClient == "" {
+		terminal, err = tty.Raw(os.Stdin, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+			return 1
+		}
+		defer terminal.Restore()
 	}
 
 	// Set up signal handling
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	ctx, cancel := signalContext(sigChan)
+	ctx, cancel := signalContext(sigChan, terminal)
 	defer cancel()
 
 	// Create receiver configuration
@@ -59,7 +103,7 @@ func main() {
 
 	// Create callbacks
 	callbacks := &zmodem.Callbacks{
-		OnFilePrompt: func(filename string, size int64, mode os.FileMode) (bool, error) {
+		OnFilePrompt: func(hdr *zmodem.FileHeader) (bool, error) {
 			if *quiet {
 				return true, nil
 			}
@@ -68,15 +112,15 @@ func main() {
 			}
 			if *protect {
 				// Check if file exists
-				if _, err := os.Stat(filename); err == nil {
+				if _, err := os.Stat(hdr.Name); err == nil {
 					if *verbose {
-						fmt.Fprintf(os.Stderr, "Skipping %s (protected)\n", filename)
+						fmt.Fprintf(os.Stderr, "Skipping %s (protected)\n", hdr.Name)
 					}
 					return false, nil
 				}
 			}
 			if *verbose {
-				fmt.Fprintf(os.Stderr, "Receiving: %s (%d bytes)\n", filename, size)
+				fmt.Fprintf(os.Stderr, "Receiving: %s (%d bytes)\n", hdr.Name, hdr.Size)
 			}
 			return true, nil
 		},
@@ -92,9 +136,9 @@ func main() {
 				fmt.Fprintf(os.Stderr, "\r%s: %.1f%% (%.0f bytes/s)", filename, percent, rate)
 			}
 		},
-		OnFileStart: func(filename string, size int64, mode os.FileMode) {
+		OnFileStart: func(hdr *zmodem.FileHeader) {
 			if *verbose && !*quiet {
-				fmt.Fprintf(os.Stderr, "Starting: %s\n", filename)
+				fmt.Fprintf(os.Stderr, "Starting: %s\n", hdr.Name)
 			}
 		},
 		OnFileComplete: func(filename string, bytesTransferred int64, duration time.Duration) {
@@ -111,26 +155,25 @@ func main() {
 			fmt.Fprintf(os.Stderr, "Error in %s: %v\n", context, err)
 			return false
 		},
-		OnFileCreate: func(filename string, size int64, mode os.FileMode) (io.Writer, error) {
-			// Create file
-			file, err := os.Create(filename)
-			if err != nil {
-				return nil, err
-			}
-			// Set permissions
-			if err := file.Chmod(mode); err != nil {
-				file.Close()
-				return nil, err
-			}
-			return file, nil
-		},
 	}
 
 	// Create stdin reader with timeout
-	stdinReader := &stdinReaderWrapper{reader: os.Stdin}
+	var reader zmodem.ReaderWithTimeout = zmodem.NewTimeoutReader(os.Stdin)
+	var writer io.Writer = os.Stdout
+	
+	// Use a separate TCP connection instead, if asked
+	if *tcpServer || *tcpClient != "" {
+		tcpReader, conn, err := tcp.Connect(ctx, *tcpClient, "gsz")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+			return 1
+		}
+		defer conn.Close()
+		reader, writer = tcpReader, conn
+	}
 	
 	// Create session
-	session := zmodem.NewSession(stdinReader, os.Stdout,
+	session := zmodem.NewSession(reader, writer,
 		zmodem.WithConfig(&zmodem.Config{
 			Use32BitCRC:   config.Use32BitCRC,
 			EscapeControl: config.EscapeControl,
@@ -138,67 +181,92 @@ func main() {
 			Timeout:       config.Timeout,
 			MaxBlockSize:  config.BufferSize,
 			Attention:     config.Attention,
+			// Files are created by the session itself, which lets a
+			// sender that asks for it rebuild existing ones from deltas
+			Delta: true,
+
+			FilenameCharset:  filenameCharset,
+			FilenameFallback: fallbackCharset,
 		}),
 		zmodem.WithCallbacks(callbacks),
 		zmodem.WithContext(ctx),
 	)
 
 	// Receive files
-	if err := session.ReceiveFiles(ctx, 0); err != nil {
+	switch protocol.Select(os.Args[0], *useXModem || *useXModemLong, *useYModem || *useYModemLong) {
+	case protocol.XModem:
+		err = receiveXModem(ctx, reader, writer, flag.Args(), callbacks)
+	case protocol.YModem:
+		err = receiveYModem(ctx, reader, writer, callbacks)
+	default:
+		err = session.ReceiveFiles(ctx, 0)
+	}
+	if err != nil {
+		terminal.Restore()
 		if !*quiet {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		}
-		os.Exit(1)
+		return 1
 	}
+	return 0
 }
 
-// stdinReaderWrapper wraps os.Stdin to implement ReaderWithTimeout
-type stdinReaderWrapper struct {
-	reader io.Reader
-}
-
-func (r *stdinReaderWrapper) Read(p []byte) (int, error) {
-	return r.reader.Read(p)
-}
-
-func (r *stdinReaderWrapper) SetReadDeadline(t time.Time) error {
-	// Stdin doesn't support deadlines, but that's OK
-	// The zmodemIO layer will handle timeouts
-	return nil
-}
-
-func signalContext(sigChan chan os.Signal) (context.Context, context.CancelFunc) {
+// signalContext returns a context cancelled by the first signal, which
+// cancels the transfer. A second signal means it didn't stop, so the
+// terminal is put back and the command exits straight away.
+func signalContext(sigChan chan os.Signal, terminal *tty.State) (context.Context, context.CancelFunc) {
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
 		<-sigChan
 		cancel()
+		sig := <-sigChan
+		terminal.Restore()
+		if s, ok := sig.(syscall.Signal); ok {
+			os.Exit(128 + int(s))
+		}
+		os.Exit(1)
 	}()
 	return ctx, cancel
 }
 
 func showUsage(exitcode int) {
-	fmt.Fprintf(os.Stderr, `%s - receive files with ZMODEM protocol
+	fmt.Fprintf(os.Stderr, `%s - receive files with ZMODEM, XMODEM or YMODEM protocol
 
 Usage: %s [options]
+       %s -X [options] file
 
 Options:
   -a, --ascii      ASCII transfer (change CR/LF to LF)
   -b, --binary     binary transfer (default)
   -e, --escape     escape control characters
+  --charset NAME   file name charset: utf-8, latin1, cp437 or auto (default)
+  --fallback NAME  charset for names that aren't UTF-8 with --charset auto
+                   (default: latin1)
   -h, --help       show this help message
   -p, --protect    protect existing files
   -q, --quiet      quiet mode, minimal output
   -t N             timeout in tenths of seconds (default: 100)
   -v, --verbose    verbose mode
   -y, --overwrite  overwrite existing files
+  -X, --xmodem     receive one file with XMODEM (default when run as rx, grx or lrx)
+  -Y, --ymodem     receive with YMODEM (default when run as rb, grb or lrb)
+  -c               ask for CRC-16 rather than the checksum (XMODEM)
+  -g               ask for streaming without ACKs (XMODEM-g, YMODEM-g);
+                   only use it on error free links
+  --tcp-server     listen on a TCP port for the sender and print how to
+                   connect to it, instead of using stdin and stdout; the port
+                   is open on all interfaces and the first to connect gets it
+  --tcp-client H:P connect to a sender started with --tcp-server
   --version        show version
 
 Examples:
   %s                    # Receive files from stdin
   %s -v                 # Verbose mode
   %s -q                 # Quiet mode
+  %s -X -c file.bin     # Receive file.bin with XMODEM-CRC
+  %s --tcp-server       # Receive over a separate TCP connection
 
-`, versionString, os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+`, versionString, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
 	os.Exit(exitcode)
 }
 
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/drunlade/go-lrzsz/internal/protocol"
+	"github.com/drunlade/go-lrzsz/xmodem"
+	"github.com/drunlade/go-lrzsz/ymodem"
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// xmodemConfig returns the configuration for XMODEM and YMODEM transfers.
+// YMODEM always uses CRC-16.
+func xmodemConfig(ctx context.Context, callbacks *zmodem.Callbacks, mode string) *xmodem.Config {
+	config := xmodem.DefaultConfig()
+	if mode == protocol.YModem {
+		config = ymodem.DefaultConfig()
+	}
+	config.CRC = *crc || mode == protocol.YModem
+	config.Streaming = *streaming
+	config.Timeout = *timeout
+	config.Context = ctx
+	config.Callbacks = callbacks
+	return config
+}
+
+// receiveXModem receives a single file with XMODEM. XMODEM doesn't send
+// file names, so the name comes from the command line.
+func receiveXModem(ctx context.Context, reader zmodem.ReaderWithTimeout, writer io.Writer, files []string,
+	callbacks *zmodem.Callbacks) error {
+	if len(files) != 1 {
+		return fmt.Errorf("XMODEM needs exactly one file name to receive into")
+	}
+	name := files[0]
+
+	if *protect {
+		if _, err := os.Stat(name); err == nil {
+			return fmt.Errorf("%s exists", name)
+		}
+	}
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	receiver := xmodem.NewReceiver(reader, writer, xmodemConfig(ctx, callbacks, protocol.XModem))
+	_, err = receiver.Receive(name, file)
+	return err
+}
+
+// receiveYModem receives a YMODEM batch into the current directory.
+func receiveYModem(ctx context.Context, reader zmodem.ReaderWithTimeout, writer io.Writer,
+	callbacks *zmodem.Callbacks) error {
+	receiver := ymodem.NewReceiver(reader, writer, xmodemConfig(ctx, callbacks, protocol.YModem))
+	return receiver.ReceiveFiles()
+}
@@ -11,6 +11,9 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/drunlade/go-lrzsz/internal/protocol"
+	"github.com/drunlade/go-lrzsz/internal/tcp"
+	"github.com/drunlade/go-lrzsz/internal/tty"
 	"github.com/drunlade/go-lrzsz/zmodem"
 )
 
@@ -21,6 +24,19 @@ var (
 	ascii     = flag.Bool("a", false, "ASCII transfer")
 	escape    = flag.Bool("e", false, "escape control characters")
 	timeout   = flag.Int("t", 100, "timeout in tenths of seconds")
+	delta     = flag.Bool("delta", false, "send only changes to files the receiver already has")
+	charset   = flag.String("charset", "utf-8", "file name charset: utf-8, latin1 or cp437")
+	dosNames  = flag.Bool("dos-names", false, "send file names as DOS 8.3 names")
+	oneK      = flag.Bool("k", false, "send 1024 byte blocks with XMODEM")
+	tcpServer = flag.Bool("tcp-server", false, "listen for the receiver on a TCP port")
+	tcpClient = flag.String("tcp-client", "", "connect to the receiver at host:port")
+	via       = flag.String("via", "", "run this shell command as the receiver and send through its stdin and stdout")
+
+	useXModem     = flag.Bool("X", false, "send with XMODEM")
+	useXModemLong = flag.Bool("xmodem", false, "send with XMODEM")
+	useYModem     = flag.Bool("Y", false, "send with YMODEM")
+	useYModemLong = flag.Bool("ymodem", false, "send with YMODEM")
+
 	help      = flag.Bool("h", false, "show help")
 	version   = flag.Bool("version", false, "show version")
 )
@@ -28,6 +44,12 @@ var (
 const versionString = "gsz version 0.1.0"
 
 func main() {
+	os.Exit(run())
+}
+
+// run does the work of main and returns the exit status, so deferred
+// cleanup like restoring the terminal happens before exiting.
+func run() int {
 	flag.Parse()
 
 	if *help {
@@ -36,7 +58,7 @@ func main() {
 
 	if *version {
 		fmt.Println(versionString)
-		os.Exit(0)
+		return 0
 	}
 
 	// Get files from command line
@@ -46,10 +68,34 @@ func main() {
 		showUsage(1)
 	}
 
+	// --via replaces the connection, so it can't be combined with TCP
+	if *via != "" && (*tcpServer || *tcpClient != "") {
+		fmt.Fprintf(os.Stderr, "%s: --via can't be used with --tcp-server or --tcp-client\n", os.Args[0])
+		return 1
+	}
+
+	filenameCharset, err := zmodem.ParseCharset(*charset)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		return 1
+	}
+
+	// Put the terminal in raw mode when the transfer runs over it, and
+	// put it back however we exit
+	var terminal *tty.State
+	if !*tcpServer && *tcpClient == "" && *via == "" {
+		terminal, err = tty.Raw(os.Stdin, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+			return 1
+		}
+		defer terminal.Restore()
+	}
+
 	// Set up signal handling
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	ctx, cancel := signalContext(sigChan)
+	ctx, cancel := signalContext(sigChan, terminal)
 	defer cancel()
 
 	// Create sender configuration
@@ -80,9 +126,9 @@ func main() {
 				fmt.Fprintf(os.Stderr, "\r%s: %.1f%% (%.0f bytes/s)", filename, percent, rate)
 			}
 		},
-		OnFileStart: func(filename string, size int64, mode os.FileMode) {
+		OnFileStart: func(hdr *zmodem.FileHeader) {
 			if *verbose && !*quiet {
-				fmt.Fprintf(os.Stderr, "Sending: %s (%d bytes)\n", filename, size)
+				fmt.Fprintf(os.Stderr, "Sending: %s (%d bytes)\n", hdr.Name, hdr.Size)
 			}
 		},
 		OnFileComplete: func(filename string, bytesTransferred int64, duration time.Duration) {
@@ -114,13 +160,35 @@ func main() {
 	}
 
 	// Create stdout writer wrapper
-	stdoutWriter := &stdoutWriterWrapper{writer: os.Stdout}
+	var writer io.Writer = &stdoutWriterWrapper{writer: os.Stdout}
 	
 	// Create stdin reader with timeout
-	stdinReader := &stdinReaderWrapper{reader: os.Stdin}
+	var reader zmodem.ReaderWithTimeout = zmodem.NewTimeoutReader(os.Stdin)
+	
+	// Use a separate TCP connection instead, if asked
+	if *tcpServer || *tcpClient != "" {
+		tcpReader, conn, err := tcp.Connect(ctx, *tcpClient, "grz")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+			return 1
+		}
+		defer conn.Close()
+		reader, writer = tcpReader, conn
+	}
+	
+	// Or through a command that runs the receiver
+	var viaTransport *zmodem.ExecTransport
+	if *via != "" {
+		viaTransport, err = startVia(*via)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+			return 1
+		}
+		reader, writer = viaTransport, viaTransport
+	}
 	
 	// Create session
-	session := zmodem.NewSession(stdinReader, stdoutWriter,
+	session := zmodem.NewSession(reader, writer,
 		zmodem.WithConfig(&zmodem.Config{
 			Use32BitCRC:   config.Use32BitCRC,
 			EscapeControl: config.EscapeControl,
@@ -130,6 +198,10 @@ func main() {
 			MaxBlockSize:  config.MaxBlockSize,
 			ZNulls:        config.ZNulls,
 			Attention:     config.Attention,
+			Delta:         *delta,
+
+			FilenameCharset: filenameCharset,
+			DOSFilenames:    *dosNames,
 		}),
 		zmodem.WithCallbacks(callbacks),
 		zmodem.WithContext(ctx),
@@ -165,31 +237,32 @@ func main() {
 
 	if len(fileInfos) == 0 {
 		fmt.Fprintf(os.Stderr, "No valid files to send\n")
-		os.Exit(1)
+		return 1
 	}
 
 	// Send files
-	if err := session.SendFiles(ctx, fileInfos); err != nil {
+	switch protocol.Select(os.Args[0], *useXModem || *useXModemLong, *useYModem || *useYModemLong) {
+	case protocol.XModem:
+		err = sendXModem(ctx, reader, writer, fileInfos, callbacks)
+	case protocol.YModem:
+		err = sendYModem(ctx, reader, writer, fileInfos, callbacks)
+	default:
+		err = session.SendFiles(ctx, fileInfos)
+	}
+	if viaTransport != nil {
+		// The command's exit status matters even if the transfer worked
+		if cerr := viaTransport.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		terminal.Restore()
 		if !*quiet {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		}
-		os.Exit(1)
+		return exitCode(err)
 	}
-}
-
-// stdinReaderWrapper wraps os.Stdin to implement ReaderWithTimeout
-type stdinReaderWrapper struct {
-	reader io.Reader
-}
-
-func (r *stdinReaderWrapper) Read(p []byte) (int, error) {
-	return r.reader.Read(p)
-}
-
-func (r *stdinReaderWrapper) SetReadDeadline(t time.Time) error {
-	// Stdin doesn't support deadlines, but that's OK
-	// The zmodemIO layer will handle timeouts
-	return nil
+	return 0
 }
 
 // stdoutWriterWrapper wraps os.Stdout
@@ -201,17 +274,26 @@ func (w *stdoutWriterWrapper) Write(p []byte) (int, error) {
 	return w.writer.Write(p)
 }
 
-func signalContext(sigChan chan os.Signal) (context.Context, context.CancelFunc) {
+// signalContext returns a context cancelled by the first signal, which
+// cancels the transfer. A second signal means it didn't stop, so the
+// terminal is put back and the command exits straight away.
+func signalContext(sigChan chan os.Signal, terminal *tty.State) (context.Context, context.CancelFunc) {
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
 		<-sigChan
 		cancel()
+		sig := <-sigChan
+		terminal.Restore()
+		if s, ok := sig.(syscall.Signal); ok {
+			os.Exit(128 + int(s))
+		}
+		os.Exit(1)
 	}()
 	return ctx, cancel
 }
 
 func showUsage(exitcode int) {
-	fmt.Fprintf(os.Stderr, `%s - send files with ZMODEM protocol
+	fmt.Fprintf(os.Stderr, `%s - send files with ZMODEM, XMODEM or YMODEM protocol
 
 Usage: %s [options] file...
 
@@ -223,14 +305,31 @@ Options:
   -q, --quiet      quiet mode, minimal output
   -t N             timeout in tenths of seconds (default: 100)
   -v, --verbose    verbose mode
+  --delta          send only the changes to files the receiver already has
+                   (the receiver must be grz)
+  --charset NAME   file name charset: utf-8 (default), latin1 or cp437
+  --dos-names      send file names as DOS 8.3 names
+  -X, --xmodem     send with XMODEM (default when run as sx, gsx or lsx)
+  -Y, --ymodem     send with YMODEM (default when run as sb, gsb or lsb)
+  -k               send 1024 byte blocks with XMODEM (YMODEM always does)
+  --tcp-server     listen on a TCP port for the receiver and print how to
+                   connect to it, instead of using stdin and stdout; the port
+                   is open on all interfaces and the first to connect gets it
+  --tcp-client H:P connect to a receiver started with --tcp-server
+  --via COMMAND    run COMMAND with sh -c as the receiver, and send through
+                   its stdin and stdout (like "docker exec -i box rz")
   --version        show version
 
 Examples:
   %s file.txt              # Send a single file
   %s file1.txt file2.txt   # Send multiple files
   %s -v *.txt              # Send all .txt files in verbose mode
+  %s -Y *.bin              # Send a YMODEM batch
+  %s --tcp-server big.iso  # Send over a separate TCP connection
+  %s --via 'kubectl exec -i pod -- rz' file.txt
+                           # Send into a container
 
-`, versionString, os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+`, versionString, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
 	os.Exit(exitcode)
 }
 
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/drunlade/go-lrzsz/internal/protocol"
+	"github.com/drunlade/go-lrzsz/xmodem"
+	"github.com/drunlade/go-lrzsz/ymodem"
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// xmodemConfig returns the configuration for XMODEM and YMODEM transfers.
+// YMODEM always sends 1K blocks, XMODEM only with -k.
+func xmodemConfig(ctx context.Context, callbacks *zmodem.Callbacks, mode string) *xmodem.Config {
+	config := xmodem.DefaultConfig()
+	if mode == protocol.YModem {
+		config = ymodem.DefaultConfig()
+	}
+	config.Block1K = config.Block1K || *oneK
+	config.Timeout = *timeout
+	config.Context = ctx
+	config.Callbacks = callbacks
+	return config
+}
+
+// sendXModem sends a single file with XMODEM, which has no file names and
+// so no batches.
+func sendXModem(ctx context.Context, reader zmodem.ReaderWithTimeout, writer io.Writer,
+	files []zmodem.FileInfo, callbacks *zmodem.Callbacks) error {
+	if len(files) > 1 {
+		return fmt.Errorf("XMODEM can only send one file")
+	}
+
+	file, err := os.Open(files[0].Filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	sender := xmodem.NewSender(reader, writer, xmodemConfig(ctx, callbacks, protocol.XModem))
+	return sender.Send(filepath.Base(files[0].Filename), file, files[0].Info.Size())
+}
+
+// sendYModem sends files as a YMODEM batch.
+func sendYModem(ctx context.Context, reader zmodem.ReaderWithTimeout, writer io.Writer,
+	files []zmodem.FileInfo, callbacks *zmodem.Callbacks) error {
+	sender := ymodem.NewSender(reader, writer, xmodemConfig(ctx, callbacks, protocol.YModem))
+	return sender.SendFiles(files)
+}
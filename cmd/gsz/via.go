@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// startVia starts the --via command, which runs the receiver, with sh -c.
+// Its stderr is shown in verbose mode; otherwise only when it fails.
+func startVia(command string) (*zmodem.ExecTransport, error) {
+	cmd := exec.Command("sh", "-c", command)
+	if *verbose {
+		cmd.Stderr = os.Stderr
+	}
+	return zmodem.NewExecTransport(cmd)
+}
+
+// exitCode returns the exit status for err: the --via command's own
+// status if it failed, 1 otherwise.
+func exitCode(err error) int {
+	var cmdErr *zmodem.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.ExitStatus > 0 {
+		return cmdErr.ExitStatus
+	}
+	return 1
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// buildGrz builds grz into a temporary directory.
+func buildGrz(t *testing.T) string {
+	t.Helper()
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skipf("no go command to build grz: %v", err)
+	}
+	grz := filepath.Join(t.TempDir(), "grz")
+	out, err := exec.Command(goTool, "build", "-o", grz, "../grz").CombinedOutput()
+	if err != nil {
+		t.Fatalf("building grz: %v\n%s", err, out)
+	}
+	return grz
+}
+
+// TestVia sends a file to grz run with --via's sh -c, the way gsz does.
+func TestVia(t *testing.T) {
+	grz := buildGrz(t)
+
+	data := make([]byte, 100*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	name := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	transport, err := startVia("cd '" + dir + "' && exec '" + grz + "' -q")
+	if err != nil {
+		t.Fatalf("startVia: %v", err)
+	}
+	session := zmodem.NewSession(transport, transport, zmodem.WithContext(ctx))
+	if err := session.SendFiles(ctx, []zmodem.FileInfo{{Filename: name, Info: info}}); err != nil {
+		t.Fatalf("SendFiles: %v (grz said %q)", err, transport.Stderr())
+	}
+	if err := transport.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "data.bin"))
+	if err != nil {
+		t.Fatalf("grz didn't create the file: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("grz wrote %d bytes that differ from the %d sent", len(got), len(data))
+	}
+}
+
+// TestViaExitStatus checks that a --via command that fails gives gsz its
+// exit status.
+func TestViaExitStatus(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	transport, err := startVia("echo 'rz: not found' >&2; exit 3")
+	if err != nil {
+		t.Fatalf("startVia: %v", err)
+	}
+	defer transport.Close()
+
+	name := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(name, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := zmodem.NewSession(transport, transport, zmodem.WithContext(ctx))
+	err = session.SendFiles(ctx, []zmodem.FileInfo{{Filename: name, Info: info}})
+	if err == nil {
+		t.Fatalf("SendFiles succeeded with no receiver")
+	}
+	if code := exitCode(err); code != 3 {
+		t.Errorf("exit status %d for %v, want 3", code, err)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/term"
+)
+
+// ttyPrompter asks the user things before the session starts, like
+// passphrases and whether to trust a host key. Like ssh, it uses
+// /dev/tty, so it works with stdin redirected too.
+type ttyPrompter struct {
+	once   sync.Once
+	tty    *os.File
+	reader *bufio.Reader
+	err    error
+}
+
+// open opens the terminal the first time it is needed.
+func (p *ttyPrompter) open() error {
+	p.once.Do(func() {
+		p.tty, p.err = os.OpenFile("/dev/tty", os.O_RDWR, 0)
+		if p.err != nil {
+			p.err = fmt.Errorf("no terminal to ask on: %v", p.err)
+			return
+		}
+		p.reader = bufio.NewReader(p.tty)
+	})
+	return p.err
+}
+
+// readLine shows prompt and reads a line.
+func (p *ttyPrompter) readLine(prompt string) (string, error) {
+	if err := p.open(); err != nil {
+		return "", err
+	}
+	fmt.Fprint(p.tty, prompt)
+	line, err := p.reader.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readSecret shows prompt and reads a line without echoing it.
+func (p *ttyPrompter) readSecret(prompt string) (string, error) {
+	if err := p.open(); err != nil {
+		return "", err
+	}
+	fmt.Fprint(p.tty, prompt)
+	secret, err := term.ReadPassword(int(p.tty.Fd()))
+	fmt.Fprint(p.tty, "\n")
+	return string(secret), err
+}
+
+// close closes the terminal, if it was opened.
+func (p *ttyPrompter) close() {
+	if p.tty != nil {
+		p.tty.Close()
+	}
+}
+
+// defaultIdentities are the private keys tried when -i isn't given, like
+// ssh does.
+var defaultIdentities = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// authMethods returns the ways to log in: keys from ssh-agent and the
+// identity files, then passwords and keyboard-interactive prompts. With
+// no identities given the default keys in ~/.ssh are used if they exist.
+// The agent connection, if any, is returned to be closed after login.
+func authMethods(identities []string, prompt *ttyPrompter) ([]ssh.AuthMethod, io.Closer, error) {
+	var conn net.Conn
+	var agentClient agent.ExtendedAgent
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if c, err := net.Dial("unix", sock); err == nil {
+			conn = c
+			agentClient = agent.NewClient(c)
+		}
+	}
+
+	explicit := len(identities) > 0
+	if !explicit {
+		if home, err := os.UserHomeDir(); err == nil {
+			for _, name := range defaultIdentities {
+				identities = append(identities, filepath.Join(home, ".ssh", name))
+			}
+		}
+	}
+	var keys []ssh.Signer
+	for _, path := range identities {
+		signer, err := loadKey(path, prompt)
+		if err != nil {
+			if !explicit && errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if conn != nil {
+				conn.Close()
+			}
+			return nil, nil, err
+		}
+		keys = append(keys, signer)
+	}
+
+	// The client tries each method once, so agent keys and key files go
+	// through one callback
+	signers := func() ([]ssh.Signer, error) {
+		var all []ssh.Signer
+		if agentClient != nil {
+			if agentKeys, err := agentClient.Signers(); err == nil {
+				all = append(all, agentKeys...)
+			}
+		}
+		return append(all, keys...), nil
+	}
+
+	methods := []ssh.AuthMethod{
+		ssh.PublicKeysCallback(signers),
+		ssh.RetryableAuthMethod(ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+			if name != "" {
+				fmt.Fprintln(os.Stderr, name)
+			}
+			if instruction != "" {
+				fmt.Fprintln(os.Stderr, instruction)
+			}
+			answers := make([]string, len(questions))
+			for i, question := range questions {
+				var err error
+				if echos[i] {
+					answers[i], err = prompt.readLine(question)
+				} else {
+					answers[i], err = prompt.readSecret(question)
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+			return answers, nil
+		}), 3),
+		ssh.RetryableAuthMethod(ssh.PasswordCallback(func() (string, error) {
+			return prompt.readSecret("Password: ")
+		}), 3),
+	}
+	if conn == nil {
+		return methods, nil, nil
+	}
+	return methods, conn, nil
+}
+
+// loadKey reads a private key. An encrypted key is only unlocked once the
+// server accepts it, so the passphrase is asked for when it's needed.
+func loadKey(path string, prompt *ttyPrompter) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err == nil {
+		return signer, nil
+	}
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	key := &lockedKey{path: path, data: data, prompt: prompt, public: missing.PublicKey}
+	if key.public == nil {
+		// Older key formats don't carry the public key; use the .pub file
+		// next to them, or unlock now
+		if pub, err := os.ReadFile(path + ".pub"); err == nil {
+			key.public, _, _, _, _ = ssh.ParseAuthorizedKey(pub)
+		}
+		if key.public == nil {
+			if err := key.unlock(); err != nil {
+				return nil, err
+			}
+			return key.signer, nil
+		}
+	}
+	return key, nil
+}
+
+// lockedKey is a passphrase-protected private key, unlocked the first
+// time it signs.
+type lockedKey struct {
+	path   string
+	data   []byte
+	prompt *ttyPrompter
+	public ssh.PublicKey
+	signer ssh.Signer
+}
+
+// unlock asks for the passphrase, three times at most.
+func (k *lockedKey) unlock() error {
+	if k.signer != nil {
+		return nil
+	}
+	var err error
+	for i := 0; i < 3; i++ {
+		var passphrase string
+		passphrase, err = k.prompt.readSecret(fmt.Sprintf("Enter passphrase for key '%s': ", k.path))
+		if err != nil {
+			return err
+		}
+		k.signer, err = ssh.ParsePrivateKeyWithPassphrase(k.data, []byte(passphrase))
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, x509.IncorrectPasswordError) {
+			break
+		}
+	}
+	return fmt.Errorf("%s: %v", k.path, err)
+}
+
+// PublicKey returns the public key, without unlocking the key.
+func (k *lockedKey) PublicKey() ssh.PublicKey {
+	return k.public
+}
+
+// Sign unlocks the key and signs data.
+func (k *lockedKey) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	if err := k.unlock(); err != nil {
+		return nil, err
+	}
+	return k.signer.Sign(rand, data)
+}
+
+// SignWithAlgorithm unlocks the key and signs data with algorithm, which
+// RSA keys need for rsa-sha2-256 and rsa-sha2-512.
+func (k *lockedKey) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	if err := k.unlock(); err != nil {
+		return nil, err
+	}
+	if signer, ok := k.signer.(ssh.AlgorithmSigner); ok {
+		return signer.SignWithAlgorithm(rand, data, algorithm)
+	}
+	return k.signer.Sign(rand, data)
+}
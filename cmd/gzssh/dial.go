@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// host is where to log in, from [user@]host[:port].
+type host struct {
+	user string
+	addr string // host:port
+}
+
+// parseHost parses [user@]host[:port], filling in defaultUser and port.
+// IPv6 addresses with a port go in brackets, like [::1]:2222.
+func parseHost(s, defaultUser string, port int) (host, error) {
+	h := host{user: defaultUser}
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		h.user, s = s[:i], s[i+1:]
+	}
+	if s == "" {
+		return h, fmt.Errorf("no host name")
+	}
+	if name, p, err := net.SplitHostPort(s); err == nil {
+		if _, err := strconv.Atoi(p); err != nil {
+			return h, fmt.Errorf("%s: bad port", s)
+		}
+		h.addr = net.JoinHostPort(name, p)
+	} else {
+		h.addr = net.JoinHostPort(strings.Trim(s, "[]"), strconv.Itoa(port))
+	}
+	if h.user == "" {
+		return h, fmt.Errorf("%s: no user name (use -l or user@host)", s)
+	}
+	return h, nil
+}
+
+// localUser returns the local user name, the default for logging in.
+func localUser() string {
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+// dial connects to target through the jump hosts, like ssh -J: each hop
+// is a connection tunnelled through the one before. It returns the client
+// for target and the jump host clients, which must stay open as long as
+// it does.
+func dial(target host, jumps []host, config func(host) *ssh.ClientConfig, timeout time.Duration) (*ssh.Client, []*ssh.Client, error) {
+	var clients []*ssh.Client
+	fail := func(err error) (*ssh.Client, []*ssh.Client, error) {
+		for i := len(clients) - 1; i >= 0; i-- {
+			clients[i].Close()
+		}
+		return nil, nil, err
+	}
+
+	hops := append(append([]host{}, jumps...), target)
+	for _, h := range hops {
+		var conn net.Conn
+		var err error
+		if len(clients) == 0 {
+			conn, err = net.DialTimeout("tcp", h.addr, timeout)
+		} else {
+			conn, err = clients[len(clients)-1].Dial("tcp", h.addr)
+		}
+		if err != nil {
+			return fail(fmt.Errorf("connecting to %s: %v", h.addr, err))
+		}
+
+		c, chans, reqs, err := ssh.NewClientConn(conn, h.addr, config(h))
+		if err != nil {
+			conn.Close()
+			return fail(fmt.Errorf("%s: %v", h.addr, err))
+		}
+		clients = append(clients, ssh.NewClient(c, chans, reqs))
+	}
+	return clients[len(clients)-1], clients[:len(clients)-1], nil
+}
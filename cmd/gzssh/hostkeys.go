@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// hostKeys checks host keys against a known_hosts file. Keys of hosts
+// that aren't in it are trusted on first use: the user is asked, or with
+// acceptNew they are added straight away, like StrictHostKeyChecking
+// accept-new. Keys that changed are always refused.
+type hostKeys struct {
+	path      string
+	acceptNew bool
+	prompt    *ttyPrompter
+}
+
+// check is the ssh.HostKeyCallback.
+func (h *hostKeys) check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	callback, err := h.load()
+	if err != nil {
+		return err
+	}
+	err = callback(hostname, remote, key)
+	var keyErr *knownhosts.KeyError
+	if err == nil || !errors.As(err, &keyErr) {
+		return err
+	}
+	if len(keyErr.Want) > 0 {
+		return h.changed(hostname, key, keyErr.Want)
+	}
+
+	fingerprint := ssh.FingerprintSHA256(key)
+	if !h.acceptNew {
+		fmt.Fprintf(os.Stderr, "The authenticity of host '%s' can't be established.\n", hostname)
+		fmt.Fprintf(os.Stderr, "%s key fingerprint is %s.\n", keyName(key), fingerprint)
+		answer, err := h.prompt.readLine("Are you sure you want to continue connecting (yes/no)? ")
+		for err == nil && answer != "yes" && answer != "no" {
+			answer, err = h.prompt.readLine("Please type 'yes' or 'no': ")
+		}
+		if err != nil || answer != "yes" {
+			return fmt.Errorf("host key verification failed")
+		}
+	}
+
+	if err := h.add(hostname, key); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to add the host to %s: %v\n", h.path, err)
+	} else {
+		fmt.Fprintf(os.Stderr, "Warning: Permanently added '%s' (%s) to the list of known hosts.\n", hostname, keyName(key))
+	}
+	return nil
+}
+
+// algorithms returns the host key algorithms to ask hostname for: those
+// of the keys known for it, so a host isn't refused for offering another
+// key first. Nil, meaning any, for unknown hosts.
+func (h *hostKeys) algorithms(hostname string) []string {
+	callback, err := h.load()
+	if err != nil {
+		return nil
+	}
+	// Any key will do; a mismatch lists the known ones
+	var keyErr *knownhosts.KeyError
+	if !errors.As(callback(hostname, &net.TCPAddr{}, probeKey{}), &keyErr) {
+		return nil
+	}
+	var algorithms []string
+	for _, known := range keyErr.Want {
+		switch known.Key.Type() {
+		case ssh.KeyAlgoRSA:
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		default:
+			algorithms = append(algorithms, known.Key.Type())
+		}
+	}
+	return algorithms
+}
+
+// load reads the known_hosts file, which may have changed since the last
+// hop.
+func (h *hostKeys) load() (ssh.HostKeyCallback, error) {
+	if _, err := os.Stat(h.path); os.IsNotExist(err) {
+		return knownhosts.New(os.DevNull)
+	}
+	return knownhosts.New(h.path)
+}
+
+// add appends key for hostname to the known_hosts file.
+func (h *hostKeys) add(hostname string, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(h.path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// changed reports a host key that doesn't match the known ones.
+func (h *hostKeys) changed(hostname string, key ssh.PublicKey, want []knownhosts.KnownKey) error {
+	fmt.Fprintf(os.Stderr, "@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\n")
+	fmt.Fprintf(os.Stderr, "@    WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED!     @\n")
+	fmt.Fprintf(os.Stderr, "@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\n")
+	fmt.Fprintf(os.Stderr, "Someone could be eavesdropping on you right now (man-in-the-middle attack)!\n")
+	fmt.Fprintf(os.Stderr, "The %s key sent by %s has fingerprint %s.\n", keyName(key), hostname, ssh.FingerprintSHA256(key))
+	for _, known := range want {
+		fmt.Fprintf(os.Stderr, "Known %s key in %s:%d\n", keyName(known.Key), known.Filename, known.Line)
+	}
+	return fmt.Errorf("host key verification failed")
+}
+
+// keyName names the type of key like ssh does, as in "ED25519".
+func keyName(key ssh.PublicKey) string {
+	name := strings.TrimPrefix(key.Type(), "ssh-")
+	if strings.HasPrefix(name, "ecdsa") {
+		return "ECDSA"
+	}
+	return strings.ToUpper(name)
+}
+
+// probeKey is a key no host has, to find the keys known for a host.
+type probeKey struct{}
+
+func (probeKey) Type() string                                 { return "gzssh-probe" }
+func (probeKey) Marshal() []byte                              { return []byte("gzssh-probe") }
+func (probeKey) Verify(data []byte, sig *ssh.Signature) error { return errors.New("probe key") }
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/drunlade/go-lrzsz/internal/console"
+	"github.com/drunlade/go-lrzsz/internal/tty"
+	"github.com/drunlade/go-lrzsz/zmodem"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// identityFlags collects the -i flags.
+type identityFlags []string
+
+func (f *identityFlags) String() string     { return strings.Join(*f, ",") }
+func (f *identityFlags) Set(v string) error { *f = append(*f, v); return nil }
+
+var (
+	port        = flag.Int("p", 22, "port to connect to")
+	login       = flag.String("l", "", "user to log in as")
+	jump        = flag.String("J", "", "connect through these jump hosts, comma separated")
+	knownHosts  = flag.String("known-hosts", "", "known_hosts file (default ~/.ssh/known_hosts)")
+	acceptNew   = flag.Bool("accept-new", false, "add keys of new hosts without asking")
+	forceTTY    = flag.Bool("t", false, "request a terminal even with a command")
+	noTTY       = flag.Bool("T", false, "don't request a terminal")
+	hotkey      = flag.String("e", "^@", "hotkey for local transfers, or none")
+	downloads   = flag.String("d", ".", "directory for received files")
+	overwrite   = flag.Bool("y", false, "save received files without asking, overwriting")
+	logFile     = flag.String("log", "", "ZModem protocol log file (for debugging)")
+	verbose     = flag.Bool("v", false, "verbose mode")
+	verboseLong = flag.Bool("verbose", false, "verbose mode")
+	quiet       = flag.Bool("q", false, "quiet mode")
+	quietLong   = flag.Bool("quiet", false, "quiet mode")
+	help        = flag.Bool("h", false, "show help")
+	helpLong    = flag.Bool("help", false, "show help")
+	version     = flag.Bool("version", false, "show version")
+
+	identities identityFlags
+)
+
+const versionString = "gzssh version 0.1.0"
+
+// exitFailed is the exit status when the connection fails, like ssh.
+const exitFailed = 255
+
+func main() {
+	flag.Var(&identities, "i", "private key file (can be repeated)")
+	os.Exit(run())
+}
+
+// run does the work of main and returns the exit status, so deferred
+// cleanup like restoring the terminal happens before exiting.
+func run() int {
+	flag.Parse()
+
+	if *help || *helpLong {
+		showUsage(0)
+	}
+
+	if *version {
+		fmt.Println(versionString)
+		return 0
+	}
+
+	if flag.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "%s: no host specified\n", os.Args[0])
+		showUsage(1)
+	}
+	defaultUser := *login
+	if defaultUser == "" {
+		defaultUser = localUser()
+	}
+	target, err := parseHost(flag.Arg(0), defaultUser, *port)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		return exitFailed
+	}
+	command := strings.Join(flag.Args()[1:], " ")
+	key, err := console.ParseKey(*hotkey)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: -e: %v\n", os.Args[0], err)
+		return exitFailed
+	}
+
+	// Jump hosts log in as the local user unless they say otherwise
+	var jumps []host
+	if *jump != "" {
+		for _, s := range strings.Split(*jump, ",") {
+			h, err := parseHost(s, localUser(), 22)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: -J: %v\n", os.Args[0], err)
+				return exitFailed
+			}
+			jumps = append(jumps, h)
+		}
+	}
+
+	// Log in
+	prompt := &ttyPrompter{}
+	defer prompt.close()
+	methods, agentConn, err := authMethods(identities, prompt)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		return exitFailed
+	}
+	if agentConn != nil {
+		defer agentConn.Close()
+	}
+	hostKeys := &hostKeys{path: *knownHosts, acceptNew: *acceptNew, prompt: prompt}
+	if hostKeys.path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+			return exitFailed
+		}
+		hostKeys.path = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	config := func(h host) *ssh.ClientConfig {
+		return &ssh.ClientConfig{
+			User:              h.user,
+			Auth:              methods,
+			HostKeyCallback:   hostKeys.check,
+			HostKeyAlgorithms: hostKeys.algorithms(h.addr),
+			Timeout:           10 * time.Second,
+		}
+	}
+
+	client, jumpClients, err := dial(target, jumps, config, 10*time.Second)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		return exitFailed
+	}
+	defer func() {
+		client.Close()
+		for i := len(jumpClients) - 1; i >= 0; i-- {
+			jumpClients[i].Close()
+		}
+	}()
+
+	session, err := client.NewSession()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		return exitFailed
+	}
+	defer session.Close()
+
+	// A terminal for a shell, or for a command with -t, like ssh
+	fd := int(os.Stdin.Fd())
+	wantTTY := !*noTTY && (command == "" || *forceTTY) && term.IsTerminal(fd)
+	var terminal *tty.State
+	if wantTTY {
+		width, height, err := term.GetSize(fd)
+		if err != nil {
+			width, height = 80, 24
+		}
+		termType := os.Getenv("TERM")
+		if termType == "" {
+			termType = "xterm"
+		}
+		modes := ssh.TerminalModes{
+			ssh.ECHO:          1,
+			ssh.TTY_OP_ISPEED: 38400,
+			ssh.TTY_OP_OSPEED: 38400,
+		}
+		if err := session.RequestPty(termType, height, width, modes); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+			return exitFailed
+		}
+
+		terminal, err = tty.Raw(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+			return exitFailed
+		}
+		defer terminal.Restore()
+
+		// Pass on window size changes
+		stop := watchSize(fd, func(width, height int) {
+			session.WindowChange(height, width)
+		})
+		defer stop()
+	}
+
+	// Hanging up or being killed ends the session
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP, syscall.SIGTERM)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-sigChan:
+			cancel()
+			session.Close()
+		case <-ctx.Done():
+		}
+	}()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		return exitFailed
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		return exitFailed
+	}
+	session.Stderr = os.Stderr
+
+	con := &console.Console{
+		Out:         os.Stdout,
+		Interactive: terminal != nil,
+		Dir:         *downloads,
+		Overwrite:   *overwrite,
+		Quiet:       *quiet || *quietLong,
+		Verbose:     *verbose || *verboseLong,
+	}
+
+	// ZModem transfers the remote side starts with rz and sz are run
+	// through TerminalIO
+	zmConfig := zmodem.DefaultConfig()
+	zmConfig.Hotkey = key
+	opts := []zmodem.Option{
+		zmodem.WithConfig(zmConfig),
+		zmodem.WithCallbacks(con.Callbacks()),
+		zmodem.WithContext(ctx),
+	}
+	var termIO *zmodem.TerminalIO
+	if *logFile != "" {
+		logger, err := zmodem.NewFileLogger(*logFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+			return exitFailed
+		}
+		defer logger.Close()
+		logger.Info("gzssh: connected to %s@%s", target.user, target.addr)
+		termIO = zmodem.NewTerminalIOWithLogger(stdout, stdin, logger, opts...)
+	} else {
+		termIO = zmodem.NewTerminalIO(stdout, stdin, opts...)
+	}
+	con.Remote = termIO.TerminalWriter()
+
+	if command == "" {
+		err = session.Shell()
+	} else {
+		err = session.Start(command)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		return exitFailed
+	}
+
+	go func() {
+		con.CopyInput(os.Stdin)
+		// End of input, like a pipe running out
+		stdin.Close()
+	}()
+	output := make(chan struct{})
+	go func() {
+		io.Copy(os.Stdout, termIO.TerminalReader())
+		close(output)
+	}()
+
+	err = session.Wait()
+	select {
+	case <-output:
+	case <-time.After(time.Second):
+	}
+	terminal.Restore()
+	return exitCode(err)
+}
+
+// exitCode returns the exit status of the remote command, 255 if there
+// isn't one, like ssh.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus()
+	}
+	if !*quiet && !*quietLong {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+	}
+	return exitFailed
+}
+
+func showUsage(exitcode int) {
+	fmt.Fprintf(os.Stderr, `%s - ssh client with ZMODEM transfers
+
+Usage: %s [options] [user@]host[:port] [command...]
+
+Options:
+  -p PORT          port to connect to (default: 22)
+  -l USER          user to log in as (default: the local user)
+  -i FILE          private key file, can be repeated (default: the
+                   id_ed25519, id_ecdsa and id_rsa keys in ~/.ssh)
+  -J HOSTS         connect through jump hosts, [user@]host[:port] separated
+                   by commas, like ssh -J
+  --known-hosts F  known_hosts file (default: ~/.ssh/known_hosts)
+  --accept-new     add keys of new hosts without asking
+  -t               request a terminal even when running a command
+  -T               don't request a terminal
+  -e KEY           hotkey for transfers started locally, like ^] (default:
+                   ^@, which is Ctrl-Space); none turns it off
+  -d DIR           directory for received files (default: .)
+  -y               save received files without asking, overwriting
+  --log FILE       ZMODEM protocol log file for debugging
+  -q, --quiet      quiet mode, minimal output
+  -v, --verbose    verbose mode
+  -h, --help       show this help message
+  --version        show version
+
+Keys are also taken from ssh-agent. When the remote side runs rz you are
+asked for the files to send, and when it runs sz, where to save each file.
+The hotkey starts a transfer from here: it asks what to upload or download
+and types rz or sz into the remote shell. The exit status is the remote
+command's, or 255 if the connection failed.
+
+Examples:
+  %s user@example.com                  # Log in
+  %s -J bastion user@internal          # Log in through a jump host
+  %s -d ~/Downloads example.com        # Save received files there
+  %s example.com uptime                # Run a command
+
+`, versionString, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+	os.Exit(exitcode)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/drunlade/go-lrzsz/internal/console"
+	"github.com/drunlade/go-lrzsz/internal/pty"
+	"github.com/drunlade/go-lrzsz/internal/tty"
+	"github.com/drunlade/go-lrzsz/zmodem"
+	"golang.org/x/term"
+)
+
+var (
+	hotkey      = flag.String("e", "^@", "hotkey for local transfers, or none")
+	downloads   = flag.String("d", ".", "directory for received files")
+	overwrite   = flag.Bool("y", false, "save received files without asking, overwriting")
+	logFile     = flag.String("log", "", "ZModem protocol log file (for debugging)")
+	verbose     = flag.Bool("v", false, "verbose mode")
+	verboseLong = flag.Bool("verbose", false, "verbose mode")
+	quiet       = flag.Bool("q", false, "quiet mode")
+	quietLong   = flag.Bool("quiet", false, "quiet mode")
+	help        = flag.Bool("h", false, "show help")
+	helpLong    = flag.Bool("help", false, "show help")
+	version     = flag.Bool("version", false, "show version")
+)
+
+const versionString = "gzterm version 0.1.0"
+
+// exitFailed is the exit status when the command can't be run, like a
+// shell's for a command that isn't found.
+const exitFailed = 127
+
+func main() {
+	os.Exit(run())
+}
+
+// run does the work of main and returns the exit status, so deferred
+// cleanup like restoring the terminal happens before exiting.
+func run() int {
+	flag.Parse()
+
+	if *help || *helpLong {
+		showUsage(0)
+	}
+
+	if *version {
+		fmt.Println(versionString)
+		return 0
+	}
+
+	key, err := console.ParseKey(*hotkey)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: -e: %v\n", os.Args[0], err)
+		showUsage(1)
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		shell := os.Getenv("SHELL")
+		if shell == "" {
+			shell = "/bin/sh"
+		}
+		args = []string{shell}
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+
+	// The program gets a terminal the size of ours
+	fd := int(os.Stdin.Fd())
+	interactive := term.IsTerminal(fd)
+	width, height := 0, 0
+	if interactive {
+		var err error
+		if width, height, err = term.GetSize(fd); err != nil {
+			width, height = 80, 24
+		}
+	}
+	ptmx, err := pty.StartWithSize(cmd, height, width)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		return exitFailed
+	}
+	defer ptmx.Close()
+
+	// What is typed goes to the program as it is; its terminal does the
+	// echoing and line editing
+	var terminal *tty.State
+	if interactive {
+		terminal, err = tty.Raw(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+			cmd.Process.Kill()
+			cmd.Wait()
+			return exitFailed
+		}
+		defer terminal.Restore()
+
+		// Pass on window size changes
+		stop := watchSize(fd, func(width, height int) {
+			pty.SetSize(ptmx, height, width)
+		})
+		defer stop()
+	}
+
+	// Hanging up or being killed hangs up the program's terminal
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP, syscall.SIGTERM)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-sigChan:
+			cancel()
+			ptmx.Close()
+		case <-ctx.Done():
+		}
+	}()
+
+	con := &console.Console{
+		Out:         os.Stdout,
+		Interactive: interactive,
+		Dir:         *downloads,
+		Overwrite:   *overwrite,
+		Quiet:       *quiet || *quietLong,
+		Verbose:     *verbose || *verboseLong,
+	}
+
+	// ZModem transfers started with rz and sz in the program, or on
+	// whatever it connects to, are run through TerminalIO
+	config := zmodem.DefaultConfig()
+	config.Hotkey = key
+	opts := []zmodem.Option{
+		zmodem.WithConfig(config),
+		zmodem.WithCallbacks(con.Callbacks()),
+		zmodem.WithContext(ctx),
+	}
+	var termIO *zmodem.TerminalIO
+	if *logFile != "" {
+		logger, err := zmodem.NewFileLogger(*logFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+			cmd.Process.Kill()
+			cmd.Wait()
+			return exitFailed
+		}
+		defer logger.Close()
+		logger.Info("gzterm: running %s", strings.Join(args, " "))
+		termIO = zmodem.NewTerminalIOWithLogger(ptmx, ptmx, logger, opts...)
+	} else {
+		termIO = zmodem.NewTerminalIO(ptmx, ptmx, opts...)
+	}
+	con.Remote = termIO.TerminalWriter()
+
+	go func() {
+		con.CopyInput(os.Stdin)
+		if !interactive {
+			// End of input, which the terminal passes on as end of file
+			ptmx.Write([]byte{4})
+		}
+	}()
+	output := make(chan struct{})
+	go func() {
+		// Reading fails with EIO once the program has closed the terminal
+		io.Copy(os.Stdout, termIO.TerminalReader())
+		close(output)
+	}()
+
+	err = cmd.Wait()
+	select {
+	case <-output:
+	case <-time.After(time.Second):
+	}
+	terminal.Restore()
+	return exitCode(err)
+}
+
+// exitCode returns the exit status of the program, 128 plus the signal
+// number if it was killed, like a shell.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		return exitFailed
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return exitErr.ExitCode()
+}
+
+func showUsage(exitcode int) {
+	fmt.Fprintf(os.Stderr, `%s - run a program in a terminal with ZMODEM transfers
+
+Usage: %s [options] [command [args...]]
+
+Options:
+  -e KEY           hotkey for transfers started locally, like ^] (default:
+                   ^@, which is Ctrl-Space); none turns it off
+  -d DIR           directory for received files (default: .)
+  -y               save received files without asking, overwriting
+  --log FILE       ZMODEM protocol log file for debugging
+  -q, --quiet      quiet mode, minimal output
+  -v, --verbose    verbose mode
+  -h, --help       show this help message
+  --version        show version
+
+The command, $SHELL by default, runs in a pseudo-terminal of its own.
+When rz runs in it, or on a host it connects to, you are asked for the
+files to send, and when sz runs, where to save each file. The hotkey
+starts a transfer from here: it asks what to upload or download and types
+rz or sz into the shell. The exit status is the command's.
+
+Examples:
+  %s                                  # Run a shell
+  %s telnet bbs.example.com           # Transfer files over telnet
+  %s screen /dev/ttyUSB0 115200       # Or over a serial console
+  %s -d ~/Downloads ssh example.com   # Save received files there
+
+`, versionString, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+	os.Exit(exitcode)
+}
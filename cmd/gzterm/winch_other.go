@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+// watchSize does nothing, since there is no SIGWINCH to learn of window
+// changes from.
+func watchSize(fd int, resize func(width, height int)) (stop func()) {
+	return func() {}
+}
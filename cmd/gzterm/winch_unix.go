@@ -0,0 +1,28 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+// watchSize calls resize with the size of the terminal fd each time the
+// window changes, until stop is called.
+func watchSize(fd int, resize func(width, height int)) (stop func()) {
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	go func() {
+		for range winch {
+			if width, height, err := term.GetSize(fd); err == nil {
+				resize(width, height)
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(winch)
+	}
+}
@@ -180,7 +180,7 @@ func main() {
 
 	// Create ZModem callbacks
 	callbacks := &zmodem.Callbacks{
-		OnFilePrompt: func(filename string, size int64, mode os.FileMode) (bool, error) {
+		OnFilePrompt: func(hdr *zmodem.FileHeader) (bool, error) {
 			if *quiet {
 				return true, nil
 			}
@@ -199,12 +199,12 @@ func main() {
 				fmt.Fprintf(os.Stderr, "\r%s: %.1f%% (%.0f bytes/s)", filename, percent, rate)
 			}
 		},
-		OnFileStart: func(filename string, size int64, mode os.FileMode) {
+		OnFileStart: func(hdr *zmodem.FileHeader) {
 			if !*quiet {
 				if *verbose {
-					fmt.Fprintf(os.Stderr, "\nStarting: %s (%d bytes)\n", filename, size)
+					fmt.Fprintf(os.Stderr, "\nStarting: %s (%d bytes)\n", hdr.Name, hdr.Size)
 				} else {
-					fmt.Fprintf(os.Stderr, "Transferring: %s\n", filename)
+					fmt.Fprintf(os.Stderr, "Transferring: %s\n", hdr.Name)
 				}
 			}
 		},
@@ -224,8 +224,8 @@ func main() {
 			}
 			return false
 		},
-		OnFileCreate: func(filename string, size int64, mode os.FileMode) (io.Writer, error) {
-			localFilename := filepath.Base(filename)
+		OnFileCreate: func(hdr *zmodem.FileHeader) (io.Writer, error) {
+			localFilename := filepath.Base(hdr.Name)
 			if *verbose {
 				fmt.Fprintf(os.Stderr, "Creating local file: %s\n", localFilename)
 			}
@@ -233,9 +233,11 @@ func main() {
 			if err != nil {
 				return nil, err
 			}
-			if err := file.Chmod(mode); err != nil {
-				file.Close()
-				return nil, err
+			if hdr.Mode != 0 {
+				if err := file.Chmod(hdr.Mode); err != nil {
+					file.Close()
+					return nil, err
+				}
 			}
 			return file, nil
 		},
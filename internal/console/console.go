@@ -0,0 +1,390 @@
+// Package console is the local terminal of gzssh and gzterm while they
+// run a session. Keyboard input goes to the remote side, and the ZModem
+// transfers the remote side starts ask the user which files to send and
+// where to save the ones it sends.
+package console
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drunlade/go-lrzsz/zmodem"
+	"golang.org/x/term"
+)
+
+// Console is the local terminal during a session. Keyboard input goes
+// to the remote side, except while a transfer asks the user something:
+// which files to send when the remote runs rz, and where to save the ones
+// it sends with sz.
+type Console struct {
+	Out    io.Writer // The local terminal
+	Remote io.Writer // The remote side's input
+
+	// Interactive is set when the terminal is in raw mode and there is
+	// someone to ask; otherwise nothing is sent, and files are saved
+	// without asking unless that would overwrite one
+	Interactive bool
+
+	// Dir is where received files go by default, and Overwrite saves
+	// them there without asking, over any file of the same name
+	Dir       string
+	Overwrite bool
+	Quiet     bool
+	Verbose   bool
+
+	mu     sync.Mutex
+	prompt *io.PipeWriter // Input goes here while asking
+}
+
+// CopyInput passes input to the remote side or the open prompt until in
+// ends.
+func (c *Console) CopyInput(in io.Reader) error {
+	buf := make([]byte, 4096)
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			c.mu.Lock()
+			prompt := c.prompt
+			c.mu.Unlock()
+			if prompt != nil {
+				// Whatever the prompt doesn't read is dropped
+				prompt.Write(buf[:n])
+			} else if _, err := c.Remote.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readLine asks for a line with line editing. Ctrl-C and Ctrl-D give
+// io.EOF.
+func (c *Console) readLine(prompt string, completePaths bool) (string, error) {
+	pr, pw := io.Pipe()
+	c.mu.Lock()
+	c.prompt = pw
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.prompt = nil
+		c.mu.Unlock()
+		pr.Close()
+	}()
+
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{pr, c.Out}, prompt)
+	if completePaths {
+		t.AutoCompleteCallback = completePath
+	}
+	line, err := t.ReadLine()
+	return strings.TrimSpace(line), err
+}
+
+// printf writes a message on a line of its own, which needs CR LF in raw
+// mode.
+func (c *Console) printf(format string, args ...interface{}) {
+	msg := strings.ReplaceAll(fmt.Sprintf(format, args...), "\n", "\r\n")
+	fmt.Fprint(c.Out, "\r\n"+msg+"\r\n")
+}
+
+// Callbacks returns the transfer callbacks.
+func (c *Console) Callbacks() *zmodem.Callbacks {
+	var lastProgress time.Time
+	return &zmodem.Callbacks{
+		OnFileList:    c.pickFiles,
+		OnFilePrompt:  c.saveAs,
+		OnLocalPrompt: c.localTransfer,
+		OnFileStart: func(hdr *zmodem.FileHeader) {
+			if !c.Quiet {
+				c.printf("%s (%d bytes)", hdr.Name, hdr.Size)
+			}
+		},
+		OnProgress: func(filename string, transferred, total int64, rate float64) {
+			if c.Quiet || time.Since(lastProgress) < 200*time.Millisecond && transferred < total {
+				return
+			}
+			lastProgress = time.Now()
+			percent := float64(100)
+			if total > 0 {
+				percent = float64(transferred) / float64(total) * 100
+			}
+			fmt.Fprintf(c.Out, "\r%s: %.1f%% (%.0f bytes/s)\x1b[K", filename, percent, rate)
+		},
+		OnFileComplete: func(filename string, bytesTransferred int64, duration time.Duration) {
+			if c.Verbose {
+				c.printf("Completed: %s (%d bytes)", filename, bytesTransferred)
+			} else if !c.Quiet {
+				c.printf("Completed: %s", filename)
+			}
+		},
+		OnError: func(err error, where string) bool {
+			// An abort is reported once, not by every step it stopped
+			if !zmodem.IsFileSkipped(err) && !errors.Is(err, context.Canceled) {
+				c.printf("Error in %s: %v", where, err)
+			}
+			return false
+		},
+	}
+}
+
+// pickFiles asks which files to send when the remote runs rz. No files
+// ends the transfer.
+func (c *Console) pickFiles() ([]string, error) {
+	if !c.Interactive {
+		c.printf("The remote side is waiting for files, but there is no one to pick them")
+		return nil, nil
+	}
+	c.printf("The remote side is waiting for files.")
+	for {
+		line, err := c.readLine("Send (Tab completes, empty or Ctrl-C cancels): ", true)
+		if err != nil || line == "" {
+			return nil, nil
+		}
+		files, err := expandFiles(line)
+		if err != nil {
+			fmt.Fprintf(c.Out, "%v\r\n", err)
+			continue
+		}
+		return files, nil
+	}
+}
+
+// localTransfer asks what to transfer when the hotkey is pressed: local
+// files to upload or remote files to download.
+func (c *Console) localTransfer() (*zmodem.TransferRequest, error) {
+	if !c.Interactive {
+		return nil, nil
+	}
+	c.printf("Local transfer.")
+	for {
+		answer, err := c.readLine("[u]pload or [d]ownload (empty or Ctrl-C cancels): ", false)
+		if err != nil || answer == "" {
+			return nil, nil
+		}
+		switch strings.ToLower(answer[:1]) {
+		case "u":
+			for {
+				line, err := c.readLine("Upload (Tab completes, empty or Ctrl-C cancels): ", true)
+				if err != nil || line == "" {
+					return nil, nil
+				}
+				files, err := expandFiles(line)
+				if err != nil {
+					fmt.Fprintf(c.Out, "%v\r\n", err)
+					continue
+				}
+				return &zmodem.TransferRequest{Upload: true, Files: files}, nil
+			}
+		case "d":
+			line, err := c.readLine("Download remote files (empty or Ctrl-C cancels): ", false)
+			if err != nil || line == "" {
+				return nil, nil
+			}
+			return &zmodem.TransferRequest{Files: splitWords(line)}, nil
+		}
+	}
+}
+
+// saveAs asks where to save a file the remote side sends, by setting
+// hdr.Name to the local path. The name from the remote side is only
+// trusted as far as its last element.
+func (c *Console) saveAs(hdr *zmodem.FileHeader) (bool, error) {
+	name := path.Base(filepath.ToSlash(hdr.Name))
+	if name == "/" || name == "." || name == ".." {
+		name = "download"
+	}
+	target := filepath.Join(c.Dir, name)
+	if c.Overwrite {
+		hdr.Name = target
+		return true, nil
+	}
+	if !c.Interactive {
+		// No one to ask, so existing files are kept
+		if _, err := os.Lstat(target); err == nil {
+			c.printf("Skipped %s: %s exists (-y overwrites)", name, target)
+			return false, nil
+		}
+		hdr.Name = target
+		return true, nil
+	}
+
+	for {
+		line, err := c.readLine(fmt.Sprintf("Save %s (%d bytes) as [%s]: ", name, hdr.Size, target), true)
+		if err != nil {
+			fmt.Fprintf(c.Out, "Skipped %s\r\n", name)
+			return false, nil
+		}
+		local := target
+		if words := splitWords(line); len(words) > 0 {
+			local = expandHome(strings.Join(words, " "))
+		}
+		if info, err := os.Stat(local); err == nil && info.IsDir() {
+			local = filepath.Join(local, name)
+		}
+		if _, err := os.Stat(local); err == nil {
+			answer, err := c.readLine(fmt.Sprintf("%s exists. Overwrite? [y/N] ", local), false)
+			if err != nil || !strings.HasPrefix(strings.ToLower(answer), "y") {
+				continue
+			}
+		}
+		hdr.Name = local
+		return true, nil
+	}
+}
+
+// ParseKey parses a hotkey given on the command line: ^X for a control
+// character, like ^@ or ^], any other text as it is, and "none" for no
+// hotkey.
+func ParseKey(s string) (string, error) {
+	switch {
+	case s == "none":
+		return "", nil
+	case s == "^?":
+		return "\x7f", nil
+	case len(s) == 2 && s[0] == '^':
+		ch := s[1]
+		if ch >= 'a' && ch <= 'z' {
+			ch -= 'a' - 'A'
+		}
+		if ch < '@' || ch > '_' {
+			return "", fmt.Errorf("%s: not a control character", s)
+		}
+		return string([]byte{ch - '@'}), nil
+	case s == "":
+		return "", fmt.Errorf("empty hotkey (use none to turn it off)")
+	}
+	return s, nil
+}
+
+// expandFiles turns a line of file names, which may be quoted and have
+// wildcards, into the files to send.
+func expandFiles(line string) ([]string, error) {
+	var files []string
+	for _, word := range splitWords(line) {
+		word = expandHome(word)
+		matches, err := filepath.Glob(word)
+		if err != nil || len(matches) == 0 {
+			matches = []string{word}
+		}
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if !info.Mode().IsRegular() {
+				return nil, fmt.Errorf("%s: not a regular file", match)
+			}
+			files = append(files, match)
+		}
+	}
+	return files, nil
+}
+
+// splitWords splits a line into words at spaces, which quotes and
+// backslashes keep in a word.
+func splitWords(line string) []string {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			} else {
+				word.WriteByte(ch)
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+			inWord = true
+		case ch == '\\' && i+1 < len(line):
+			i++
+			word.WriteByte(line[i])
+			inWord = true
+		case ch == ' ' || ch == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(ch)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// expandHome replaces a leading ~/ with the home directory.
+func expandHome(name string) string {
+	if name == "~" || strings.HasPrefix(name, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return home + name[1:]
+		}
+	}
+	return name
+}
+
+// completePath completes the file name before the cursor on Tab, as far
+// as the matches agree.
+func completePath(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	start := strings.LastIndexAny(line[:pos], " \t") + 1
+	for start > 1 && line[start-2] == '\\' {
+		// An escaped space is part of the name
+		start = strings.LastIndexAny(line[:start-2], " \t") + 1
+	}
+	prefix := expandHome(strings.ReplaceAll(line[start:pos], `\ `, " "))
+
+	matches, err := filepath.Glob(globEscape(prefix) + "*")
+	if err != nil || len(matches) == 0 {
+		return "", 0, false
+	}
+	common := matches[0]
+	for _, match := range matches[1:] {
+		for !strings.HasPrefix(match, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	if len(matches) == 1 {
+		if info, err := os.Stat(common); err == nil && info.IsDir() {
+			common += string(filepath.Separator)
+		}
+	}
+	if len(common) <= len(prefix) {
+		return "", 0, false
+	}
+	completed := line[start:pos] + strings.ReplaceAll(common[len(prefix):], " ", `\ `)
+	return line[:start] + completed + line[pos:], start + len(completed), true
+}
+
+// globEscape escapes the characters filepath.Glob treats specially.
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, ch := range s {
+		if strings.ContainsRune(`*?[\`, ch) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(ch)
+	}
+	return b.String()
+}
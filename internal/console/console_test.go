@@ -0,0 +1,63 @@
+package console
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+func TestSaveAsNotInteractive(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "old.txt"), []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		overwrite bool
+		accept    bool
+		want      string
+	}{
+		{"new.txt", false, true, filepath.Join(dir, "new.txt")},
+		{"../../new.txt", false, true, filepath.Join(dir, "new.txt")},
+		{"old.txt", false, false, ""},
+		{"old.txt", true, true, filepath.Join(dir, "old.txt")},
+	}
+	for _, tt := range tests {
+		c := &Console{Out: &bytes.Buffer{}, Dir: dir, Overwrite: tt.overwrite}
+		hdr := &zmodem.FileHeader{Name: tt.name}
+		accept, err := c.saveAs(hdr)
+		if err != nil {
+			t.Fatalf("saveAs(%q): %v", tt.name, err)
+		}
+		if accept != tt.accept || accept && hdr.Name != tt.want {
+			t.Errorf("saveAs(%q) with overwrite %v = %v, %q; want %v, %q",
+				tt.name, tt.overwrite, accept, hdr.Name, tt.accept, tt.want)
+		}
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		s, want string
+		ok      bool
+	}{
+		{"^@", "\x00", true},
+		{"^]", "\x1d", true},
+		{"^x", "\x18", true},
+		{"^?", "\x7f", true},
+		{"~.", "~.", true},
+		{"none", "", true},
+		{"^1", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, err := ParseKey(tt.s)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseKey(%q) = %q, %v; want %q", tt.s, got, err, tt.want)
+		}
+	}
+}
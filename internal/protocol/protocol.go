@@ -0,0 +1,40 @@
+// Package protocol picks the transfer protocol for gsz and grz: the -X
+// and -Y flags, or the name the command is run as, like lrzsz does for
+// sx, sb, rx and rb.
+package protocol
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Transfer protocols.
+const (
+	ZModem = "zmodem"
+	XModem = "xmodem"
+	YModem = "ymodem"
+)
+
+// Select returns the protocol to use: the flags win, then the command
+// name, then ZModem. The name can have a g (gsx, grb) or l (lsx, lrb)
+// in front.
+func Select(argv0 string, xmodem, ymodem bool) string {
+	switch {
+	case xmodem:
+		return XModem
+	case ymodem:
+		return YModem
+	}
+
+	name := strings.TrimSuffix(filepath.Base(argv0), ".exe")
+	if strings.HasPrefix(name, "g") || strings.HasPrefix(name, "l") {
+		name = name[1:]
+	}
+	switch name {
+	case "sx", "rx":
+		return XModem
+	case "sb", "rb":
+		return YModem
+	}
+	return ZModem
+}
@@ -0,0 +1,29 @@
+package protocol
+
+import "testing"
+
+func TestSelect(t *testing.T) {
+	tests := []struct {
+		argv0          string
+		xmodem, ymodem bool
+		want           string
+	}{
+		{"/usr/bin/gsz", false, false, ZModem},
+		{"sz", false, false, ZModem},
+		{"sx", false, false, XModem},
+		{"/usr/local/bin/gsx", false, false, XModem},
+		{"lsx", false, false, XModem},
+		{"rb", false, false, YModem},
+		{"lrb", false, false, YModem},
+		{"grb.exe", false, false, YModem},
+		{"lrz", false, false, ZModem},
+		{"gsz", true, false, XModem},
+		{"sx", false, true, YModem},
+		{"xsx", false, false, ZModem},
+	}
+	for _, tt := range tests {
+		if got := Select(tt.argv0, tt.xmodem, tt.ymodem); got != tt.want {
+			t.Errorf("Select(%q, %v, %v) = %s, want %s", tt.argv0, tt.xmodem, tt.ymodem, got, tt.want)
+		}
+	}
+}
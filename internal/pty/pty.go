@@ -0,0 +1,38 @@
+// Package pty runs programs in pseudo-terminals, for gzterm: the program
+// sees a terminal on the slave side and the master side carries what it
+// writes to it and what is typed at it.
+package pty
+
+import (
+	"os"
+	"os/exec"
+)
+
+// StartWithSize runs cmd in a new pseudo-terminal of rows by cols, unless
+// they are 0, and returns the master side. The program gets the slave
+// side as its stdin, stdout and stderr and as the controlling terminal of
+// a new session, so job control and ^C work as they would in a terminal
+// window. Reading the master returns an error (EIO) once the program and
+// everything else it started have closed the terminal.
+func StartWithSize(cmd *exec.Cmd, rows, cols int) (*os.File, error) {
+	master, slave, err := Open()
+	if err != nil {
+		return nil, err
+	}
+	defer slave.Close()
+	if rows > 0 && cols > 0 {
+		// Before starting, so the program sees the size from the start
+		if err := SetSize(slave, rows, cols); err != nil {
+			master.Close()
+			return nil, err
+		}
+	}
+
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	setCtty(cmd)
+	if err := cmd.Start(); err != nil {
+		master.Close()
+		return nil, err
+	}
+	return master, nil
+}
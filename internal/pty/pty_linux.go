@@ -0,0 +1,70 @@
+//go:build linux
+
+package pty
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Open opens a new pseudo-terminal pair, like openpty(3): the master side
+// and the slave side a program runs on.
+func Open() (master, slave *os.File, err error) {
+	// Non-blocking, so the master goes through the runtime poller and
+	// closing it stops a Read in progress
+	fd, err := unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, nil, &os.PathError{Op: "open", Path: "/dev/ptmx", Err: err}
+	}
+
+	// unlockpt and ptsname
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		unix.Close(fd)
+		return nil, nil, fmt.Errorf("pty: unlock: %v", err)
+	}
+	n, err := unix.IoctlGetUint32(fd, unix.TIOCGPTN)
+	if err != nil {
+		unix.Close(fd)
+		return nil, nil, fmt.Errorf("pty: %v", err)
+	}
+	master = os.NewFile(uintptr(fd), "/dev/ptmx")
+
+	// The program gets the slave side as it is, blocking
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
+
+// SetSize sets the window size of the terminal f, either side of a
+// pseudo-terminal. The program running on it gets SIGWINCH.
+func SetSize(f *os.File, rows, cols int) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	ws := &unix.Winsize{Row: uint16(rows), Col: uint16(cols)}
+	var ioctlErr error
+	if err := conn.Control(func(fd uintptr) {
+		ioctlErr = unix.IoctlSetWinsize(int(fd), unix.TIOCSWINSZ, ws)
+	}); err != nil {
+		return err
+	}
+	return ioctlErr
+}
+
+// setCtty makes cmd's stdin its controlling terminal in a new session.
+func setCtty(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0
+}
@@ -0,0 +1,95 @@
+//go:build linux
+
+package pty
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// start runs script with sh in a pseudo-terminal of rows by cols.
+func start(t *testing.T, script string, rows, cols int) (*os.File, *exec.Cmd) {
+	t.Helper()
+	cmd := exec.Command("sh", "-c", script)
+	master, err := StartWithSize(cmd, rows, cols)
+	if err != nil {
+		t.Skipf("can't run sh in a pty: %v", err)
+	}
+	t.Cleanup(func() {
+		master.Close()
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	return master, cmd
+}
+
+// readUntil reads from master until the output has want in it, and
+// returns the output.
+func readUntil(t *testing.T, master *os.File, want string) string {
+	t.Helper()
+	master.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer master.SetReadDeadline(time.Time{})
+	var out []byte
+	buf := make([]byte, 256)
+	for !strings.Contains(string(out), want) {
+		n, err := master.Read(buf)
+		out = append(out, buf[:n]...)
+		if err != nil {
+			t.Fatalf("waiting for %q: %v (got %q)", want, err, out)
+		}
+	}
+	return string(out)
+}
+
+func TestStartWithSize(t *testing.T) {
+	// The program runs on a terminal of the size asked for, which is its
+	// controlling terminal, and output gets CR LF like in a terminal
+	master, _ := start(t, `stty size; [ -t 0 ] && echo stdin; : < /dev/tty && echo ctty`, 24, 80)
+	out := readUntil(t, master, "ctty\r\n")
+	for _, want := range []string{"24 80\r\n", "stdin\r\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output %q has no %q", out, want)
+		}
+	}
+}
+
+func TestSetSize(t *testing.T) {
+	master, _ := start(t, `stty size; read x; stty size`, 24, 80)
+	readUntil(t, master, "24 80\r\n")
+
+	if err := SetSize(master, 30, 100); err != nil {
+		t.Fatalf("SetSize: %v", err)
+	}
+	if _, err := master.Write([]byte("\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	readUntil(t, master, "30 100\r\n")
+}
+
+func TestInputAndExit(t *testing.T) {
+	master, cmd := start(t, `read line; echo "got $line"`, 0, 0)
+	if _, err := master.Write([]byte("hello\r")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	readUntil(t, master, "got hello\r\n")
+
+	// Once the program is gone, reads fail
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("sh: %v", err)
+	}
+	master.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 256)
+	for {
+		_, err := master.Read(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("master still open after the program exited")
+		}
+		if err != nil {
+			break
+		}
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package pty
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// errUnsupported is returned by Open on platforms without pty support.
+var errUnsupported = errors.New("pty: pseudo-terminals are only supported on Linux")
+
+// Open opens a new pseudo-terminal pair. It is only supported on Linux.
+func Open() (master, slave *os.File, err error) {
+	return nil, nil, errUnsupported
+}
+
+// SetSize sets the window size of the terminal f. It is only supported on
+// Linux.
+func SetSize(f *os.File, rows, cols int) error {
+	return errUnsupported
+}
+
+func setCtty(cmd *exec.Cmd) {}
@@ -0,0 +1,58 @@
+// Package tcp opens the separate TCP data connection of gsz and grz
+// --tcp-server and --tcp-client, like lsz and lrz do.
+//
+// The server side listens on all interfaces, on a port the system picks,
+// and takes the first connection that comes in without checking where it
+// is from. Anyone who can reach the port in that window can take the
+// transfer's place, so only use it on networks you trust.
+package tcp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// Connect dials address if it isn't empty. Otherwise it listens, prints
+// the command peer should be run with to connect, and waits for one
+// connection, or until ctx is done.
+func Connect(ctx context.Context, address, peer string) (zmodem.ReaderWithTimeout, io.WriteCloser, error) {
+	if address != "" {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zmodem.NewConnReader(conn), conn, nil
+	}
+
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer ln.Close()
+
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, nil, err
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	fmt.Fprintf(os.Stderr, "connect with %s --tcp-client \"%s:%d\"\n", peer, host, port)
+
+	// Stop waiting on a signal
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		return nil, nil, err
+	}
+	return zmodem.NewConnReader(conn), conn, nil
+}
@@ -0,0 +1,11 @@
+//go:build linux
+
+package tty
+
+import "golang.org/x/sys/unix"
+
+// drain waits until output written to the terminal has been sent, so
+// changing the mode doesn't affect it (tcdrain).
+func drain(fd int) {
+	unix.IoctlSetInt(fd, unix.TCSBRK, 1)
+}
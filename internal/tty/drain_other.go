@@ -0,0 +1,6 @@
+//go:build !linux
+
+package tty
+
+// drain does nothing; the terminal is restored with output still pending.
+func drain(fd int) {}
@@ -0,0 +1,52 @@
+// Package tty switches the terminal gsz, grz, gzssh and gzterm run on to
+// raw mode, like lrzsz does, so the line discipline doesn't mangle the
+// transfer: no CR to NL mapping (ICRNL), no stripping of the 8th bit
+// (ISTRIP), no XON/XOFF (IXON), no echo and no signals from the keyboard.
+package tty
+
+import (
+	"os"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// State is a terminal in raw mode, to be restored.
+type State struct {
+	fd   int
+	old  *term.State
+	once sync.Once
+	err  error
+}
+
+// Raw puts the first of files that is a terminal into raw mode. Stdin and
+// stdout are usually the same terminal, so one is enough. If none is a
+// terminal, Raw does nothing and returns nil, which Restore accepts.
+func Raw(files ...*os.File) (*State, error) {
+	for _, f := range files {
+		fd := int(f.Fd())
+		if !term.IsTerminal(fd) {
+			continue
+		}
+		old, err := term.MakeRaw(fd)
+		if err != nil {
+			return nil, err
+		}
+		return &State{fd: fd, old: old}, nil
+	}
+	return nil, nil
+}
+
+// Restore waits for pending output to go out and puts the terminal back
+// the way it was. Only the first call does anything, so it is safe to
+// call from a signal handler as well as on the way out.
+func (s *State) Restore() error {
+	if s == nil {
+		return nil
+	}
+	s.once.Do(func() {
+		drain(s.fd)
+		s.err = term.Restore(s.fd, s.old)
+	})
+	return s.err
+}
@@ -0,0 +1,76 @@
+package kermit
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// Attribute tags.
+const (
+	attrFileType = '"' // "B8" for 8-bit binary
+	attrDate     = '#' // Creation date, yyyymmdd hh:mm:ss local time
+	attrSizeK    = '!' // Size in K
+	attrSize     = '1' // Size in bytes
+)
+
+// dateLayouts are the date formats Kermit implementations send.
+var dateLayouts = []string{
+	"20060102 15:04:05",
+	"20060102 15:04",
+	"060102 15:04:05",
+	"20060102",
+	"060102",
+}
+
+// marshalAttributes encodes hdr's size and date for an A packet.
+func marshalAttributes(hdr *zmodem.FileHeader) []byte {
+	var out []byte
+	add := func(tag byte, value string) {
+		out = append(out, tag, tochar(len(value)))
+		out = append(out, value...)
+	}
+
+	add(attrFileType, "B8")
+	add(attrSize, strconv.FormatInt(hdr.Size, 10))
+	add(attrSizeK, strconv.FormatInt((hdr.Size+1023)/1024, 10))
+	if !hdr.ModTime.IsZero() {
+		add(attrDate, hdr.ModTime.Local().Format(dateLayouts[0]))
+	}
+	return out
+}
+
+// parseAttributes fills in hdr from A packet data. Unknown attributes are
+// ignored.
+func parseAttributes(hdr *zmodem.FileHeader, data []byte) {
+	sizeKnown := false
+	for i := 0; i+1 < len(data); {
+		tag, n := data[i], unchar(data[i+1])
+		i += 2
+		if n < 0 || i+n > len(data) {
+			return
+		}
+		value := string(data[i : i+n])
+		i += n
+
+		switch tag {
+		case attrSize:
+			if size, err := strconv.ParseInt(value, 10, 64); err == nil {
+				hdr.Size = size
+				sizeKnown = true
+			}
+		case attrSizeK:
+			if size, err := strconv.ParseInt(value, 10, 64); err == nil && !sizeKnown {
+				hdr.Size = size * 1024
+			}
+		case attrDate:
+			for _, layout := range dateLayouts {
+				if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+					hdr.ModTime = t
+					break
+				}
+			}
+		}
+	}
+}
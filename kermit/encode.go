@@ -0,0 +1,103 @@
+package kermit
+
+import "github.com/drunlade/go-lrzsz/zmodem"
+
+// encoder does Kermit's prefix encoding: control characters are sent as
+// QCTL and the printable character, bytes with the 8th bit set as QBIN and
+// the low 7 bits, and runs as REPT and a count. A zero prefix is unused.
+type encoder struct {
+	qctl byte
+	qbin byte
+	rept byte
+}
+
+// encode encodes as much of data as fits in limit bytes. It returns the
+// encoding and how many bytes of data it covers.
+func (e encoder) encode(data []byte, limit int) ([]byte, int) {
+	out := make([]byte, 0, min(limit, 2*len(data)+8))
+	i := 0
+	for i < len(data) {
+		b := data[i]
+		n := 1
+		if e.rept != 0 {
+			for i+n < len(data) && data[i+n] == b && n < 94 {
+				n++
+			}
+		}
+
+		enc := e.encodeByte(b)
+		if n >= 3 {
+			enc = append([]byte{e.rept, tochar(n)}, enc...)
+		} else {
+			n = 1
+		}
+		if len(out)+len(enc) > limit {
+			break
+		}
+		out = append(out, enc...)
+		i += n
+	}
+	return out, i
+}
+
+func (e encoder) encodeByte(b byte) []byte {
+	out := make([]byte, 0, 3)
+	if e.qbin != 0 && b&0x80 != 0 {
+		out = append(out, e.qbin)
+		b &= 0x7f
+	}
+	switch a := b & 0x7f; {
+	case a < 32 || a == 127:
+		out = append(out, e.qctl, ctl(b))
+	case a == e.qctl || (e.qbin != 0 && a == e.qbin) || (e.rept != 0 && a == e.rept):
+		out = append(out, e.qctl, b)
+	default:
+		out = append(out, b)
+	}
+	return out
+}
+
+// decode undoes encode.
+func (e encoder) decode(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	truncated := zmodem.NewError(zmodem.ErrInvalidFrame, "truncated prefix")
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		n := 1
+		if e.rept != 0 && c == e.rept {
+			if i+2 >= len(data) {
+				return nil, truncated
+			}
+			n = unchar(data[i+1])
+			i += 2
+			c = data[i]
+		}
+
+		var high byte
+		if e.qbin != 0 && c == e.qbin {
+			if i+1 >= len(data) {
+				return nil, truncated
+			}
+			high = 0x80
+			i++
+			c = data[i]
+		}
+
+		if c == e.qctl {
+			if i+1 >= len(data) {
+				return nil, truncated
+			}
+			i++
+			c = data[i]
+			if a := c & 0x7f; (a >= 0100 && a <= 0137) || a == 077 {
+				c = ctl(c)
+			}
+		}
+
+		c |= high
+		for range n {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,210 @@
+// Package kermit implements the Kermit file transfer protocol, for hosts
+// and devices that offer nothing else: all three block check types, long
+// packets, sliding windows, attribute packets (size and date), control and
+// 8th-bit prefixing and repeat counts.
+//
+// It shares its conventions with the zmodem package: the line is a
+// zmodem.ReaderWithTimeout and an io.Writer, timeouts are in tenths of a
+// second, errors are *zmodem.Error, and events go to zmodem.Callbacks.
+// Parameters are negotiated in the Send-Init exchange, so either side falls
+// back to what the other supports.
+package kermit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// Packet types.
+const (
+	TypeSendInit   = 'S'
+	TypeInit       = 'I' // Server mode Send-Init without a transfer
+	TypeFile       = 'F'
+	TypeAttributes = 'A'
+	TypeData       = 'D'
+	TypeEOF        = 'Z'
+	TypeBreak      = 'B' // End of transmission
+	TypeACK        = 'Y'
+	TypeNAK        = 'N'
+	TypeError      = 'E'
+)
+
+// Packet framing characters.
+const (
+	MARK = zmodem.SOH // Start of every packet
+	CR   = '\r'       // Default end of line
+)
+
+// Capability bits in the Send-Init CAPAS field.
+const (
+	capLongPackets = 0x02
+	capWindows     = 0x04
+	capAttributes  = 0x08
+)
+
+// Config holds Kermit configuration for both senders and receivers.
+type Config struct {
+	// CheckType is the block check to ask for: 1 (6-bit checksum),
+	// 2 (12-bit checksum) or 3 (CRC-16). Type 1 is used unless both sides
+	// ask for the same one.
+	CheckType int
+
+	// MaxLength is the longest packet to receive. Over 94 needs long
+	// packets, which are used if the other side supports them.
+	MaxLength int
+
+	// Window is the sliding window size, 1 to 31. 1 waits for each packet
+	// to be acknowledged.
+	Window int
+
+	// EighthBit asks for bytes with the 8th bit set to be prefixed, for
+	// 7-bit links. Prefixing is also used whenever the other side asks.
+	EighthBit bool
+
+	// Repeat compresses runs of the same byte.
+	Repeat bool
+
+	// Attributes sends file size and date in an attribute packet.
+	Attributes bool
+
+	// Timeout for each packet, in tenths of seconds
+	Timeout int
+
+	// MaxErrors is how many consecutive errors abort the transfer
+	MaxErrors int
+
+	Context          context.Context
+	Logger           zmodem.Logger
+	Callbacks        *zmodem.Callbacks
+	ProgressInterval time.Duration
+}
+
+// DefaultConfig returns a default configuration.
+func DefaultConfig() *Config {
+	return &Config{
+		CheckType:        3,
+		MaxLength:        4096,
+		Window:           8,
+		EighthBit:        false,
+		Repeat:           true,
+		Attributes:       true,
+		Timeout:          100, // 10 seconds
+		MaxErrors:        10,
+		Context:          context.Background(),
+		ProgressInterval: 100 * time.Millisecond,
+	}
+}
+
+// tochar makes a small number printable, unchar undoes it, and ctl toggles
+// a character between control and printable.
+func tochar(x int) byte { return byte(x + 32) }
+func unchar(c byte) int { return int(c) - 32 }
+func ctl(c byte) byte   { return c ^ 64 }
+
+// port does buffered, timed reads on the line.
+type port struct {
+	reader  zmodem.ReaderWithTimeout
+	writer  io.Writer
+	buf     []byte
+	pos     int
+	n       int
+	timeout time.Duration
+	ctx     context.Context
+}
+
+func newPort(reader zmodem.ReaderWithTimeout, writer io.Writer, timeout int, ctx context.Context) *port {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &port{
+		reader:  reader,
+		writer:  writer,
+		buf:     make([]byte, 8192),
+		timeout: time.Duration(timeout) * 100 * time.Millisecond,
+		ctx:     ctx,
+	}
+}
+
+// readByte reads a byte with the port's timeout. An expired deadline
+// becomes a zmodem timeout error.
+func (p *port) readByte() (byte, error) {
+	if p.pos < p.n {
+		b := p.buf[p.pos]
+		p.pos++
+		return b, nil
+	}
+
+	select {
+	case <-p.ctx.Done():
+		return 0, zmodem.NewError(zmodem.ErrCancelled, p.ctx.Err().Error())
+	default:
+	}
+
+	if p.timeout > 0 {
+		if err := p.reader.SetReadDeadline(time.Now().Add(p.timeout)); err != nil {
+			return 0, err
+		}
+	}
+
+	var n int
+	var err error
+	if cr, ok := p.reader.(zmodem.ContextReader); ok {
+		n, err = cr.ReadContext(p.ctx, p.buf)
+	} else {
+		n, err = p.reader.Read(p.buf)
+	}
+	if n > 0 {
+		p.pos, p.n = 1, n
+		return p.buf[0], nil
+	}
+	if err == nil {
+		err = io.ErrNoProgress
+	}
+	if isTimeout(err) {
+		return 0, zmodem.NewError(zmodem.ErrTimeout, "timeout")
+	}
+	if p.ctx.Err() != nil {
+		return 0, zmodem.NewError(zmodem.ErrCancelled, p.ctx.Err().Error())
+	}
+	return 0, err
+}
+
+// isTimeout reports whether err is an expired read deadline.
+func isTimeout(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) || zmodem.IsTimeout(err) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// progress rate limits OnProgress calls.
+type progress struct {
+	callbacks *zmodem.Callbacks
+	interval  time.Duration
+	name      string
+	total     int64
+	start     time.Time
+	last      time.Time
+}
+
+func newProgress(callbacks *zmodem.Callbacks, interval time.Duration, name string, total int64) *progress {
+	now := time.Now()
+	return &progress{callbacks: callbacks, interval: interval, name: name, total: total, start: now, last: now}
+}
+
+func (p *progress) report(done int64, final bool) {
+	now := time.Now()
+	if !final && (p.interval <= 0 || now.Sub(p.last) < p.interval) {
+		return
+	}
+	p.last = now
+	rate := float64(done) / max(now.Sub(p.start).Seconds(), 1e-9)
+	p.callbacks.OnProgress(p.name, done, p.total, rate)
+}
@@ -0,0 +1,255 @@
+package kermit
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// line is one direction of a link between two ends of a net.Pipe. Writes
+// are queued, since a sender with a window writes while the receiver
+// writes its ACKs, and net.Pipe writes wait for the reader.
+type line struct {
+	conn   net.Conn
+	queue  chan []byte
+	done   chan struct{}
+	filter func(p []byte) []byte // Applied to each write, in order
+
+	mu      sync.Mutex
+	packets [][]byte // Every write, as sent
+}
+
+func newLine(conn net.Conn, filter func([]byte) []byte) *line {
+	l := &line{conn: conn, queue: make(chan []byte, 4096), done: make(chan struct{}), filter: filter}
+	go func() {
+		defer close(l.done)
+		for p := range l.queue {
+			l.conn.Write(p)
+		}
+	}()
+	return l
+}
+
+func (l *line) Write(p []byte) (int, error) {
+	p = append([]byte(nil), p...)
+	l.mu.Lock()
+	l.packets = append(l.packets, p)
+	l.mu.Unlock()
+	if l.filter != nil {
+		p = l.filter(append([]byte(nil), p...))
+	}
+	l.queue <- p
+	return len(p), nil
+}
+
+func (l *line) close() {
+	close(l.queue)
+	l.conn.Close()
+	<-l.done
+}
+
+// types returns the packet types written, one byte per packet.
+func (l *line) types() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var types []byte
+	for _, p := range l.packets {
+		if i := bytes.IndexByte(p, MARK); i >= 0 && i+3 < len(p) {
+			types = append(types, p[i+3])
+		}
+	}
+	return string(types)
+}
+
+// transfer sends data as one file from a sender with sendConfig to a
+// receiver with recvConfig and returns what arrived. filter, if not nil,
+// sees every write of the sender.
+func transfer(t *testing.T, sendConfig, recvConfig *Config, data []byte, filter func([]byte) []byte) (*Sender, *line, *line, []byte, *zmodem.FileHeader) {
+	t.Helper()
+	a, b := net.Pipe()
+	toReceiver := newLine(a, filter)
+	toSender := newLine(b, nil)
+	defer toReceiver.close()
+	defer toSender.close()
+
+	var received bytes.Buffer
+	var got *zmodem.FileHeader
+	recvConfig.Callbacks = &zmodem.Callbacks{
+		OnFilePrompt: func(hdr *zmodem.FileHeader) (bool, error) {
+			got = hdr
+			return true, nil
+		},
+		OnFileCreate: func(hdr *zmodem.FileHeader) (io.Writer, error) {
+			return &received, nil
+		},
+	}
+	receiver := NewReceiver(b, toSender, recvConfig)
+	done := make(chan error, 1)
+	go func() {
+		done <- receiver.ReceiveFiles()
+	}()
+
+	sender := NewSender(a, toReceiver, sendConfig)
+	mtime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.Local)
+	hdr := &zmodem.FileHeader{Name: "data.bin", Size: int64(len(data)), ModTime: mtime}
+	if err := sender.SendFile(hdr, bytes.NewReader(data)); err != nil {
+		t.Fatalf("SendFile: %v", err)
+	}
+	if err := sender.Finish(); err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("ReceiveFiles: %v", err)
+	}
+	return sender, toReceiver, toSender, received.Bytes(), got
+}
+
+// testConfig returns a configuration with short timeouts.
+func testConfig() *Config {
+	config := DefaultConfig()
+	config.Timeout = 10
+	return config
+}
+
+func randomData(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	return data
+}
+
+func TestRoundTrip(t *testing.T) {
+	data := randomData(100 * 1024)
+	for chkt := 1; chkt <= 3; chkt++ {
+		send, recv := testConfig(), testConfig()
+		send.CheckType, recv.CheckType = chkt, chkt
+		sender, _, _, got, hdr := transfer(t, send, recv, data, nil)
+		if !bytes.Equal(got, data) {
+			t.Errorf("check type %d: received %d bytes that differ from the %d sent", chkt, len(got), len(data))
+		}
+		if sender.chkt != chkt {
+			t.Errorf("check type %d: used %d", chkt, sender.chkt)
+		}
+		if hdr == nil || hdr.Size != int64(len(data)) || hdr.ModTime.Unix() != time.Date(2021, 3, 4, 5, 6, 7, 0, time.Local).Unix() {
+			t.Errorf("check type %d: attributes arrived as %+v", chkt, hdr)
+		}
+	}
+}
+
+func TestLongPacketsAndWindows(t *testing.T) {
+	data := randomData(200 * 1024)
+	sender, toReceiver, _, got, _ := transfer(t, testConfig(), testConfig(), data, nil)
+	if !bytes.Equal(got, data) {
+		t.Errorf("received %d bytes that differ from the %d sent", len(got), len(data))
+	}
+	if sender.sendMax != 4096 || sender.window != 8 {
+		t.Errorf("packets up to %d in a window of %d, want 4096 and 8", sender.sendMax, sender.window)
+	}
+	for _, p := range toReceiver.packets {
+		if len(p) > 94+2 && p[1] != tochar(0) {
+			t.Errorf("a packet of %d bytes isn't a long packet", len(p))
+			break
+		}
+	}
+
+	// Without them on one side, both go back to the basics
+	recv := testConfig()
+	recv.MaxLength, recv.Window = 94, 1
+	sender, _, _, got, _ = transfer(t, testConfig(), recv, data[:10*1024], nil)
+	if !bytes.Equal(got, data[:10*1024]) {
+		t.Errorf("short packets: received %d bytes that differ from the %d sent", len(got), 10*1024)
+	}
+	if sender.sendMax != 94 || sender.window != 1 {
+		t.Errorf("short packets: up to %d in a window of %d, want 94 and 1", sender.sendMax, sender.window)
+	}
+}
+
+func TestEighthBitAndRepeat(t *testing.T) {
+	// Runs of every byte value, some long enough to repeat
+	var data []byte
+	for i := range 256 {
+		data = append(data, bytes.Repeat([]byte{byte(i)}, 1+i%100)...)
+	}
+
+	send, recv := testConfig(), testConfig()
+	recv.EighthBit = true
+	sender, toReceiver, _, got, _ := transfer(t, send, recv, data, nil)
+	if !bytes.Equal(got, data) {
+		t.Errorf("received %d bytes that differ from the %d sent", len(got), len(data))
+	}
+	if sender.enc.qbin != '&' || sender.enc.rept != '~' {
+		t.Errorf("prefixes %q and %q, want '&' and '~'", sender.enc.qbin, sender.enc.rept)
+	}
+	sent := 0
+	for _, p := range toReceiver.packets {
+		sent += len(p)
+		for _, b := range p {
+			if b&0x80 != 0 {
+				t.Fatalf("sent a byte with the 8th bit set: %#x", b)
+			}
+		}
+	}
+	if sent >= len(data) {
+		t.Errorf("sent %d bytes for %d, repeat counts don't compress", sent, len(data))
+	}
+}
+
+func TestCorruptedPacket(t *testing.T) {
+	data := randomData(50 * 1024)
+	writes := 0
+	corrupt := func(p []byte) []byte {
+		// The second data packet, after S, F and A
+		if writes++; writes == 5 {
+			p[len(p)/2] ^= 1
+		}
+		return p
+	}
+	_, toReceiver, toSender, got, _ := transfer(t, testConfig(), testConfig(), data, corrupt)
+	if !bytes.Equal(got, data) {
+		t.Errorf("received %d bytes that differ from the %d sent", len(got), len(data))
+	}
+	if !bytes.ContainsRune([]byte(toSender.types()), TypeNAK) {
+		t.Errorf("receiver sent %q, no NAK for the bad packet", toSender.types())
+	}
+	if types := toReceiver.types(); len(types) < 5 || types[:5] != "SFADD" {
+		t.Errorf("sender sent %q", types)
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	data := make([]byte, 0, 512)
+	for i := range 256 {
+		data = append(data, byte(i))
+	}
+	data = append(data, bytes.Repeat([]byte{'~'}, 100)...)
+	data = append(data, bytes.Repeat([]byte{0x81}, 5)...)
+
+	for _, e := range []encoder{
+		{qctl: '#'},
+		{qctl: '#', qbin: '&'},
+		{qctl: '#', rept: '~'},
+		{qctl: '#', qbin: '&', rept: '~'},
+	} {
+		var got []byte
+		for rest := data; len(rest) > 0; {
+			enc, n := e.encode(rest, 90)
+			if n == 0 || len(enc) > 90 {
+				t.Fatalf("%+v: encoded %d bytes into %d", e, n, len(enc))
+			}
+			dec, err := e.decode(enc)
+			if err != nil {
+				t.Fatalf("%+v: decode: %v", e, err)
+			}
+			got = append(got, dec...)
+			rest = rest[n:]
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%+v: round trip gave %q", e, got)
+		}
+	}
+}
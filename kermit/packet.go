@@ -0,0 +1,287 @@
+package kermit
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// maxLongPacket is the longest long packet the encoding allows.
+const maxLongPacket = 95*95 - 1
+
+// packet is a packet read off the line. data is still prefix encoded.
+type packet struct {
+	seq  int
+	typ  byte
+	data []byte
+}
+
+// errResync means a MARK turned up inside a packet, which starts a new one.
+var errResync = errors.New("kermit: packet interrupted")
+
+// link is the packet layer shared by senders and receivers: framing, block
+// checks and the parameters negotiated in the Send-Init exchange.
+type link struct {
+	port      *port
+	config    *Config
+	logger    zmodem.Logger
+	callbacks *zmodem.Callbacks
+
+	chkt    int  // Block check type, 1 until negotiated
+	sendMax int  // Longest packet the other side takes
+	window  int  // Sliding window size
+	attrs   bool // Attribute packets
+	eol     byte // Sent after each packet
+	npad    int  // Padding sent before each packet
+	padc    byte
+
+	enc encoder // Prefixes for what we send
+	dec encoder // Prefixes the other side uses
+}
+
+func newLink(reader zmodem.ReaderWithTimeout, writer io.Writer, config *Config) *link {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	logger := config.Logger
+	if logger == nil {
+		logger = zmodem.NoopLogger{}
+	}
+	return &link{
+		port:      newPort(reader, writer, config.Timeout, config.Context),
+		config:    config,
+		logger:    logger,
+		callbacks: zmodem.MergeCallbacks(config.Callbacks),
+		chkt:      1,
+		sendMax:   80,
+		window:    1,
+		eol:       CR,
+		enc:       encoder{qctl: '#'},
+		dec:       encoder{qctl: '#'},
+	}
+}
+
+// negotiate sets up the link from both sides' Send-Init parameters.
+func (l *link) negotiate(local, remote *params) {
+	l.chkt = 1
+	if local.chkt == remote.chkt {
+		l.chkt = local.chkt
+	}
+
+	capas := local.capas & remote.capas
+	l.sendMax = remote.maxLen
+	if capas&capLongPackets != 0 && remote.maxLenX > 0 {
+		l.sendMax = min(remote.maxLenX, maxLongPacket)
+	}
+	l.window = 1
+	if capas&capWindows != 0 {
+		l.window = max(1, min(local.window, remote.window))
+	}
+	l.attrs = capas&capAttributes != 0
+
+	l.eol = remote.eol
+	l.npad = remote.npad
+	l.padc = remote.padc
+
+	// The 8th-bit prefix is used if one side asks for it and the other
+	// agrees, and repeat counts if both offer the same prefix
+	var qbin byte
+	switch {
+	case isPrefix(local.qbin) && (remote.qbin == 'Y' || remote.qbin == local.qbin):
+		qbin = local.qbin
+	case isPrefix(remote.qbin) && local.qbin == 'Y':
+		qbin = remote.qbin
+	}
+	var rept byte
+	if isPrefix(local.rept) && local.rept == remote.rept {
+		rept = local.rept
+	}
+	l.enc = encoder{qctl: local.qctl, qbin: qbin, rept: rept}
+	l.dec = encoder{qctl: remote.qctl, qbin: qbin, rept: rept}
+
+	l.logger.Info("kermit: check type %d, packets up to %d, window %d, attributes %v, 8th-bit prefix %q, repeat prefix %q",
+		l.chkt, l.sendMax, l.window, l.attrs, qbin, rept)
+}
+
+// maxData returns how much encoded data fits in a packet.
+func (l *link) maxData() int {
+	if l.sendMax > 94 {
+		// MARK, LEN, SEQ, TYPE, LENX1, LENX2, HCHECK and the check
+		return l.sendMax - 7 - l.chkt
+	}
+	// MARK, LEN, SEQ, TYPE and the check
+	return l.sendMax - 4 - l.chkt
+}
+
+// writePacket sends a packet with the given block check type.
+func (l *link) writePacket(seq int, typ byte, data []byte, chkt int) error {
+	pkt := make([]byte, 0, l.npad+len(data)+16)
+	for range l.npad {
+		pkt = append(pkt, l.padc)
+	}
+	start := len(pkt) + 1
+
+	n := len(data) + chkt
+	if n+2 <= 94 {
+		pkt = append(pkt, MARK, tochar(n+2), tochar(seq), typ)
+	} else {
+		pkt = append(pkt, MARK, tochar(0), tochar(seq), typ, tochar(n/95), tochar(n%95))
+		pkt = append(pkt, blockCheck(1, pkt[start:])...)
+	}
+	pkt = append(pkt, data...)
+	pkt = append(pkt, blockCheck(chkt, pkt[start:])...)
+	pkt = append(pkt, l.eol)
+
+	l.logger.Debug("kermit: sending %c packet %d, %d bytes", typ, seq, len(data))
+	_, err := l.port.writer.Write(pkt)
+	return err
+}
+
+// readPacket reads the next packet off the line and checks it. Send-Init
+// packets always use block check type 1.
+func (l *link) readPacket() (*packet, error) {
+	for {
+		pkt, err := l.readFrame()
+		if err == errResync {
+			continue
+		}
+		if err == nil {
+			l.logger.Debug("kermit: received %c packet %d, %d bytes", pkt.typ, pkt.seq, len(pkt.data))
+		}
+		return pkt, err
+	}
+}
+
+func (l *link) readFrame() (*packet, error) {
+	for {
+		c, err := l.port.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if c == MARK {
+			break
+		}
+	}
+
+	next := func() (byte, error) {
+		c, err := l.port.readByte()
+		if err == nil && c == MARK {
+			return 0, errResync
+		}
+		return c, err
+	}
+
+	checked := make([]byte, 3, 16)
+	for i := range checked {
+		c, err := next()
+		if err != nil {
+			return nil, err
+		}
+		checked[i] = c
+	}
+	typ := checked[2]
+	seq := unchar(checked[1])
+	if seq < 0 || seq > 63 {
+		return nil, zmodem.NewError(zmodem.ErrInvalidFrame, "bad sequence number")
+	}
+
+	var rest int // Length of data and check
+	if n := unchar(checked[0]); n == 0 {
+		for range 3 {
+			c, err := next()
+			if err != nil {
+				return nil, err
+			}
+			checked = append(checked, c)
+		}
+		hcheck := checked[5]
+		checked = checked[:5]
+		if hcheck != blockCheck(1, checked)[0] {
+			return nil, zmodem.NewError(zmodem.ErrCRC, "bad header check")
+		}
+		checked = append(checked, hcheck)
+		rest = unchar(checked[3])*95 + unchar(checked[4])
+	} else {
+		rest = n - 2
+	}
+
+	chkt := l.chkt
+	if typ == TypeSendInit || typ == TypeInit {
+		chkt = 1
+	}
+	if rest < chkt || rest > maxLongPacket {
+		return nil, zmodem.NewError(zmodem.ErrInvalidFrame, "bad packet length")
+	}
+
+	buf := make([]byte, rest)
+	for i := range buf {
+		c, err := next()
+		if err != nil {
+			return nil, err
+		}
+		buf[i] = c
+	}
+	data, check := buf[:rest-chkt], buf[rest-chkt:]
+	if !bytes.Equal(check, blockCheck(chkt, append(checked, data...))) {
+		return nil, zmodem.NewError(zmodem.ErrCRC, "bad block check")
+	}
+	return &packet{seq: seq, typ: typ, data: data}, nil
+}
+
+// errorPacket sends an Error packet, which aborts the transfer on the
+// other side.
+func (l *link) errorPacket(seq int, msg string) {
+	data, _ := l.enc.encode([]byte(msg), l.maxData())
+	l.writePacket(seq, TypeError, data, l.chkt)
+}
+
+// remoteError turns an Error packet into an error.
+func (l *link) remoteError(pkt *packet) error {
+	msg, _ := l.dec.decode(pkt.data)
+	return zmodem.NewError(zmodem.ErrCancelled, "remote error: "+string(msg))
+}
+
+// blockCheck returns the block check of the given type for s.
+func blockCheck(chkt int, s []byte) []byte {
+	switch chkt {
+	case 2:
+		sum := checksum(s) & 07777
+		return []byte{tochar((sum >> 6) & 077), tochar(sum & 077)}
+	case 3:
+		crc := int(crc16(s))
+		return []byte{tochar((crc >> 12) & 017), tochar((crc >> 6) & 077), tochar(crc & 077)}
+	}
+	sum := checksum(s)
+	return []byte{tochar((sum + ((sum & 0300) >> 6)) & 077)}
+}
+
+func checksum(s []byte) int {
+	sum := 0
+	for _, b := range s {
+		sum += int(b)
+	}
+	return sum
+}
+
+// crc16 returns Kermit's CRC-16 (CCITT, reversed) of s.
+func crc16(s []byte) uint16 {
+	var crc uint16
+	for _, b := range s {
+		crc ^= uint16(b)
+		for range 8 {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0x8408
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// nextSeq returns the sequence number after seq.
+func nextSeq(seq int) int {
+	return (seq + 1) & 63
+}
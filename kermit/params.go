@@ -0,0 +1,146 @@
+package kermit
+
+// params are the Send-Init parameters one side sends in its S packet or in
+// the ACK to one.
+type params struct {
+	maxLen  int  // MAXL: longest normal packet it takes
+	timeout int  // TIME: seconds it wants us to wait
+	npad    int  // NPAD: padding it needs before each packet
+	padc    byte // PADC
+	eol     byte // EOL: what it needs after each packet
+	qctl    byte // QCTL: its control prefix
+	qbin    byte // QBIN: its 8th-bit prefix, 'Y' (will if asked) or 'N'
+	chkt    int  // CHKT: block check type
+	rept    byte // REPT: its repeat prefix, ' ' for none
+	capas   byte // CAPAS: capability bits
+	window  int  // WINDO: window size
+	maxLenX int  // MAXLX1, MAXLX2: longest long packet it takes
+}
+
+// localParams returns our parameters for config.
+func localParams(config *Config) *params {
+	p := &params{
+		maxLen:  min(config.MaxLength, 94),
+		timeout: max(config.Timeout/10, 1),
+		eol:     CR,
+		qctl:    '#',
+		qbin:    'Y',
+		chkt:    config.CheckType,
+		rept:    ' ',
+		window:  1,
+	}
+	if p.chkt < 1 || p.chkt > 3 {
+		p.chkt = 1
+	}
+	if config.EighthBit {
+		p.qbin = '&'
+	}
+	if config.Repeat {
+		p.rept = '~'
+	}
+	if config.MaxLength > 94 {
+		p.capas |= capLongPackets
+		p.maxLenX = min(config.MaxLength, maxLongPacket)
+	}
+	if config.Window > 1 {
+		p.capas |= capWindows
+		p.window = min(config.Window, 31)
+	}
+	if config.Attributes {
+		p.capas |= capAttributes
+	}
+	return p
+}
+
+// marshal encodes the parameters for an S packet or its ACK. They're sent
+// as is, without prefixing.
+func (p *params) marshal() []byte {
+	return []byte{
+		tochar(p.maxLen),
+		tochar(p.timeout),
+		tochar(p.npad),
+		ctl(p.padc),
+		tochar(int(p.eol)),
+		p.qctl,
+		p.qbin,
+		'0' + byte(p.chkt),
+		p.rept,
+		tochar(int(p.capas)),
+		tochar(p.window),
+		tochar(p.maxLenX / 95),
+		tochar(p.maxLenX % 95),
+	}
+}
+
+// parseParams decodes the other side's parameters. Missing fields get the
+// protocol defaults, so old implementations that send fewer still work.
+func parseParams(data []byte) *params {
+	p := &params{
+		maxLen:  80,
+		timeout: 5,
+		eol:     CR,
+		qctl:    '#',
+		qbin:    'N',
+		chkt:    1,
+		rept:    ' ',
+		window:  1,
+	}
+	field := func(i int) (byte, bool) {
+		if i < len(data) && data[i] != ' ' {
+			return data[i], true
+		}
+		return 0, false
+	}
+
+	if c, ok := field(0); ok {
+		p.maxLen = min(max(unchar(c), 10), 94)
+	}
+	if c, ok := field(1); ok {
+		p.timeout = unchar(c)
+	}
+	if c, ok := field(2); ok {
+		p.npad = unchar(c)
+	}
+	if c, ok := field(3); ok {
+		p.padc = ctl(c)
+	}
+	if c, ok := field(4); ok {
+		p.eol = byte(unchar(c))
+	}
+	if c, ok := field(5); ok {
+		p.qctl = c
+	}
+	if c, ok := field(6); ok {
+		p.qbin = c
+	}
+	if c, ok := field(7); ok && c >= '1' && c <= '3' {
+		p.chkt = int(c - '0')
+	}
+	if c, ok := field(8); ok {
+		p.rept = c
+	}
+
+	// CAPAS may run over several characters, the low bit of each saying
+	// another follows; the fields after it count from its end
+	i := 9
+	if c, ok := field(i); ok {
+		p.capas = byte(unchar(c))
+		for unchar(data[i])&1 != 0 && i+1 < len(data) {
+			i++
+		}
+	}
+	if c, ok := field(i + 1); ok {
+		p.window = unchar(c)
+	}
+	x1, ok1 := field(i + 2)
+	x2, ok2 := field(i + 3)
+	if ok1 && ok2 {
+		p.maxLenX = unchar(x1)*95 + unchar(x2)
+	}
+	return p
+}
+
+// isPrefix reports whether c can be used as an 8th-bit or repeat prefix.
+func isPrefix(c byte) bool {
+	return (c > 32 && c < 63) || (c > 95 && c < 127)
+}
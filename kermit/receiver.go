@@ -0,0 +1,269 @@
+package kermit
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// Receiver receives files with Kermit.
+type Receiver struct {
+	*link
+
+	seq      int             // Sequence number of the next packet
+	acks     [64][]byte      // Data of the last ACK sent for each sequence number
+	buffered map[int]*packet // Packets received ahead of seq
+}
+
+// incoming is the file being received.
+type incoming struct {
+	hdr      *zmodem.FileHeader
+	w        io.Writer
+	created  *os.File // Set if the receiver created the file itself
+	prompted bool
+	refused  bool
+	written  int64
+	start    time.Time
+	prog     *progress
+}
+
+// NewReceiver creates a new Kermit receiver.
+func NewReceiver(reader zmodem.ReaderWithTimeout, writer io.Writer, config *Config) *Receiver {
+	return &Receiver{
+		link:     newLink(reader, writer, config),
+		buffered: make(map[int]*packet),
+	}
+}
+
+// ReceiveFiles receives files until the sender ends the batch. Files are
+// offered to OnFilePrompt, once their attributes are in, and written with
+// OnFileCreate if it's set, or created in the current directory under
+// their LocalName otherwise.
+func (r *Receiver) ReceiveFiles() error {
+	var file *incoming
+	for {
+		pkt, err := r.next()
+		if err != nil {
+			r.abort(file)
+			r.callbacks.OnError(err, "receive files")
+			return err
+		}
+
+		var reply []byte
+		switch pkt.typ {
+		case TypeSendInit, TypeInit:
+			local := localParams(r.config)
+			r.negotiate(local, parseParams(pkt.data))
+			r.ack(pkt.seq, local.marshal(), 1)
+			r.seq = nextSeq(r.seq)
+			continue
+
+		case TypeFile:
+			r.abort(file)
+			name, err := r.dec.decode(pkt.data)
+			if err != nil {
+				return r.fail(file, pkt.seq, err)
+			}
+			file = &incoming{hdr: &zmodem.FileHeader{Name: string(name)}}
+			reply = pkt.data
+
+		case TypeAttributes:
+			if file == nil {
+				return r.fail(file, pkt.seq, zmodem.NewError(zmodem.ErrProtocol, "attributes without a file"))
+			}
+			attrs, err := r.dec.decode(pkt.data)
+			if err != nil {
+				return r.fail(file, pkt.seq, err)
+			}
+			parseAttributes(file.hdr, attrs)
+			if err := r.open(file); err != nil {
+				return r.fail(file, pkt.seq, err)
+			}
+			reply = []byte("Y")
+			if file.refused {
+				reply = []byte("N")
+			}
+
+		case TypeData:
+			if file == nil {
+				return r.fail(file, pkt.seq, zmodem.NewError(zmodem.ErrProtocol, "data without a file"))
+			}
+			if err := r.open(file); err != nil {
+				return r.fail(file, pkt.seq, err)
+			}
+			if file.refused {
+				// Ask the sender to stop sending this file
+				reply = []byte("X")
+				break
+			}
+			data, err := r.dec.decode(pkt.data)
+			if err != nil {
+				return r.fail(file, pkt.seq, err)
+			}
+			n, err := file.w.Write(data)
+			file.written += int64(n)
+			if err != nil {
+				return r.fail(file, pkt.seq, err)
+			}
+			file.prog.report(file.written, false)
+
+		case TypeEOF:
+			if file == nil {
+				break
+			}
+			if err := r.open(file); err != nil {
+				return r.fail(file, pkt.seq, err)
+			}
+			discard := len(pkt.data) > 0 && pkt.data[0] == 'D'
+			r.finish(file, discard)
+			file = nil
+
+		case TypeBreak:
+			r.ack(pkt.seq, nil, r.chkt)
+			return nil
+		}
+
+		r.ack(pkt.seq, reply, r.chkt)
+		r.seq = nextSeq(r.seq)
+	}
+}
+
+// next returns the next packet in sequence. Packets ahead of it within the
+// window are acknowledged and kept, and the ones missing before them asked
+// for again. Repeated old packets get their ACK again.
+func (r *Receiver) next() (*packet, error) {
+	for errors := 0; ; {
+		if pkt, ok := r.buffered[r.seq]; ok {
+			delete(r.buffered, r.seq)
+			return pkt, nil
+		}
+
+		pkt, err := r.readPacket()
+		if err != nil {
+			if !retryable(err) {
+				return nil, err
+			}
+			r.logger.Error("kermit: packet %d: %v", r.seq, err)
+			if errors++; errors > r.config.MaxErrors {
+				r.errorPacket(r.seq, "too many retries")
+				return nil, err
+			}
+			r.writePacket(r.seq, TypeNAK, nil, r.chkt)
+			continue
+		}
+		if pkt.typ == TypeError {
+			return nil, r.remoteError(pkt)
+		}
+
+		switch d := (pkt.seq - r.seq) & 63; {
+		case d == 0:
+			return pkt, nil
+		case d < r.window:
+			r.buffered[pkt.seq] = pkt
+			r.ack(pkt.seq, nil, r.chkt)
+			for seq := r.seq; seq != pkt.seq; seq = nextSeq(seq) {
+				if _, ok := r.buffered[seq]; !ok {
+					r.writePacket(seq, TypeNAK, nil, r.chkt)
+				}
+			}
+		case d >= 32:
+			// Our ACK got lost
+			chkt := r.chkt
+			if pkt.typ == TypeSendInit || pkt.typ == TypeInit {
+				chkt = 1
+			}
+			r.writePacket(pkt.seq, TypeACK, r.acks[pkt.seq], chkt)
+		}
+	}
+}
+
+// ack acknowledges packet seq with data.
+func (r *Receiver) ack(seq int, data []byte, chkt int) {
+	r.acks[seq] = data
+	r.writePacket(seq, TypeACK, data, chkt)
+}
+
+// fail aborts the transfer with an Error packet.
+func (r *Receiver) fail(file *incoming, seq int, err error) error {
+	r.abort(file)
+	r.errorPacket(seq, err.Error())
+	r.callbacks.OnError(err, "receive file")
+	return err
+}
+
+// open offers the file to OnFilePrompt and creates it, once.
+func (r *Receiver) open(file *incoming) error {
+	if file.prompted {
+		return nil
+	}
+	file.prompted = true
+
+	accept, err := r.callbacks.OnFilePrompt(file.hdr)
+	if err != nil {
+		return err
+	}
+	var name string
+	if accept && r.callbacks.OnFileCreate == nil {
+		// Not wherever the sender's path points
+		if name, err = file.hdr.LocalName(); err != nil {
+			r.logger.Error("kermit: %v", err)
+			accept = false
+		}
+	}
+	if !accept {
+		r.logger.Info("kermit: refusing %s", file.hdr.Name)
+		file.refused = true
+		return nil
+	}
+
+	if r.callbacks.OnFileCreate != nil {
+		file.w, err = r.callbacks.OnFileCreate(file.hdr)
+	} else {
+		file.created, err = os.Create(name)
+		file.w = file.created
+	}
+	if err != nil {
+		return err
+	}
+
+	r.callbacks.OnFileStart(file.hdr)
+	file.start = time.Now()
+	file.prog = newProgress(r.callbacks, r.config.ProgressInterval, file.hdr.Name, file.hdr.Size)
+	return nil
+}
+
+// finish closes a received file, or throws it away if the sender said to.
+func (r *Receiver) finish(file *incoming, discard bool) {
+	if file.refused {
+		return
+	}
+	if closer, ok := file.w.(io.Closer); ok {
+		closer.Close()
+	}
+
+	if discard {
+		r.logger.Info("kermit: sender interrupted %s", file.hdr.Name)
+		if file.created != nil {
+			os.Remove(file.created.Name())
+		}
+		return
+	}
+	if file.created != nil && !file.hdr.ModTime.IsZero() {
+		os.Chtimes(file.created.Name(), file.hdr.ModTime, file.hdr.ModTime)
+	}
+
+	file.prog.report(file.written, true)
+	r.callbacks.OnFileComplete(file.hdr.Name, file.written, time.Since(file.start))
+}
+
+// abort closes a file left incomplete.
+func (r *Receiver) abort(file *incoming) {
+	if file == nil || file.w == nil {
+		return
+	}
+	if closer, ok := file.w.(io.Closer); ok {
+		closer.Close()
+	}
+}
@@ -0,0 +1,358 @@
+package kermit
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// Sender sends files with Kermit.
+type Sender struct {
+	*link
+
+	seq     int  // Sequence number of the next packet
+	started bool // Set once Send-Init has been exchanged
+	stopped bool // Set when the receiver interrupts the batch
+}
+
+// slot is a data packet sent in the window and not yet acknowledged.
+type slot struct {
+	seq   int
+	data  []byte
+	acked bool
+}
+
+// NewSender creates a new Kermit sender.
+func NewSender(reader zmodem.ReaderWithTimeout, writer io.Writer, config *Config) *Sender {
+	return &Sender{link: newLink(reader, writer, config)}
+}
+
+// Start exchanges Send-Init parameters with the receiver. SendFile calls it
+// if it hasn't been.
+func (s *Sender) Start() error {
+	if s.started {
+		return nil
+	}
+	local := localParams(s.config)
+	ack, err := s.exchange(TypeSendInit, local.marshal())
+	if err != nil {
+		return err
+	}
+	s.negotiate(local, parseParams(ack))
+	s.started = true
+	return nil
+}
+
+// exchange sends a packet and waits for it to be acknowledged, resending it
+// as needed. It returns the ACK's data.
+func (s *Sender) exchange(typ byte, data []byte) ([]byte, error) {
+	chkt := s.chkt
+	if typ == TypeSendInit || typ == TypeInit {
+		chkt = 1
+	}
+
+	seq := s.seq
+	for errors := 0; ; {
+		if err := s.writePacket(seq, typ, data, chkt); err != nil {
+			return nil, err
+		}
+
+		ack, err := s.waitAck(seq, typ != TypeSendInit && typ != TypeInit)
+		if err == nil {
+			s.seq = nextSeq(seq)
+			return ack, nil
+		}
+		if !retryable(err) {
+			return nil, err
+		}
+		s.logger.Error("kermit: %c packet %d: %v", typ, seq, err)
+		if errors++; errors > s.config.MaxErrors {
+			s.errorPacket(seq, "too many retries")
+			return nil, err
+		}
+	}
+}
+
+// waitAck waits for the ACK to packet seq. Stale ACKs are skipped. A NAK
+// for the next packet also acknowledges this one, except for Send-Init,
+// whose ACK carries the receiver's parameters.
+func (s *Sender) waitAck(seq int, nakIsAck bool) ([]byte, error) {
+	for {
+		pkt, err := s.readPacket()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case pkt.typ == TypeACK && pkt.seq == seq:
+			return pkt.data, nil
+		case pkt.typ == TypeNAK && pkt.seq == nextSeq(seq) && nakIsAck:
+			return nil, nil
+		case pkt.typ == TypeNAK:
+			return nil, zmodem.NewError(zmodem.ErrCRC, "packet rejected")
+		case pkt.typ == TypeError:
+			return nil, s.remoteError(pkt)
+		}
+	}
+}
+
+// SendFile sends one file of a batch: its name, its attributes if the
+// receiver takes them, its data and the end of file.
+func (s *Sender) SendFile(hdr *zmodem.FileHeader, file io.Reader) error {
+	if err := s.Start(); err != nil {
+		s.callbacks.OnError(err, "send init")
+		return err
+	}
+
+	s.callbacks.OnFileStart(hdr)
+	start := time.Now()
+
+	sent, err := s.sendFile(hdr, file)
+	if err != nil {
+		if !zmodem.IsFileSkipped(err) {
+			s.callbacks.OnError(err, "send file")
+		}
+		return err
+	}
+
+	s.callbacks.OnFileComplete(hdr.Name, sent, time.Since(start))
+	return nil
+}
+
+func (s *Sender) sendFile(hdr *zmodem.FileHeader, file io.Reader) (int64, error) {
+	name, _ := s.enc.encode([]byte(hdr.Name), s.maxData())
+	if _, err := s.exchange(TypeFile, name); err != nil {
+		return 0, err
+	}
+
+	if s.attrs {
+		attrs, _ := s.enc.encode(marshalAttributes(hdr), s.maxData())
+		ack, err := s.exchange(TypeAttributes, attrs)
+		if err != nil {
+			return 0, err
+		}
+		if len(ack) > 0 && ack[0] == 'N' {
+			s.logger.Info("kermit: receiver refused %s", hdr.Name)
+			if _, err := s.exchange(TypeEOF, []byte("D")); err != nil {
+				return 0, err
+			}
+			return 0, zmodem.NewError(zmodem.ErrFileSkipped, "receiver refused "+hdr.Name)
+		}
+	}
+
+	sent, err := s.sendData(hdr, file)
+	interrupted := zmodem.IsFileSkipped(err)
+	if err != nil && !interrupted {
+		return sent, err
+	}
+
+	var eof []byte
+	if interrupted {
+		// Tell the receiver to discard what it got
+		eof = []byte("D")
+	}
+	if _, err := s.exchange(TypeEOF, eof); err != nil {
+		return sent, err
+	}
+	if interrupted {
+		return sent, zmodem.NewError(zmodem.ErrFileSkipped, "receiver interrupted "+hdr.Name)
+	}
+	return sent, nil
+}
+
+// sendData sends the file as Data packets, keeping up to a window of them
+// in flight. Rejected packets are resent on their own; a timeout resends
+// the oldest one.
+func (s *Sender) sendData(hdr *zmodem.FileHeader, file io.Reader) (int64, error) {
+	prog := newProgress(s.callbacks, s.config.ProgressInterval, hdr.Name, hdr.Size)
+	maxData := s.maxData()
+
+	var queue []*slot
+	var raw []byte
+	var sent int64
+	buf := make([]byte, 32*1024)
+	eof := false
+
+	// next encodes the next packet's worth of the file, nil at the end
+	next := func() ([]byte, error) {
+		for !eof && len(raw) < 4*maxData {
+			n, err := file.Read(buf)
+			raw = append(raw, buf[:n]...)
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		if len(raw) == 0 {
+			return nil, nil
+		}
+		data, n := s.enc.encode(raw, maxData)
+		raw = append(raw[:0], raw[n:]...)
+		sent += int64(n)
+		return data, nil
+	}
+
+	find := func(seq int) *slot {
+		for _, sl := range queue {
+			if sl.seq == seq {
+				return sl
+			}
+		}
+		return nil
+	}
+
+	for errors := 0; ; {
+		// Fill the window
+		for len(queue) < s.window {
+			data, err := next()
+			if err != nil {
+				s.errorPacket(s.seq, err.Error())
+				return sent, err
+			}
+			if data == nil {
+				break
+			}
+			sl := &slot{seq: s.seq, data: data}
+			s.seq = nextSeq(s.seq)
+			if err := s.writePacket(sl.seq, TypeData, sl.data, s.chkt); err != nil {
+				return sent, err
+			}
+			queue = append(queue, sl)
+			prog.report(sent, false)
+		}
+		if len(queue) == 0 {
+			prog.report(sent, true)
+			return sent, nil
+		}
+
+		pkt, err := s.readPacket()
+		if err != nil {
+			if !retryable(err) {
+				return sent, err
+			}
+			s.logger.Error("kermit: waiting for ACK: %v", err)
+			if errors++; errors > s.config.MaxErrors {
+				s.errorPacket(queue[0].seq, "too many retries")
+				return sent, err
+			}
+			if zmodem.IsTimeout(err) {
+				if err := s.writePacket(queue[0].seq, TypeData, queue[0].data, s.chkt); err != nil {
+					return sent, err
+				}
+			}
+			continue
+		}
+
+		switch pkt.typ {
+		case TypeACK:
+			if sl := find(pkt.seq); sl != nil {
+				sl.acked = true
+				errors = 0
+			}
+			if len(pkt.data) > 0 && (pkt.data[0] == 'X' || pkt.data[0] == 'Z') {
+				s.stopped = pkt.data[0] == 'Z'
+				return sent, zmodem.NewError(zmodem.ErrFileSkipped, "receiver interrupted the file")
+			}
+		case TypeNAK:
+			if sl := find(pkt.seq); sl != nil {
+				if err := s.writePacket(sl.seq, TypeData, sl.data, s.chkt); err != nil {
+					return sent, err
+				}
+			} else if pkt.seq == s.seq {
+				// The receiver has everything before the next packet
+				for _, sl := range queue {
+					sl.acked = true
+				}
+			}
+			if errors++; errors > s.config.MaxErrors {
+				s.errorPacket(queue[0].seq, "too many retries")
+				return sent, zmodem.NewError(zmodem.ErrCRC, "too many packets rejected")
+			}
+		case TypeError:
+			return sent, s.remoteError(pkt)
+		}
+
+		// Slide the window
+		for len(queue) > 0 && queue[0].acked {
+			queue = queue[1:]
+		}
+	}
+}
+
+// Finish ends the batch.
+func (s *Sender) Finish() error {
+	if err := s.Start(); err != nil {
+		return err
+	}
+	_, err := s.exchange(TypeBreak, nil)
+	return err
+}
+
+// SendFiles sends files as one batch and ends it. Files are opened with
+// OnFileOpen if it's set. Files the receiver refuses are skipped.
+func (s *Sender) SendFiles(files []zmodem.FileInfo) error {
+	for _, f := range files {
+		file, info, err := s.open(f)
+		if err != nil {
+			s.callbacks.OnError(err, "open file")
+			continue
+		}
+
+		hdr := zmodem.NewFileHeader(filepath.Base(f.Filename), info)
+		err = s.SendFile(hdr, file)
+		if closer, ok := file.(io.Closer); ok {
+			closer.Close()
+		}
+		if zmodem.IsFileSkipped(err) {
+			if s.stopped {
+				break
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.Finish()
+}
+
+// open opens a file for SendFiles.
+func (s *Sender) open(f zmodem.FileInfo) (io.Reader, os.FileInfo, error) {
+	if s.callbacks.OnFileOpen != nil {
+		file, info, err := s.callbacks.OnFileOpen(f.Filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		if info == nil {
+			info = f.Info
+		}
+		if info == nil {
+			return nil, nil, zmodem.NewError(zmodem.ErrIO, "no file info for "+f.Filename)
+		}
+		return file, info, nil
+	}
+
+	file, err := os.Open(f.Filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, info, nil
+}
+
+// retryable reports whether err is worth resending a packet for.
+func retryable(err error) bool {
+	if zmodem.IsTimeout(err) || zmodem.IsCRC(err) {
+		return true
+	}
+	e, ok := err.(*zmodem.Error)
+	return ok && e.Type == zmodem.ErrInvalidFrame
+}
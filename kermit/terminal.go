@@ -0,0 +1,137 @@
+package kermit
+
+import (
+	"os"
+
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// TerminalProtocol detects Kermit in terminal output, for
+// zmodem.WithTerminalProtocol. A Send-Init packet means the remote is
+// sending (kermit -s) and files are received. A NAK means the remote is
+// waiting to receive (kermit -r, or a server waiting for commands) and the
+// files OnFileList returns are sent. Only packets with a valid block check
+// count, so normal text doesn't start transfers.
+type TerminalProtocol struct {
+	config *Config
+
+	buf  []byte // Output since the last MARK
+	held []byte // Start of the detected packet from earlier output
+	typ  byte   // Type of the detected packet
+}
+
+// maxDetectPacket is the longest packet detection looks at; Send-Init and
+// NAK packets are short.
+const maxDetectPacket = 96
+
+// NewTerminalProtocol creates a TerminalProtocol. A nil config uses
+// DefaultConfig; its Context, Logger and Callbacks are replaced by those of
+// the TerminalIO.
+func NewTerminalProtocol(config *Config) *TerminalProtocol {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	return &TerminalProtocol{config: config}
+}
+
+// Name implements zmodem.TerminalProtocol.
+func (d *TerminalProtocol) Name() string {
+	return "Kermit"
+}
+
+// Detect implements zmodem.TerminalProtocol.
+func (d *TerminalProtocol) Detect(p []byte) int {
+	start := -1
+	for i, c := range p {
+		switch {
+		case c == MARK:
+			d.buf = append(d.buf[:0], c)
+			start = i
+			continue
+		case len(d.buf) == 0:
+			continue
+		}
+
+		d.buf = append(d.buf, c)
+		n := unchar(d.buf[1])
+		if n < 3 || n > maxDetectPacket-2 {
+			d.buf = d.buf[:0]
+			continue
+		}
+		if len(d.buf) < n+2 {
+			continue
+		}
+
+		pkt := d.buf
+		d.buf = d.buf[:0]
+		typ := pkt[3]
+		if typ != TypeSendInit && typ != TypeNAK {
+			continue
+		}
+		if pkt[n+1] != blockCheck(1, pkt[1:n+1])[0] {
+			continue
+		}
+
+		d.typ = typ
+		if start >= 0 {
+			d.held = nil
+			return start
+		}
+		// The packet started in earlier output
+		d.held = append([]byte(nil), pkt[:len(pkt)-(i+1)]...)
+		return 0
+	}
+	return -1
+}
+
+// Transfer implements zmodem.TerminalProtocol.
+func (d *TerminalProtocol) Transfer(t *zmodem.TerminalTransfer) error {
+	config := *d.config
+	config.Context = t.Context
+	config.Logger = t.Logger
+	config.Callbacks = t.Callbacks
+
+	if d.typ == TypeSendInit {
+		reader := &prefixReader{held: d.held, ReaderWithTimeout: t.Reader}
+		d.held = nil
+		return NewReceiver(reader, t.Writer, &config).ReceiveFiles()
+	}
+
+	sender := NewSender(t.Reader, t.Writer, &config)
+	var files []string
+	if t.Callbacks.OnFileList != nil {
+		list, err := t.Callbacks.OnFileList()
+		if err != nil {
+			sender.errorPacket(0, err.Error())
+			return err
+		}
+		files = list
+	}
+	if len(files) == 0 {
+		t.Logger.Info("kermit: no files to send, cancelling receiver")
+		sender.errorPacket(0, "no files to send")
+		return nil
+	}
+
+	infos := make([]zmodem.FileInfo, 0, len(files))
+	for _, f := range files {
+		info, _ := os.Stat(f)
+		infos = append(infos, zmodem.FileInfo{Filename: f, Info: info})
+	}
+	return sender.SendFiles(infos)
+}
+
+// prefixReader returns held before reading the line.
+type prefixReader struct {
+	held []byte
+	zmodem.ReaderWithTimeout
+}
+
+func (r *prefixReader) Read(p []byte) (int, error) {
+	if len(r.held) > 0 {
+		n := copy(p, r.held)
+		r.held = r.held[n:]
+		return n, nil
+	}
+	return r.ReaderWithTimeout.Read(p)
+}
@@ -0,0 +1,57 @@
+package modem
+
+import (
+	"time"
+)
+
+// Conn is a connected call. Close hangs up.
+type Conn struct {
+	modem  *Modem
+	speed  int
+	result string
+	held   []byte // Data that came in with the CONNECT result
+}
+
+// Speed returns the speed from the CONNECT result, or 0 if the modem
+// didn't report one.
+func (c *Conn) Speed() int {
+	return c.speed
+}
+
+// Result returns the CONNECT result line, like "CONNECT 33600/ARQ/V34".
+func (c *Conn) Result() string {
+	return c.result
+}
+
+// Read reads from the remote end.
+func (c *Conn) Read(p []byte) (int, error) {
+	if len(c.held) > 0 {
+		n := copy(p, c.held)
+		c.held = c.held[n:]
+		return n, nil
+	}
+	return c.modem.line.Read(p)
+}
+
+// Write writes to the remote end.
+func (c *Conn) Write(p []byte) (int, error) {
+	n, err := c.modem.line.Write(p)
+	c.modem.mu.Lock()
+	c.modem.lastSent = time.Now()
+	c.modem.mu.Unlock()
+	return n, err
+}
+
+// SetReadDeadline sets the read deadline of the line. On lines without
+// deadlines it does nothing.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	if d, ok := c.modem.line.(deadliner); ok {
+		return d.SetReadDeadline(t)
+	}
+	return nil
+}
+
+// Close hangs up.
+func (c *Conn) Close() error {
+	return c.modem.Hangup()
+}
@@ -0,0 +1,336 @@
+// Package modem drives Hayes-compatible modems for dial-up transfers. A
+// Modem sits on a serial line, typically a serial.Port: it sends the init
+// strings, dials with ATDT and returns a Conn once the modem reports
+// CONNECT. A Conn is a zmodem.ReaderWithTimeout, so it can be handed to
+// zmodem.NewSession or any of the other protocols. Closing it escapes back
+// to command mode and hangs up.
+//
+// Verbose (ATV1) and numeric (ATV0) result codes are both understood.
+// Failed calls return ErrBusy, ErrNoCarrier, ErrNoDialtone or ErrNoAnswer.
+//
+// Timeouts need a line with SetReadDeadline, like serial.Port or a
+// net.Conn; on other lines the modem is waited for until it answers.
+package modem
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// Config holds modem settings.
+type Config struct {
+	// Init is sent before dialling, one command at a time, each waiting
+	// for OK
+	Init []string
+
+	// DialCommand is put before the number, ATDT for tone dialling
+	DialCommand string
+
+	// CommandTimeout is how long to wait for the result of a command
+	CommandTimeout time.Duration
+
+	// ConnectTimeout is how long to wait for the result of dialling
+	ConnectTimeout time.Duration
+
+	// Escape is the escape sequence back to command mode, three times
+	// the S2 character
+	Escape string
+
+	// GuardTime is the silence needed before and after Escape, from the
+	// S12 register
+	GuardTime time.Duration
+
+	// Context cancels dialling and commands
+	Context context.Context
+
+	// Logger for debug output
+	Logger zmodem.Logger
+}
+
+// DefaultConfig returns settings for most Hayes-compatible modems: reset,
+// then no echo, verbose result codes with speeds, and DCD following
+// carrier with hangup on DTR drop.
+func DefaultConfig() *Config {
+	return &Config{
+		Init:           []string{"ATZ", "ATE0 V1 Q0 X4 &C1 &D2"},
+		DialCommand:    "ATDT",
+		CommandTimeout: 5 * time.Second,
+		ConnectTimeout: 60 * time.Second,
+		Escape:         "+++",
+		GuardTime:      time.Second,
+	}
+}
+
+// deadliner is a line with read deadlines.
+type deadliner interface {
+	SetReadDeadline(time.Time) error
+}
+
+// Modem is a Hayes-compatible modem on a serial line.
+type Modem struct {
+	line   io.ReadWriter
+	config *Config
+	logger zmodem.Logger
+	ctx    context.Context
+
+	buf      []byte    // Read but not yet used
+	lastSent time.Time // End of the last write, for guard times
+	mu       sync.Mutex
+}
+
+// New creates a Modem on line. A nil config uses DefaultConfig.
+func New(line io.ReadWriter, config *Config) *Modem {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	logger := config.Logger
+	if logger == nil {
+		logger = zmodem.NoopLogger{}
+	}
+	ctx := config.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &Modem{
+		line:   line,
+		config: config,
+		logger: logger,
+		ctx:    ctx,
+	}
+}
+
+// Init sends the init strings.
+func (m *Modem) Init() error {
+	for _, cmd := range m.config.Init {
+		if _, err := m.Command(cmd); err != nil {
+			return fmt.Errorf("modem: %s: %w", cmd, err)
+		}
+	}
+	return nil
+}
+
+// Command sends an AT command and waits for OK. Lines the modem sends
+// before it, like the answer to ATI, are returned.
+func (m *Modem) Command(cmd string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.logger.Debug("modem: sending %s", cmd)
+	if err := m.send(cmd + "\r"); err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	deadline := time.Now().Add(m.config.CommandTimeout)
+	for {
+		line, err := m.readLine(deadline)
+		if err != nil {
+			return lines, err
+		}
+		resp, ok := parseResult(line)
+		if !ok {
+			if strings.TrimSpace(line) != "" && !strings.EqualFold(line, cmd) {
+				lines = append(lines, line)
+			}
+			continue
+		}
+		m.logger.Debug("modem: %s", resp.line)
+		if resp.result == resultRing {
+			continue
+		}
+		if err := resp.err(); err != nil {
+			return lines, err
+		}
+		return lines, nil
+	}
+}
+
+// Dial sends the init strings, dials number and waits for the call to
+// connect.
+func (m *Modem) Dial(number string) (*Conn, error) {
+	if err := m.Init(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.logger.Info("modem: dialling %s", number)
+	if err := m.send(m.config.DialCommand + number + "\r"); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(m.config.ConnectTimeout)
+	for {
+		line, err := m.readLine(deadline)
+		if err != nil {
+			if zmodem.IsTimeout(err) || m.ctx.Err() != nil {
+				// Any character aborts dialling
+				m.send("\r")
+			}
+			return nil, err
+		}
+		resp, ok := parseResult(line)
+		if !ok || resp.result == resultRing || resp.result == resultOK {
+			continue
+		}
+		m.logger.Info("modem: %s", resp.line)
+		if err := resp.err(); err != nil {
+			return nil, err
+		}
+
+		if resp.verbose {
+			// Verbose results end with CR LF; the LF isn't data
+			m.skipLF()
+		}
+		conn := &Conn{modem: m, speed: resp.speed, result: resp.line, held: m.buf}
+		m.buf = nil
+		return conn, nil
+	}
+}
+
+// Hangup escapes to command mode and hangs up. It's also safe to call
+// when the call has dropped already.
+func (m *Modem) Hangup() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.logger.Info("modem: hanging up")
+
+	// The escape needs silence on both sides
+	if wait := m.config.GuardTime - time.Since(m.lastSent); wait > 0 {
+		if err := m.sleep(wait); err != nil {
+			return err
+		}
+	}
+	if err := m.send(m.config.Escape); err != nil {
+		return err
+	}
+	if err := m.sleep(m.config.GuardTime); err != nil {
+		return err
+	}
+	// Without carrier there is no OK, so the result doesn't matter
+	m.buf = nil
+	m.waitResult(time.Now().Add(m.config.CommandTimeout))
+
+	if err := m.send("ATH0\r"); err != nil {
+		return err
+	}
+	resp, err := m.waitResult(time.Now().Add(m.config.CommandTimeout))
+	if err != nil {
+		return err
+	}
+	if resp.result == resultError {
+		return ErrCommand
+	}
+	return nil
+}
+
+// waitResult reads lines until a result code other than RING.
+func (m *Modem) waitResult(deadline time.Time) (*response, error) {
+	for {
+		line, err := m.readLine(deadline)
+		if err != nil {
+			return nil, err
+		}
+		if resp, ok := parseResult(line); ok && resp.result != resultRing {
+			m.logger.Debug("modem: %s", resp.line)
+			return resp, nil
+		}
+	}
+}
+
+// send writes s to the modem.
+func (m *Modem) send(s string) error {
+	_, err := io.WriteString(m.line, s)
+	m.lastSent = time.Now()
+	return err
+}
+
+// sleep waits for d, or until the context is done.
+func (m *Modem) sleep(d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-m.ctx.Done():
+		return m.ctx.Err()
+	}
+}
+
+// readLine returns the next line from the modem, without its CR or LF.
+// Empty lines are skipped.
+func (m *Modem) readLine(deadline time.Time) (string, error) {
+	for {
+		if i := bytes.IndexAny(m.buf, "\r\n"); i >= 0 {
+			line := string(m.buf[:i])
+			m.buf = m.buf[i+1:]
+			if line == "" {
+				continue
+			}
+			return line, nil
+		}
+		if err := m.fill(deadline); err != nil {
+			return "", err
+		}
+	}
+}
+
+// skipLF drops the LF after a CR-terminated line, waiting briefly for it.
+func (m *Modem) skipLF() {
+	if len(m.buf) == 0 {
+		m.fill(time.Now().Add(m.config.CommandTimeout))
+	}
+	if len(m.buf) > 0 && m.buf[0] == '\n' {
+		m.buf = m.buf[1:]
+	}
+}
+
+// fill reads more from the line into buf.
+func (m *Modem) fill(deadline time.Time) error {
+	if err := m.ctx.Err(); err != nil {
+		return err
+	}
+	if time.Now().After(deadline) {
+		return zmodem.NewError(zmodem.ErrTimeout, "modem: no response")
+	}
+	if d, ok := m.line.(deadliner); ok {
+		// Wake up now and then to check the context
+		wake := time.Now().Add(time.Second)
+		if deadline.Before(wake) {
+			wake = deadline
+		}
+		if err := d.SetReadDeadline(wake); err != nil {
+			return err
+		}
+		defer d.SetReadDeadline(time.Time{})
+	}
+
+	tmp := make([]byte, 256)
+	n, err := m.line.Read(tmp)
+	m.buf = append(m.buf, tmp[:n]...)
+	if err != nil && n == 0 && !isTimeout(err) {
+		return err
+	}
+	return nil
+}
+
+// isTimeout reports whether err is an expired read deadline.
+func isTimeout(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) || zmodem.IsTimeout(err) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
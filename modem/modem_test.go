@@ -0,0 +1,248 @@
+package modem
+
+import (
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// fakeModem answers AT commands on one end of a net.Pipe like a Hayes
+// modem. Once a call connects it echoes data until it sees the escape.
+type fakeModem struct {
+	conn     net.Conn
+	dial     string            // Result of dialling
+	held     string            // Data sent right after CONNECT
+	info     map[string]string // Lines sent before OK, by command
+	numeric  bool              // ATV0 result codes
+	silent   bool              // Never answer
+	commands []string          // Commands received, read after done
+	done     chan struct{}
+}
+
+// newFakeModem starts a fake modem and returns a Modem talking to it.
+func newFakeModem(t *testing.T, f *fakeModem) *Modem {
+	t.Helper()
+	line, conn := net.Pipe()
+	f.conn = conn
+	f.done = make(chan struct{})
+	go f.run()
+	t.Cleanup(func() {
+		line.Close()
+		<-f.done
+	})
+
+	config := DefaultConfig()
+	config.CommandTimeout = time.Second
+	config.ConnectTimeout = time.Second
+	config.GuardTime = 10 * time.Millisecond
+	return New(line, config)
+}
+
+func (f *fakeModem) run() {
+	defer close(f.done)
+	defer f.conn.Close()
+
+	var cmd []byte
+	b := make([]byte, 256)
+	online := false
+	for {
+		n, err := f.conn.Read(b)
+		if err != nil {
+			return
+		}
+		if online {
+			if string(b[:n]) == "+++" {
+				online = false
+				f.reply("OK", "0")
+				continue
+			}
+			f.conn.Write(b[:n])
+			continue
+		}
+
+		for _, c := range b[:n] {
+			if c != '\r' {
+				cmd = append(cmd, c)
+				continue
+			}
+			command := string(cmd)
+			cmd = nil
+			f.commands = append(f.commands, command)
+			if f.silent {
+				continue
+			}
+			switch {
+			case strings.HasPrefix(command, "ATD"):
+				f.reply(f.dial, f.dial)
+				if strings.HasPrefix(f.dial, "CONNECT") || f.dial == "1" || f.dial == "12" {
+					if f.held != "" {
+						f.conn.Write([]byte(f.held))
+					}
+					online = true
+				}
+			case command == "ATBAD":
+				f.reply("ERROR", "4")
+			default:
+				for _, line := range strings.Split(f.info[command], "\n") {
+					if line != "" {
+						f.conn.Write([]byte("\r\n" + line + "\r\n"))
+					}
+				}
+				f.reply("OK", "0")
+			}
+		}
+	}
+}
+
+// reply sends a result code, verbose or numeric.
+func (f *fakeModem) reply(verbose, numeric string) {
+	if f.numeric {
+		f.conn.Write([]byte(numeric + "\r"))
+		return
+	}
+	f.conn.Write([]byte("\r\n" + verbose + "\r\n"))
+}
+
+func TestDial(t *testing.T) {
+	f := &fakeModem{dial: "CONNECT 33600/ARQ/V34", held: "hello"}
+	m := newFakeModem(t, f)
+
+	conn, err := m.Dial("5551234")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if conn.Speed() != 33600 || conn.Result() != "CONNECT 33600/ARQ/V34" {
+		t.Errorf("connected at %d with %q", conn.Speed(), conn.Result())
+	}
+
+	// Data right after CONNECT isn't lost
+	got := make([]byte, 5)
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != "hello" {
+		t.Errorf("read %q, %v; want the data sent with CONNECT", got, err)
+	}
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	got = make([]byte, 4)
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != "ping" {
+		t.Errorf("read %q, %v; want the echo", got, err)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	if err := conn.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	m.line.(net.Conn).Close()
+	<-f.done
+	want := []string{"ATZ", "ATE0 V1 Q0 X4 &C1 &D2", "ATDT5551234", "ATH0"}
+	if !reflect.DeepEqual(f.commands, want) {
+		t.Errorf("modem got %q, want %q", f.commands, want)
+	}
+}
+
+func TestDialNumeric(t *testing.T) {
+	m := newFakeModem(t, &fakeModem{dial: "12", numeric: true})
+
+	conn, err := m.Dial("5551234")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if conn.Speed() != 9600 {
+		t.Errorf("connected at %d, want 9600", conn.Speed())
+	}
+	if err := conn.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func TestDialFailures(t *testing.T) {
+	tests := []struct {
+		dial    string
+		numeric bool
+		want    error
+	}{
+		{"BUSY", false, ErrBusy},
+		{"NO CARRIER", false, ErrNoCarrier},
+		{"NO DIALTONE", false, ErrNoDialtone},
+		{"NO DIAL TONE", false, ErrNoDialtone},
+		{"NO ANSWER", false, ErrNoAnswer},
+		{"7", true, ErrBusy},
+		{"3", true, ErrNoCarrier},
+	}
+	for _, tt := range tests {
+		m := newFakeModem(t, &fakeModem{dial: tt.dial, numeric: tt.numeric})
+		if _, err := m.Dial("5551234"); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Dial returned %v, want %v", tt.dial, err, tt.want)
+		}
+	}
+}
+
+func TestCommand(t *testing.T) {
+	m := newFakeModem(t, &fakeModem{info: map[string]string{
+		"ATI": "Hayes Smartmodem\nVersion 1.0",
+	}})
+
+	lines, err := m.Command("ATI")
+	if err != nil {
+		t.Fatalf("Command: %v", err)
+	}
+	if want := []string{"Hayes Smartmodem", "Version 1.0"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("ATI returned %q, want %q", lines, want)
+	}
+
+	if _, err := m.Command("ATBAD"); !errors.Is(err, ErrCommand) {
+		t.Errorf("ATBAD returned %v, want %v", err, ErrCommand)
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	m := newFakeModem(t, &fakeModem{silent: true})
+	m.config.CommandTimeout = 50 * time.Millisecond
+
+	start := time.Now()
+	if _, err := m.Command("AT"); !zmodem.IsTimeout(err) {
+		t.Errorf("Command returned %v, want a timeout", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("timeout took %v", d)
+	}
+}
+
+func TestParseResult(t *testing.T) {
+	tests := []struct {
+		line   string
+		ok     bool
+		result resultCode
+		speed  int
+	}{
+		{"OK", true, resultOK, 0},
+		{"CONNECT", true, resultConnect, 0},
+		{"CONNECT 2400", true, resultConnect, 2400},
+		{"CONNECT 115200/V42bis", true, resultConnect, 115200},
+		{"19", true, resultConnect, 115200},
+		{"RING", true, resultRing, 0},
+		{"OKAY", false, 0, 0},
+		{"99", false, 0, 0},
+		{"ATDT123", false, 0, 0},
+		{"", false, 0, 0},
+	}
+	for _, tt := range tests {
+		resp, ok := parseResult(tt.line)
+		if ok != tt.ok {
+			t.Errorf("parseResult(%q) ok = %v, want %v", tt.line, ok, tt.ok)
+			continue
+		}
+		if ok && (resp.result != tt.result || resp.speed != tt.speed) {
+			t.Errorf("parseResult(%q) = %d at %d, want %d at %d",
+				tt.line, resp.result, resp.speed, tt.result, tt.speed)
+		}
+	}
+}
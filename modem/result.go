@@ -0,0 +1,139 @@
+package modem
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Errors for the modem's failure result codes.
+var (
+	ErrNoCarrier  = errors.New("modem: no carrier")
+	ErrBusy       = errors.New("modem: busy")
+	ErrNoDialtone = errors.New("modem: no dialtone")
+	ErrNoAnswer   = errors.New("modem: no answer")
+	ErrCommand    = errors.New("modem: command error")
+)
+
+// resultCode is a modem result code.
+type resultCode int
+
+const (
+	resultOK resultCode = iota
+	resultConnect
+	resultRing
+	resultNoCarrier
+	resultError
+	resultNoDialtone
+	resultBusy
+	resultNoAnswer
+)
+
+// verboseResults are the result words sent with ATV1.
+var verboseResults = []struct {
+	text   string
+	result resultCode
+}{
+	{"OK", resultOK},
+	{"CONNECT", resultConnect},
+	{"RING", resultRing},
+	{"NO CARRIER", resultNoCarrier},
+	{"ERROR", resultError},
+	{"NO DIALTONE", resultNoDialtone},
+	{"NO DIAL TONE", resultNoDialtone},
+	{"BUSY", resultBusy},
+	{"NO ANSWER", resultNoAnswer},
+}
+
+// numericResults are the result codes sent with ATV0. The CONNECT codes
+// above 4 carry the speed; these are the common Hayes ones.
+var numericResults = map[int]struct {
+	result resultCode
+	speed  int
+}{
+	0:  {resultOK, 0},
+	1:  {resultConnect, 0},
+	2:  {resultRing, 0},
+	3:  {resultNoCarrier, 0},
+	4:  {resultError, 0},
+	5:  {resultConnect, 1200},
+	6:  {resultNoDialtone, 0},
+	7:  {resultBusy, 0},
+	8:  {resultNoAnswer, 0},
+	10: {resultConnect, 2400},
+	11: {resultConnect, 4800},
+	12: {resultConnect, 9600},
+	13: {resultConnect, 7200},
+	14: {resultConnect, 12000},
+	15: {resultConnect, 14400},
+	16: {resultConnect, 19200},
+	17: {resultConnect, 38400},
+	18: {resultConnect, 57600},
+	19: {resultConnect, 115200},
+}
+
+// response is a result line from the modem.
+type response struct {
+	result  resultCode
+	speed   int    // CONNECT speed, 0 if not reported
+	line    string // The line as received
+	verbose bool
+}
+
+// parseResult parses a line from the modem. Lines that aren't result
+// codes, like command echo, return false.
+func parseResult(line string) (*response, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil, false
+	}
+
+	if code, err := strconv.Atoi(line); err == nil {
+		r, ok := numericResults[code]
+		if !ok {
+			return nil, false
+		}
+		return &response{result: r.result, speed: r.speed, line: line}, true
+	}
+
+	for _, v := range verboseResults {
+		if line != v.text && !strings.HasPrefix(line, v.text+" ") {
+			continue
+		}
+		resp := &response{result: v.result, line: line, verbose: true}
+		if v.result == resultConnect {
+			resp.speed = connectSpeed(line[len(v.text):])
+		}
+		return resp, true
+	}
+	return nil, false
+}
+
+// connectSpeed returns the speed from what follows CONNECT, like
+// " 33600/ARQ/V34".
+func connectSpeed(s string) int {
+	s = strings.TrimSpace(s)
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	speed, _ := strconv.Atoi(s[:end])
+	return speed
+}
+
+// err returns the error for a failure result, or nil.
+func (r *response) err() error {
+	switch r.result {
+	case resultNoCarrier:
+		return ErrNoCarrier
+	case resultError:
+		return ErrCommand
+	case resultNoDialtone:
+		return ErrNoDialtone
+	case resultBusy:
+		return ErrBusy
+	case resultNoAnswer:
+		return ErrNoAnswer
+	}
+	return nil
+}
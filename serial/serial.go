@@ -0,0 +1,104 @@
+// Package serial opens serial ports for file transfers over a modem or a
+// null-modem cable. A Port is a zmodem.ReaderWithTimeout and an io.Writer,
+// so it can be handed straight to the zmodem, xmodem, ymodem and kermit
+// senders and receivers.
+//
+// The line is put in raw mode with the speed, character format and flow
+// control from the Config. Read deadlines use the runtime poller. With
+// CarrierDetect set, reads fail with a zmodem.ErrCarrierLost error once the
+// modem drops DCD, which the ZModem code reports as RCDO.
+//
+// Ports are only supported on Linux. The other end of a pseudo-terminal
+// works as a port too, which is handy for testing: ptys have no modem
+// lines, so carrier is always present.
+package serial
+
+import "fmt"
+
+// Parity is the parity bit setting.
+type Parity int
+
+const (
+	// ParityNone sends no parity bit
+	ParityNone Parity = iota
+
+	// ParityOdd sends an odd parity bit
+	ParityOdd
+
+	// ParityEven sends an even parity bit
+	ParityEven
+)
+
+func (p Parity) String() string {
+	switch p {
+	case ParityNone:
+		return "none"
+	case ParityOdd:
+		return "odd"
+	case ParityEven:
+		return "even"
+	default:
+		return fmt.Sprintf("Parity(%d)", int(p))
+	}
+}
+
+// FlowControl is the flow control used on the line.
+type FlowControl int
+
+const (
+	// FlowNone uses no flow control
+	FlowNone FlowControl = iota
+
+	// FlowHardware uses the RTS and CTS lines
+	FlowHardware
+
+	// FlowSoftware uses XON and XOFF characters. ZModem escapes them, but
+	// binary XMODEM and YMODEM data doesn't survive it.
+	FlowSoftware
+)
+
+func (f FlowControl) String() string {
+	switch f {
+	case FlowNone:
+		return "none"
+	case FlowHardware:
+		return "RTS/CTS"
+	case FlowSoftware:
+		return "XON/XOFF"
+	default:
+		return fmt.Sprintf("FlowControl(%d)", int(f))
+	}
+}
+
+// Config holds the line settings of a port.
+type Config struct {
+	// Baud is the line speed in bits per second
+	Baud int
+
+	// DataBits is the number of data bits, 5 to 8
+	DataBits int
+
+	// Parity is the parity bit setting
+	Parity Parity
+
+	// StopBits is the number of stop bits, 1 or 2
+	StopBits int
+
+	// FlowControl is the flow control used on the line
+	FlowControl FlowControl
+
+	// CarrierDetect makes reads fail once the modem drops DCD. Leave it off
+	// for cables that don't carry DCD, or the port never sees carrier.
+	CarrierDetect bool
+}
+
+// DefaultConfig returns 115200 baud, 8N1 with hardware flow control.
+func DefaultConfig() *Config {
+	return &Config{
+		Baud:        115200,
+		DataBits:    8,
+		Parity:      ParityNone,
+		StopBits:    1,
+		FlowControl: FlowHardware,
+	}
+}
@@ -0,0 +1,259 @@
+//go:build linux
+
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// speeds maps line speeds to their termios constants.
+var speeds = map[int]uint32{
+	50:      unix.B50,
+	75:      unix.B75,
+	110:     unix.B110,
+	134:     unix.B134,
+	150:     unix.B150,
+	200:     unix.B200,
+	300:     unix.B300,
+	600:     unix.B600,
+	1200:    unix.B1200,
+	1800:    unix.B1800,
+	2400:    unix.B2400,
+	4800:    unix.B4800,
+	9600:    unix.B9600,
+	19200:   unix.B19200,
+	38400:   unix.B38400,
+	57600:   unix.B57600,
+	115200:  unix.B115200,
+	230400:  unix.B230400,
+	460800:  unix.B460800,
+	500000:  unix.B500000,
+	576000:  unix.B576000,
+	921600:  unix.B921600,
+	1000000: unix.B1000000,
+	1152000: unix.B1152000,
+	1500000: unix.B1500000,
+	2000000: unix.B2000000,
+	2500000: unix.B2500000,
+	3000000: unix.B3000000,
+	3500000: unix.B3500000,
+	4000000: unix.B4000000,
+}
+
+// Port is an open serial port.
+type Port struct {
+	f      *os.File
+	config Config
+	saved  *unix.Termios // Settings to restore on Close
+}
+
+// Open opens the tty device name and configures it. A nil config uses
+// DefaultConfig.
+func Open(name string, config *Config) (*Port, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+
+	// O_NONBLOCK keeps the open from waiting for carrier, and lets the
+	// runtime poller handle read deadlines
+	f, err := os.OpenFile(name, os.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Port{f: f, config: *config}
+	if err := p.configure(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// configure puts the line in raw mode with the port's settings.
+func (p *Port) configure() error {
+	speed, ok := speeds[p.config.Baud]
+	if !ok {
+		return fmt.Errorf("serial: unsupported baud rate %d", p.config.Baud)
+	}
+
+	var size uint32
+	switch p.config.DataBits {
+	case 5:
+		size = unix.CS5
+	case 6:
+		size = unix.CS6
+	case 7:
+		size = unix.CS7
+	case 8:
+		size = unix.CS8
+	default:
+		return fmt.Errorf("serial: unsupported data bits %d", p.config.DataBits)
+	}
+	if p.config.StopBits != 1 && p.config.StopBits != 2 {
+		return fmt.Errorf("serial: unsupported stop bits %d", p.config.StopBits)
+	}
+
+	return p.control(func(fd int) error {
+		t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+		if err != nil {
+			return fmt.Errorf("serial: %s is not a tty: %w", p.f.Name(), err)
+		}
+		saved := *t
+		p.saved = &saved
+
+		t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR |
+			unix.IGNCR | unix.ICRNL | unix.IXON | unix.IXOFF | unix.IXANY | unix.INPCK
+		t.Oflag &^= unix.OPOST
+		t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		t.Cflag &^= unix.CSIZE | unix.PARENB | unix.PARODD | unix.CSTOPB | unix.CRTSCTS |
+			unix.CBAUD | unix.CLOCAL
+		t.Cflag |= unix.CREAD | unix.HUPCL | size | speed
+
+		switch p.config.Parity {
+		case ParityOdd:
+			t.Cflag |= unix.PARENB | unix.PARODD
+			t.Iflag |= unix.INPCK
+		case ParityEven:
+			t.Cflag |= unix.PARENB
+			t.Iflag |= unix.INPCK
+		}
+		if p.config.StopBits == 2 {
+			t.Cflag |= unix.CSTOPB
+		}
+		switch p.config.FlowControl {
+		case FlowHardware:
+			t.Cflag |= unix.CRTSCTS
+		case FlowSoftware:
+			t.Iflag |= unix.IXON | unix.IXOFF
+		}
+		if !p.config.CarrierDetect {
+			// Ignore the modem lines
+			t.Cflag |= unix.CLOCAL
+		}
+
+		// Reads return as soon as there is a byte; deadlines come from the
+		// poller
+		t.Cc[unix.VMIN] = 1
+		t.Cc[unix.VTIME] = 0
+		t.Ispeed = speed
+		t.Ospeed = speed
+
+		return unix.IoctlSetTermios(fd, unix.TCSETS, t)
+	})
+}
+
+// control runs fn on the port's file descriptor.
+func (p *Port) control(fn func(fd int) error) error {
+	conn, err := p.f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ferr error
+	if err := conn.Control(func(fd uintptr) { ferr = fn(int(fd)) }); err != nil {
+		return err
+	}
+	return ferr
+}
+
+// Read reads from the line. An expired deadline returns a zmodem timeout
+// error, and with CarrierDetect a dropped carrier returns a zmodem carrier
+// lost error.
+func (p *Port) Read(b []byte) (int, error) {
+	n, err := p.f.Read(b)
+	if err == nil {
+		return n, nil
+	}
+	if p.config.CarrierDetect {
+		// The kernel hangs up the line when carrier drops, which shows up
+		// as end of file or EIO
+		if carrier, cerr := p.Carrier(); cerr == nil && !carrier {
+			return n, zmodem.NewFrameError(zmodem.ErrCarrierLost, "carrier lost", zmodem.RCDO)
+		}
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return n, zmodem.NewError(zmodem.ErrTimeout, "timeout")
+	}
+	return n, err
+}
+
+// Write writes to the line.
+func (p *Port) Write(b []byte) (int, error) {
+	return p.f.Write(b)
+}
+
+// SetReadDeadline sets the deadline for reads. A zero time means no
+// deadline.
+func (p *Port) SetReadDeadline(t time.Time) error {
+	return p.f.SetReadDeadline(t)
+}
+
+// Carrier reports whether the modem asserts DCD. Lines without modem
+// status, like ptys, always have carrier.
+func (p *Port) Carrier() (bool, error) {
+	var status int
+	err := p.control(func(fd int) error {
+		var err error
+		status, err = unix.IoctlGetInt(fd, unix.TIOCMGET)
+		return err
+	})
+	if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOTTY) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return status&unix.TIOCM_CD != 0, nil
+}
+
+// SetDTR raises or drops DTR. Most modems hang up when DTR drops.
+func (p *Port) SetDTR(on bool) error {
+	return p.control(func(fd int) error {
+		if on {
+			return unix.IoctlSetPointerInt(fd, unix.TIOCMBIS, unix.TIOCM_DTR)
+		}
+		return unix.IoctlSetPointerInt(fd, unix.TIOCMBIC, unix.TIOCM_DTR)
+	})
+}
+
+// Discard throws away input not yet read and output not yet sent, like
+// line noise left over from a cancelled transfer. It isn't called Flush:
+// the ZModem writers call Flush to push output out, not to drop it.
+func (p *Port) Discard() error {
+	return p.control(func(fd int) error {
+		return unix.IoctlSetInt(fd, unix.TCFLSH, unix.TCIOFLUSH)
+	})
+}
+
+// Drain waits until all output has been sent.
+func (p *Port) Drain() error {
+	return p.control(func(fd int) error {
+		return unix.IoctlSetInt(fd, unix.TCSBRK, 1)
+	})
+}
+
+// Name returns the device name.
+func (p *Port) Name() string {
+	return p.f.Name()
+}
+
+// Close restores the line settings the port was opened with and closes
+// it.
+func (p *Port) Close() error {
+	if p.saved != nil {
+		p.control(func(fd int) error {
+			return unix.IoctlSetTermios(fd, unix.TCSETS, p.saved)
+		})
+	}
+	return p.f.Close()
+}
+
+var _ zmodem.ReaderWithTimeout = (*Port)(nil)
+var _ io.ReadWriteCloser = (*Port)(nil)
@@ -0,0 +1,217 @@
+//go:build linux
+
+package serial
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/drunlade/go-lrzsz/internal/pty"
+	"github.com/drunlade/go-lrzsz/zmodem"
+	"golang.org/x/sys/unix"
+)
+
+// openPty opens a pseudo-terminal and the slave side as a Port, with no
+// flow control: ptys have no RTS/CTS.
+func openPty(t *testing.T) (*os.File, *Port) {
+	t.Helper()
+	master, slave, err := pty.Open()
+	if err != nil {
+		t.Skipf("no pseudo-terminals: %v", err)
+	}
+	t.Cleanup(func() { master.Close() })
+	defer slave.Close()
+
+	config := DefaultConfig()
+	config.FlowControl = FlowNone
+	port, err := Open(slave.Name(), config)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { port.Close() })
+	return master, port
+}
+
+// readN reads n bytes from r, failing after a second.
+func readN(t *testing.T, r interface {
+	io.Reader
+	SetReadDeadline(time.Time) error
+}, n int) []byte {
+	t.Helper()
+	r.SetReadDeadline(time.Now().Add(time.Second))
+	defer r.SetReadDeadline(time.Time{})
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatalf("reading %d bytes: %v", n, err)
+	}
+	return buf
+}
+
+func TestRawMode(t *testing.T) {
+	master, port := openPty(t)
+
+	// Nothing the line discipline would act on is changed on the way in:
+	// CR, XON/XOFF, ^C, ^Z, ^D, DEL and the 8th bit
+	in := []byte("a\rb\nc\x11\x13\x03\x1a\x04\x7f\xff\x80\x18")
+	if _, err := master.Write(in); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if got := readN(t, port, len(in)); !bytes.Equal(got, in) {
+		t.Errorf("port read %q, want %q", got, in)
+	}
+
+	// No output processing either, so NL isn't turned into CR NL
+	out := []byte("x\ny\r\x00\xfe")
+	if _, err := port.Write(out); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if got := readN(t, master, len(out)); !bytes.Equal(got, out) {
+		t.Errorf("master read %q, want %q", got, out)
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	_, port := openPty(t)
+
+	port.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	start := time.Now()
+	_, err := port.Read(make([]byte, 1))
+	if !zmodem.IsTimeout(err) {
+		t.Fatalf("Read returned %v, want a zmodem timeout", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("timeout took %v", d)
+	}
+}
+
+func TestCarrierOnPty(t *testing.T) {
+	_, port := openPty(t)
+	carrier, err := port.Carrier()
+	if err != nil || !carrier {
+		t.Errorf("Carrier() = %v, %v; ptys always have carrier", carrier, err)
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	master, port := openPty(t)
+
+	if _, err := master.Write([]byte("noise")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := port.Discard(); err != nil {
+		t.Fatalf("Discard: %v", err)
+	}
+	port.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if n, err := port.Read(make([]byte, 16)); !zmodem.IsTimeout(err) {
+		t.Errorf("Read after Discard returned %d, %v; want a timeout", n, err)
+	}
+}
+
+func TestCloseRestoresSettings(t *testing.T) {
+	master, slave, err := pty.Open()
+	if err != nil {
+		t.Skipf("no pseudo-terminals: %v", err)
+	}
+	defer master.Close()
+	defer slave.Close()
+	termios := func() unix.Termios {
+		t.Helper()
+		tio, err := unix.IoctlGetTermios(int(slave.Fd()), unix.TCGETS)
+		if err != nil {
+			t.Fatalf("TCGETS: %v", err)
+		}
+		return *tio
+	}
+	before := termios()
+
+	config := DefaultConfig()
+	config.FlowControl = FlowNone
+	port, err := Open(slave.Name(), config)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if raw := termios(); raw.Lflag&unix.ICANON != 0 || raw.Lflag&unix.ECHO != 0 {
+		t.Errorf("Open left the line in canonical mode or echoing")
+	}
+	port.Close()
+
+	if after := termios(); after != before {
+		t.Errorf("Close left the settings at %+v, want %+v", after, before)
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	_, slave, err := pty.Open()
+	if err != nil {
+		t.Skipf("no pseudo-terminals: %v", err)
+	}
+	defer slave.Close()
+
+	bad := map[string]*Config{
+		"baud rate": {Baud: 12345, DataBits: 8, StopBits: 1},
+		"data bits": {Baud: 9600, DataBits: 9, StopBits: 1},
+		"stop bits": {Baud: 9600, DataBits: 8, StopBits: 3},
+	}
+	for name, config := range bad {
+		if port, err := Open(slave.Name(), config); err == nil {
+			port.Close()
+			t.Errorf("%s: Open accepted a bad config", name)
+		}
+	}
+
+	if port, err := Open(os.DevNull, nil); err == nil {
+		port.Close()
+		t.Errorf("Open accepted %s, which isn't a tty", os.DevNull)
+	}
+}
+
+// TestZModemOverPty sends a file through a Port, as gsz would over a
+// serial line, to a receiver on the other end of the pty.
+func TestZModemOverPty(t *testing.T) {
+	master, port := openPty(t)
+
+	data := make([]byte, 100*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	name := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var received bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		receiver := zmodem.NewSession(zmodem.NewTimeoutReader(master), master,
+			zmodem.WithContext(ctx),
+			zmodem.WithCallbacks(&zmodem.Callbacks{
+				OnFileCreate: func(hdr *zmodem.FileHeader) (io.Writer, error) {
+					return &received, nil
+				},
+			}))
+		done <- receiver.ReceiveFiles(ctx, 0)
+	}()
+
+	sender := zmodem.NewSession(port, port, zmodem.WithContext(ctx))
+	if err := sender.SendFiles(ctx, []zmodem.FileInfo{{Filename: name, Info: info}}); err != nil {
+		t.Fatalf("SendFiles: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("ReceiveFiles: %v", err)
+	}
+	if !bytes.Equal(received.Bytes(), data) {
+		t.Errorf("received %d bytes that differ from the %d sent", received.Len(), len(data))
+	}
+}
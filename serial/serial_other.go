@@ -0,0 +1,30 @@
+//go:build !linux
+
+package serial
+
+import (
+	"errors"
+	"time"
+)
+
+// errUnsupported is returned by Open on platforms without serial support.
+var errUnsupported = errors.New("serial: ports are only supported on Linux")
+
+// Port is an open serial port.
+type Port struct{}
+
+// Open opens the tty device name and configures it. It is only supported
+// on Linux.
+func Open(name string, config *Config) (*Port, error) {
+	return nil, errUnsupported
+}
+
+func (p *Port) Read(b []byte) (int, error)        { return 0, errUnsupported }
+func (p *Port) Write(b []byte) (int, error)       { return 0, errUnsupported }
+func (p *Port) SetReadDeadline(t time.Time) error { return errUnsupported }
+func (p *Port) Carrier() (bool, error)            { return false, errUnsupported }
+func (p *Port) SetDTR(on bool) error              { return errUnsupported }
+func (p *Port) Discard() error                    { return errUnsupported }
+func (p *Port) Drain() error                      { return errUnsupported }
+func (p *Port) Name() string                      { return "" }
+func (p *Port) Close() error                      { return errUnsupported }
@@ -0,0 +1,84 @@
+package telnet
+
+// request sends our opening option requests. Each is remembered as
+// pending, so the answer isn't taken as a new request (RFC 1143).
+func (c *Conn) request(reqs ...[]byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	var buf []byte
+	for _, req := range reqs {
+		verb, opt := req[0], req[1]
+		switch verb {
+		case WILL:
+			c.localPending[opt] = true
+		case DO:
+			c.remotePending[opt] = true
+		}
+		buf = append(buf, IAC, verb, opt)
+	}
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// negotiate handles an option command from the other side. Supported
+// options are agreed to and others refused; answers to our own requests
+// and requests for what is already in effect get no reply, so the two
+// sides can't loop.
+func (c *Conn) negotiate(verb, opt byte) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	var reply byte
+	switch verb {
+	case WILL:
+		pending := c.remotePending[opt]
+		c.remotePending[opt] = false
+		switch {
+		case !c.remoteOK[opt]:
+			reply = DONT
+		case !c.remote[opt]:
+			c.remote[opt] = true
+			if !pending {
+				reply = DO
+			}
+		}
+
+	case WONT:
+		pending := c.remotePending[opt]
+		c.remotePending[opt] = false
+		if c.remote[opt] {
+			c.remote[opt] = false
+			if !pending {
+				reply = DONT
+			}
+		}
+
+	case DO:
+		pending := c.localPending[opt]
+		c.localPending[opt] = false
+		switch {
+		case !c.localOK[opt]:
+			reply = WONT
+		case !c.local[opt]:
+			c.local[opt] = true
+			if !pending {
+				reply = WILL
+			}
+		}
+
+	case DONT:
+		pending := c.localPending[opt]
+		c.localPending[opt] = false
+		if c.local[opt] {
+			c.local[opt] = false
+			if !pending {
+				reply = WONT
+			}
+		}
+	}
+
+	if reply != 0 {
+		c.conn.Write([]byte{IAC, reply, opt})
+	}
+}
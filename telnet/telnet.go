@@ -0,0 +1,265 @@
+// Package telnet carries transfers over telnet connections to BBSes and
+// console servers. A Conn escapes and unescapes IAC so 0xFF bytes survive,
+// strips option subnegotiation and other commands from the stream, and
+// negotiates BINARY, SGA and ECHO with the other side.
+//
+// A Conn is a zmodem.ReaderWithTimeout and an io.Writer, so it works with
+// zmodem.NewSession and zmodem.NewTerminalIO. Client and Dial play the
+// client side; Server plays the server side on accepted connections.
+package telnet
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// Telnet commands (RFC 854).
+const (
+	SE   = 240 // End of subnegotiation
+	NOP  = 241
+	GA   = 249 // Go ahead
+	SB   = 250 // Start of subnegotiation
+	WILL = 251
+	WONT = 252
+	DO   = 253
+	DONT = 254
+	IAC  = 255 // Interpret as command
+)
+
+// Telnet options.
+const (
+	OptBinary = 0 // RFC 856
+	OptEcho   = 1 // RFC 857
+	OptSGA    = 3 // Suppress go ahead, RFC 858
+)
+
+// Parser states.
+const (
+	stateData = iota
+	stateIAC
+	stateOption // After WILL, WONT, DO or DONT
+	stateSB
+	stateSBIAC
+	stateCR
+)
+
+// Conn is a telnet connection.
+type Conn struct {
+	conn net.Conn
+
+	// Options we support on our side and on theirs
+	localOK  [256]bool
+	remoteOK [256]bool
+
+	// Option states, guarded by wmu since replies are written while
+	// reading
+	local         [256]bool
+	remote        [256]bool
+	localPending  [256]bool
+	remotePending [256]bool
+
+	rbuf  []byte
+	state int
+	verb  byte // WILL, WONT, DO or DONT waiting for its option
+
+	wmu sync.Mutex
+}
+
+// Dial connects to a telnet server at address, "host:port".
+func Dial(address string) (*Conn, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Client(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Client starts the client side of a telnet connection: it asks for
+// binary in both directions, no go-aheads, and for the server to echo.
+func Client(conn net.Conn) (*Conn, error) {
+	c := newConn(conn)
+	c.localOK[OptBinary] = true
+	c.localOK[OptSGA] = true
+	c.remoteOK[OptBinary] = true
+	c.remoteOK[OptSGA] = true
+	c.remoteOK[OptEcho] = true
+	return c, c.request(
+		[]byte{WILL, OptBinary}, []byte{DO, OptBinary},
+		[]byte{WILL, OptSGA}, []byte{DO, OptSGA},
+		[]byte{DO, OptEcho},
+	)
+}
+
+// Server starts the server side of a telnet connection: it offers binary
+// in both directions, no go-aheads, and to echo, like a BBS.
+func Server(conn net.Conn) (*Conn, error) {
+	c := newConn(conn)
+	c.localOK[OptBinary] = true
+	c.localOK[OptSGA] = true
+	c.localOK[OptEcho] = true
+	c.remoteOK[OptBinary] = true
+	c.remoteOK[OptSGA] = true
+	return c, c.request(
+		[]byte{WILL, OptBinary}, []byte{DO, OptBinary},
+		[]byte{WILL, OptSGA}, []byte{DO, OptSGA},
+		[]byte{WILL, OptEcho},
+	)
+}
+
+func newConn(conn net.Conn) *Conn {
+	return &Conn{conn: conn, rbuf: make([]byte, 4096)}
+}
+
+// Read reads data from the other side, with telnet commands taken out.
+// An expired deadline returns a zmodem timeout error.
+func (c *Conn) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for {
+		// Data never grows when unescaped, so it fits in p
+		n, err := c.conn.Read(c.rbuf[:min(len(p), len(c.rbuf))])
+		out := c.decode(c.rbuf[:n], p)
+		if out > 0 {
+			return out, nil
+		}
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return 0, zmodem.NewError(zmodem.ErrTimeout, "timeout")
+			}
+			return 0, err
+		}
+	}
+}
+
+// decode runs in through the parser, writing data to out.
+func (c *Conn) decode(in, out []byte) int {
+	n := 0
+	for _, b := range in {
+		switch c.state {
+		case stateCR:
+			// CR NUL is a bare CR
+			c.state = stateData
+			if b == 0 {
+				continue
+			}
+			fallthrough
+
+		case stateData:
+			switch {
+			case b == IAC:
+				c.state = stateIAC
+			case b == '\r' && !c.binaryIn():
+				out[n] = b
+				n++
+				c.state = stateCR
+			default:
+				out[n] = b
+				n++
+			}
+
+		case stateIAC:
+			c.state = stateData
+			switch b {
+			case IAC:
+				out[n] = IAC
+				n++
+			case WILL, WONT, DO, DONT:
+				c.verb = b
+				c.state = stateOption
+			case SB:
+				c.state = stateSB
+			}
+			// Other commands (NOP, GA, AYT, ...) mean nothing here
+
+		case stateOption:
+			c.state = stateData
+			c.negotiate(c.verb, b)
+
+		case stateSB:
+			if b == IAC {
+				c.state = stateSBIAC
+			}
+
+		case stateSBIAC:
+			c.state = stateSB
+			if b == SE {
+				c.state = stateData
+			}
+		}
+	}
+	return n
+}
+
+// Write sends data to the other side, doubling IAC and, unless binary
+// was agreed, sending a bare CR as CR NUL. CR LF goes as it is (RFC 854).
+// A CR at the end of p is sent as CR NUL, since what follows isn't known;
+// if it is LF, the other side still reads CR LF.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	binary := c.local[OptBinary]
+	buf := make([]byte, 0, len(p)+len(p)/8+1)
+	for i, b := range p {
+		buf = append(buf, b)
+		switch {
+		case b == IAC:
+			buf = append(buf, IAC)
+		case b == '\r' && !binary && (i+1 == len(p) || p[i+1] != '\n'):
+			buf = append(buf, 0)
+		}
+	}
+	if _, err := c.conn.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// SetReadDeadline sets the read deadline of the connection.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the connection.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// Binary reports whether binary mode was agreed in both directions.
+func (c *Conn) Binary() bool {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.local[OptBinary] && c.remote[OptBinary]
+}
+
+// RemoteEcho reports whether the other side echoes what we send.
+func (c *Conn) RemoteEcho() bool {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.remote[OptEcho]
+}
+
+// binaryIn reports whether the other side sends binary.
+func (c *Conn) binaryIn() bool {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.remote[OptBinary]
+}
+
+var _ zmodem.ReaderWithTimeout = (*Conn)(nil)
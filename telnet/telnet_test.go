@@ -0,0 +1,186 @@
+package telnet
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// serve starts a telnet server on the loopback interface, running handle
+// on each connection, and returns a client connected to it.
+func serve(t *testing.T, handle func(*Conn)) *Conn {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no loopback: %v", err)
+	}
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		l.Close()
+		<-done
+	})
+	go func() {
+		defer close(done)
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		c, err := Server(conn)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		defer c.Close()
+		handle(c)
+	}()
+
+	c, err := Dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestNegotiation(t *testing.T) {
+	serverBinary := make(chan bool, 1)
+	c := serve(t, func(s *Conn) {
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(s, buf); err != nil {
+			return
+		}
+		serverBinary <- s.Binary()
+		s.Write(buf)
+	})
+
+	if _, err := c.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("read %q, %v; want the echo", buf, err)
+	}
+
+	if !c.Binary() {
+		t.Errorf("client isn't in binary mode")
+	}
+	if !<-serverBinary {
+		t.Errorf("server isn't in binary mode")
+	}
+	if !c.RemoteEcho() {
+		t.Errorf("client doesn't see the server echo")
+	}
+}
+
+func TestWriteEscapes(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"abc", "abc"},
+		{"a\xffb", "a\xff\xffb"},
+		{"a\r\nb", "a\r\nb"},
+		{"a\rb", "a\r\x00b"},
+		{"a\r\r\n", "a\r\x00\r\n"},
+		{"a\r", "a\r\x00"},
+		{"\n\r", "\n\r\x00"},
+	}
+	for _, tt := range tests {
+		a, b := net.Pipe()
+		c := newConn(a)
+		go func() {
+			c.Write([]byte(tt.in))
+			a.Close()
+		}()
+		got, _ := io.ReadAll(b)
+		if string(got) != tt.want {
+			t.Errorf("Write(%q) sent %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	// Binary mode only doubles IAC
+	a, b := net.Pipe()
+	c := newConn(a)
+	c.local[OptBinary] = true
+	go func() {
+		c.Write([]byte("a\rb\xff"))
+		a.Close()
+	}()
+	if got, _ := io.ReadAll(b); string(got) != "a\rb\xff\xff" {
+		t.Errorf("binary Write sent %q", got)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"abc", "abc"},
+		{"a\xff\xffb", "a\xffb"},
+		{"a\r\x00b", "a\rb"},
+		{"a\r\nb", "a\r\nb"},
+		{"a\xff\xf1b", "ab"},                      // NOP
+		{"a\xff\xfa\x18\x00xterm\xff\xf0b", "ab"}, // Subnegotiation
+	}
+	for _, tt := range tests {
+		c := newConn(nil)
+		out := make([]byte, len(tt.in))
+		// A byte at a time, so commands are split across reads
+		n := 0
+		for i := range len(tt.in) {
+			n += c.decode([]byte(tt.in[i:i+1]), out[n:])
+		}
+		if got := string(out[:n]); got != tt.want {
+			t.Errorf("decode(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestZModemOverTelnet sends a file from a telnet server to a client,
+// like a BBS download. The data has every byte value, IAC included.
+func TestZModemOverTelnet(t *testing.T) {
+	data := make([]byte, 100*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	name := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	sent := make(chan error, 1)
+	c := serve(t, func(s *Conn) {
+		sender := zmodem.NewSession(s, s, zmodem.WithContext(ctx))
+		sent <- sender.SendFiles(ctx, []zmodem.FileInfo{{Filename: name, Info: info}})
+	})
+
+	var received bytes.Buffer
+	receiver := zmodem.NewSession(c, c, zmodem.WithContext(ctx),
+		zmodem.WithCallbacks(&zmodem.Callbacks{
+			OnFileCreate: func(hdr *zmodem.FileHeader) (io.Writer, error) {
+				return &received, nil
+			},
+		}))
+	if err := receiver.ReceiveFiles(ctx, 0); err != nil {
+		t.Fatalf("ReceiveFiles: %v", err)
+	}
+	if err := <-sent; err != nil {
+		t.Fatalf("SendFiles: %v", err)
+	}
+	if !bytes.Equal(received.Bytes(), data) {
+		t.Errorf("received %d bytes that differ from the %d sent", received.Len(), len(data))
+	}
+}
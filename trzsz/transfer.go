@@ -0,0 +1,456 @@
+package trzsz
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// Chunk sizes for file data. Chunks start small and grow while the remote
+// keeps up, like trzsz does.
+const (
+	minChunk = 1024
+	maxChunk = 512 * 1024
+)
+
+// action is the client's answer to the magic line.
+type action struct {
+	Lang       string `json:"lang"`
+	Confirm    bool   `json:"confirm"`
+	Version    string `json:"version"`
+	SupportDir bool   `json:"support_dir"`
+}
+
+// remoteConfig is the configuration the remote tool sends from its command
+// line.
+type remoteConfig struct {
+	Quiet     bool  `json:"quiet"`
+	Binary    bool  `json:"binary"`
+	Directory bool  `json:"directory"`
+	Overwrite bool  `json:"overwrite"`
+	Timeout   int   `json:"timeout"`
+	BufSize   int64 `json:"bufsize"`
+}
+
+// transfer is one trzsz transfer: lines of "#TYPE:payload" in both
+// directions, with payloads in decimal or as base64 of zlib data.
+type transfer struct {
+	reader    zmodem.ReaderWithTimeout
+	buffered  *bufio.Reader
+	writer    io.Writer
+	callbacks *zmodem.Callbacks
+	logger    zmodem.Logger
+	timeout   time.Duration
+	config    remoteConfig
+}
+
+func newTransfer(t *zmodem.TerminalTransfer) *transfer {
+	timeout := time.Duration(t.Config.Timeout) * 100 * time.Millisecond
+	if timeout <= 0 {
+		timeout = 20 * time.Second
+	}
+	return &transfer{
+		reader:    t.Reader,
+		buffered:  bufio.NewReaderSize(t.Reader, 64*1024),
+		writer:    t.Writer,
+		callbacks: t.Callbacks,
+		logger:    t.Logger,
+		timeout:   timeout,
+	}
+}
+
+// encodeBytes compresses and base64 encodes a payload.
+func encodeBytes(data []byte) string {
+	var buf bytes.Buffer
+	z := zlib.NewWriter(&buf)
+	z.Write(data)
+	z.Close()
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// decodeBytes undoes encodeBytes.
+func decodeBytes(s string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, zmodem.NewError(zmodem.ErrInvalidFrame, "bad base64 payload")
+	}
+	z, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, zmodem.NewError(zmodem.ErrInvalidFrame, "bad zlib payload")
+	}
+	defer z.Close()
+	return io.ReadAll(z)
+}
+
+func (t *transfer) sendLine(typ, payload string) error {
+	_, err := io.WriteString(t.writer, "#"+typ+":"+payload+"\n")
+	return err
+}
+
+func (t *transfer) sendInteger(typ string, val int64) error {
+	return t.sendLine(typ, strconv.FormatInt(val, 10))
+}
+
+func (t *transfer) sendBinary(typ string, data []byte) error {
+	return t.sendLine(typ, encodeBytes(data))
+}
+
+func (t *transfer) sendString(typ, s string) error {
+	return t.sendBinary(typ, []byte(s))
+}
+
+// recvLine reads the next line of type typ and returns its payload. Lines
+// may carry junk from terminal multiplexers before the message, so the
+// last "#TYPE:" in a line is used, and lines without one are skipped.
+// A failure message from the remote becomes an error.
+func (t *transfer) recvLine(typ string) (string, error) {
+	for {
+		if t.timeout > 0 {
+			if err := t.reader.SetReadDeadline(time.Now().Add(t.timeout)); err != nil {
+				return "", err
+			}
+		}
+		line, err := t.buffered.ReadBytes('\n')
+		if err != nil {
+			if os.IsTimeout(err) {
+				return "", zmodem.NewError(zmodem.ErrTimeout, "timeout waiting for "+typ)
+			}
+			return "", err
+		}
+		line = bytes.ReplaceAll(line[:len(line)-1], []byte("\r"), nil)
+
+		if i := bytes.LastIndex(line, []byte("#FAIL:")); i >= 0 {
+			msg, _ := decodeBytes(string(line[i+6:]))
+			return "", zmodem.NewError(zmodem.ErrCancelled, "remote error: "+string(msg))
+		}
+		if i := bytes.LastIndex(line, []byte("#fail:")); i >= 0 {
+			return "", zmodem.NewError(zmodem.ErrCancelled, "remote error: "+string(line[i+6:]))
+		}
+		if i := bytes.LastIndex(line, []byte("#"+typ+":")); i >= 0 {
+			return string(line[i+len(typ)+2:]), nil
+		}
+		t.logger.Debug("trzsz: skipping %q waiting for %s", line, typ)
+	}
+}
+
+func (t *transfer) recvInteger(typ string) (int64, error) {
+	s, err := t.recvLine(typ)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, zmodem.NewError(zmodem.ErrInvalidFrame, "bad integer for "+typ)
+	}
+	return n, nil
+}
+
+func (t *transfer) recvBinary(typ string) ([]byte, error) {
+	s, err := t.recvLine(typ)
+	if err != nil {
+		return nil, err
+	}
+	return decodeBytes(s)
+}
+
+func (t *transfer) recvString(typ string) (string, error) {
+	data, err := t.recvBinary(typ)
+	return string(data), err
+}
+
+// checkInteger waits for the remote to confirm val.
+func (t *transfer) checkInteger(val int64) error {
+	n, err := t.recvInteger("SUCC")
+	if err != nil {
+		return err
+	}
+	if n != val {
+		return zmodem.NewError(zmodem.ErrProtocol, fmt.Sprintf("remote confirmed %d, expected %d", n, val))
+	}
+	return nil
+}
+
+// sendAction answers the magic line, and if confirmed receives the
+// remote's configuration.
+func (t *transfer) sendAction(confirm bool) error {
+	data, _ := json.Marshal(action{Lang: "go", Confirm: confirm, Version: version})
+	if err := t.sendString("ACT", string(data)); err != nil || !confirm {
+		return err
+	}
+
+	cfg, err := t.recvString("CFG")
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(cfg), &t.config); err != nil {
+		return zmodem.NewError(zmodem.ErrProtocol, "bad remote configuration")
+	}
+	if t.config.Timeout > 0 {
+		t.timeout = time.Duration(t.config.Timeout) * time.Second
+	}
+	if t.config.Binary {
+		return zmodem.NewError(zmodem.ErrProtocol, "binary mode is not supported, run without -b")
+	}
+	if t.config.Directory {
+		return zmodem.NewError(zmodem.ErrProtocol, "directory transfers are not supported, run without -d")
+	}
+	return nil
+}
+
+// clientExit ends the transfer, with a message for the remote to print.
+func (t *transfer) clientExit(msg string) error {
+	return t.sendString("EXIT", msg)
+}
+
+// clientError aborts the transfer, with a message for the remote to print.
+func (t *transfer) clientError(err error) {
+	t.sendString("FAIL", err.Error())
+}
+
+// fail reports err to the remote and the application.
+func (t *transfer) fail(err error) error {
+	t.clientError(err)
+	t.callbacks.OnError(err, "trzsz transfer")
+	return err
+}
+
+// sendFiles uploads files to a remote trz.
+func (t *transfer) sendFiles(files []string) ([]string, error) {
+	if err := t.sendInteger("NUM", int64(len(files))); err != nil {
+		return nil, err
+	}
+	if err := t.checkInteger(int64(len(files))); err != nil {
+		return nil, err
+	}
+
+	var remoteNames []string
+	for _, filename := range files {
+		name, err := t.sendFile(filename)
+		if err != nil {
+			return remoteNames, err
+		}
+		remoteNames = append(remoteNames, name)
+	}
+	return remoteNames, nil
+}
+
+func (t *transfer) sendFile(filename string) (string, error) {
+	file, info, err := t.open(filename)
+	if err != nil {
+		return "", err
+	}
+	if closer, ok := file.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	hdr := zmodem.NewFileHeader(baseName(filename), info)
+	t.callbacks.OnFileStart(hdr)
+	start := time.Now()
+
+	if err := t.sendString("NAME", hdr.Name); err != nil {
+		return "", err
+	}
+	remoteName, err := t.recvString("SUCC")
+	if err != nil {
+		return "", err
+	}
+	if err := t.sendInteger("SIZE", hdr.Size); err != nil {
+		return "", err
+	}
+	if err := t.checkInteger(hdr.Size); err != nil {
+		return "", err
+	}
+
+	digest := md5.New()
+	buf := make([]byte, maxChunk)
+	chunk := minChunk
+	var sent int64
+	for sent < hdr.Size {
+		n, err := io.ReadFull(file, buf[:min(int64(chunk), hdr.Size-sent)])
+		if err != nil {
+			return "", err
+		}
+		digest.Write(buf[:n])
+
+		sendStart := time.Now()
+		if err := t.sendBinary("DATA", buf[:n]); err != nil {
+			return "", err
+		}
+		if err := t.checkInteger(int64(n)); err != nil {
+			return "", err
+		}
+		sent += int64(n)
+		t.callbacks.OnProgress(hdr.Name, sent, hdr.Size, float64(sent)/max(time.Since(start).Seconds(), 1e-9))
+
+		// Grow the chunks while the remote keeps up
+		if time.Since(sendStart) < time.Second && int64(chunk*2) <= t.maxChunk() {
+			chunk *= 2
+		}
+	}
+
+	sum := digest.Sum(nil)
+	if err := t.sendBinary("MD5", sum); err != nil {
+		return "", err
+	}
+	check, err := t.recvBinary("SUCC")
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(check, sum) {
+		return "", zmodem.NewError(zmodem.ErrCRC, "MD5 mismatch for "+hdr.Name)
+	}
+
+	t.callbacks.OnFileComplete(hdr.Name, sent, time.Since(start))
+	return remoteName, nil
+}
+
+// maxChunk returns the largest chunk the remote takes.
+func (t *transfer) maxChunk() int64 {
+	if t.config.BufSize > 0 {
+		return min(t.config.BufSize, maxChunk)
+	}
+	return maxChunk
+}
+
+// open opens a file to upload.
+func (t *transfer) open(filename string) (io.Reader, os.FileInfo, error) {
+	if t.callbacks.OnFileOpen != nil {
+		file, info, err := t.callbacks.OnFileOpen(filename)
+		if err == nil && info == nil {
+			info, err = os.Stat(filename)
+		}
+		return file, info, err
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, info, nil
+}
+
+// recvFiles downloads files from a remote tsz.
+func (t *transfer) recvFiles() ([]string, error) {
+	num, err := t.recvInteger("NUM")
+	if err != nil {
+		return nil, err
+	}
+	if err := t.sendInteger("SUCC", num); err != nil {
+		return nil, err
+	}
+
+	var localNames []string
+	for range num {
+		name, err := t.recvFile()
+		if err != nil {
+			return localNames, err
+		}
+		localNames = append(localNames, name)
+	}
+	return localNames, nil
+}
+
+func (t *transfer) recvFile() (string, error) {
+	name, err := t.recvString("NAME")
+	if err != nil {
+		return "", err
+	}
+	hdr := &zmodem.FileHeader{Name: baseName(name)}
+
+	file, localName, err := t.create(hdr)
+	if err != nil {
+		return "", err
+	}
+	if closer, ok := file.(io.Closer); ok {
+		defer closer.Close()
+	}
+	if err := t.sendString("SUCC", localName); err != nil {
+		return "", err
+	}
+
+	if hdr.Size, err = t.recvInteger("SIZE"); err != nil {
+		return "", err
+	}
+	if err := t.sendInteger("SUCC", hdr.Size); err != nil {
+		return "", err
+	}
+
+	t.callbacks.OnFileStart(hdr)
+	start := time.Now()
+	digest := md5.New()
+	var received int64
+	for received < hdr.Size {
+		data, err := t.recvBinary("DATA")
+		if err != nil {
+			return "", err
+		}
+		if _, err := file.Write(data); err != nil {
+			return "", err
+		}
+		digest.Write(data)
+		received += int64(len(data))
+		if err := t.sendInteger("SUCC", int64(len(data))); err != nil {
+			return "", err
+		}
+		t.callbacks.OnProgress(hdr.Name, received, hdr.Size, float64(received)/max(time.Since(start).Seconds(), 1e-9))
+	}
+
+	sum, err := t.recvBinary("MD5")
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(sum, digest.Sum(nil)) {
+		return "", zmodem.NewError(zmodem.ErrCRC, "MD5 mismatch for "+hdr.Name)
+	}
+	if err := t.sendBinary("SUCC", sum); err != nil {
+		return "", err
+	}
+
+	t.callbacks.OnFileComplete(hdr.Name, received, time.Since(start))
+	return localName, nil
+}
+
+// create opens where a downloaded file goes. Files the application
+// declines are received and thrown away, as trzsz can't skip them. Without
+// OnFileCreate, files are created in the current directory, with a numeric
+// suffix if the name is taken and the remote didn't ask to overwrite.
+func (t *transfer) create(hdr *zmodem.FileHeader) (io.Writer, string, error) {
+	accept, err := t.callbacks.OnFilePrompt(hdr)
+	if err != nil {
+		return nil, "", err
+	}
+	if !accept {
+		return io.Discard, hdr.Name, nil
+	}
+	if t.callbacks.OnFileCreate != nil {
+		w, err := t.callbacks.OnFileCreate(hdr)
+		return w, hdr.Name, err
+	}
+
+	name := hdr.Name
+	if !t.config.Overwrite {
+		for i := 0; ; i++ {
+			if _, err := os.Lstat(name); os.IsNotExist(err) {
+				break
+			}
+			name = fmt.Sprintf("%s.%d", hdr.Name, i)
+		}
+	}
+	file, err := os.Create(name)
+	return file, name, err
+}
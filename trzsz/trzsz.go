@@ -0,0 +1,142 @@
+// Package trzsz implements the client side of the trzsz protocol, which
+// transfers files as base64 lines and so works through tmux and screen,
+// where ZModem breaks.
+//
+// A remote trz (upload) or tsz (download) announces itself with a magic
+// line, "::TRZSZ:TRANSFER:" followed by the mode and its version. The
+// TerminalProtocol detects it in TerminalIO output and runs the transfer
+// with the TerminalIO's callbacks: uploads send the files OnFileList
+// returns, downloads go through OnFilePrompt and OnFileCreate.
+//
+// Binary (-b) and directory (-d) transfers are not supported; the remote
+// is told so and prints the message.
+package trzsz
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// version is the trzsz version reported to the remote.
+const version = "1.1.5"
+
+// magicRegexp matches the magic line: the mode (S: the remote sends, R: it
+// receives, D: it receives directories), its version and a unique id.
+var magicRegexp = regexp.MustCompile(`::TRZSZ:TRANSFER:([SRD]):(\d+\.\d+\.\d+)(:\d+)?[\r\n]`)
+
+// magicPrefix is what detection waits for before matching the whole line.
+const magicPrefix = "::TRZSZ:TRANSFER:"
+
+// maxMagic is how much earlier output detection keeps to find magic lines
+// split across reads.
+const maxMagic = 64
+
+// TerminalProtocol detects trzsz transfers for zmodem.WithTerminalProtocol.
+type TerminalProtocol struct {
+	tail   []byte // Recent output, to find magic lines split across reads
+	mode   byte   // Mode of the detected transfer
+	lastID string // Unique id of the last transfer, which mustn't run twice
+}
+
+// NewTerminalProtocol creates a trzsz TerminalProtocol.
+func NewTerminalProtocol() *TerminalProtocol {
+	return &TerminalProtocol{}
+}
+
+// Name implements zmodem.TerminalProtocol.
+func (d *TerminalProtocol) Name() string {
+	return "trzsz"
+}
+
+// Detect implements zmodem.TerminalProtocol. The transfer starts at the
+// magic line, so it isn't shown; if it started in earlier output, only the
+// rest of it is hidden.
+func (d *TerminalProtocol) Detect(p []byte) int {
+	held := len(d.tail)
+	buf := append(d.tail, p...)
+
+	m := magicRegexp.FindSubmatchIndex(buf)
+	if m == nil {
+		// Keep enough to complete a magic line that started in this read
+		keep := maxMagic
+		if i := strings.LastIndex(string(buf), magicPrefix); i >= 0 && len(buf)-i < 2*maxMagic {
+			keep = len(buf) - i
+		}
+		d.tail = append(d.tail[:0], buf[max(0, len(buf)-keep):]...)
+		return -1
+	}
+
+	d.tail = d.tail[:0]
+	if m[6] >= 0 {
+		// The same magic line again is output being replayed, like a
+		// log of an earlier session
+		id := string(buf[m[6]:m[7]])
+		if id == d.lastID {
+			return -1
+		}
+		d.lastID = id
+	}
+	d.mode = buf[m[2]]
+	return max(0, m[0]-held)
+}
+
+// Transfer implements zmodem.TerminalProtocol.
+func (d *TerminalProtocol) Transfer(t *zmodem.TerminalTransfer) error {
+	tr := newTransfer(t)
+
+	switch d.mode {
+	case 'R':
+		return d.upload(t, tr)
+	case 'S':
+		return d.download(tr)
+	}
+	t.Logger.Info("trzsz: directory transfers are not supported")
+	return tr.sendAction(false)
+}
+
+// upload sends files to a remote trz.
+func (d *TerminalProtocol) upload(t *zmodem.TerminalTransfer, tr *transfer) error {
+	var files []string
+	if t.Callbacks.OnFileList != nil {
+		list, err := t.Callbacks.OnFileList()
+		if err != nil {
+			t.Logger.Error("trzsz: OnFileList error: %v", err)
+		}
+		files = list
+	}
+	if len(files) == 0 {
+		t.Logger.Info("trzsz: no files to send")
+		return tr.sendAction(false)
+	}
+
+	if err := tr.sendAction(true); err != nil {
+		return tr.fail(err)
+	}
+	names, err := tr.sendFiles(files)
+	if err != nil {
+		return tr.fail(err)
+	}
+	return tr.clientExit("Received " + strings.Join(names, ", "))
+}
+
+// download receives files from a remote tsz.
+func (d *TerminalProtocol) download(tr *transfer) error {
+	if err := tr.sendAction(true); err != nil {
+		return tr.fail(err)
+	}
+	names, err := tr.recvFiles()
+	if err != nil {
+		return tr.fail(err)
+	}
+	return tr.clientExit("Saved " + strings.Join(names, ", "))
+}
+
+// baseName returns the last element of a Unix or Windows path.
+func baseName(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
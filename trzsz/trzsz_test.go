@@ -0,0 +1,273 @@
+package trzsz
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   int // Chunk the transfer starts in, -1 for none
+		at     int // Where in that chunk
+		mode   byte
+	}{
+		{"upload", []string{"$ trz\r\n::TRZSZ:TRANSFER:R:1.1.5:123\r\n"}, 0, 7, 'R'},
+		{"download", []string{"::TRZSZ:TRANSFER:S:1.1.5:123\n"}, 0, 0, 'S'},
+		{"directory", []string{"::TRZSZ:TRANSFER:D:1.1.5:123\r\n"}, 0, 0, 'D'},
+		{"no id", []string{"::TRZSZ:TRANSFER:R:1.1.5\r\n"}, 0, 0, 'R'},
+		{"split", []string{"$ tsz x\r\n::TRZ", "SZ:TRANSFER:S:1.1", ".5:42\r\n"}, 2, 0, 'S'},
+		{"split after the prefix", []string{"::TRZSZ:TRANSFER:", "S:1.1.5:42\r\n"}, 1, 0, 'S'},
+		{"no end of line", []string{"::TRZSZ:TRANSFER:R:1.1.5:123"}, -1, 0, 0},
+		{"bad mode", []string{"::TRZSZ:TRANSFER:X:1.1.5:123\r\n"}, -1, 0, 0},
+		{"text", []string{"TRZSZ:TRANSFER:R:1.1.5:123\r\n"}, -1, 0, 0},
+	}
+
+	for _, tt := range tests {
+		d := NewTerminalProtocol()
+		got := -1
+		for i, chunk := range tt.chunks {
+			if at := d.Detect([]byte(chunk)); at >= 0 {
+				got = i
+				if at != tt.at {
+					t.Errorf("%s: transfer starts at %d, want %d", tt.name, at, tt.at)
+				}
+				break
+			}
+		}
+		if got != tt.want {
+			t.Errorf("%s: detected in chunk %d, want %d", tt.name, got, tt.want)
+		}
+		if got >= 0 && d.mode != tt.mode {
+			t.Errorf("%s: mode %c, want %c", tt.name, d.mode, tt.mode)
+		}
+	}
+}
+
+func TestDetectReplay(t *testing.T) {
+	d := NewTerminalProtocol()
+	magic := []byte("::TRZSZ:TRANSFER:R:1.1.5:123\r\n")
+	if d.Detect(magic) < 0 {
+		t.Fatalf("magic line not detected")
+	}
+
+	// The same transfer again is output being replayed
+	if d.Detect(magic) >= 0 {
+		t.Errorf("the same magic line was detected twice")
+	}
+	if d.Detect([]byte("::TRZSZ:TRANSFER:R:1.1.5:124\r\n")) < 0 {
+		t.Errorf("the next transfer wasn't detected")
+	}
+}
+
+// remote is the remote side of a terminal, running trz or tsz.
+type remote struct {
+	*transfer
+	out *os.File // Terminal output, read by TerminalIO
+}
+
+// terminal returns a TerminalIO with trzsz and the remote side of its
+// terminal.
+func terminal(t *testing.T, ctx context.Context, callbacks *zmodem.Callbacks, remoteCallbacks *zmodem.Callbacks) (*zmodem.TerminalIO, *remote) {
+	t.Helper()
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for _, f := range []*os.File{outR, outW, inR, inW} {
+			f.Close()
+		}
+	})
+
+	termIO := zmodem.NewTerminalIO(outR, inW, zmodem.WithContext(ctx), zmodem.WithCallbacks(callbacks),
+		zmodem.WithTerminalProtocol(NewTerminalProtocol()))
+	r := &remote{
+		transfer: &transfer{
+			reader:    inR,
+			buffered:  bufio.NewReader(inR),
+			writer:    outW,
+			callbacks: zmodem.MergeCallbacks(remoteCallbacks),
+			logger:    zmodem.NoopLogger{},
+			timeout:   10 * time.Second,
+		},
+		out: outW,
+	}
+	return termIO, r
+}
+
+// start prints the magic line for mode, checks the client's answer and
+// sends the remote configuration.
+func (r *remote) start(t *testing.T, mode string) error {
+	if _, err := io.WriteString(r.out, "$ command\r\n::TRZSZ:TRANSFER:"+mode+":1.1.5:1\r\n"); err != nil {
+		return err
+	}
+	act, err := r.recvString("ACT")
+	if err != nil {
+		return err
+	}
+	var a action
+	if err := json.Unmarshal([]byte(act), &a); err != nil || !a.Confirm {
+		t.Errorf("client answered %s", act)
+	}
+	return r.sendString("CFG", `{"timeout":10}`)
+}
+
+// finish waits for the client's exit message and prints more output.
+func (r *remote) finish() (string, error) {
+	msg, err := r.recvString("EXIT")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.WriteString(r.out, "done\r\n")
+	return msg, err
+}
+
+// readUntil reads the terminal output until it contains s.
+func readUntil(t *testing.T, termIO *zmodem.TerminalIO, s string) string {
+	t.Helper()
+	var out []byte
+	buf := make([]byte, 4096)
+	for !bytes.Contains(out, []byte(s)) {
+		n, err := termIO.Read(buf)
+		if err != nil {
+			t.Fatalf("Read: %v (got %q)", err, out)
+		}
+		out = append(out, buf[:n]...)
+	}
+	return string(out)
+}
+
+func randomFile(t *testing.T, n int) (string, []byte) {
+	t.Helper()
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	name := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name, data
+}
+
+func TestUpload(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	name, data := randomFile(t, 300*1024)
+
+	var received bytes.Buffer
+	termIO, r := terminal(t, ctx, &zmodem.Callbacks{
+		OnFileList: func() ([]string, error) {
+			return []string{name}, nil
+		},
+	}, &zmodem.Callbacks{
+		OnFileCreate: func(hdr *zmodem.FileHeader) (io.Writer, error) {
+			return &received, nil
+		},
+	})
+
+	done := make(chan error, 1)
+	var msg string
+	go func() {
+		if err := r.start(t, "R"); err != nil {
+			done <- err
+			return
+		}
+		if _, err := r.recvFiles(); err != nil {
+			done <- err
+			return
+		}
+		var err error
+		msg, err = r.finish()
+		done <- err
+	}()
+
+	out := readUntil(t, termIO, "done\r\n")
+	if err := <-done; err != nil {
+		t.Fatalf("remote: %v", err)
+	}
+	if !bytes.Equal(received.Bytes(), data) {
+		t.Errorf("remote received %d bytes that differ from the %d sent", received.Len(), len(data))
+	}
+	if msg != "Received data.bin" {
+		t.Errorf("exit message %q", msg)
+	}
+	if strings.Contains(out, "TRZSZ") || !strings.HasPrefix(out, "$ command\r\n") {
+		t.Errorf("terminal showed %q", out)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	name, data := randomFile(t, 300*1024)
+
+	var received bytes.Buffer
+	var hdr *zmodem.FileHeader
+	termIO, r := terminal(t, ctx, &zmodem.Callbacks{
+		OnFileCreate: func(h *zmodem.FileHeader) (io.Writer, error) {
+			hdr = h
+			return &received, nil
+		},
+	}, nil)
+
+	done := make(chan error, 1)
+	var msg string
+	go func() {
+		if err := r.start(t, "S"); err != nil {
+			done <- err
+			return
+		}
+		if _, err := r.sendFiles([]string{name}); err != nil {
+			done <- err
+			return
+		}
+		var err error
+		msg, err = r.finish()
+		done <- err
+	}()
+
+	out := readUntil(t, termIO, "done\r\n")
+	if err := <-done; err != nil {
+		t.Fatalf("remote: %v", err)
+	}
+	if !bytes.Equal(received.Bytes(), data) {
+		t.Errorf("received %d bytes that differ from the %d sent", received.Len(), len(data))
+	}
+	if hdr == nil || hdr.Name != "data.bin" || hdr.Size != int64(len(data)) {
+		t.Errorf("header %+v", hdr)
+	}
+	if msg != "Saved data.bin" {
+		t.Errorf("exit message %q", msg)
+	}
+	if strings.Contains(out, "TRZSZ") {
+		t.Errorf("terminal showed %q", out)
+	}
+}
+
+func TestEncodeBytes(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("hello"), bytes.Repeat([]byte{0, 0xff}, 10000)} {
+		got, err := decodeBytes(encodeBytes(data))
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("round trip of %d bytes gave %d bytes, %v", len(data), len(got), err)
+		}
+	}
+	if _, err := decodeBytes("not base64!"); err == nil {
+		t.Errorf("decoded a bad payload")
+	}
+}
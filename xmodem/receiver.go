@@ -0,0 +1,287 @@
+package xmodem
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// crcTries is how many times a receiver asks for CRC-16 before falling
+// back to the checksum, as rx does.
+const crcTries = 3
+
+// Receiver receives data with XMODEM.
+type Receiver struct {
+	port      *port
+	config    *Config
+	logger    zmodem.Logger
+	callbacks *zmodem.Callbacks
+
+	crc       bool
+	streaming bool
+
+	blockNum byte // Number of the next block
+	started  bool // Set once the first block has been asked for
+}
+
+// NewReceiver creates a new XMODEM receiver.
+func NewReceiver(reader zmodem.ReaderWithTimeout, writer io.Writer, config *Config) *Receiver {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	logger := config.Logger
+	if logger == nil {
+		logger = zmodem.NoopLogger{}
+	}
+	return &Receiver{
+		port:      newPort(reader, writer, config.Timeout, config.Context),
+		config:    config,
+		logger:    logger,
+		callbacks: zmodem.MergeCallbacks(config.Callbacks),
+		blockNum:  1,
+	}
+}
+
+// Start asks the sender for the first block and waits for it to begin.
+// CRC-16 is asked for a few times before falling back to the checksum.
+// Block numbering restarts at 1.
+// This matches the start of wcgetsec() from lrz.c.
+func (r *Receiver) Start() error {
+	r.blockNum = 1
+	r.crc = r.config.CRC || r.config.Streaming
+	r.streaming = r.config.Streaming
+
+	for tries := 0; ; tries++ {
+		if r.crc && !r.streaming && tries == crcTries {
+			r.logger.Info("xmodem: no answer to CRC request, using checksum")
+			r.crc = false
+		}
+		if err := r.port.write(r.request()); err != nil {
+			return err
+		}
+
+		c, err := r.port.readByte(0)
+		if err != nil {
+			if isTimeout(err) && tries < r.config.MaxErrors {
+				continue
+			}
+			return err
+		}
+		// Leave the byte for ReadBlock
+		r.port.unreadByte()
+		if c == zmodem.CAN || c == zmodem.SOH || c == zmodem.STX || c == zmodem.EOT {
+			r.started = true
+			return nil
+		}
+		r.port.purge()
+	}
+}
+
+// request returns the byte that asks for the first block.
+func (r *Receiver) request() byte {
+	switch {
+	case r.streaming:
+		return zmodem.WANTG
+	case r.crc:
+		return zmodem.WANTCRC
+	}
+	return zmodem.NAK
+}
+
+// ReadBlock0 asks for and receives block 0, which is where YMODEM sends its
+// file headers. The following ReadBlock asks for block 1 afresh.
+func (r *Receiver) ReadBlock0() ([]byte, error) {
+	if err := r.Start(); err != nil {
+		return nil, err
+	}
+	r.blockNum = 0
+	data, err := r.ReadBlock()
+	r.blockNum = 1
+	r.started = false
+	return data, err
+}
+
+// ReadBlock receives the next block, padding included. It returns io.EOF
+// once the sender ends the transfer.
+// This matches wcgetsec() from lrz.c.
+func (r *Receiver) ReadBlock() ([]byte, error) {
+	if !r.started {
+		if err := r.Start(); err != nil {
+			return nil, err
+		}
+	}
+
+	for errors := 0; ; {
+		data, num, err := r.readBlock()
+		if err == nil {
+			switch num {
+			case r.blockNum:
+				if !r.streaming {
+					if err := r.port.write(zmodem.ACK); err != nil {
+						return nil, err
+					}
+				}
+				r.blockNum++
+				return data, nil
+			case r.blockNum - 1:
+				// Our ACK got lost and the sender repeated the block
+				if err := r.port.write(zmodem.ACK); err != nil {
+					return nil, err
+				}
+				continue
+			default:
+				r.port.cancel()
+				return nil, zmodem.NewError(zmodem.ErrProtocol, "block out of sequence")
+			}
+		}
+
+		if err == io.EOF {
+			if err := r.port.write(zmodem.ACK); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		if zmodem.IsCancelled(err) || (!isTimeout(err) && !zmodem.IsCRC(err) && !isFrameError(err)) {
+			return nil, err
+		}
+
+		r.logger.Error("xmodem: block %d: %v", r.blockNum, err)
+		if r.streaming {
+			// XMODEM-g has no way to resend a block
+			r.port.cancel()
+			return nil, err
+		}
+		if errors++; errors > r.config.MaxErrors {
+			r.port.cancel()
+			return nil, err
+		}
+		if !isTimeout(err) {
+			r.port.purge()
+		}
+		if err := r.port.write(zmodem.NAK); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readBlock reads one block off the line and checks it.
+func (r *Receiver) readBlock() ([]byte, byte, error) {
+	var size int
+	for size == 0 {
+		c, err := r.port.readByte(0)
+		if err != nil {
+			return nil, 0, err
+		}
+		switch c {
+		case zmodem.SOH:
+			size = BlockSize
+		case zmodem.STX:
+			size = BlockSize1K
+		case zmodem.EOT:
+			return nil, 0, io.EOF
+		case zmodem.CAN:
+			if c, err := r.port.readByte(time.Second); err == nil && c == zmodem.CAN {
+				return nil, 0, zmodem.NewError(zmodem.ErrCancelled, "sender cancelled")
+			}
+		}
+	}
+
+	checkLen := 1
+	if r.crc {
+		checkLen = 2
+	}
+	buf := make([]byte, 2+size+checkLen)
+	if err := r.port.readFull(buf); err != nil {
+		return nil, 0, err
+	}
+	num, data, check := buf[0], buf[2:2+size], buf[2+size:]
+	if buf[1] != ^num {
+		return nil, 0, zmodem.NewError(zmodem.ErrInvalidFrame, "bad block number")
+	}
+	if r.crc {
+		crc := zmodem.CRC16(data)
+		if check[0] != byte(crc>>8) || check[1] != byte(crc) {
+			return nil, 0, zmodem.NewError(zmodem.ErrCRC, "bad CRC")
+		}
+	} else if check[0] != checksum(data) {
+		return nil, 0, zmodem.NewError(zmodem.ErrCRC, "bad checksum")
+	}
+	return data, num, nil
+}
+
+// isFrameError reports whether err is a garbled block.
+func isFrameError(err error) bool {
+	e, ok := err.(*zmodem.Error)
+	return ok && e.Type == zmodem.ErrInvalidFrame
+}
+
+// Receive receives a whole file into w and returns its length. name is
+// only used for callbacks. With StripPadding set, CP/M EOF padding is
+// removed from the end of the last block.
+func (r *Receiver) Receive(name string, w io.Writer) (int64, error) {
+	r.callbacks.OnFileStart(&zmodem.FileHeader{Name: name})
+	start := time.Now()
+
+	n, err := r.ReceiveData(name, w, 0)
+	if err != nil {
+		r.callbacks.OnError(err, "receive file")
+		return n, err
+	}
+
+	r.callbacks.OnFileComplete(name, n, time.Since(start))
+	return n, nil
+}
+
+// ReceiveData copies blocks to w until EOT and returns the number of bytes
+// written. If size is known (YMODEM sends it), the data is cut off there;
+// otherwise the last block's padding is stripped if StripPadding is set.
+// name and size are passed on to OnProgress.
+func (r *Receiver) ReceiveData(name string, w io.Writer, size int64) (int64, error) {
+	prog := newProgress(r.callbacks, r.config.ProgressInterval, name, size)
+
+	var written int64
+	var held []byte
+	for {
+		data, err := r.ReadBlock()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return written, err
+		}
+		if held != nil {
+			if err := r.write(w, held, &written, size); err != nil {
+				return written, err
+			}
+			prog.report(written, false)
+		}
+		held = data
+	}
+
+	if r.config.StripPadding && size <= 0 {
+		held = bytes.TrimRight(held, "\x1a")
+	}
+	if err := r.write(w, held, &written, size); err != nil {
+		return written, err
+	}
+	prog.report(written, true)
+	return written, nil
+}
+
+// write writes a block to w, cutting it off at size if that's known.
+func (r *Receiver) write(w io.Writer, data []byte, written *int64, size int64) error {
+	if size > 0 {
+		data = data[:max(0, min(int64(len(data)), size-*written))]
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	n, err := w.Write(data)
+	*written += int64(n)
+	if err != nil {
+		r.port.cancel()
+	}
+	return err
+}
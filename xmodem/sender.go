@@ -0,0 +1,269 @@
+package xmodem
+
+import (
+	"io"
+	"time"
+
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// Sender sends data with XMODEM.
+type Sender struct {
+	port      *port
+	config    *Config
+	logger    zmodem.Logger
+	callbacks *zmodem.Callbacks
+
+	// Negotiated with the receiver by WaitForReceiver
+	crc       bool
+	streaming bool
+
+	blockNum byte // Number of the next block
+}
+
+// NewSender creates a new XMODEM sender.
+func NewSender(reader zmodem.ReaderWithTimeout, writer io.Writer, config *Config) *Sender {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	logger := config.Logger
+	if logger == nil {
+		logger = zmodem.NoopLogger{}
+	}
+	return &Sender{
+		port:      newPort(reader, writer, config.Timeout, config.Context),
+		config:    config,
+		logger:    logger,
+		callbacks: zmodem.MergeCallbacks(config.Callbacks),
+		blockNum:  1,
+	}
+}
+
+// WaitForReceiver waits for the receiver to ask for the first block with
+// NAK (checksum), 'C' (CRC-16) or 'G' (XMODEM-g), and sets up the transfer
+// to match. Block numbering restarts at 1.
+// This matches getnak() from lsz.c.
+func (s *Sender) WaitForReceiver() error {
+	s.blockNum = 1
+	for errors := 0; ; {
+		c, err := s.port.readByte(0)
+		switch {
+		case err != nil && isTimeout(err):
+			if errors++; errors > s.config.MaxErrors {
+				return zmodem.NewError(zmodem.ErrTimeout, "timeout waiting for receiver")
+			}
+			continue
+		case err != nil:
+			return err
+		}
+
+		switch c {
+		case zmodem.NAK:
+			s.crc, s.streaming = false, false
+		case zmodem.WANTCRC:
+			s.crc, s.streaming = true, false
+		case zmodem.WANTG:
+			s.crc, s.streaming = true, true
+		case zmodem.CAN:
+			if s.cancelled() {
+				return zmodem.NewError(zmodem.ErrCancelled, "receiver cancelled")
+			}
+			continue
+		default:
+			// Line noise, or the tail of a shell prompt
+			continue
+		}
+		s.logger.Info("xmodem: receiver ready (crc=%v, streaming=%v)", s.crc, s.streaming)
+		return nil
+	}
+}
+
+// cancelled reports whether a CAN just read is followed by another, which
+// is how a receiver aborts.
+func (s *Sender) cancelled() bool {
+	c, err := s.port.readByte(time.Second)
+	return err == nil && c == zmodem.CAN
+}
+
+// SendBlock sends data as the next block, padded with CP/M EOF to 128 or
+// 1024 bytes, and waits for the receiver to take it. Blocks longer than
+// 128 bytes are sent as 1K blocks.
+// This matches wcputsec() from lsz.c.
+func (s *Sender) SendBlock(data []byte) error {
+	size := BlockSize
+	if len(data) > BlockSize {
+		size = BlockSize1K
+	}
+	if len(data) > size {
+		return zmodem.NewError(zmodem.ErrProtocol, "block too long")
+	}
+	if err := s.sendBlock(s.blockNum, data, size, zmodem.CPMEOF); err != nil {
+		return err
+	}
+	s.blockNum++
+	return nil
+}
+
+// SendBlock0 sends data as block 0, padded with NULs, which is how YMODEM
+// sends its file headers. It doesn't change the number of the next block.
+func (s *Sender) SendBlock0(data []byte) error {
+	size := BlockSize
+	if len(data) > BlockSize {
+		size = BlockSize1K
+	}
+	if len(data) > size {
+		return zmodem.NewError(zmodem.ErrProtocol, "block too long")
+	}
+	return s.sendBlock(0, data, size, 0)
+}
+
+// sendBlock sends one block and waits for it to be acknowledged, resending
+// it as needed.
+func (s *Sender) sendBlock(num byte, data []byte, size int, pad byte) error {
+	block := make([]byte, 0, 3+size+2)
+	if size == BlockSize1K {
+		block = append(block, zmodem.STX)
+	} else {
+		block = append(block, zmodem.SOH)
+	}
+	block = append(block, num, ^num)
+	block = append(block, data...)
+	for len(block) < 3+size {
+		block = append(block, pad)
+	}
+	if s.crc {
+		crc := zmodem.CRC16(block[3:])
+		block = append(block, byte(crc>>8), byte(crc))
+	} else {
+		block = append(block, checksum(block[3:]))
+	}
+
+	for errors := 0; ; {
+		if _, err := s.port.writer.Write(block); err != nil {
+			return err
+		}
+		if s.streaming {
+			return nil
+		}
+
+		err := s.waitAck()
+		if err == nil {
+			return nil
+		}
+		if zmodem.IsCancelled(err) {
+			return err
+		}
+		s.logger.Error("xmodem: block %d: %v", num, err)
+		if errors++; errors > s.config.MaxErrors {
+			s.port.cancel()
+			return err
+		}
+	}
+}
+
+// waitAck waits for the receiver's answer to a block. Anything other than
+// an ACK is an error that means the block should be sent again.
+func (s *Sender) waitAck() error {
+	for {
+		c, err := s.port.readByte(0)
+		if err != nil {
+			return err
+		}
+		switch c {
+		case zmodem.ACK:
+			return nil
+		case zmodem.NAK:
+			return zmodem.NewError(zmodem.ErrCRC, "block rejected")
+		case zmodem.WANTCRC, zmodem.WANTG:
+			// Receiver still asking for the first block
+			return zmodem.NewError(zmodem.ErrProtocol, "block not received")
+		case zmodem.CAN:
+			if s.cancelled() {
+				return zmodem.NewError(zmodem.ErrCancelled, "receiver cancelled")
+			}
+		}
+	}
+}
+
+// SendStream sends everything r returns as blocks. total is the size for
+// progress reports, 0 if unknown. It returns the number of bytes sent.
+func (s *Sender) SendStream(name string, r io.Reader, total int64) (int64, error) {
+	size := BlockSize
+	if s.config.Block1K {
+		size = BlockSize1K
+	}
+	prog := newProgress(s.callbacks, s.config.ProgressInterval, name, total)
+
+	buf := make([]byte, size)
+	var sent int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			// Send a short tail as 128-byte blocks rather than pad a 1K one
+			for chunk := buf[:n]; len(chunk) > 0; {
+				m := len(chunk)
+				if m <= BlockSize1K-BlockSize {
+					m = min(m, BlockSize)
+				}
+				if err := s.SendBlock(chunk[:m]); err != nil {
+					return sent, err
+				}
+				sent += int64(m)
+				chunk = chunk[m:]
+			}
+			prog.report(sent, false)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			prog.report(sent, true)
+			return sent, nil
+		}
+		if err != nil {
+			s.port.cancel()
+			return sent, err
+		}
+	}
+}
+
+// SendEOT ends the transfer and waits for the receiver to acknowledge it.
+// This matches the EOT loop in wctx() from lsz.c.
+func (s *Sender) SendEOT() error {
+	for errors := 0; ; {
+		if err := s.port.write(zmodem.EOT); err != nil {
+			return err
+		}
+		err := s.waitAck()
+		if err == nil {
+			return nil
+		}
+		if zmodem.IsCancelled(err) {
+			return err
+		}
+		if errors++; errors > s.config.MaxErrors {
+			return zmodem.NewError(zmodem.ErrTimeout, "no acknowledgement for EOT")
+		}
+	}
+}
+
+// Send sends a whole file: it waits for the receiver, sends the data and
+// ends the transfer. name is only used for callbacks, as XMODEM doesn't
+// carry file names.
+func (s *Sender) Send(name string, r io.Reader, size int64) error {
+	s.callbacks.OnFileStart(&zmodem.FileHeader{Name: name, Size: size})
+	start := time.Now()
+
+	err := s.WaitForReceiver()
+	var sent int64
+	if err == nil {
+		sent, err = s.SendStream(name, r, size)
+	}
+	if err == nil {
+		err = s.SendEOT()
+	}
+	if err != nil {
+		s.callbacks.OnError(err, "send file")
+		return err
+	}
+
+	s.callbacks.OnFileComplete(name, sent, time.Since(start))
+	return nil
+}
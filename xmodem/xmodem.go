@@ -0,0 +1,236 @@
+// Package xmodem implements the XMODEM file transfer protocol, as spoken by
+// sx/rx from lrzsz: 128-byte blocks with an arithmetic checksum or CRC-16,
+// 1K blocks (XMODEM-1K), and the streaming XMODEM-g variant.
+//
+// It shares its conventions with the zmodem package: the line is a
+// zmodem.ReaderWithTimeout and an io.Writer, timeouts are in tenths of a
+// second, errors are *zmodem.Error, and events go to zmodem.Callbacks.
+package xmodem
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// Block sizes.
+const (
+	BlockSize   = 128
+	BlockSize1K = 1024
+)
+
+// Config holds XMODEM configuration for both senders and receivers.
+type Config struct {
+	// Block1K sends 1024-byte blocks (XMODEM-1K). Receivers always accept
+	// both sizes.
+	Block1K bool
+
+	// CRC makes the receiver ask for CRC-16 ('C') rather than the
+	// checksum. It falls back to the checksum if the sender doesn't
+	// answer.
+	CRC bool
+
+	// Streaming makes the receiver ask for XMODEM-g ('G'): blocks are
+	// never acknowledged and any error aborts the transfer. Only use it on
+	// error free links.
+	Streaming bool
+
+	// Timeout for each read, in tenths of seconds
+	Timeout int
+
+	// MaxErrors is how many consecutive errors abort the transfer
+	MaxErrors int
+
+	// StripPadding makes the receiver drop the CP/M EOF (^Z) padding from
+	// the end of the last block
+	StripPadding bool
+
+	Context          context.Context
+	Logger           zmodem.Logger
+	Callbacks        *zmodem.Callbacks
+	ProgressInterval time.Duration
+}
+
+// DefaultConfig returns a default configuration.
+func DefaultConfig() *Config {
+	return &Config{
+		Block1K:          false,
+		CRC:              true,
+		Streaming:        false,
+		Timeout:          100, // 10 seconds
+		MaxErrors:        10,
+		StripPadding:     true,
+		Context:          context.Background(),
+		ProgressInterval: 100 * time.Millisecond,
+	}
+}
+
+// port does buffered, timed reads on the line.
+// This matches the parts of zreadline.c that sx/rx use.
+type port struct {
+	reader  zmodem.ReaderWithTimeout
+	writer  io.Writer
+	buf     []byte
+	pos     int
+	n       int
+	timeout time.Duration
+	ctx     context.Context
+}
+
+func newPort(reader zmodem.ReaderWithTimeout, writer io.Writer, timeout int, ctx context.Context) *port {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &port{
+		reader:  reader,
+		writer:  writer,
+		buf:     make([]byte, 1100),
+		timeout: time.Duration(timeout) * 100 * time.Millisecond,
+		ctx:     ctx,
+	}
+}
+
+// readByte reads a byte, waiting at most timeout (0 means the port's
+// default). An expired deadline becomes a zmodem timeout error.
+func (p *port) readByte(timeout time.Duration) (byte, error) {
+	if p.pos < p.n {
+		b := p.buf[p.pos]
+		p.pos++
+		return b, nil
+	}
+
+	select {
+	case <-p.ctx.Done():
+		return 0, zmodem.NewError(zmodem.ErrCancelled, p.ctx.Err().Error())
+	default:
+	}
+
+	if timeout == 0 {
+		timeout = p.timeout
+	}
+	if timeout > 0 {
+		if err := p.reader.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return 0, err
+		}
+	}
+
+	var n int
+	var err error
+	if cr, ok := p.reader.(zmodem.ContextReader); ok {
+		n, err = cr.ReadContext(p.ctx, p.buf)
+	} else {
+		n, err = p.reader.Read(p.buf)
+	}
+	if n > 0 {
+		p.pos, p.n = 1, n
+		return p.buf[0], nil
+	}
+	if err == nil {
+		err = io.ErrNoProgress
+	}
+	if isTimeout(err) {
+		return 0, zmodem.NewError(zmodem.ErrTimeout, "timeout")
+	}
+	if p.ctx.Err() != nil {
+		return 0, zmodem.NewError(zmodem.ErrCancelled, p.ctx.Err().Error())
+	}
+	return 0, err
+}
+
+// unreadByte puts back the byte readByte just returned.
+func (p *port) unreadByte() {
+	p.pos--
+}
+
+// readFull fills buf, giving each byte the default timeout.
+func (p *port) readFull(buf []byte) error {
+	for i := range buf {
+		b, err := p.readByte(0)
+		if err != nil {
+			return err
+		}
+		buf[i] = b
+	}
+	return nil
+}
+
+// purge discards input until the line has been quiet for a second.
+// This matches purgeline() followed by the wait in wcgetsec().
+func (p *port) purge() {
+	p.pos, p.n = 0, 0
+	for {
+		if _, err := p.readByte(time.Second); err != nil {
+			return
+		}
+	}
+}
+
+func (p *port) write(b ...byte) error {
+	_, err := p.writer.Write(b)
+	return err
+}
+
+// cancel aborts the transfer on the remote side.
+func (p *port) cancel() {
+	Cancel(p.writer)
+}
+
+// Cancel aborts a transfer, or a receiver still waiting for one, on the
+// remote side of w.
+// This matches canit() from lrz.c.
+func Cancel(w io.Writer) error {
+	_, err := w.Write([]byte{
+		zmodem.CAN, zmodem.CAN, zmodem.CAN, zmodem.CAN, zmodem.CAN,
+		zmodem.CAN, zmodem.CAN, zmodem.CAN, zmodem.CAN, zmodem.CAN,
+		8, 8, 8, 8, 8, 8, 8, 8, 8, 8,
+	})
+	return err
+}
+
+// isTimeout reports whether err is an expired read deadline.
+func isTimeout(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) || zmodem.IsTimeout(err) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// checksum returns the arithmetic checksum of a block.
+func checksum(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return sum
+}
+
+// progress rate limits OnProgress calls.
+type progress struct {
+	callbacks *zmodem.Callbacks
+	interval  time.Duration
+	name      string
+	total     int64
+	start     time.Time
+	last      time.Time
+}
+
+func newProgress(callbacks *zmodem.Callbacks, interval time.Duration, name string, total int64) *progress {
+	now := time.Now()
+	return &progress{callbacks: callbacks, interval: interval, name: name, total: total, start: now, last: now}
+}
+
+func (p *progress) report(done int64, final bool) {
+	now := time.Now()
+	if !final && (p.interval <= 0 || now.Sub(p.last) < p.interval) {
+		return
+	}
+	p.last = now
+	rate := float64(done) / max(now.Sub(p.start).Seconds(), 1e-9)
+	p.callbacks.OnProgress(p.name, done, p.total, rate)
+}
@@ -0,0 +1,229 @@
+package xmodem
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// filterWriter passes writes through filter, which may drop them by
+// returning nil.
+type filterWriter struct {
+	w      io.Writer
+	filter func([]byte) []byte
+}
+
+func (f *filterWriter) Write(p []byte) (int, error) {
+	if q := f.filter(append([]byte(nil), p...)); q != nil {
+		if _, err := f.w.Write(q); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// end is one side of a serial line: both sides can write at once, as they
+// do when their timeouts expire together, which a net.Pipe doesn't allow.
+type end struct {
+	*os.File // Read side
+	w        *os.File
+}
+
+func (e *end) Write(p []byte) (int, error) {
+	return e.w.Write(p)
+}
+
+// line returns the two ends of a line, closed when the test ends.
+func line(t *testing.T) (*end, *end) {
+	t.Helper()
+	ar, bw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br, aw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for _, f := range []*os.File{ar, aw, br, bw} {
+			f.Close()
+		}
+	})
+	return &end{ar, aw}, &end{br, bw}
+}
+
+// transfer sends data from a sender with sendConfig to a receiver with
+// recvConfig and returns what arrived. sendFilter and recvFilter, if not
+// nil, see every write of the sender and the receiver.
+func transfer(t *testing.T, sendConfig, recvConfig *Config, data []byte, sendFilter, recvFilter func([]byte) []byte) (*Sender, []byte) {
+	t.Helper()
+	a, b := line(t)
+
+	var toSender, toReceiver io.Writer = b, a
+	if recvFilter != nil {
+		toSender = &filterWriter{w: b, filter: recvFilter}
+	}
+	if sendFilter != nil {
+		toReceiver = &filterWriter{w: a, filter: sendFilter}
+	}
+	var received bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		_, err := NewReceiver(b, toSender, recvConfig).Receive("data.bin", &received)
+		done <- err
+	}()
+
+	sender := NewSender(a, toReceiver, sendConfig)
+	if err := sender.Send("data.bin", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	return sender, received.Bytes()
+}
+
+// testConfig returns a configuration with short timeouts.
+func testConfig() *Config {
+	config := DefaultConfig()
+	config.Timeout = 5
+	return config
+}
+
+// randomData returns n random bytes that don't end in CP/M EOF.
+func randomData(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	data[n-1] = 'x'
+	return data
+}
+
+func TestRoundTrip(t *testing.T) {
+	data := randomData(10000)
+	tests := []struct {
+		name      string
+		block1K   bool
+		crc       bool
+		streaming bool
+	}{
+		{"checksum", false, false, false},
+		{"CRC-16", false, true, false},
+		{"1K", true, true, false},
+		{"1K checksum", true, false, false},
+		{"XMODEM-g", true, true, true},
+	}
+	for _, tt := range tests {
+		send, recv := testConfig(), testConfig()
+		send.Block1K = tt.block1K
+		recv.CRC, recv.Streaming = tt.crc, tt.streaming
+		sender, got := transfer(t, send, recv, data, nil, nil)
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s: received %d bytes that differ from the %d sent", tt.name, len(got), len(data))
+		}
+		if sender.crc != tt.crc || sender.streaming != tt.streaming {
+			t.Errorf("%s: sender used crc=%v streaming=%v", tt.name, sender.crc, sender.streaming)
+		}
+	}
+}
+
+func TestPadding(t *testing.T) {
+	data := randomData(200)
+
+	// The last block is padded to 128 bytes with ^Z
+	recv := testConfig()
+	recv.StripPadding = false
+	_, got := transfer(t, testConfig(), recv, data, nil, nil)
+	want := append(append([]byte(nil), data...), bytes.Repeat([]byte{zmodem.CPMEOF}, 2*BlockSize-len(data))...)
+	if !bytes.Equal(got, want) {
+		t.Errorf("without stripping, received %d bytes, want %d with the padding", len(got), len(want))
+	}
+
+	// and StripPadding takes it off again
+	_, got = transfer(t, testConfig(), testConfig(), data, nil, nil)
+	if !bytes.Equal(got, data) {
+		t.Errorf("with stripping, received %d bytes, want %d", len(got), len(data))
+	}
+}
+
+func TestDuplicateBlock(t *testing.T) {
+	data := randomData(1000)
+	dropped := false
+	loseFirstACK := func(p []byte) []byte {
+		if !dropped && len(p) == 1 && p[0] == zmodem.ACK {
+			dropped = true
+			return nil
+		}
+		return p
+	}
+	_, got := transfer(t, testConfig(), testConfig(), data, nil, loseFirstACK)
+	if !dropped {
+		t.Fatalf("no ACK to lose")
+	}
+	// The sender sent the block again, and it was written once
+	if !bytes.Equal(got, data) {
+		t.Errorf("received %d bytes that differ from the %d sent", len(got), len(data))
+	}
+}
+
+func TestCorruptBlock(t *testing.T) {
+	data := randomData(1000)
+	corrupted := false
+	corrupt := func(p []byte) []byte {
+		if !corrupted && len(p) > BlockSize {
+			corrupted = true
+			p[10] ^= 0xff
+		}
+		return p
+	}
+	// The receiver waits for a second of quiet before asking again
+	send := testConfig()
+	send.Timeout = 20
+	_, got := transfer(t, send, testConfig(), data, corrupt, nil)
+	if !corrupted {
+		t.Fatalf("no block to corrupt")
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("received %d bytes that differ from the %d sent", len(got), len(data))
+	}
+}
+
+func TestCancel(t *testing.T) {
+	// The receiver cancels after the first block
+	a, b := line(t)
+	go func() {
+		b.Write([]byte{zmodem.WANTCRC})
+		buf := make([]byte, 3+BlockSize+2)
+		if _, err := io.ReadFull(b, buf); err != nil {
+			return
+		}
+		Cancel(b)
+	}()
+	err := NewSender(a, a, testConfig()).Send("data.bin", bytes.NewReader(randomData(1000)), 1000)
+	if !zmodem.IsCancelled(err) {
+		t.Errorf("Send returned %v, want a cancellation", err)
+	}
+
+	// The sender cancels instead of sending the second block
+	a, b = line(t)
+	go func() {
+		sender := NewSender(a, a, testConfig())
+		if sender.WaitForReceiver() != nil || sender.SendBlock(randomData(BlockSize)) != nil {
+			return
+		}
+		Cancel(a)
+	}()
+	start := time.Now()
+	var received bytes.Buffer
+	_, err = NewReceiver(b, b, testConfig()).Receive("data.bin", &received)
+	if !zmodem.IsCancelled(err) {
+		t.Errorf("Receive returned %v, want a cancellation", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("cancellation took %v", d)
+	}
+}
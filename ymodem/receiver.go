@@ -0,0 +1,141 @@
+package ymodem
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/drunlade/go-lrzsz/xmodem"
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// Receiver receives batches of files with YMODEM.
+type Receiver struct {
+	xmodem    *xmodem.Receiver
+	logger    zmodem.Logger
+	callbacks *zmodem.Callbacks
+}
+
+// NewReceiver creates a new YMODEM receiver. A nil config uses
+// DefaultConfig.
+func NewReceiver(reader zmodem.ReaderWithTimeout, writer io.Writer, config *xmodem.Config) *Receiver {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	logger := config.Logger
+	if logger == nil {
+		logger = zmodem.NoopLogger{}
+	}
+	return &Receiver{
+		xmodem:    xmodem.NewReceiver(reader, writer, config),
+		logger:    logger,
+		callbacks: zmodem.MergeCallbacks(config.Callbacks),
+	}
+}
+
+// ReadHeader receives the next file's header from block 0. It returns
+// io.EOF at the end of the batch.
+// This matches wcrxpn() from lrz.c.
+func (r *Receiver) ReadHeader() (*zmodem.FileHeader, error) {
+	data, err := r.xmodem.ReadBlock0()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || data[0] == 0 {
+		return nil, io.EOF
+	}
+
+	hdr := &zmodem.FileHeader{}
+	if err := hdr.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	r.logger.Info("ymodem: received header %s", hdr)
+	return hdr, nil
+}
+
+// ReceiveFile receives the data of the file hdr describes into w and
+// returns its length. If the size is known, the padding of the last block
+// is cut off exactly.
+func (r *Receiver) ReceiveFile(hdr *zmodem.FileHeader, w io.Writer) (int64, error) {
+	return r.xmodem.ReceiveData(hdr.Name, w, hdr.Size)
+}
+
+// ReceiveFiles receives files until the sender ends the batch. Files are
+// offered to OnFilePrompt and written with OnFileCreate if it's set, or
+// created in the current directory under their LocalName otherwise.
+// YMODEM can't skip a file, so rejected files are received and thrown
+// away, as are files without a usable name.
+func (r *Receiver) ReceiveFiles() error {
+	for {
+		hdr, err := r.ReadHeader()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			r.callbacks.OnError(err, "read file header")
+			return err
+		}
+
+		if err := r.receive(hdr); err != nil {
+			return err
+		}
+	}
+}
+
+// receive receives one file of a batch.
+func (r *Receiver) receive(hdr *zmodem.FileHeader) error {
+	accept, err := r.callbacks.OnFilePrompt(hdr)
+	if err != nil {
+		return err
+	}
+	var name string
+	if accept && r.callbacks.OnFileCreate == nil {
+		// Not wherever the sender's path points
+		if name, err = hdr.LocalName(); err != nil {
+			r.logger.Error("ymodem: %v", err)
+			accept = false
+		}
+	}
+	if !accept {
+		r.logger.Info("ymodem: skipping %s", hdr.Name)
+		_, err := r.ReceiveFile(hdr, io.Discard)
+		return err
+	}
+
+	// Create file
+	var file io.Writer
+	if r.callbacks.OnFileCreate != nil {
+		file, err = r.callbacks.OnFileCreate(hdr)
+	} else {
+		file, err = os.Create(name)
+	}
+	if err != nil {
+		r.callbacks.OnError(err, "create file")
+		return err
+	}
+	if closer, ok := file.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	r.callbacks.OnFileStart(hdr)
+	start := time.Now()
+
+	n, err := r.ReceiveFile(hdr, file)
+	if err != nil {
+		r.callbacks.OnError(err, "receive file")
+		return err
+	}
+
+	// Set file permissions and mtime if possible
+	if f, ok := file.(*os.File); ok {
+		if hdr.Mode != 0 {
+			f.Chmod(hdr.Mode)
+		}
+		if !hdr.ModTime.IsZero() {
+			os.Chtimes(f.Name(), hdr.ModTime, hdr.ModTime)
+		}
+	}
+
+	r.callbacks.OnFileComplete(hdr.Name, n, time.Since(start))
+	return nil
+}
@@ -0,0 +1,152 @@
+package ymodem
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/drunlade/go-lrzsz/xmodem"
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// Sender sends batches of files with YMODEM.
+type Sender struct {
+	xmodem    *xmodem.Sender
+	logger    zmodem.Logger
+	callbacks *zmodem.Callbacks
+}
+
+// NewSender creates a new YMODEM sender. A nil config uses DefaultConfig.
+func NewSender(reader zmodem.ReaderWithTimeout, writer io.Writer, config *xmodem.Config) *Sender {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	logger := config.Logger
+	if logger == nil {
+		logger = zmodem.NoopLogger{}
+	}
+	return &Sender{
+		xmodem:    xmodem.NewSender(reader, writer, config),
+		logger:    logger,
+		callbacks: zmodem.MergeCallbacks(config.Callbacks),
+	}
+}
+
+// SendFile sends one file of a batch: its header in block 0, then its data.
+// This matches wctxpn() and wctx() from lsz.c.
+func (s *Sender) SendFile(hdr *zmodem.FileHeader, file io.Reader) error {
+	s.callbacks.OnFileStart(hdr)
+	start := time.Now()
+
+	sent, err := s.sendFile(hdr, file)
+	if err != nil {
+		s.callbacks.OnError(err, "send file")
+		return err
+	}
+
+	s.callbacks.OnFileComplete(hdr.Name, sent, time.Since(start))
+	return nil
+}
+
+func (s *Sender) sendFile(hdr *zmodem.FileHeader, file io.Reader) (int64, error) {
+	data, err := hdr.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+	if len(data) > xmodem.BlockSize1K {
+		return 0, zmodem.NewError(zmodem.ErrProtocol, "file header too long")
+	}
+
+	if err := s.xmodem.WaitForReceiver(); err != nil {
+		return 0, err
+	}
+	s.logger.Info("ymodem: sending header %s", hdr)
+	if err := s.xmodem.SendBlock0(data); err != nil {
+		return 0, err
+	}
+
+	// The receiver asks for the data afresh once it has the header
+	if err := s.xmodem.WaitForReceiver(); err != nil {
+		return 0, err
+	}
+	sent, err := s.xmodem.SendStream(hdr.Name, file, hdr.Size)
+	if err != nil {
+		return sent, err
+	}
+	return sent, s.xmodem.SendEOT()
+}
+
+// Finish ends the batch with an empty block 0.
+func (s *Sender) Finish() error {
+	if err := s.xmodem.WaitForReceiver(); err != nil {
+		return err
+	}
+	return s.xmodem.SendBlock0(nil)
+}
+
+// SendFiles sends files as one batch and ends it. Files are opened with
+// OnFileOpen if it's set.
+func (s *Sender) SendFiles(files []zmodem.FileInfo) error {
+	filesLeft := len(files)
+	var bytesLeft int64
+	for _, f := range files {
+		if f.Info != nil {
+			bytesLeft += f.Info.Size()
+		}
+	}
+
+	for _, f := range files {
+		file, info, closer, err := s.open(f)
+		if err != nil {
+			s.callbacks.OnError(err, "open file")
+			filesLeft--
+			continue
+		}
+
+		hdr := zmodem.NewFileHeader(filepath.Base(f.Filename), info)
+		hdr.FilesLeft = filesLeft
+		hdr.BytesLeft = max(bytesLeft, hdr.Size)
+		filesLeft--
+		bytesLeft -= hdr.Size
+
+		err = s.SendFile(hdr, file)
+		if closer != nil {
+			closer.Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.Finish()
+}
+
+// open opens a file for SendFiles.
+func (s *Sender) open(f zmodem.FileInfo) (io.Reader, os.FileInfo, io.Closer, error) {
+	if s.callbacks.OnFileOpen != nil {
+		file, info, err := s.callbacks.OnFileOpen(f.Filename)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if info == nil {
+			info = f.Info
+		}
+		if info == nil {
+			return nil, nil, nil, zmodem.NewError(zmodem.ErrIO, "no file info for "+f.Filename)
+		}
+		closer, _ := file.(io.Closer)
+		return file, info, closer, nil
+	}
+
+	file, err := os.Open(f.Filename)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, nil, err
+	}
+	return file, info, file, nil
+}
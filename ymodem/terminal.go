@@ -0,0 +1,174 @@
+package ymodem
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/drunlade/go-lrzsz/xmodem"
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// TerminalProtocol detects XMODEM and YMODEM receivers, such as rx, rb or a
+// bootloader's loady, waiting in terminal output and sends them the files
+// OnFileList returns. Register it with zmodem.WithTerminalProtocol.
+//
+// Receivers wait by repeating a prompt every few seconds: 'C' asks for
+// CRC-16, NAK for the checksum and 'G' for streaming. A prompt only counts
+// when nothing follows it in the output so far. The first one must start
+// a line, and the rest must be the same prompt again, on its own after a
+// pause, the way a receiver repeats it; text like "CC" or "Go" doesn't
+// count even when it arrives a byte at a time. A transfer starts after
+// Prompts of them. 'C' and 'G' get YMODEM (YMODEM-g), NAK gets XMODEM, as
+// YMODEM needs CRC-16.
+type TerminalProtocol struct {
+	// Prompts is how many prompts in a row start a transfer. One prompt is
+	// easily typed by hand or printed by accident.
+	Prompts int
+
+	// Pause is the shortest time between two prompts for the second one to
+	// count. Receivers wait seconds before they repeat themselves.
+	Pause time.Duration
+
+	// XModem sends to 'C' and 'G' receivers with XMODEM too, for ones that
+	// don't understand YMODEM's block 0. Only the first file is sent.
+	XModem bool
+
+	// Block1K sends XMODEM with 1K blocks. YMODEM always uses them.
+	Block1K bool
+
+	prompt byte      // Last prompt seen
+	count  int       // Prompts seen in a row
+	seen   time.Time // When the last one was counted
+	last   byte      // Last byte seen
+}
+
+// NewTerminalProtocol creates a TerminalProtocol that starts a transfer
+// after two prompts at least half a second apart.
+func NewTerminalProtocol() *TerminalProtocol {
+	return &TerminalProtocol{Prompts: 2, Pause: 500 * time.Millisecond, last: '\n'}
+}
+
+// Name implements zmodem.TerminalProtocol.
+func (d *TerminalProtocol) Name() string {
+	if d.XModem || d.prompt == zmodem.NAK {
+		return "XMODEM"
+	}
+	return "YMODEM"
+}
+
+// Detect implements zmodem.TerminalProtocol. The transfer starts at the
+// last prompt, which the sender reads to pick its mode.
+func (d *TerminalProtocol) Detect(p []byte) int {
+	if len(p) == 0 {
+		return -1
+	}
+	c, prev := p[len(p)-1], d.last
+	if len(p) > 1 {
+		prev = p[len(p)-2]
+	}
+	d.last = c
+
+	now := time.Now()
+	switch {
+	case !isPrompt(c):
+		d.count = 0
+	case len(p) == 1 && d.count > 0 && c == d.prompt:
+		// The receiver again, unless it's too soon to be; that doesn't
+		// count, but doesn't end the run either
+		if now.Sub(d.seen) >= d.Pause {
+			d.count++
+		}
+		d.seen = now
+	case prev == '\n' || prev == '\r':
+		d.prompt, d.count, d.seen = c, 1, now
+	default:
+		d.count = 0
+	}
+
+	if d.count == 0 || d.count < max(d.Prompts, 1) {
+		return -1
+	}
+	d.count = 0
+	d.last = 0
+	return len(p) - 1
+}
+
+// isPrompt reports whether c is a receiver asking for the first block.
+func isPrompt(c byte) bool {
+	return c == zmodem.WANTCRC || c == zmodem.NAK || c == zmodem.WANTG
+}
+
+// Transfer implements zmodem.TerminalProtocol. Without files to send, the
+// receiver is cancelled.
+func (d *TerminalProtocol) Transfer(t *zmodem.TerminalTransfer) error {
+	var files []string
+	if t.Callbacks.OnFileList != nil {
+		list, err := t.Callbacks.OnFileList()
+		if err != nil {
+			xmodem.Cancel(t.Writer)
+			return err
+		}
+		files = list
+	}
+	if len(files) == 0 {
+		t.Logger.Info("ymodem: no files to send, cancelling receiver")
+		return xmodem.Cancel(t.Writer)
+	}
+
+	config := DefaultConfig()
+	config.Timeout = t.Config.Timeout
+	config.Context = t.Context
+	config.Logger = t.Logger
+	config.Callbacks = t.Callbacks
+	config.ProgressInterval = t.Config.ProgressInterval
+
+	if d.XModem || d.prompt == zmodem.NAK {
+		if len(files) > 1 {
+			t.Logger.Info("xmodem: only sending %s, XMODEM can't send %d more", files[0], len(files)-1)
+		}
+		config.Block1K = d.Block1K
+		return sendXModem(t, files[0], config)
+	}
+
+	infos := make([]zmodem.FileInfo, 0, len(files))
+	for _, f := range files {
+		info, _ := os.Stat(f)
+		infos = append(infos, zmodem.FileInfo{Filename: f, Info: info})
+	}
+	return NewSender(t.Reader, t.Writer, config).SendFiles(infos)
+}
+
+// sendXModem sends a single file with XMODEM.
+func sendXModem(t *zmodem.TerminalTransfer, filename string, config *xmodem.Config) error {
+	var file io.Reader
+	var size int64
+	if t.Callbacks.OnFileOpen != nil {
+		f, info, err := t.Callbacks.OnFileOpen(filename)
+		if err != nil {
+			xmodem.Cancel(t.Writer)
+			return err
+		}
+		if closer, ok := f.(io.Closer); ok {
+			defer closer.Close()
+		}
+		if info != nil {
+			size = info.Size()
+		}
+		file = f
+	} else {
+		f, err := os.Open(filename)
+		if err != nil {
+			xmodem.Cancel(t.Writer)
+			return err
+		}
+		defer f.Close()
+		if info, err := f.Stat(); err == nil {
+			size = info.Size()
+		}
+		file = f
+	}
+
+	return xmodem.NewSender(t.Reader, t.Writer, config).Send(filepath.Base(filename), file, size)
+}
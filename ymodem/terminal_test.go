@@ -0,0 +1,65 @@
+package ymodem
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDetect(t *testing.T) {
+	const pause = 20 * time.Millisecond
+	tests := []struct {
+		name   string
+		chunks []string
+		wait   bool // Pause between chunks, like a receiver
+		want   bool
+	}{
+		{"receiver", []string{"$ rb\r\nC", "C"}, true, true},
+		{"prompt alone", []string{"C", "C"}, true, true},
+		{"streaming", []string{"\nG", "G"}, true, true},
+		{"checksum", []string{"\r\n\x15", "\x15"}, true, true},
+		{"too soon", []string{"\nC", "C"}, false, false},
+		{"text a byte at a time", []string{"\nC", "C", "ontinue"}, false, false},
+		{"text after a pause", []string{"\nC", "G", "o"}, true, false},
+		{"other prompt", []string{"\nC", "G"}, true, false},
+		{"together", []string{"\nCC"}, false, false},
+		{"not a line start", []string{"ABC", "C"}, true, false},
+		{"more output", []string{"\nC", "C\n"}, true, false},
+	}
+
+	for _, tt := range tests {
+		d := NewTerminalProtocol()
+		d.Pause = pause
+		got := false
+		for i, chunk := range tt.chunks {
+			if tt.wait && i > 0 {
+				time.Sleep(pause + 5*time.Millisecond)
+			}
+			if at := d.Detect([]byte(chunk)); at >= 0 {
+				if at != len(chunk)-1 {
+					t.Errorf("%s: transfer starts at %d, want the last prompt at %d", tt.name, at, len(chunk)-1)
+				}
+				got = true
+			}
+		}
+		if got != tt.want {
+			t.Errorf("%s: detected %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDetectTooSoonDoesNotCount(t *testing.T) {
+	const pause = 20 * time.Millisecond
+	d := NewTerminalProtocol()
+	d.Pause = pause
+
+	// A repeat that arrives too soon is ignored, not the end of the run
+	for _, chunk := range []string{"\nC", "C"} {
+		if d.Detect([]byte(chunk)) >= 0 {
+			t.Fatalf("detected after %q", chunk)
+		}
+	}
+	time.Sleep(pause + 5*time.Millisecond)
+	if d.Detect([]byte("C")) < 0 {
+		t.Errorf("not detected after a repeat with a pause")
+	}
+}
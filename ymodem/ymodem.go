@@ -0,0 +1,22 @@
+// Package ymodem implements YMODEM batch transfers, as spoken by sb/rb from
+// lrzsz and by bootloaders such as U-Boot's loady, including the streaming
+// YMODEM-g variant.
+//
+// YMODEM is XMODEM-1K with CRC-16, plus a block 0 before each file that
+// carries its ZModem style file header (see zmodem.FileHeader). A block 0
+// with an empty name ends the batch. Blocks are sent and received with the
+// xmodem package, so configuration is an *xmodem.Config.
+package ymodem
+
+import (
+	"github.com/drunlade/go-lrzsz/xmodem"
+)
+
+// DefaultConfig returns a default configuration: 1K blocks with CRC-16.
+// Set Streaming for YMODEM-g.
+func DefaultConfig() *xmodem.Config {
+	config := xmodem.DefaultConfig()
+	config.Block1K = true
+	config.CRC = true
+	return config
+}
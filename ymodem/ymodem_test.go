@@ -0,0 +1,208 @@
+package ymodem
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drunlade/go-lrzsz/xmodem"
+	"github.com/drunlade/go-lrzsz/zmodem"
+)
+
+// end is one side of a serial line. Unlike with a net.Pipe, both sides can
+// write at once.
+type end struct {
+	*os.File // Read side
+	w        *os.File
+
+	mu     sync.Mutex
+	blocks [][]byte // Every write, as sent
+}
+
+func (e *end) Write(p []byte) (int, error) {
+	e.mu.Lock()
+	e.blocks = append(e.blocks, append([]byte(nil), p...))
+	e.mu.Unlock()
+	return e.w.Write(p)
+}
+
+// block0s returns the data of the block 0s written.
+func (e *end) block0s() [][]byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var blocks [][]byte
+	for _, p := range e.blocks {
+		if len(p) > 3 && (p[0] == zmodem.SOH || p[0] == zmodem.STX) && p[1] == 0 && p[2] == 0xff {
+			size := xmodem.BlockSize
+			if p[0] == zmodem.STX {
+				size = xmodem.BlockSize1K
+			}
+			blocks = append(blocks, p[3:3+size])
+		}
+	}
+	return blocks
+}
+
+// line returns the two ends of a line, closed when the test ends.
+func line(t *testing.T) (*end, *end) {
+	t.Helper()
+	ar, bw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br, aw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for _, f := range []*os.File{ar, aw, br, bw} {
+			f.Close()
+		}
+	})
+	return &end{File: ar, w: aw}, &end{File: br, w: bw}
+}
+
+// testFile writes a file of n random bytes ending in CP/M EOF, which only
+// the size in block 0 tells from padding.
+func testFile(t *testing.T, dir, name string, n int) ([]byte, os.FileInfo) {
+	t.Helper()
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	for i := max(0, n-10); i < n; i++ {
+		data[i] = zmodem.CPMEOF
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0640); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2022, 6, 7, 8, 9, 10, 0, time.UTC)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, info
+}
+
+// batch sends files as a batch and returns the headers and data that
+// arrived, by name, and what the sender wrote.
+func batch(t *testing.T, config *xmodem.Config, files []zmodem.FileInfo, accept func(*zmodem.FileHeader) bool) (map[string]*zmodem.FileHeader, map[string]*bytes.Buffer, *end) {
+	t.Helper()
+	a, b := line(t)
+	headers := map[string]*zmodem.FileHeader{}
+	received := map[string]*bytes.Buffer{}
+
+	recvConfig := *config
+	recvConfig.Callbacks = &zmodem.Callbacks{
+		OnFilePrompt: func(hdr *zmodem.FileHeader) (bool, error) {
+			headers[hdr.Name] = hdr
+			return accept == nil || accept(hdr), nil
+		},
+		OnFileCreate: func(hdr *zmodem.FileHeader) (io.Writer, error) {
+			received[hdr.Name] = &bytes.Buffer{}
+			return received[hdr.Name], nil
+		},
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- NewReceiver(b, b, &recvConfig).ReceiveFiles()
+	}()
+
+	sendConfig := *config
+	if err := NewSender(a, a, &sendConfig).SendFiles(files); err != nil {
+		t.Fatalf("SendFiles: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("ReceiveFiles: %v", err)
+	}
+	return headers, received, a
+}
+
+func TestBatch(t *testing.T) {
+	dir := t.TempDir()
+	one, oneInfo := testFile(t, dir, "one.bin", 3000)
+	two, twoInfo := testFile(t, dir, "two.bin", 100)
+	files := []zmodem.FileInfo{
+		{Filename: filepath.Join(dir, "one.bin"), Info: oneInfo},
+		{Filename: filepath.Join(dir, "two.bin"), Info: twoInfo},
+	}
+
+	for _, streaming := range []bool{false, true} {
+		config := DefaultConfig()
+		config.Timeout = 5
+		config.Streaming = streaming
+		headers, received, sender := batch(t, config, files, nil)
+
+		for name, want := range map[string][]byte{"one.bin": one, "two.bin": two} {
+			if got := received[name]; got == nil {
+				t.Errorf("streaming=%v: %s didn't arrive", streaming, name)
+			} else if !bytes.Equal(got.Bytes(), want) {
+				t.Errorf("streaming=%v: %s arrived as %d bytes, want %d", streaming, name, got.Len(), len(want))
+			}
+			hdr := headers[name]
+			if hdr == nil {
+				continue
+			}
+			if hdr.Size != int64(len(want)) || !hdr.ModTime.Equal(oneInfo.ModTime()) || hdr.Mode.Perm() != 0640 {
+				t.Errorf("streaming=%v: %s header has size %d, time %v and mode %v", streaming, name, hdr.Size, hdr.ModTime, hdr.Mode)
+			}
+		}
+		if hdr := headers["one.bin"]; hdr != nil && hdr.FilesLeft != 2 {
+			t.Errorf("streaming=%v: one.bin header has %d files left, want 2", streaming, hdr.FilesLeft)
+		}
+
+		// Block 0 is the header as lsz encodes it, padded with NULs, and an
+		// empty one ends the batch
+		blocks := sender.block0s()
+		if len(blocks) != 3 {
+			t.Fatalf("streaming=%v: sent %d block 0s, want 3", streaming, len(blocks))
+		}
+		want := "one.bin\x003000 " + strconv.FormatInt(oneInfo.ModTime().Unix(), 8) + " 100640 0 2 3100"
+		if got := string(bytes.TrimRight(blocks[0], "\x00")); got != want {
+			t.Errorf("streaming=%v: first block 0 is %q, want %q", streaming, got, want)
+		}
+		if len(bytes.Trim(blocks[2], "\x00")) != 0 {
+			t.Errorf("streaming=%v: last block 0 is %q, want it empty", streaming, blocks[2])
+		}
+	}
+}
+
+func TestBatchSkip(t *testing.T) {
+	dir := t.TempDir()
+	_, oneInfo := testFile(t, dir, "one.bin", 3000)
+	two, twoInfo := testFile(t, dir, "two.bin", 100)
+	files := []zmodem.FileInfo{
+		{Filename: filepath.Join(dir, "one.bin"), Info: oneInfo},
+		{Filename: filepath.Join(dir, "two.bin"), Info: twoInfo},
+	}
+
+	config := DefaultConfig()
+	config.Timeout = 5
+	_, received, _ := batch(t, config, files, func(hdr *zmodem.FileHeader) bool {
+		return hdr.Name != "one.bin"
+	})
+	if received["one.bin"] != nil {
+		t.Errorf("one.bin was created after it was refused")
+	}
+	if got := received["two.bin"]; got == nil || !bytes.Equal(got.Bytes(), two) {
+		t.Errorf("two.bin after a refused file didn't arrive")
+	}
+}
+
+func TestReadHeaderEnd(t *testing.T) {
+	a, b := line(t)
+	config := DefaultConfig()
+	config.Timeout = 5
+	go NewSender(a, a, config).Finish()
+	if hdr, err := NewReceiver(b, b, config).ReadHeader(); err != io.EOF {
+		t.Errorf("ReadHeader of an empty block 0 returned %v, %v; want io.EOF", hdr, err)
+	}
+}
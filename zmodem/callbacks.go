@@ -12,7 +12,7 @@ type Callbacks struct {
 	// OnFilePrompt is called when a file transfer is about to start.
 	// Return true to accept the file, false to skip it.
 	// If an error is returned, the transfer is aborted.
-	OnFilePrompt func(filename string, size int64, mode os.FileMode) (bool, error)
+	OnFilePrompt func(hdr *FileHeader) (bool, error)
 
 	// OnProgress is called periodically during file transfer.
 	// filename: name of the file being transferred
@@ -22,7 +22,7 @@ type Callbacks struct {
 	OnProgress func(filename string, transferred, total int64, rate float64)
 	
 	// OnFileStart is called when a file transfer starts.
-	OnFileStart func(filename string, size int64, mode os.FileMode)
+	OnFileStart func(hdr *FileHeader)
 
 	// OnFileComplete is called when a file transfer completes.
 	// duration: time taken for the transfer
@@ -40,13 +40,19 @@ type Callbacks struct {
 	// It should return a list of files to send, or nil/empty slice if no files to send.
 	OnFileList func() ([]string, error)
 
+	// OnLocalPrompt is called when the Config.Hotkey is typed into
+	// TerminalIO, to ask which files to upload or download. Return nil to
+	// do nothing. It runs on its own goroutine, while input and output go
+	// on.
+	OnLocalPrompt func() (*TransferRequest, error)
+
 	// OnFileOpen is called when opening a file for reading (sender).
 	// If nil, uses default file opening.
 	OnFileOpen func(filename string) (io.Reader, os.FileInfo, error)
 
 	// OnFileCreate is called when creating a file for writing (receiver).
 	// If nil, uses default file creation.
-	OnFileCreate func(filename string, size int64, mode os.FileMode) (io.Writer, error)
+	OnFileCreate func(hdr *FileHeader) (io.Writer, error)
 }
 
 // Event represents a protocol event for logging/debugging.
@@ -73,11 +79,11 @@ const (
 // defaultCallbacks returns a set of callbacks with default implementations.
 func defaultCallbacks() *Callbacks {
 	return &Callbacks{
-		OnFilePrompt: func(string, int64, os.FileMode) (bool, error) {
+		OnFilePrompt: func(*FileHeader) (bool, error) {
 			return true, nil // Accept all files by default
 		},
 		OnProgress:     func(string, int64, int64, float64) {},
-		OnFileStart:    func(string, int64, os.FileMode) {},
+		OnFileStart:    func(*FileHeader) {},
 		OnFileComplete: func(string, int64, time.Duration) {},
 		OnError: func(error, string) bool {
 			return false // Don't retry by default
@@ -88,9 +94,9 @@ func defaultCallbacks() *Callbacks {
 	}
 }
 
-// mergeCallbacks merges user callbacks with defaults.
+// MergeCallbacks merges user callbacks with defaults.
 // User callbacks override defaults, nil callbacks use defaults.
-func mergeCallbacks(user *Callbacks) *Callbacks {
+func MergeCallbacks(user *Callbacks) *Callbacks {
 	if user == nil {
 		return defaultCallbacks()
 	}
@@ -143,6 +149,7 @@ func mergeCallbacks(user *Callbacks) *Callbacks {
 
 	// File operations (nil means use default)
 	result.OnFileList = user.OnFileList
+	result.OnLocalPrompt = user.OnLocalPrompt
 	result.OnFileOpen = user.OnFileOpen
 	result.OnFileCreate = user.OnFileCreate
 
@@ -0,0 +1,229 @@
+package zmodem
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Charset is the character set file names use on the wire. ZModem itself
+// just carries bytes; modern peers send UTF-8, while BBS and DOS-era ones
+// use their code page.
+type Charset int
+
+const (
+	CharsetUTF8   Charset = iota // UTF-8, passed through unchanged
+	CharsetLatin1                // ISO 8859-1
+	CharsetCP437                 // IBM PC code page 437
+	CharsetAuto                  // UTF-8 if the name is valid UTF-8, the fallback otherwise
+)
+
+var charsetNames = []string{"utf-8", "latin1", "cp437", "auto"}
+
+// String returns the charset's name, as accepted by ParseCharset.
+func (c Charset) String() string {
+	if c >= 0 && int(c) < len(charsetNames) {
+		return charsetNames[c]
+	}
+	return fmt.Sprintf("Charset(%d)", int(c))
+}
+
+// ParseCharset looks up a charset by name, ignoring case.
+func ParseCharset(name string) (Charset, error) {
+	switch strings.ToLower(name) {
+	case "utf-8", "utf8":
+		return CharsetUTF8, nil
+	case "latin1", "latin-1", "iso-8859-1", "iso8859-1":
+		return CharsetLatin1, nil
+	case "cp437", "ibm437", "437":
+		return CharsetCP437, nil
+	case "auto":
+		return CharsetAuto, nil
+	}
+	return 0, fmt.Errorf("unknown charset %q", name)
+}
+
+// Encode converts name to the charset. Characters it can't represent
+// become '_'. CharsetAuto encodes as UTF-8.
+func (c Charset) Encode(name string) []byte {
+	switch c {
+	case CharsetLatin1:
+		return encodeSingleByte(name, func(r rune) (byte, bool) {
+			return byte(r), r < 0x100
+		})
+	case CharsetCP437:
+		return encodeSingleByte(name, func(r rune) (byte, bool) {
+			if r < 0x80 {
+				return byte(r), true
+			}
+			b, ok := cp437Encode[r]
+			return b, ok
+		})
+	}
+	return []byte(name)
+}
+
+// Decode converts a name in the charset to a Go string. CharsetAuto
+// decodes anything that isn't valid UTF-8 as Latin-1; use DecodeFallback
+// to pick another charset.
+func (c Charset) Decode(name []byte) string {
+	return c.DecodeFallback(name, CharsetLatin1)
+}
+
+// DecodeFallback is like Decode, but CharsetAuto falls back to fallback
+// for names that aren't valid UTF-8.
+func (c Charset) DecodeFallback(name []byte, fallback Charset) string {
+	switch c {
+	case CharsetLatin1:
+		return decodeSingleByte(name, func(b byte) rune { return rune(b) })
+	case CharsetCP437:
+		return decodeSingleByte(name, func(b byte) rune { return cp437Decode[b-0x80] })
+	case CharsetAuto:
+		if utf8.Valid(name) {
+			return string(name)
+		}
+		if fallback == CharsetUTF8 || fallback == CharsetAuto {
+			fallback = CharsetLatin1
+		}
+		return fallback.Decode(name)
+	}
+	return string(name)
+}
+
+func encodeSingleByte(name string, enc func(rune) (byte, bool)) []byte {
+	buf := make([]byte, 0, len(name))
+	for _, r := range name {
+		b, ok := enc(r)
+		if !ok {
+			b = '_'
+		}
+		buf = append(buf, b)
+	}
+	return buf
+}
+
+func decodeSingleByte(name []byte, dec func(byte) rune) string {
+	var sb strings.Builder
+	for _, b := range name {
+		if b < 0x80 {
+			sb.WriteByte(b)
+		} else {
+			sb.WriteRune(dec(b))
+		}
+	}
+	return sb.String()
+}
+
+// cp437Decode maps the upper half of code page 437 to Unicode. The lower
+// half is treated as ASCII, since its graphic glyphs for control
+// characters have no place in a file name.
+var cp437Decode = [128]rune([]rune(
+	"ÇüéâäàåçêëèïîìÄÅ" +
+		"ÉæÆôöòûùÿÖÜ¢£¥₧ƒ" +
+		"áíóúñÑªº¿⌐¬½¼¡«»" +
+		"░▒▓│┤╡╢╖╕╣║╗╝╜╛┐" +
+		"└┴┬├─┼╞╟╚╔╩╦╠═╬╧" +
+		"╨╤╥╙╘╒╓╫╪┘┌█▄▌▐▀" +
+		"αßΓπΣσµτΦΘΩδ∞φε∩" +
+		"≡±≥≤⌠⌡÷≈°∙·√ⁿ²■ "))
+
+var cp437Encode = func() map[rune]byte {
+	m := make(map[rune]byte, len(cp437Decode))
+	for i, r := range cp437Decode {
+		m[r] = byte(0x80 + i)
+	}
+	return m
+}()
+
+// dosNameMapper turns names into DOS 8.3 names for old receivers, keeping
+// the names it has handed out unique. A name mapped before gets the same
+// 8.3 name again, so a file that is sent again isn't given a new ~N name.
+type dosNameMapper struct {
+	used  map[string]bool
+	names map[string]string // 8.3 names by the name they were made from
+}
+
+// dosNameChars are the characters DOS allows in a file name besides
+// letters and digits.
+const dosNameChars = "!#$%&'()-@^_`{}~"
+
+// dosReservedNames are device names DOS won't create files under.
+var dosReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true, "CLOCK$": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true,
+	"LPT1": true, "LPT2": true, "LPT3": true,
+}
+
+// Map returns the 8.3 name for name. Names that had to be shortened or
+// changed get a ~N tail, like Windows gives them.
+func (m *dosNameMapper) Map(name string) string {
+	if m.used == nil {
+		m.used = make(map[string]bool)
+		m.names = make(map[string]string)
+	}
+	if short, ok := m.names[name]; ok {
+		return short
+	}
+	short := m.shorten(name)
+	m.used[short] = true
+	m.names[name] = short
+	return short
+}
+
+// shorten makes a new 8.3 name for name that isn't used yet.
+func (m *dosNameMapper) shorten(name string) string {
+
+	base, ext := strings.TrimLeft(name, "."), ""
+	if i := strings.LastIndexByte(base, '.'); i >= 0 {
+		base, ext = base[:i], base[i+1:]
+	}
+	base, lossyBase := dosNamePart(base)
+	ext, lossyExt := dosNamePart(ext)
+	if len(ext) > 3 {
+		ext, lossyExt = ext[:3], true
+	}
+	if base == "" || dosReservedNames[base] {
+		base = "_" + base
+	}
+	if len(base) > 8 {
+		lossyBase = true
+	}
+
+	join := func(base string) string {
+		if ext == "" {
+			return base
+		}
+		return base + "." + ext
+	}
+
+	if !lossyBase && !lossyExt && !m.used[join(base)] {
+		return join(base)
+	}
+	for n := 1; ; n++ {
+		tail := "~" + strconv.Itoa(n)
+		short := join(base[:min(len(base), 8-len(tail))] + tail)
+		if !m.used[short] {
+			return short
+		}
+	}
+}
+
+// dosNamePart upper-cases s and replaces what DOS doesn't allow. Spaces
+// and dots are dropped. It reports whether anything besides case changed.
+func dosNamePart(s string) (string, bool) {
+	var sb strings.Builder
+	lossy := false
+	for _, r := range strings.ToUpper(s) {
+		switch {
+		case r == ' ' || r == '.':
+			lossy = true
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', strings.ContainsRune(dosNameChars, r):
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte('_')
+			lossy = true
+		}
+	}
+	return sb.String(), lossy
+}
@@ -0,0 +1,25 @@
+package zmodem
+
+import "testing"
+
+func TestDOSNameMapper(t *testing.T) {
+	var m dosNameMapper
+	tests := []struct {
+		name, want string
+	}{
+		{"README.TXT", "README.TXT"},
+		{"readme.txt", "README~1.TXT"},
+		{"a long name.html", "ALONGN~1.HTM"},
+		{"a long name.htm", "ALONGN~2.HTM"},
+		{"con", "_CON"},
+		{".profile", "PROFILE"},
+		// Names sent again keep their 8.3 name
+		{"readme.txt", "README~1.TXT"},
+		{"a long name.html", "ALONGN~1.HTM"},
+	}
+	for _, tt := range tests {
+		if got := m.Map(tt.name); got != tt.want {
+			t.Errorf("Map(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
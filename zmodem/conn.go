@@ -0,0 +1,43 @@
+package zmodem
+
+import (
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+// ConnReader adapts a net.Conn for the protocol code: reads that pass
+// their deadline return a timeout Error, which the protocol retries like
+// any other timeout, rather than the connection's own error.
+type ConnReader struct {
+	conn net.Conn
+}
+
+// NewConnReader wraps conn. Write to conn directly.
+func NewConnReader(conn net.Conn) *ConnReader {
+	return &ConnReader{conn: conn}
+}
+
+// Read reads from the connection.
+func (r *ConnReader) Read(p []byte) (int, error) {
+	n, err := r.conn.Read(p)
+	if err != nil && isDeadlineError(err) {
+		return n, NewError(ErrTimeout, "timeout")
+	}
+	return n, err
+}
+
+// SetReadDeadline sets the read deadline of the connection.
+func (r *ConnReader) SetReadDeadline(t time.Time) error {
+	return r.conn.SetReadDeadline(t)
+}
+
+// isDeadlineError reports whether err is an expired deadline.
+func isDeadlineError(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
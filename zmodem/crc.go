@@ -108,6 +108,16 @@ func CRC16Finalize(crc uint16) uint16 {
 	return crc
 }
 
+// CRC16 returns the finalized 16-bit CRC of data, as used by XMODEM and
+// YMODEM blocks.
+func CRC16(data []byte) uint16 {
+	crc := uint16(0)
+	for _, b := range data {
+		crc = updcrc16(b, crc)
+	}
+	return CRC16Finalize(crc)
+}
+
 // CRC32Finalize finalizes a 32-bit CRC calculation.
 // The CRC is initialized to 0xFFFFFFFF and finalized by inverting.
 // The final CRC check value should be 0xDEBB20E3.
@@ -0,0 +1,736 @@
+package zmodem
+
+// This file implements delta transfers, a nonstandard extension used between
+// go-lrzsz peers to resend only the changed parts of a file.
+//
+// Negotiation:
+//   - The receiver sets ZF1_CANDELTA in ZRINIT ZF1.
+//   - The sender sets TDELTA in ZSINIT ZF1 to turn delta transfers on.
+//
+// Per file, a receiver that already holds a copy of the file answers ZFILE
+// with ZSIGS (instead of ZRPOS), followed by the block signatures of its copy
+// in data subpackets. The sender answers with ZDELTA, followed by a stream of
+// literal runs and block references, and finishes with the usual ZEOF. The
+// receiver rebuilds the file into a temporary file next to the original.
+//
+// If anything goes wrong on the receiving side, it answers with ZRPOS(0) and
+// the sender falls back to a full transfer, exactly as lsz does after a
+// garbled data subpacket.
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+const (
+	deltaMinBlock   = 512       // Smallest block size the receiver signs
+	deltaMaxBlock   = 64 * 1024 // Largest block size the receiver signs
+	deltaStrongLen  = 8         // Bytes of MD5 kept per block
+	deltaSigEntry   = 4 + deltaStrongLen
+	deltaSigChunk   = 1024             // Signature bytes per data subpacket
+	deltaMaxSigs    = 64 * 1024 * 1024 // Largest signature set the sender accepts
+	deltaMaxLiteral = 32 * 1024        // Longest literal run in one instruction
+)
+
+// Delta instruction opcodes
+const (
+	deltaOpLiteral = 'L' // uvarint length, then the literal bytes
+	deltaOpCopy    = 'C' // uvarint first block, uvarint block count
+	deltaOpEnd     = 'E' // CRC-32 (big-endian) of the rebuilt file, uvarint length
+)
+
+// errDeltaFallback tells the session to fall back to a full transfer.
+var errDeltaFallback = NewError(ErrProtocol, "delta transfer failed, falling back to full transfer")
+
+// deltaBlockSize picks the signature block size for a basis file,
+// using the square root of its length like rsync does.
+func deltaBlockSize(size int64) int {
+	bs := int(math.Sqrt(float64(size))) &^ 7
+	if bs < deltaMinBlock {
+		bs = deltaMinBlock
+	}
+	if bs > deltaMaxBlock {
+		bs = deltaMaxBlock
+	}
+	return bs
+}
+
+// rollingSum is the rsync weak checksum, which can be rolled along
+// a window one byte at a time.
+type rollingSum struct {
+	a, b uint32
+	n    uint32
+}
+
+func (r *rollingSum) init(p []byte) {
+	r.a, r.b, r.n = 0, 0, uint32(len(p))
+	for i, c := range p {
+		r.a += uint32(c)
+		r.b += uint32(len(p)-i) * uint32(c)
+	}
+}
+
+func (r *rollingSum) roll(out, in byte) {
+	r.a += uint32(in) - uint32(out)
+	r.b += r.a - r.n*uint32(out)
+}
+
+func (r *rollingSum) sum() uint32 {
+	return (r.a & 0xFFFF) | (r.b << 16)
+}
+
+// strongSum returns the truncated MD5 used to confirm weak matches.
+func strongSum(p []byte) (s [deltaStrongLen]byte) {
+	h := md5.Sum(p)
+	copy(s[:], h[:])
+	return s
+}
+
+// deltaSignature holds the block signatures of the receiver's copy.
+// Only full blocks are signed; the tail of the basis is always resent.
+type deltaSignature struct {
+	blockSize int
+	weak      []uint32
+	strong    [][deltaStrongLen]byte
+	index     map[uint32][]int
+}
+
+// computeSignature signs basis block by block.
+func computeSignature(basis io.Reader, size int64) (*deltaSignature, error) {
+	sig := &deltaSignature{blockSize: deltaBlockSize(size)}
+	buf := make([]byte, sig.blockSize)
+	var r rollingSum
+	for {
+		if _, err := io.ReadFull(basis, buf); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return sig, nil
+			}
+			return nil, err
+		}
+		r.init(buf)
+		sig.weak = append(sig.weak, r.sum())
+		sig.strong = append(sig.strong, strongSum(buf))
+	}
+}
+
+// marshal encodes the signature as: block size, block count, then a weak
+// sum and strong sum per block (all big-endian).
+func (sig *deltaSignature) marshal() []byte {
+	buf := make([]byte, 8, 8+len(sig.weak)*deltaSigEntry)
+	binary.BigEndian.PutUint32(buf[0:], uint32(sig.blockSize))
+	binary.BigEndian.PutUint32(buf[4:], uint32(len(sig.weak)))
+	for i := range sig.weak {
+		buf = binary.BigEndian.AppendUint32(buf, sig.weak[i])
+		buf = append(buf, sig.strong[i][:]...)
+	}
+	return buf
+}
+
+// parseSignature decodes a signature produced by marshal and indexes it
+// by weak sum.
+func parseSignature(data []byte) (*deltaSignature, error) {
+	if len(data) < 8 {
+		return nil, NewError(ErrInvalidFrame, "short delta signature")
+	}
+	bs := int(binary.BigEndian.Uint32(data[0:]))
+	count := int(binary.BigEndian.Uint32(data[4:]))
+	data = data[8:]
+	if bs < deltaMinBlock || bs > deltaMaxBlock || len(data) != count*deltaSigEntry {
+		return nil, NewError(ErrInvalidFrame, "bad delta signature")
+	}
+
+	sig := &deltaSignature{
+		blockSize: bs,
+		weak:      make([]uint32, count),
+		strong:    make([][deltaStrongLen]byte, count),
+		index:     make(map[uint32][]int, count),
+	}
+	for i := 0; i < count; i++ {
+		entry := data[i*deltaSigEntry:]
+		sig.weak[i] = binary.BigEndian.Uint32(entry)
+		copy(sig.strong[i][:], entry[4:deltaSigEntry])
+		sig.index[sig.weak[i]] = append(sig.index[sig.weak[i]], i)
+	}
+	return sig, nil
+}
+
+// match returns the index of the basis block matching window, or -1.
+func (sig *deltaSignature) match(weak uint32, window []byte) int {
+	candidates := sig.index[weak]
+	if len(candidates) == 0 {
+		return -1
+	}
+	strong := strongSum(window)
+	for _, i := range candidates {
+		if sig.strong[i] == strong {
+			return i
+		}
+	}
+	return -1
+}
+
+// deltaEncoder turns the sender's file into delta instructions.
+type deltaEncoder struct {
+	sig  *deltaSignature
+	out  bytes.Buffer
+	emit func(p []byte) error // Sends a chunk of instructions
+
+	chunk     int
+	copyStart int // Pending run of copied blocks
+	copyCount int
+}
+
+// literal sends p as it is, in runs of deltaMaxLiteral at most; the
+// tail of the file can be longer than that.
+func (e *deltaEncoder) literal(p []byte) error {
+	if err := e.flushCopy(); err != nil {
+		return err
+	}
+	for len(p) > 0 {
+		n := min(len(p), deltaMaxLiteral)
+		e.out.WriteByte(deltaOpLiteral)
+		e.out.Write(binary.AppendUvarint(nil, uint64(n)))
+		e.out.Write(p[:n])
+		if err := e.drain(false); err != nil {
+			return err
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+func (e *deltaEncoder) copyBlock(i int) error {
+	if e.copyCount > 0 && e.copyStart+e.copyCount == i {
+		e.copyCount++
+		return nil
+	}
+	if err := e.flushCopy(); err != nil {
+		return err
+	}
+	e.copyStart, e.copyCount = i, 1
+	return nil
+}
+
+func (e *deltaEncoder) flushCopy() error {
+	if e.copyCount == 0 {
+		return nil
+	}
+	e.out.WriteByte(deltaOpCopy)
+	e.out.Write(binary.AppendUvarint(nil, uint64(e.copyStart)))
+	e.out.Write(binary.AppendUvarint(nil, uint64(e.copyCount)))
+	e.copyCount = 0
+	return e.drain(false)
+}
+
+func (e *deltaEncoder) end(crc uint32, size int64) error {
+	if err := e.flushCopy(); err != nil {
+		return err
+	}
+	e.out.WriteByte(deltaOpEnd)
+	e.out.Write(binary.BigEndian.AppendUint32(nil, crc))
+	e.out.Write(binary.AppendUvarint(nil, uint64(size)))
+	return e.drain(true)
+}
+
+// drain hands full chunks of instructions to emit. When final is set,
+// whatever is left is sent too.
+func (e *deltaEncoder) drain(final bool) error {
+	for e.out.Len() >= e.chunk || (final && e.out.Len() > 0) {
+		if err := e.emit(e.out.Next(e.chunk)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encode reads src to the end, matching it against the signature with a
+// rolling window. It returns the number of bytes read and their CRC-32.
+func (e *deltaEncoder) encode(src io.Reader, progress func(int64)) (int64, uint32, error) {
+	bs := e.sig.blockSize
+	buf := make([]byte, 0, deltaMaxLiteral+2*bs)
+	crc := crc32.NewIEEE()
+	var size int64
+	pos, litStart := 0, 0
+	eof := false
+
+	// fill makes sure a full window is buffered at pos unless src is
+	// exhausted, compacting the buffer when it runs out of room.
+	fill := func() error {
+		for !eof && len(buf)-pos < bs {
+			if cap(buf)-len(buf) < bs {
+				n := copy(buf, buf[litStart:])
+				buf = buf[:n]
+				pos -= litStart
+				litStart = 0
+			}
+			n, err := src.Read(buf[len(buf):cap(buf)])
+			crc.Write(buf[len(buf) : len(buf)+n])
+			buf = buf[:len(buf)+n]
+			size += int64(n)
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+		if progress != nil {
+			progress(size)
+		}
+		return nil
+	}
+
+	var r rollingSum
+	rolling := false
+	for {
+		if err := fill(); err != nil {
+			return size, 0, err
+		}
+		if len(buf)-pos < bs {
+			break
+		}
+		if !rolling {
+			r.init(buf[pos : pos+bs])
+			rolling = true
+		}
+
+		if i := e.sig.match(r.sum(), buf[pos:pos+bs]); i >= 0 {
+			if err := e.literal(buf[litStart:pos]); err != nil {
+				return size, 0, err
+			}
+			if err := e.copyBlock(i); err != nil {
+				return size, 0, err
+			}
+			pos += bs
+			litStart = pos
+			rolling = false
+			continue
+		}
+
+		// No match, slide the window along by one byte
+		if pos+1-litStart >= deltaMaxLiteral {
+			if err := e.literal(buf[litStart : pos+1]); err != nil {
+				return size, 0, err
+			}
+			litStart = pos + 1
+		}
+		out := buf[pos]
+		pos++
+		if err := fill(); err != nil {
+			return size, 0, err
+		}
+		if len(buf)-pos < bs {
+			break
+		}
+		r.roll(out, buf[pos+bs-1])
+	}
+
+	if err := e.literal(buf[litStart:]); err != nil {
+		return size, 0, err
+	}
+	return size, crc.Sum32(), nil
+}
+
+// deltaDecoder applies delta instructions against the basis file.
+// Instructions may be split across data subpackets at any byte.
+type deltaDecoder struct {
+	basis     io.ReaderAt
+	blockSize int
+	blocks    int
+	out       io.Writer
+	block     []byte
+
+	pending []byte
+	crc     hash.Hash32
+	written int64
+
+	done    bool
+	endCRC  uint32
+	endSize int64
+}
+
+func newDeltaDecoder(basis io.ReaderAt, sig *deltaSignature, out io.Writer) *deltaDecoder {
+	return &deltaDecoder{
+		basis:     basis,
+		blockSize: sig.blockSize,
+		blocks:    len(sig.weak),
+		out:       out,
+		block:     make([]byte, sig.blockSize),
+		crc:       crc32.NewIEEE(),
+	}
+}
+
+func (d *deltaDecoder) output(p []byte) error {
+	if _, err := d.out.Write(p); err != nil {
+		return err
+	}
+	d.crc.Write(p)
+	d.written += int64(len(p))
+	return nil
+}
+
+// Write consumes as many complete instructions as are available.
+func (d *deltaDecoder) Write(p []byte) error {
+	d.pending = append(d.pending, p...)
+	consumed := 0
+	for consumed < len(d.pending) {
+		if d.done {
+			return NewError(ErrInvalidFrame, "data after delta end")
+		}
+		n, err := d.step(d.pending[consumed:])
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break // Need more data
+		}
+		consumed += n
+	}
+	d.pending = d.pending[:copy(d.pending, d.pending[consumed:])]
+	return nil
+}
+
+// step applies the instruction at the start of p. It returns the number
+// of bytes used, or 0 if the instruction is incomplete.
+func (d *deltaDecoder) step(p []byte) (int, error) {
+	switch p[0] {
+	case deltaOpLiteral:
+		length, k := binary.Uvarint(p[1:])
+		if k < 0 || length > deltaMaxLiteral {
+			return 0, NewError(ErrInvalidFrame, "bad delta literal")
+		}
+		if k == 0 || len(p) < 1+k+int(length) {
+			return 0, nil
+		}
+		return 1 + k + int(length), d.output(p[1+k : 1+k+int(length)])
+
+	case deltaOpCopy:
+		first, k1 := binary.Uvarint(p[1:])
+		if k1 <= 0 {
+			return 0, d.varintError(k1)
+		}
+		count, k2 := binary.Uvarint(p[1+k1:])
+		if k2 <= 0 {
+			return 0, d.varintError(k2)
+		}
+		if first+count > uint64(d.blocks) {
+			return 0, NewError(ErrInvalidFrame, "delta block reference out of range")
+		}
+		for i := first; i < first+count; i++ {
+			if _, err := d.basis.ReadAt(d.block, int64(i)*int64(d.blockSize)); err != nil {
+				return 0, err
+			}
+			if err := d.output(d.block); err != nil {
+				return 0, err
+			}
+		}
+		return 1 + k1 + k2, nil
+
+	case deltaOpEnd:
+		if len(p) < 5 {
+			return 0, nil
+		}
+		size, k := binary.Uvarint(p[5:])
+		if k <= 0 {
+			return 0, d.varintError(k)
+		}
+		d.done = true
+		d.endCRC = binary.BigEndian.Uint32(p[1:5])
+		d.endSize = int64(size)
+		return 5 + k, nil
+
+	default:
+		return 0, NewError(ErrInvalidFrame, "bad delta instruction")
+	}
+}
+
+// varintError maps a binary.Uvarint failure to an error, where k == 0
+// only means more data is needed.
+func (d *deltaDecoder) varintError(k int) error {
+	if k == 0 {
+		return nil
+	}
+	return NewError(ErrInvalidFrame, "bad delta varint")
+}
+
+// verify reports whether the rebuilt file matches what the sender described.
+func (d *deltaDecoder) verify(size int64) bool {
+	return d.done && len(d.pending) == 0 &&
+		d.endSize == d.written && d.written == size &&
+		d.endCRC == d.crc.Sum32()
+}
+
+// sendFileDelta answers the receiver's ZSIGS with a delta of file.
+// Any signature that can't be read is treated as empty, which turns the
+// delta into a single literal run of the whole file.
+func (s *Sender) sendFileDelta(file io.Reader, fileSize int64, sigHdr Header) error {
+	sig := &deltaSignature{blockSize: deltaMinBlock}
+	data, err := s.readSubpackets(int(min(rclhdr(sigHdr), deltaMaxSigs)))
+	if err == nil {
+		if parsed, err := parseSignature(data); err == nil {
+			sig = parsed
+		}
+	}
+	if err != nil {
+		s.logger.Error("sendFileDelta: bad signature, sending whole file: %v", err)
+	}
+	s.logger.Info("sendFileDelta: %d blocks of %d bytes", len(sig.weak), sig.blockSize)
+
+	hdr := stohdr(0)
+	if err := zsbhdr(s.writer, ZDELTA, hdr, s.use32bitCRC, s.znulls); err != nil {
+		return err
+	}
+	s.logger.Info(FormatFrameLog("TX", ZDELTA, hdr, nil, 0))
+
+	enc := &deltaEncoder{
+		sig:   sig,
+		chunk: s.blockSize,
+	}
+	var last []byte
+	enc.emit = func(p []byte) error {
+		// Hold one subpacket back so the last can be sent with ZCRCE
+		if last != nil {
+			if err := zsdata(s.writer, last, ZCRCG, s.use32bitCRC); err != nil {
+				return err
+			}
+		}
+		last = append(last[:0], p...)
+		if s.ctx != nil {
+			return s.ctx.Err()
+		}
+		return nil
+	}
+
+	size, crc, err := enc.encode(file, s.reportProgress)
+	if err != nil {
+		return err
+	}
+	if err := enc.end(crc, size); err != nil {
+		return err
+	}
+	if err := zsdata(s.writer, last, ZCRCE, s.use32bitCRC); err != nil {
+		return err
+	}
+
+	return s.sendEOF(file, fileSize, size)
+}
+
+// readSubpackets reads data subpackets up to the end of the frame. More
+// than limit bytes, or deltaMaxSigs, fail, but only at the end of the
+// frame, so what follows is read from the right place.
+func (s *Sender) readSubpackets(limit int) ([]byte, error) {
+	// The limit comes from the peer, so the data grows as it arrives
+	limit = min(limit, deltaMaxSigs)
+	var data []byte
+	tooMuch := false
+	buf := make([]byte, s.maxBlockSize)
+	for {
+		n, frameEnd, err := zrdata(s.reader, s.unescaper, buf, s.use32bitCRC)
+		if err != nil {
+			return nil, err
+		}
+		if tooMuch || len(data)+n > limit {
+			tooMuch, data = true, nil
+		} else {
+			data = append(data, buf[:n]...)
+		}
+		switch frameEnd {
+		case GOTCRCG, GOTCRCQ:
+			continue
+		case GOTCRCE, GOTCRCW:
+			if tooMuch {
+				return nil, NewError(ErrInvalidFrame, "too much subpacket data")
+			}
+			return data, nil
+		case ZCAN:
+			return nil, NewError(ErrCancelled, "receiver cancelled")
+		default:
+			return nil, NewError(ErrInvalidFrame, "bad data subpacket")
+		}
+	}
+}
+
+// reportProgress reports progress if the progress interval has elapsed.
+func (s *Sender) reportProgress(bytesSent int64) {
+	if s.callbacks == nil || s.callbacks.OnProgress == nil || s.progressInterval <= 0 {
+		return
+	}
+	now := time.Now()
+	if now.Sub(s.lastProgressTime) >= s.progressInterval {
+		rate := float64(bytesSent) / now.Sub(s.startTime).Seconds()
+		s.callbacks.OnProgress(s.currentFilename, bytesSent, s.currentFileSize, rate)
+		s.lastProgressTime = now
+	}
+}
+
+// ReceiveFileDelta rebuilds a file from basis, the receiver's existing copy,
+// writing the result to file. It returns errDeltaFallback if the delta could
+// not be applied; the caller should then start over with ReceiveFile, whose
+// ZRPOS(0) makes the sender resend the whole file.
+func (r *Receiver) ReceiveFileDelta(basis io.ReaderAt, basisSize int64, file io.Writer, expectedSize int64) error {
+	sig, err := computeSignature(io.NewSectionReader(basis, 0, basisSize), basisSize)
+	if err != nil {
+		return err
+	}
+	sigData := sig.marshal()
+	// Index the signature exactly as the sender will see it
+	if sig, err = parseSignature(sigData); err != nil {
+		return err
+	}
+
+	fw := &frameWriterWrapper{
+		writer:  r.writer,
+		escaper: newZsendlineEscaper(r.writer, r.escapeCtrl, r.turboEscape),
+	}
+	sendSigs := func() error {
+		hdr := stohdr(uint32(len(sigData)))
+		// The sender drains a stale ZRINIT up to the next ZPAD, so lead
+		// with a spare one like a hex header does
+		if _, err := fw.Write([]byte{ZPAD}); err != nil {
+			return err
+		}
+		if err := zsbhdr(fw, ZSIGS, hdr, r.use32bitCRC, 0); err != nil {
+			return err
+		}
+		r.logger.Info(FormatFrameLog("TX", ZSIGS, hdr, nil, len(sigData)))
+		for rest := sigData; ; {
+			n := min(len(rest), deltaSigChunk)
+			frameEnd := int(ZCRCG)
+			if n == len(rest) {
+				frameEnd = ZCRCE
+			}
+			if err := zsdata(fw, rest[:n], frameEnd, r.use32bitCRC); err != nil {
+				return err
+			}
+			if rest = rest[n:]; len(rest) == 0 {
+				return fw.Flush()
+			}
+		}
+	}
+	if err := sendSigs(); err != nil {
+		return err
+	}
+
+	dec := newDeltaDecoder(basis, sig, file)
+	buf := make([]byte, r.bufferSize)
+	errors := 0
+	for {
+		frameType, rxHdr, err := r.getHeader(0)
+		if err != nil && !IsTimeout(err) {
+			return err
+		}
+		switch frameType {
+		case ZFILE:
+			// Our signatures got lost, so the sender repeated ZFILE
+			zrdata(r.reader, r.unescaper, buf, r.use32bitCRC)
+			if errors++; errors > 10 {
+				return NewError(ErrProtocol, "sender ignored delta signatures")
+			}
+			if err := sendSigs(); err != nil {
+				return err
+			}
+
+		case ZDELTA:
+			for !dec.done {
+				n, frameEnd, err := zrdata(r.reader, r.unescaper, buf, r.use32bitCRC)
+				if err != nil {
+					r.logger.Error("ReceiveFileDelta: zrdata error: %v", err)
+					return errDeltaFallback
+				}
+				if frameEnd == ZCAN {
+					return NewError(ErrCancelled, "sender cancelled")
+				}
+				if err := dec.Write(buf[:n]); err != nil {
+					r.logger.Error("ReceiveFileDelta: %v", err)
+					return errDeltaFallback
+				}
+				if frameEnd == GOTCRCE || frameEnd == GOTCRCW {
+					break
+				}
+			}
+
+		case ZEOF:
+			if !dec.verify(expectedSize) || int64(rclhdr(rxHdr)) != dec.written {
+				r.logger.Error("ReceiveFileDelta: rebuilt file does not match (%d bytes)", dec.written)
+				return errDeltaFallback
+			}
+			hdr := stohdr(uint32(dec.written))
+			return zshhdr(r.writer, ZACK, hdr)
+
+		case ZSKIP:
+			return NewError(ErrFileSkipped, "sender skipped file")
+
+		case ZCAN:
+			return NewError(ErrCancelled, "sender cancelled")
+
+		default:
+			if errors++; errors > 20 {
+				return NewError(ErrTimeout, "no delta from sender")
+			}
+		}
+	}
+}
+
+// receiveFileDelta rebuilds the file from the copy already on disk as name
+// (its LocalName), through a temporary file that replaces it once the
+// transfer completes. It reports false if there is no usable copy, in
+// which case nothing was sent.
+func (s *Session) receiveFileDelta(hdr *FileHeader, name string) (bool, error) {
+	basis, err := os.Open(name)
+	if err != nil {
+		return false, nil
+	}
+	defer basis.Close()
+	info, err := basis.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return false, nil
+	}
+
+	tmp, err := os.CreateTemp(".", "."+name+".delta-*")
+	if err != nil {
+		return false, nil
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	s.logger.Info("ReceiveFile: rebuilding %s from %d byte local copy", name, info.Size())
+	s.callbacks.OnFileStart(hdr)
+
+	err = s.receiver.ReceiveFileDelta(basis, info.Size(), tmp, hdr.Size)
+	if err == errDeltaFallback {
+		s.logger.Info("ReceiveFile: delta failed, receiving whole file")
+		if err := tmp.Truncate(0); err != nil {
+			return true, err
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return true, err
+		}
+		err = s.receiver.ReceiveFile(tmp, hdr.Size)
+	}
+	if err != nil {
+		return true, err
+	}
+
+	mode := hdr.Mode
+	if mode == 0 {
+		mode = info.Mode().Perm()
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		s.logger.Error("ReceiveFile: setting the mode of %s: %v", name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return true, err
+	}
+	if !hdr.ModTime.IsZero() {
+		os.Chtimes(tmp.Name(), hdr.ModTime, hdr.ModTime)
+	}
+	return true, os.Rename(tmp.Name(), name)
+}
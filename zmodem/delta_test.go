@@ -0,0 +1,198 @@
+package zmodem
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// deltaRoundTrip signs basis, encodes target against the signature and
+// decodes the result, feeding the decoder pieces of split bytes to check
+// that instructions can be cut anywhere. It returns the rebuilt file and
+// the size of the instructions.
+func deltaRoundTrip(t *testing.T, basis, target []byte, split int) ([]byte, int) {
+	t.Helper()
+
+	sig, err := computeSignature(bytes.NewReader(basis), int64(len(basis)))
+	if err != nil {
+		t.Fatalf("computeSignature: %v", err)
+	}
+	parsed, err := parseSignature(sig.marshal())
+	if err != nil {
+		t.Fatalf("parseSignature: %v", err)
+	}
+	if parsed.blockSize != sig.blockSize || len(parsed.weak) != len(sig.weak) {
+		t.Fatalf("signature came back as %d blocks of %d, want %d of %d",
+			len(parsed.weak), parsed.blockSize, len(sig.weak), sig.blockSize)
+	}
+
+	var instructions []byte
+	enc := &deltaEncoder{
+		sig:   parsed,
+		chunk: deltaSigChunk,
+		emit: func(p []byte) error {
+			instructions = append(instructions, p...)
+			return nil
+		},
+	}
+	size, crc, err := enc.encode(bytes.NewReader(target), nil)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if size != int64(len(target)) {
+		t.Fatalf("encode read %d bytes, want %d", size, len(target))
+	}
+	if err := enc.end(crc, size); err != nil {
+		t.Fatalf("end: %v", err)
+	}
+
+	var out bytes.Buffer
+	dec := newDeltaDecoder(bytes.NewReader(basis), parsed, &out)
+	for p := instructions; len(p) > 0; {
+		n := min(split, len(p))
+		if err := dec.Write(p[:n]); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		p = p[n:]
+	}
+	if !dec.verify(int64(len(target))) {
+		t.Fatalf("decoded delta doesn't verify")
+	}
+	return out.Bytes(), len(instructions)
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := func(n int) []byte {
+		p := make([]byte, n)
+		rng.Read(p)
+		return p
+	}
+	basis := random(200 * 1024)
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+
+	tests := []struct {
+		name   string
+		basis  []byte
+		target []byte
+		reuse  bool // Most of target should come from the basis
+	}{
+		{"identical", basis, basis, true},
+		{"appended", basis, join(basis, random(3000)), true},
+		{"inserted", basis, join(basis[:70000], random(777), basis[70000:]), true},
+		{"changed", basis, join(basis[:50000], random(10), basis[50010:]), true},
+		{"truncated", basis, basis[:123457], true},
+		{"prepended", basis, join(random(5), basis), true},
+		{"unrelated", basis, random(100 * 1024), false},
+		{"empty basis", nil, basis[:5000], false},
+		{"empty target", basis, nil, false},
+		{"shorter than a block", basis[:100], basis[:100], false},
+		{"long literal", nil, random(3*deltaMaxLiteral + 5), false},
+	}
+
+	for _, tt := range tests {
+		for _, split := range []int{1, 7, deltaSigChunk} {
+			got, size := deltaRoundTrip(t, tt.basis, tt.target, split)
+			if !bytes.Equal(got, tt.target) {
+				t.Errorf("%s: rebuilt %d bytes that differ from the %d byte target", tt.name, len(got), len(tt.target))
+				continue
+			}
+			if tt.reuse && size > len(tt.target)/4 {
+				t.Errorf("%s: %d bytes of instructions for a %d byte file", tt.name, size, len(tt.target))
+			}
+		}
+	}
+}
+
+func TestParseSignatureErrors(t *testing.T) {
+	sig, err := computeSignature(bytes.NewReader(make([]byte, 4096)), 4096)
+	if err != nil {
+		t.Fatalf("computeSignature: %v", err)
+	}
+	data := sig.marshal()
+
+	bad := map[string][]byte{
+		"short":     data[:7],
+		"truncated": data[:len(data)-1],
+		"trailing":  append(append([]byte{}, data...), 0),
+		"tiny blocks": func() []byte {
+			d := append([]byte{}, data...)
+			d[2], d[3] = 0, 1
+			return d
+		}(),
+	}
+	for name, d := range bad {
+		if _, err := parseSignature(d); err == nil {
+			t.Errorf("%s: parseSignature accepted a bad signature", name)
+		}
+	}
+}
+
+func TestDeltaDecoderErrors(t *testing.T) {
+	basis := make([]byte, 4*deltaMinBlock)
+	sig, err := computeSignature(bytes.NewReader(basis), int64(len(basis)))
+	if err != nil {
+		t.Fatalf("computeSignature: %v", err)
+	}
+
+	bad := map[string][]byte{
+		"copy out of range": {deltaOpCopy, 3, 2},
+		"literal too long":  {deltaOpLiteral, 0xff, 0xff, 0xff, 0x7f},
+		"unknown opcode":    {'X'},
+		"after end":         {deltaOpEnd, 0, 0, 0, 0, 0, deltaOpLiteral, 1, 'x'},
+	}
+	for name, p := range bad {
+		dec := newDeltaDecoder(bytes.NewReader(basis), sig, &bytes.Buffer{})
+		if err := dec.Write(p); err == nil {
+			t.Errorf("%s: Write accepted bad instructions", name)
+		}
+	}
+
+	// A delta that ends short of the size the sender gave doesn't verify
+	dec := newDeltaDecoder(bytes.NewReader(basis), sig, &bytes.Buffer{})
+	if err := dec.Write([]byte{deltaOpLiteral, 1, 'x', deltaOpEnd, 0, 0, 0, 0, 1}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if dec.verify(1) {
+		t.Errorf("delta with a wrong CRC verified")
+	}
+}
+
+func TestReadSubpacketsTooMuch(t *testing.T) {
+	// Signatures over the limit, then the receiver's next header
+	config := &SenderConfig{Use32BitCRC: true, MaxBlockSize: 1024, Timeout: 50}
+	var wire bytes.Buffer
+	w := NewSender(nil, &wire, config)
+	// More than getHeader skips as garbage
+	for _, end := range []int{ZCRCG, ZCRCG, ZCRCG, ZCRCG, ZCRCG, ZCRCE} {
+		if err := zsdata(w.writer, bytes.Repeat([]byte{'x'}, 1000), end, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zshhdr(&wire, ZACK, stohdr(0)); err != nil {
+		t.Fatal(err)
+	}
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+	go func() {
+		pw.Write(wire.Bytes())
+		pw.Close()
+	}()
+
+	s := NewSender(pr, &bytes.Buffer{}, config)
+	if _, err := s.readSubpackets(1000); err == nil {
+		t.Fatalf("readSubpackets accepted 6000 bytes with a limit of 1000")
+	}
+	// The rest of the signatures were read past
+	frameType, _, err := s.GetHeaderDirect()
+	if err != nil || frameType != ZACK {
+		t.Errorf("header after the signatures is %s, %v; want ZACK", FrameTypeName(frameType), err)
+	}
+}
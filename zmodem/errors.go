@@ -41,6 +41,9 @@ const (
 	
 	// ErrRemoteCommandDenied indicates a remote command was denied
 	ErrRemoteCommandDenied
+	
+	// ErrCarrierLost indicates the line dropped carrier (RCDO)
+	ErrCarrierLost
 )
 
 func (e *Error) Error() string {
@@ -68,6 +71,8 @@ func (t ErrorType) String() string {
 		return "file skipped"
 	case ErrRemoteCommandDenied:
 		return "remote command denied"
+	case ErrCarrierLost:
+		return "carrier lost"
 	default:
 		return "unknown error"
 	}
@@ -115,3 +120,18 @@ func IsCancelled(err error) bool {
 	return false
 }
 
+// IsFileSkipped checks if an error means the receiver skipped the file
+func IsFileSkipped(err error) bool {
+	if e, ok := err.(*Error); ok {
+		return e.Type == ErrFileSkipped
+	}
+	return false
+}
+
+// IsCarrierLost checks if an error means the line dropped carrier
+func IsCarrierLost(err error) bool {
+	if e, ok := err.(*Error); ok {
+		return e.Type == ErrCarrierLost
+	}
+	return false
+}
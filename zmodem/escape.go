@@ -295,7 +295,7 @@ func (z *zdlreadUnescaper) readEscapeSequence() (int, error) {
 		return z.readEscapeSequence()
 	default:
 		// Escaped byte - unescape by XOR with 0x40
-		if (c & 0x80) == 0x40 {
+		if (c & 0x60) == 0x40 {
 			return int(c ^ 0x40), nil
 		}
 		// Invalid escape sequence
@@ -0,0 +1,247 @@
+package zmodem
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxStderr is how much of a command's stderr is kept for CommandError.
+const maxStderr = 4096
+
+// CommandError is returned when a command run for a transfer fails.
+type CommandError struct {
+	// Command is the command line
+	Command string
+
+	// ExitStatus is the exit status, or -1 if the command didn't exit
+	// normally
+	ExitStatus int
+
+	// Stderr is the end of what the command wrote to stderr
+	Stderr string
+
+	// Err is the underlying error
+	Err error
+}
+
+func (e *CommandError) Error() string {
+	msg := fmt.Sprintf("command %q failed", e.Command)
+	if e.ExitStatus >= 0 {
+		msg = fmt.Sprintf("command %q exited with status %d", e.Command, e.ExitStatus)
+	} else if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		msg += ": " + stderr
+	}
+	return msg
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// ExecTransport runs a local command and talks ZModem through its stdin
+// and stdout, for channels like "docker exec -i" or "kubectl exec -i"
+// that only pass stdio. It is a ReaderWithTimeout and an io.Writer, so
+// it goes to NewSession as both:
+//
+//	t, err := zmodem.NewExecTransport(exec.Command("kubectl", "exec", "-i", pod, "--", "rz"))
+//	session := zmodem.NewSession(t, t)
+//
+// Reads have real deadlines, since stdout is a pipe. Once the command has
+// failed, reads and writes return a *CommandError with its exit status and
+// stderr.
+type ExecTransport struct {
+	cmd    *exec.Cmd
+	stdin  *os.File
+	stdout *os.File
+	stderr *tailBuffer
+
+	// CloseTimeout is how long Close waits for the command to exit after
+	// closing its stdin before killing it
+	CloseTimeout time.Duration
+
+	done    chan struct{}
+	waitErr error
+}
+
+// NewExecTransport starts cmd with pipes on its stdin and stdout. Its
+// stderr is captured for errors, and also goes to cmd.Stderr if that is
+// set.
+func NewExecTransport(cmd *exec.Cmd) (*ExecTransport, error) {
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		stdinR.Close()
+		stdinW.Close()
+		return nil, err
+	}
+
+	t := &ExecTransport{
+		cmd:          cmd,
+		stdin:        stdinW,
+		stdout:       stdoutR,
+		stderr:       &tailBuffer{max: maxStderr},
+		CloseTimeout: 10 * time.Second,
+		done:         make(chan struct{}),
+	}
+	cmd.Stdin = stdinR
+	cmd.Stdout = stdoutW
+	if cmd.Stderr != nil {
+		cmd.Stderr = io.MultiWriter(t.stderr, cmd.Stderr)
+	} else {
+		cmd.Stderr = t.stderr
+	}
+
+	err = cmd.Start()
+	// The command has its own copies now
+	stdinR.Close()
+	stdoutW.Close()
+	if err != nil {
+		stdinW.Close()
+		stdoutR.Close()
+		return nil, &CommandError{Command: t.command(), ExitStatus: -1, Err: err}
+	}
+
+	go func() {
+		t.waitErr = cmd.Wait()
+		close(t.done)
+	}()
+	return t, nil
+}
+
+// Read reads from the command's stdout. An expired deadline returns a
+// timeout Error.
+func (t *ExecTransport) Read(p []byte) (int, error) {
+	n, err := t.stdout.Read(p)
+	if err == nil {
+		return n, nil
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return n, NewError(ErrTimeout, "timeout")
+	}
+	if err == io.EOF {
+		// The command is exiting; say why if it failed
+		select {
+		case <-t.done:
+		case <-time.After(time.Second):
+		}
+	}
+	if cerr := t.failure(); cerr != nil {
+		return n, cerr
+	}
+	return n, err
+}
+
+// Write writes to the command's stdin.
+func (t *ExecTransport) Write(p []byte) (int, error) {
+	n, err := t.stdin.Write(p)
+	if err != nil {
+		if cerr := t.failure(); cerr != nil {
+			return n, cerr
+		}
+	}
+	return n, err
+}
+
+// SetReadDeadline sets the deadline for reads from the command.
+func (t *ExecTransport) SetReadDeadline(deadline time.Time) error {
+	return t.stdout.SetReadDeadline(deadline)
+}
+
+// Stderr returns the end of what the command wrote to stderr so far.
+func (t *ExecTransport) Stderr() string {
+	return t.stderr.String()
+}
+
+// Wait waits for the command to exit. It returns a *CommandError if the
+// command failed.
+func (t *ExecTransport) Wait() error {
+	<-t.done
+	return t.failure()
+}
+
+// ExitCode returns the command's exit status, or -1 if it is still
+// running or was killed.
+func (t *ExecTransport) ExitCode() int {
+	select {
+	case <-t.done:
+		return t.cmd.ProcessState.ExitCode()
+	default:
+		return -1
+	}
+}
+
+// Close closes the command's stdin, which ends a receiver that is done,
+// and waits for it to exit. A command that doesn't exit within
+// CloseTimeout is killed.
+func (t *ExecTransport) Close() error {
+	t.stdin.Close()
+
+	timer := time.NewTimer(t.CloseTimeout)
+	defer timer.Stop()
+	select {
+	case <-t.done:
+	case <-timer.C:
+		t.cmd.Process.Kill()
+		<-t.done
+	}
+	t.stdout.Close()
+	return t.failure()
+}
+
+// failure returns a *CommandError if the command has exited and failed.
+func (t *ExecTransport) failure() error {
+	select {
+	case <-t.done:
+	default:
+		return nil
+	}
+	if t.waitErr == nil {
+		return nil
+	}
+	return &CommandError{
+		Command:    t.command(),
+		ExitStatus: t.cmd.ProcessState.ExitCode(),
+		Stderr:     t.stderr.String(),
+		Err:        t.waitErr,
+	}
+}
+
+// command returns the command line for errors.
+func (t *ExecTransport) command() string {
+	return strings.Join(t.cmd.Args, " ")
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.max {
+		b.buf = append(b.buf[:0], b.buf[len(b.buf)-b.max:]...)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
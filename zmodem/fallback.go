@@ -0,0 +1,712 @@
+package zmodem
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Shell fallback
+//
+// Minimal hosts often have no lrzsz. When the remote rz or sz can't be
+// found, SSHClient moves files through the remote POSIX shell instead,
+// one SSH session per file, with the tools every shell has. SSHSession
+// has only the one SSH session, so it has no fallback.
+//
+// Uploads run a loop that reads one chunk per line, base64 if the host
+// has base64 and printf octal escapes if not. Each line carries the
+// chunk's cksum; the loop checks it, appends the chunk to NAME.part and
+// answers "ok" or "bad". Once the whole file is there and its cksum
+// matches, NAME.part becomes NAME. A NAME.part left by an earlier try is
+// kept if it matches the start of the local file.
+//
+// Downloads run a loop that cuts the file into chunks with dd and prints
+// each one's cksum and its bytes in hex with od, then the cksum of the
+// whole file. Chunks that don't match are fetched again. Files go to
+// NAME.part in the working directory until they are complete and match,
+// so a later download resumes them, or to OnFileCreate. A NAME.part is
+// only resumed if it matches the start of the remote file.
+
+// fallbackChunkSize is the chunk size of shell transfers.
+const fallbackChunkSize = 16 * 1024
+
+// fallbackTimeout is how long to wait for a line from the remote shell.
+const fallbackTimeout = 30 * time.Second
+
+// fallbackRetries is how many bad chunks in a row end a shell transfer.
+const fallbackRetries = 3
+
+// uploadScript receives one file. Arguments: directory, name.
+const uploadScript = `[ -z "$1" ] || cd "$1" || exit 1
+f=$2; p=$2.part; t=$2.chunk
+trap 'rm -f "$t"' EXIT
+[ -f "$p" ] || : > "$p" || exit 1
+if command -v base64 >/dev/null 2>&1; then dec=base64; else dec=printf; fi
+set -- $(cksum < "$p")
+echo "part $1 $2 $dec"
+while read -r op crc len data; do
+	case $op in
+	c)
+		if [ $dec = base64 ]; then
+			printf '%s\n' "$data" | base64 -d > "$t" || exit 1
+		else
+			printf "$data" > "$t" || exit 1
+		fi
+		set -- $(cksum < "$t")
+		if [ "$1 $2" = "$crc $len" ]; then
+			cat "$t" >> "$p" || exit 1
+			echo ok
+		else
+			echo bad
+		fi
+		;;
+	t)
+		: > "$p" || exit 1
+		echo ok
+		;;
+	e)
+		set -- $(cksum < "$p")
+		if [ "$1 $2" = "$crc $len" ]; then
+			mv -f "$p" "$f" || exit 1
+			echo done
+		else
+			echo bad
+		fi
+		exit
+		;;
+	esac
+done`
+
+// downloadScript sends one file. Arguments: directory, path, first chunk,
+// chunk size.
+const downloadScript = `[ -z "$1" ] || cd "$1" || exit 1
+f=$2; i=$3; bs=$4
+[ -f "$f" ] || { echo "$f: No such file" >&2; exit 2; }
+t=$(mktemp) || exit 1
+trap 'rm -f "$t"' EXIT
+set -- $(wc -c < "$f")
+echo "size $1"
+if [ "$i" -gt 0 ]; then
+	set -- $(dd if="$f" bs=$bs count=$i 2>/dev/null | cksum)
+	echo "head $1 $2"
+fi
+while :; do
+	dd if="$f" of="$t" bs=$bs skip=$i count=1 2>/dev/null || exit 1
+	[ -s "$t" ] || break
+	set -- $(cksum < "$t")
+	echo "chunk $1 $2"
+	od -An -v -tx1 < "$t"
+	echo end
+	i=$((i+1))
+done
+set -- $(cksum < "$f")
+echo "done $1 $2"`
+
+// isMissingCommand reports whether err is a remote command that the
+// shell couldn't find.
+func isMissingCommand(err error) bool {
+	var cerr *CommandError
+	if !errors.As(err, &cerr) {
+		return false
+	}
+	if cerr.ExitStatus == 127 {
+		return true
+	}
+	stderr := strings.ToLower(cerr.Stderr)
+	return cerr.ExitStatus < 0 && (strings.Contains(stderr, "not found") ||
+		strings.Contains(stderr, "no such file"))
+}
+
+// shellUpload sends files through the remote shell.
+func (c *SSHClient) shellUpload(ctx context.Context, s *Session, remote *RemoteCommand, files []FileInfo) error {
+	for _, fileInfo := range files {
+		err := c.shellUploadFile(ctx, s, remote, fileInfo)
+		if err == nil {
+			continue
+		}
+		if !s.callbacks.OnError(err, "send file") {
+			return err
+		}
+	}
+	return nil
+}
+
+// shellUploadFile sends one file through the remote shell.
+func (c *SSHClient) shellUploadFile(ctx context.Context, s *Session, remote *RemoteCommand, fileInfo FileInfo) error {
+	var file io.Reader
+	var info os.FileInfo
+	var err error
+	if s.callbacks.OnFileOpen != nil {
+		file, info, err = s.callbacks.OnFileOpen(fileInfo.Filename)
+	} else {
+		var f *os.File
+		if f, err = os.Open(fileInfo.Filename); err == nil {
+			defer f.Close()
+			file = f
+			info, err = f.Stat()
+		}
+	}
+	if err != nil {
+		// Like SendFiles, go on with the next file
+		s.callbacks.OnError(err, "open file")
+		return nil
+	}
+	if info == nil {
+		info = fileInfo.Info
+	}
+	if closer, ok := file.(io.Closer); ok && s.callbacks.OnFileOpen != nil {
+		defer closer.Close()
+	}
+
+	hdr := NewFileHeader(path.Base(fileInfo.Filename), info)
+	s.callbacks.OnFileStart(hdr)
+
+	sh, err := c.startShell(ctx, uploadScript, remote.Dir, hdr.Name)
+	if err != nil {
+		return err
+	}
+	defer sh.kill()
+
+	// part CRC LEN DECODER
+	fields, err := sh.expect("part", 3)
+	if err != nil {
+		return sh.failure(err)
+	}
+	partCRC, partLen := fields[0], fields[1]
+	encode := encodeBase64
+	if fields[2] != "base64" {
+		encode = encodePrintf
+	}
+
+	// Keep what's there if it's the start of this file
+	sum := newCksum()
+	var offset int64
+	if n, _ := strconv.ParseInt(partLen, 10, 64); n > 0 && n <= hdr.Size {
+		if _, err := io.CopyN(sum, file, n); err != nil {
+			return err
+		}
+		if sum.String() == partCRC+" "+partLen {
+			offset = n
+			s.logger.Info("Shell upload: resuming %s at %d", hdr.Name, offset)
+		} else if seeker, ok := file.(io.Seeker); ok {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			sum = newCksum()
+		} else {
+			return NewError(ErrIO, hdr.Name+": partial upload differs and the file can't be rewound")
+		}
+	}
+	if offset == 0 && partLen != "0" {
+		if err := sh.send("t"); err != nil {
+			return sh.failure(err)
+		}
+		if _, err := sh.expect("ok", 0); err != nil {
+			return sh.failure(err)
+		}
+	}
+
+	start := time.Now()
+	sent := offset
+	buf := make([]byte, fallbackChunkSize)
+	for {
+		n, rerr := io.ReadFull(file, buf)
+		if n > 0 {
+			chunk := buf[:n]
+			line := "c " + newCksum().sumOf(chunk) + " " + encode(chunk)
+			if err := sh.sendChecked(line); err != nil {
+				return sh.failure(err)
+			}
+			sum.Write(chunk)
+			sent += int64(n)
+			s.callbacks.OnProgress(hdr.Name, sent, hdr.Size, rate(sent-offset, start))
+		}
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		}
+		if rerr != nil {
+			return rerr
+		}
+	}
+
+	if err := sh.send("e " + sum.String()); err != nil {
+		return sh.failure(err)
+	}
+	if _, err := sh.expect("done", 0); err != nil {
+		return sh.failure(err)
+	}
+	if err := sh.close(); err != nil {
+		return err
+	}
+	s.callbacks.OnFileComplete(hdr.Name, sent, time.Since(start))
+	return nil
+}
+
+// shellDownload fetches remotePaths through the remote shell.
+func (c *SSHClient) shellDownload(ctx context.Context, s *Session, remote *RemoteCommand, remotePaths []string) error {
+	for _, remotePath := range remotePaths {
+		err := c.shellDownloadFile(ctx, s, remote, remotePath)
+		if err == nil || IsFileSkipped(err) {
+			continue
+		}
+		var cerr *CommandError
+		if errors.As(err, &cerr) && cerr.ExitStatus == 2 {
+			// No such file; sz skips those too
+			s.callbacks.OnError(err, "open file")
+			continue
+		}
+		if !s.callbacks.OnError(err, "receive file") {
+			return err
+		}
+	}
+	return nil
+}
+
+// shellDownloadFile fetches one file through the remote shell.
+func (c *SSHClient) shellDownloadFile(ctx context.Context, s *Session, remote *RemoteCommand, remotePath string) error {
+	hdr := &FileHeader{Name: path.Base(remotePath)}
+	var name string // Local name, without OnFileCreate
+	var out io.Writer
+	var part *os.File
+	var offset int64
+	sum := newCksum() // Of what is in out, from the start of the file
+	prompted := false
+	start := time.Now()
+
+	for bad := 0; ; {
+		sh, err := c.startShell(ctx, downloadScript, remote.Dir, remotePath,
+			strconv.FormatInt(offset/fallbackChunkSize, 10), strconv.Itoa(fallbackChunkSize))
+		if err != nil {
+			return err
+		}
+		fields, err := sh.expect("size", 1)
+		if err != nil {
+			err = sh.failure(err)
+			sh.kill()
+			return err
+		}
+		hdr.Size, _ = strconv.ParseInt(fields[0], 10, 64)
+
+		if !prompted {
+			prompted = true
+			accept, err := s.callbacks.OnFilePrompt(hdr)
+			if err != nil || !accept {
+				sh.kill()
+				if err == nil {
+					err = NewError(ErrFileSkipped, hdr.Name)
+				}
+				return err
+			}
+			// Like the ZModem receiver, files created here go in the
+			// current directory
+			if s.callbacks.OnFileCreate == nil {
+				if name, err = hdr.LocalName(); err != nil {
+					sh.kill()
+					s.logger.Error("Shell download: %v", err)
+					return NewError(ErrFileSkipped, hdr.Name)
+				}
+			}
+			if out, part, offset, err = shellCreate(s, hdr, name); err != nil {
+				sh.kill()
+				return err
+			}
+			if part != nil {
+				defer part.Close()
+			}
+			s.callbacks.OnFileStart(hdr)
+			if offset > 0 {
+				// Start again where the last try stopped
+				sh.kill()
+				if _, err := io.Copy(sum, io.NewSectionReader(part, 0, offset)); err != nil {
+					return err
+				}
+				s.logger.Info("Shell download: resuming %s at %d", hdr.Name, offset)
+				continue
+			}
+		}
+
+		if offset > 0 {
+			// head CRC LEN: what we have must still be the start of the
+			// remote file
+			fields, err := sh.expect("head", 2)
+			if err != nil {
+				err = sh.failure(err)
+				sh.kill()
+				return err
+			}
+			if want := fields[0] + " " + fields[1]; sum.String() != want {
+				sh.kill()
+				if part == nil || bad >= fallbackRetries {
+					return NewError(ErrCRC, fmt.Sprintf("%s: the first %d bytes have cksum %s, expected %s",
+						hdr.Name, offset, sum.String(), want))
+				}
+				bad++
+				s.logger.Info("Shell download: %s.part doesn't match the remote file, starting again", name)
+				if err := part.Truncate(0); err != nil {
+					return err
+				}
+				if _, err := part.Seek(0, io.SeekStart); err != nil {
+					return err
+				}
+				offset = 0
+				sum = newCksum()
+				continue
+			}
+		}
+
+		want, err := shellReceiveChunks(sh, io.MultiWriter(out, sum), &offset, func() {
+			s.callbacks.OnProgress(hdr.Name, offset, hdr.Size, rate(offset, start))
+		})
+		var cerr *Error
+		if errors.As(err, &cerr) && cerr.Type == ErrCRC {
+			sh.kill()
+			if bad++; bad > fallbackRetries {
+				return err
+			}
+			s.logger.Info("Shell download: %s: %v, fetching again from %d", hdr.Name, err, offset)
+			continue
+		}
+		if err != nil {
+			err = sh.failure(err)
+			sh.kill()
+			return err
+		}
+		if err := sh.close(); err != nil {
+			return err
+		}
+		if got := sum.String(); got != want {
+			return NewError(ErrCRC, fmt.Sprintf("%s has cksum %s, expected %s", hdr.Name, got, want))
+		}
+		break
+	}
+
+	if part != nil {
+		if err := part.Close(); err != nil {
+			return err
+		}
+		if err := os.Rename(part.Name(), name); err != nil {
+			return err
+		}
+	}
+	s.callbacks.OnFileComplete(hdr.Name, offset, time.Since(start))
+	return nil
+}
+
+// shellCreate opens where a download goes: OnFileCreate, or name.part in
+// the working directory, cut back to whole chunks to resume from.
+func shellCreate(s *Session, hdr *FileHeader, name string) (io.Writer, *os.File, int64, error) {
+	if s.callbacks.OnFileCreate != nil {
+		w, err := s.callbacks.OnFileCreate(hdr)
+		return w, nil, 0, err
+	}
+
+	part, err := os.OpenFile(name+".part", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	info, err := part.Stat()
+	if err != nil {
+		part.Close()
+		return nil, nil, 0, err
+	}
+	offset := info.Size() - info.Size()%fallbackChunkSize
+	if offset > hdr.Size {
+		offset = 0
+	}
+	if err := part.Truncate(offset); err != nil {
+		part.Close()
+		return nil, nil, 0, err
+	}
+	if _, err := part.Seek(offset, io.SeekStart); err != nil {
+		part.Close()
+		return nil, nil, 0, err
+	}
+	return part, part, offset, nil
+}
+
+// shellReceiveChunks reads chunks from the download loop until "done",
+// writing them to out and moving offset on, and returns the cksum of the
+// whole remote file. A chunk that doesn't match its cksum returns a CRC
+// error.
+func shellReceiveChunks(sh *shellChannel, out io.Writer, offset *int64, progress func()) (string, error) {
+	chunk := make([]byte, 0, fallbackChunkSize)
+	for {
+		line, err := sh.readLine()
+		if err != nil {
+			return "", err
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "done" {
+			return fields[1] + " " + fields[2], nil
+		}
+		if len(fields) != 3 || fields[0] != "chunk" {
+			continue
+		}
+		want := fields[1] + " " + fields[2]
+
+		chunk = chunk[:0]
+		for {
+			line, err := sh.readLine()
+			if err != nil {
+				return "", err
+			}
+			if line == "end" {
+				break
+			}
+			b, err := hex.DecodeString(strings.Join(strings.Fields(line), ""))
+			if err != nil {
+				return "", NewError(ErrCRC, "bad hex from remote od")
+			}
+			chunk = append(chunk, b...)
+		}
+
+		if got := newCksum().sumOf(chunk); got != want {
+			return "", NewError(ErrCRC, fmt.Sprintf("chunk at %d has cksum %s, expected %s", *offset, got, want))
+		}
+		if _, err := out.Write(chunk); err != nil {
+			return "", err
+		}
+		*offset += int64(len(chunk))
+		progress()
+	}
+}
+
+// encodeBase64 encodes a chunk for base64 -d.
+func encodeBase64(chunk []byte) string {
+	return base64.StdEncoding.EncodeToString(chunk)
+}
+
+// encodePrintf encodes a chunk as a printf format of octal escapes.
+func encodePrintf(chunk []byte) string {
+	var b strings.Builder
+	b.Grow(len(chunk) * 4)
+	for _, c := range chunk {
+		fmt.Fprintf(&b, "\\%03o", c)
+	}
+	return b.String()
+}
+
+// rate returns bytes per second since start.
+func rate(n int64, start time.Time) float64 {
+	elapsed := time.Since(start).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(n) / elapsed
+}
+
+// shellChannel is a script running in the remote shell.
+type shellChannel struct {
+	session *ssh.Session
+	command string
+	stdin   io.WriteCloser
+	reader  *TimeoutReader
+	lines   *bufio.Reader
+	stderr  *tailBuffer
+	done    chan error
+}
+
+// startShell runs script with sh on a new SSH session. The login shell
+// may not be POSIX, so the script goes to sh -c, quoted.
+func (c *SSHClient) startShell(ctx context.Context, script string, args ...string) (*shellChannel, error) {
+	session, err := c.client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	words := []string{"sh", "-c", shellQuote(script), "sh"}
+	for _, arg := range args {
+		words = append(words, shellQuote(arg))
+	}
+	sh := &shellChannel{
+		session: session,
+		command: "sh -c <fallback> " + strings.Join(args, " "),
+		stdin:   stdin,
+		reader:  NewTimeoutReader(stdout),
+		stderr:  &tailBuffer{max: maxStderr},
+		done:    make(chan error, 1),
+	}
+	sh.reader.SetContext(ctx)
+	sh.lines = bufio.NewReader(sh.reader)
+	session.Stderr = sh.stderr
+
+	if err := session.Start(strings.Join(words, " ")); err != nil {
+		session.Close()
+		return nil, &CommandError{Command: sh.command, ExitStatus: -1, Err: err}
+	}
+	go func() {
+		sh.done <- session.Wait()
+	}()
+	return sh, nil
+}
+
+// readLine returns the next line from the script.
+func (sh *shellChannel) readLine() (string, error) {
+	sh.reader.SetReadDeadline(time.Now().Add(fallbackTimeout))
+	line, err := sh.lines.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// expect reads lines until one starting with word and at least n more
+// fields, and returns those fields. Other output, like from a noisy
+// shell profile, is skipped.
+func (sh *shellChannel) expect(word string, n int) ([]string, error) {
+	for {
+		line, err := sh.readLine()
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(line)
+		if len(fields) > n && fields[0] == word {
+			return fields[1:], nil
+		}
+		if len(fields) == 1 && fields[0] == "bad" {
+			return nil, NewError(ErrCRC, "remote cksum doesn't match")
+		}
+	}
+}
+
+// send writes a line to the script.
+func (sh *shellChannel) send(line string) error {
+	_, err := io.WriteString(sh.stdin, line+"\n")
+	return err
+}
+
+// sendChecked sends a chunk line until the script says it arrived intact.
+func (sh *shellChannel) sendChecked(line string) error {
+	for bad := 0; ; bad++ {
+		if err := sh.send(line); err != nil {
+			return err
+		}
+		_, err := sh.expect("ok", 0)
+		var cerr *Error
+		if err == nil || !errors.As(err, &cerr) || cerr.Type != ErrCRC || bad == fallbackRetries {
+			return err
+		}
+	}
+}
+
+// close ends the script's input and waits for it to exit. It returns a
+// *CommandError if the script failed.
+func (sh *shellChannel) close() error {
+	sh.stdin.Close()
+	select {
+	case err := <-sh.done:
+		sh.done <- err
+		return sh.exitError(err, nil)
+	case <-time.After(fallbackTimeout):
+		sh.session.Close()
+		return &CommandError{Command: sh.command, ExitStatus: -1, Stderr: sh.stderr.String(),
+			Err: NewError(ErrTimeout, "remote shell didn't exit")}
+	}
+}
+
+// kill ends the script.
+func (sh *shellChannel) kill() {
+	sh.session.Close()
+}
+
+// failure returns err, or the *CommandError behind it if the script has
+// exited and failed.
+func (sh *shellChannel) failure(err error) error {
+	select {
+	case waitErr := <-sh.done:
+		sh.done <- waitErr
+		if cerr := sh.exitError(waitErr, err); cerr != nil {
+			return cerr
+		}
+	case <-time.After(time.Second):
+	}
+	return err
+}
+
+// exitError returns a *CommandError for a failed Wait, with err as the
+// underlying error if there was one.
+func (sh *shellChannel) exitError(waitErr, err error) error {
+	if waitErr == nil {
+		return nil
+	}
+	status := -1
+	var exitErr *ssh.ExitError
+	if errors.As(waitErr, &exitErr) {
+		status = exitErr.ExitStatus()
+	}
+	if err == nil {
+		err = waitErr
+	}
+	return &CommandError{Command: sh.command, ExitStatus: status, Stderr: sh.stderr.String(), Err: err}
+}
+
+// cksum computes the CRC of the POSIX cksum command, which every shell
+// host has to check chunks with.
+type cksum struct {
+	crc uint32
+	n   int64
+}
+
+// cksumTable is the CRC-32 table for cksum's polynomial, MSB first.
+var cksumTable = func() (t [256]uint32) {
+	for i := range t {
+		c := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ 0x04C11DB7
+			} else {
+				c <<= 1
+			}
+		}
+		t[i] = c
+	}
+	return t
+}()
+
+func newCksum() *cksum {
+	return &cksum{}
+}
+
+func (c *cksum) Write(p []byte) (int, error) {
+	for _, b := range p {
+		c.crc = c.crc<<8 ^ cksumTable[byte(c.crc>>24)^b]
+	}
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// String returns the sum like cksum prints it, "CRC LENGTH".
+func (c *cksum) String() string {
+	crc := c.crc
+	for n := c.n; n != 0; n >>= 8 {
+		crc = crc<<8 ^ cksumTable[byte(crc>>24)^byte(n)]
+	}
+	return strconv.FormatUint(uint64(^crc), 10) + " " + strconv.FormatInt(c.n, 10)
+}
+
+// sumOf returns the sum of p alone.
+func (c *cksum) sumOf(p []byte) string {
+	c.Write(p)
+	return c.String()
+}
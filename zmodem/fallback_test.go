@@ -0,0 +1,286 @@
+package zmodem
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCksum(t *testing.T) {
+	// From GNU cksum
+	tests := []struct {
+		data []byte
+		want string
+	}{
+		{nil, "4294967295 0"},
+		{[]byte("a"), "1220704766 1"},
+		{[]byte("123456789"), "930766865 9"},
+		{[]byte("hello world\n"), "3733384285 12"},
+		{make([]byte, 70000), "1774371287 70000"},
+		{func() []byte {
+			p := make([]byte, 256)
+			for i := range p {
+				p[i] = byte(i)
+			}
+			return p
+		}(), "1313719201 256"},
+	}
+	for _, tt := range tests {
+		if got := newCksum().sumOf(tt.data); got != tt.want {
+			t.Errorf("cksum of %d bytes = %s, want %s", len(tt.data), got, tt.want)
+		}
+
+		// Written in pieces
+		sum := newCksum()
+		for p := tt.data; len(p) > 0; {
+			n := min(7, len(p))
+			sum.Write(p[:n])
+			p = p[n:]
+		}
+		if got := sum.String(); got != tt.want {
+			t.Errorf("cksum of %d bytes in pieces = %s, want %s", len(tt.data), got, tt.want)
+		}
+	}
+}
+
+// TestDownloadScript runs the download loop in the local shell, resuming
+// after the first chunk, and reads it like a shell download does.
+func TestDownloadScript(t *testing.T) {
+	for _, tool := range []string{"sh", "dd", "od", "cksum", "mktemp", "wc"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("no %s: %v", tool, err)
+		}
+	}
+
+	data := make([]byte, 2*fallbackChunkSize+1234)
+	rand.New(rand.NewSource(1)).Read(data)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "data.bin"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("sh", "-c", downloadScript, "sh", dir, "data.bin", "1", strconv.Itoa(fallbackChunkSize))
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	sh := &shellChannel{reader: NewTimeoutReader(stdout)}
+	sh.lines = bufio.NewReader(sh.reader)
+
+	fields, err := sh.expect("size", 1)
+	if err != nil {
+		t.Fatalf("size: %v", err)
+	}
+	if fields[0] != strconv.Itoa(len(data)) {
+		t.Errorf("size %s, want %d", fields[0], len(data))
+	}
+
+	fields, err = sh.expect("head", 2)
+	if err != nil {
+		t.Fatalf("head: %v", err)
+	}
+	if got, want := fields[0]+" "+fields[1], newCksum().sumOf(data[:fallbackChunkSize]); got != want {
+		t.Errorf("head cksum %s, want %s", got, want)
+	}
+
+	var out bytes.Buffer
+	offset := int64(fallbackChunkSize)
+	sum, err := shellReceiveChunks(sh, &out, &offset, func() {})
+	if err != nil {
+		t.Fatalf("shellReceiveChunks: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), data[fallbackChunkSize:]) {
+		t.Errorf("got %d bytes that differ from the %d after the first chunk", out.Len(), len(data)-fallbackChunkSize)
+	}
+	if offset != int64(len(data)) {
+		t.Errorf("offset %d, want %d", offset, len(data))
+	}
+	if want := newCksum().sumOf(data); sum != want {
+		t.Errorf("file cksum %s, want %s", sum, want)
+	}
+}
+
+// shellServer serves SSH sessions like a host without lrzsz: commands
+// run with sh in dir, except that rz and sz aren't there.
+func shellServer(t *testing.T, dir string) func(context.Context, ssh.Channel, <-chan *ssh.Request) error {
+	for _, tool := range []string{"sh", "base64", "cat", "dd", "od", "cksum", "mktemp", "wc", "mv"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("no %s: %v", tool, err)
+		}
+	}
+	return func(ctx context.Context, channel ssh.Channel, requests <-chan *ssh.Request) error {
+		defer channel.Close()
+		req, ok := <-requests
+		if !ok || req.Type != "exec" {
+			return io.EOF
+		}
+		go ssh.DiscardRequests(requests)
+		var command execRequest
+		if err := ssh.Unmarshal(req.Payload, &command); err != nil {
+			req.Reply(false, nil)
+			return err
+		}
+		req.Reply(true, nil)
+
+		status := 127
+		if strings.HasPrefix(command.Command, "sh -c ") {
+			cmd := exec.CommandContext(ctx, "sh", "-c", command.Command)
+			cmd.Dir = dir
+			cmd.Stdout = channel
+			cmd.Stderr = channel.Stderr()
+			stdin, err := cmd.StdinPipe()
+			if err != nil {
+				return err
+			}
+			if err := cmd.Start(); err != nil {
+				return err
+			}
+			go func() {
+				io.Copy(stdin, channel)
+				stdin.Close()
+			}()
+			status = 0
+			if err := cmd.Wait(); err != nil {
+				status = 1
+				var exitErr *exec.ExitError
+				if errors.As(err, &exitErr) {
+					status = exitErr.ExitCode()
+				}
+			}
+		} else {
+			fmt.Fprintf(channel.Stderr(), "sh: %s: not found\n", command.Command)
+		}
+		channel.CloseWrite()
+		channel.SendRequest("exit-status", false, ssh.Marshal(exitStatus{Status: uint32(status)}))
+		return nil
+	}
+}
+
+func TestShellUpload(t *testing.T) {
+	remote := t.TempDir()
+	client := NewSSHClient(sshServerClient(t, shellServer(t, remote)))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	local := filepath.Join(t.TempDir(), "up.bin")
+	data := writeRandom(t, local, 2*fallbackChunkSize+1234)
+
+	// A NAME.part that isn't the start of the file is started again
+	if err := os.WriteFile(filepath.Join(remote, "up.bin.part"), make([]byte, fallbackChunkSize), 0644); err != nil {
+		t.Fatal(err)
+	}
+	results, err := client.Upload(ctx, local, "")
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if len(results) != 1 || results[0].Transferred != int64(len(data)) {
+		t.Errorf("results %+v, want one file of %d bytes", results, len(data))
+	}
+	got, err := os.ReadFile(filepath.Join(remote, "up.bin"))
+	if err != nil {
+		t.Fatalf("uploaded file: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("uploaded %d bytes that differ from the %d sent", len(got), len(data))
+	}
+	if _, err := os.Stat(filepath.Join(remote, "up.bin.part")); !os.IsNotExist(err) {
+		t.Errorf("up.bin.part is still there: %v", err)
+	}
+}
+
+func TestShellDownload(t *testing.T) {
+	remote := t.TempDir()
+	data := writeRandom(t, filepath.Join(remote, "down.bin"), 2*fallbackChunkSize+1234)
+	client := NewSSHClient(sshServerClient(t, shellServer(t, remote)))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	t.Chdir(t.TempDir())
+
+	tests := []struct {
+		name string
+		part []byte // NAME.part there before, nil for none
+	}{
+		{"new", nil},
+		{"resumed", data[:fallbackChunkSize+100]},
+		{"stale part", make([]byte, fallbackChunkSize)},
+	}
+	for _, tt := range tests {
+		os.Remove("down.bin")
+		if tt.part != nil {
+			if err := os.WriteFile("down.bin.part", tt.part, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := client.Download(ctx, "down.bin"); err != nil {
+			t.Errorf("%s: Download: %v", tt.name, err)
+			continue
+		}
+		got, err := os.ReadFile("down.bin")
+		if err != nil {
+			t.Errorf("%s: downloaded file: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s: downloaded %d bytes that differ from the %d on the host", tt.name, len(got), len(data))
+		}
+		if _, err := os.Stat("down.bin.part"); !os.IsNotExist(err) {
+			t.Errorf("%s: down.bin.part is still there: %v", tt.name, err)
+		}
+	}
+}
+
+// TestShellDownloadName checks that downloads of paths without a file
+// name, which a host could answer anyway, create nothing.
+func TestShellDownloadName(t *testing.T) {
+	client := NewSSHClient(sshServerClient(t, func(ctx context.Context, channel ssh.Channel, requests <-chan *ssh.Request) error {
+		defer channel.Close()
+		req, ok := <-requests
+		if !ok || req.Type != "exec" {
+			return io.EOF
+		}
+		go ssh.DiscardRequests(requests)
+		var command execRequest
+		if err := ssh.Unmarshal(req.Payload, &command); err != nil {
+			req.Reply(false, nil)
+			return err
+		}
+		req.Reply(true, nil)
+		if !strings.HasPrefix(command.Command, "sh -c ") {
+			channel.SendRequest("exit-status", false, ssh.Marshal(exitStatus{Status: 127}))
+			return nil
+		}
+		io.WriteString(channel, "size 3\n")
+		_, err := io.Copy(io.Discard, channel)
+		return err
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	for _, name := range []string{"/", ".", "..", "dir/.."} {
+		if _, err := client.Download(ctx, name); err != nil {
+			t.Errorf("Download(%q): %v", name, err)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) > 0 {
+		t.Errorf("downloads created %s", entries[0].Name())
+	}
+}
@@ -0,0 +1,165 @@
+package zmodem
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unixRegularFile is the S_IFREG bit Unix senders include in the mode field.
+// lrz treats any of the file type bits as a hint that the file is binary.
+const unixRegularFile = 0100000
+
+// FileHeader is the file information carried in the ZFILE data subpacket
+// (and in YMODEM block 0).
+//
+// On the wire it is the pathname, a NUL, then space separated fields:
+//
+//	size mtime mode serial filesleft bytesleft filetype
+//
+// size, filesleft and bytesleft are decimal, mtime, mode and serial are
+// octal. Every field is optional, but a field can only be sent if all the
+// ones before it are.
+type FileHeader struct {
+	Name      string      // Pathname, as sent by the sender
+	Size      int64       // File length in bytes, 0 if unknown
+	ModTime   time.Time   // Modification time, zero if unknown
+	Mode      os.FileMode // Permission bits, 0 if unknown
+	Serial    int         // Serial number of the sending program, 0 if none
+	FilesLeft int         // Files left in the batch, including this one
+	BytesLeft int64       // Bytes left in the batch, including this file
+	FileType  int         // File type, 0 for a plain sequential file
+}
+
+// NewFileHeader builds the header for sending a file with the given name.
+func NewFileHeader(name string, info os.FileInfo) *FileHeader {
+	return &FileHeader{
+		Name:    name,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Mode:    info.Mode().Perm(),
+	}
+}
+
+// LocalName returns the name to create a received file under in the
+// current directory: the last element of Name, with backslashes taken as
+// separators too for DOS senders. Names the sender chose can't point
+// anywhere else, so "../x" and "/etc/x" both become "x"; names without a
+// last element, like "..", are refused.
+func (h *FileHeader) LocalName() (string, error) {
+	name := path.Base(strings.ReplaceAll(h.Name, "\\", "/"))
+	if name == "." || name == ".." || name == "/" {
+		return "", fmt.Errorf("%q: bad file name", h.Name)
+	}
+	return name, nil
+}
+
+// MarshalBinary encodes the header the way wctxpn() in lsz.c does, with
+// the name in UTF-8. Every field up to bytesleft is written, as 0 if
+// unset; the file type only when it isn't 0.
+func (h *FileHeader) MarshalBinary() ([]byte, error) {
+	return h.marshal(CharsetUTF8)
+}
+
+// marshal encodes the header with the name in the given charset.
+func (h *FileHeader) marshal(cs Charset) ([]byte, error) {
+	name := cs.Encode(h.Name)
+	if bytes.IndexByte(name, 0) >= 0 {
+		return nil, NewError(ErrInvalidFrame, "file name contains NUL")
+	}
+
+	var mtime int64
+	if !h.ModTime.IsZero() {
+		mtime = h.ModTime.Unix()
+	}
+	var mode uint32
+	if h.Mode != 0 {
+		mode = unixRegularFile | uint32(h.Mode.Perm())
+	}
+
+	buf := make([]byte, 0, len(name)+64)
+	buf = append(buf, name...)
+	buf = append(buf, 0)
+	buf = fmt.Appendf(buf, "%d %o %o %o %d %d", h.Size, mtime, mode, h.Serial, h.FilesLeft, h.BytesLeft)
+	if h.FileType != 0 {
+		buf = fmt.Appendf(buf, " %d", h.FileType)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a header, taking the name as UTF-8. This matches
+// procheader() from lrz.c, with allowances for what other senders emit:
+// missing fields, a decimal mtime, mode bits beyond the permissions, and
+// NUL padding at the end.
+func (h *FileHeader) UnmarshalBinary(data []byte) error {
+	return h.unmarshal(data, CharsetUTF8, CharsetUTF8)
+}
+
+// unmarshal decodes a header with the name in the given charset, using
+// fallback for names CharsetAuto finds aren't UTF-8.
+func (h *FileHeader) unmarshal(data []byte, cs, fallback Charset) error {
+	nul := bytes.IndexByte(data, 0)
+	if nul < 0 {
+		return NewError(ErrInvalidFrame, "no null terminator in file header")
+	}
+
+	*h = FileHeader{Name: cs.DecodeFallback(data[:nul], fallback)}
+
+	// The info string ends at the next NUL, if the block was padded
+	info := data[nul+1:]
+	if end := bytes.IndexByte(info, 0); end >= 0 {
+		info = info[:end]
+	}
+	fields := strings.Fields(string(info))
+
+	field := func(i, base int) (int64, bool) {
+		if i >= len(fields) {
+			return 0, false
+		}
+		v, err := strconv.ParseInt(fields[i], base, 64)
+		return v, err == nil
+	}
+
+	if v, ok := field(0, 10); ok {
+		h.Size = v
+	}
+	// Some senders put the time in decimal. That shows as digits an
+	// octal number can't contain, or as ten digits starting with 1,
+	// which in octal would be a time in the mid 1970s
+	mtime, ok := field(1, 8)
+	if !ok || (len(fields[1]) == 10 && fields[1][0] == '1') {
+		mtime, _ = field(1, 10)
+	}
+	if mtime > 0 {
+		h.ModTime = time.Unix(mtime, 0)
+	}
+	if v, ok := field(2, 8); ok {
+		h.Mode = os.FileMode(v).Perm()
+	}
+	if v, ok := field(3, 8); ok {
+		h.Serial = int(v)
+	}
+	if v, ok := field(4, 10); ok {
+		h.FilesLeft = int(v)
+	}
+	if v, ok := field(5, 10); ok {
+		h.BytesLeft = v
+	}
+	if v, ok := field(6, 10); ok {
+		h.FileType = int(v)
+	}
+	return nil
+}
+
+// String returns the header in its wire form, for logging.
+func (h *FileHeader) String() string {
+	data, err := h.MarshalBinary()
+	if err != nil {
+		return fmt.Sprintf("%q", h.Name)
+	}
+	return fmt.Sprintf("%q", data)
+}
@@ -0,0 +1,65 @@
+package zmodem
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFileHeaderMarshal(t *testing.T) {
+	tests := []struct {
+		hdr  FileHeader
+		want string // As lsz sends it
+	}{
+		{
+			FileHeader{Name: "dir/file.txt", Size: 1234, ModTime: time.Unix(1600000000, 0), Mode: 0644, FilesLeft: 2, BytesLeft: 5000},
+			"dir/file.txt\x001234 13727410000 100644 0 2 5000",
+		},
+		{
+			FileHeader{Name: "script", Size: 10, ModTime: time.Unix(8, 0), Mode: 0755, FilesLeft: 1, BytesLeft: 10},
+			"script\x0010 10 100755 0 1 10",
+		},
+		// Unset fields are still written, as 0
+		{FileHeader{Name: "empty"}, "empty\x000 0 0 0 0 0"},
+		{FileHeader{Name: "typed", FileType: 1}, "typed\x000 0 0 0 0 0 1"},
+	}
+
+	for _, tt := range tests {
+		data, err := tt.hdr.MarshalBinary()
+		if err != nil {
+			t.Fatalf("%s: MarshalBinary: %v", tt.hdr.Name, err)
+		}
+		if string(data) != tt.want {
+			t.Errorf("%s: marshalled to %q, want %q", tt.hdr.Name, data, tt.want)
+		}
+
+		// Back again, also from a block padded with NULs
+		for _, block := range [][]byte{data, append(data, make([]byte, 128-len(data))...)} {
+			var got FileHeader
+			if err := got.UnmarshalBinary(block); err != nil {
+				t.Fatalf("%s: UnmarshalBinary: %v", tt.hdr.Name, err)
+			}
+			if got.Name != tt.hdr.Name || got.Size != tt.hdr.Size || !got.ModTime.Equal(tt.hdr.ModTime) ||
+				got.Mode != tt.hdr.Mode || got.FilesLeft != tt.hdr.FilesLeft || got.BytesLeft != tt.hdr.BytesLeft || got.FileType != tt.hdr.FileType {
+				t.Errorf("%s: unmarshalled to %+v, want %+v", tt.hdr.Name, got, tt.hdr)
+			}
+		}
+	}
+
+	if _, err := (&FileHeader{Name: "a\x00b"}).MarshalBinary(); err == nil {
+		t.Errorf("marshalled a name with a NUL")
+	}
+}
+
+func TestFileHeaderUnmarshal(t *testing.T) {
+	var hdr FileHeader
+	// A decimal mtime, mode bits beyond the permissions and missing fields
+	if err := hdr.UnmarshalBinary([]byte("name\x00100 1600000000 100600")); err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Size != 100 || hdr.ModTime.Unix() != 1600000000 || hdr.Mode != 0600 || hdr.FilesLeft != 0 {
+		t.Errorf("unmarshalled to %+v", hdr)
+	}
+	if err := hdr.UnmarshalBinary([]byte("name")); err == nil {
+		t.Errorf("unmarshalled a header without a NUL")
+	}
+}
@@ -561,6 +561,12 @@ func zrdata(r FrameReader, unescaper *zdlreadUnescaper, buf []byte, use32bitCRC
 			return pos, 0, err
 		}
 		
+		// Check for special sequences; GOTCAN has the GOTOR bit too, but
+		// it ends the session, not the frame
+		if c == GOTCAN {
+			return pos, ZCAN, nil
+		}
+
 		// Check for frame end sequences
 		if c&GOTOR != 0 {
 			// Frame end sequence detected
@@ -597,11 +603,6 @@ func zrdata(r FrameReader, unescaper *zdlreadUnescaper, buf []byte, use32bitCRC
 			return pos, frameend, nil
 		}
 		
-		// Check for special sequences
-		if c == GOTCAN {
-			return pos, ZCAN, nil
-		}
-		
 		if c < 0 {
 			return pos, 0, NewError(ErrInvalidFrame, "bad data subpacket")
 		}
@@ -633,6 +634,12 @@ func zrdat32(r FrameReader, unescaper *zdlreadUnescaper, buf []byte) (int, int,
 			return pos, 0, err
 		}
 		
+		// Check for special sequences; GOTCAN has the GOTOR bit too, but
+		// it ends the session, not the frame
+		if c == GOTCAN {
+			return pos, ZCAN, nil
+		}
+
 		// Check for frame end sequences
 		if c&GOTOR != 0 {
 			// Frame end sequence detected
@@ -662,11 +669,6 @@ func zrdat32(r FrameReader, unescaper *zdlreadUnescaper, buf []byte) (int, int,
 			return pos, frameend, nil
 		}
 		
-		// Check for special sequences
-		if c == GOTCAN {
-			return pos, ZCAN, nil
-		}
-		
 		if c < 0 {
 			return pos, 0, NewError(ErrInvalidFrame, "bad data subpacket")
 		}
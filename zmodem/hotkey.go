@@ -0,0 +1,163 @@
+package zmodem
+
+import (
+	"strings"
+	"time"
+)
+
+// localUploadTimeout is how long files picked with the hotkey wait for
+// the remote rz to start. After that a ZRINIT is a remote rz run by hand,
+// which asks OnFileList as usual.
+const localUploadTimeout = 30 * time.Second
+
+// TransferRequest is a transfer started from the local side, returned by
+// OnLocalPrompt.
+type TransferRequest struct {
+	// Upload is true to send local Files with the remote receiver, false
+	// to fetch remote Files with the remote sender
+	Upload bool
+	Files  []string
+}
+
+// WithRemoteCommand sets how TerminalIO runs rz and sz in the remote shell
+// for transfers started with the hotkey (DefaultRemoteCommand if unset).
+// It has no effect on a Session.
+func WithRemoteCommand(remote *RemoteCommand) Option {
+	return func(s *Session) {
+		s.remote = remote
+	}
+}
+
+// Write implements io.Writer for TerminalIO.
+// It passes input through and watches it for the hotkey. During a
+// transfer input is held back instead (see transferInput).
+func (t *TerminalIO) Write(p []byte) (int, error) {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	t.mu.Lock()
+	inTransfer := t.inZModem
+	t.mu.Unlock()
+	if inTransfer {
+		t.transferInput(p)
+		return len(p), nil
+	}
+
+	hotkey := t.config.Hotkey
+	if hotkey == "" || t.callbacks.OnLocalPrompt == nil {
+		return t.writer.Write(p)
+	}
+
+	// Bytes held back from the last write could be the start of the hotkey
+	data := append([]byte(hotkey[:t.hotkeyHeld]), p...)
+	t.hotkeyHeld = 0
+	for {
+		i := strings.Index(string(data), hotkey)
+		if i < 0 {
+			break
+		}
+		if _, err := t.writer.Write(data[:i]); err != nil {
+			return 0, err
+		}
+		data = data[i+len(hotkey):]
+		t.startLocalTransfer()
+	}
+
+	// Hold back an unfinished hotkey at the end
+	for n := len(hotkey) - 1; n > 0; n-- {
+		if len(data) >= n && string(data[len(data)-n:]) == hotkey[:n] {
+			t.hotkeyHeld = n
+			data = data[:len(data)-n]
+			break
+		}
+	}
+	if _, err := t.writer.Write(data); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// sendHeld sends the start of the hotkey held back from the last write to
+// the remote side, for a transfer that is starting: input typed during it
+// is held back, so it can't finish the hotkey any more.
+func (t *TerminalIO) sendHeld() {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	if t.hotkeyHeld == 0 {
+		return
+	}
+	held := t.config.Hotkey[:t.hotkeyHeld]
+	t.hotkeyHeld = 0
+	if _, err := t.writer.Write([]byte(held)); err != nil {
+		t.logger.Error("Sending held input: %v", err)
+	}
+}
+
+// startLocalTransfer asks OnLocalPrompt what to transfer, unless it is
+// already asking or a transfer is running. It doesn't wait for the
+// answer, so the input that answers it isn't held up behind it.
+func (t *TerminalIO) startLocalTransfer() {
+	t.mu.Lock()
+	busy := t.localPrompt || t.inZModem
+	t.localPrompt = !busy
+	t.mu.Unlock()
+	if busy {
+		t.logger.Debug("TerminalIO: hotkey ignored, busy")
+		return
+	}
+	go t.localTransfer()
+}
+
+// localTransfer runs the prompt and types the remote command for the
+// answer into the remote shell. The transfer itself starts like any
+// other, when the output shows the remote rz or sz: files to upload are
+// kept for it instead of calling OnFileList, and downloads are received
+// as usual.
+func (t *TerminalIO) localTransfer() {
+	defer func() {
+		t.mu.Lock()
+		t.localPrompt = false
+		t.mu.Unlock()
+	}()
+
+	t.logger.Info("TerminalIO: hotkey, asking for a local transfer")
+	req, err := t.callbacks.OnLocalPrompt()
+	if err != nil {
+		t.logger.Error("OnLocalPrompt error: %v", err)
+		return
+	}
+	if req == nil || len(req.Files) == 0 {
+		return
+	}
+
+	var line string
+	if req.Upload {
+		t.mu.Lock()
+		t.localFiles = req.Files
+		t.localFilesAt = time.Now()
+		t.mu.Unlock()
+		line = t.remote.ReceiveCommand()
+	} else {
+		line = t.remote.SendCommand(req.Files...)
+	}
+	t.logger.Info("TerminalIO: typing %q", line)
+
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	if _, err := t.writer.Write([]byte(line + "\r")); err != nil {
+		t.logger.Error("Typing the remote command: %v", err)
+	}
+}
+
+// takeLocalFiles returns the files picked with the hotkey for the transfer
+// that is starting, if they are still waiting for it, and forgets them.
+func (t *TerminalIO) takeLocalFiles() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	files := t.localFiles
+	t.localFiles = nil
+	if time.Since(t.localFilesAt) > localUploadTimeout {
+		return nil
+	}
+	return files
+}
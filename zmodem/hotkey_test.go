@@ -0,0 +1,108 @@
+package zmodem
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// hotkeyTerminal returns a test terminal with hotkey, whose prompt asks
+// for a download of "remote.txt" and reports on prompted.
+func hotkeyTerminal(t *testing.T, hotkey string, protocols ...TerminalProtocol) (*testTerminal, chan struct{}) {
+	t.Helper()
+	config := DefaultConfig()
+	config.Hotkey = hotkey
+	prompted := make(chan struct{}, 10)
+	opts := []Option{WithConfig(config), WithCallbacks(&Callbacks{
+		OnLocalPrompt: func() (*TransferRequest, error) {
+			prompted <- struct{}{}
+			return &TransferRequest{Files: []string{"remote.txt"}}, nil
+		},
+	})}
+	for _, protocol := range protocols {
+		opts = append(opts, WithTerminalProtocol(protocol))
+	}
+	return newTestTerminal(t, nil, opts...), prompted
+}
+
+func TestHotkey(t *testing.T) {
+	term, prompted := hotkeyTerminal(t, "\x00")
+	command := DefaultRemoteCommand().SendCommand("remote.txt") + "\r"
+
+	// The hotkey isn't sent, and the prompt's answer is typed after the
+	// input around it
+	term.Write([]byte("ab\x00cd"))
+	if got := term.remoteReads(t, command); got != "abcd"+command {
+		t.Errorf("remote side got %q, want %q", got, "abcd"+command)
+	}
+	select {
+	case <-prompted:
+	default:
+		t.Errorf("OnLocalPrompt wasn't called")
+	}
+}
+
+func TestHotkeySplit(t *testing.T) {
+	term, prompted := hotkeyTerminal(t, "~z")
+	command := DefaultRemoteCommand().SendCommand("remote.txt") + "\r"
+
+	// The start of the hotkey waits for the next write
+	term.Write([]byte("a~"))
+	if got := term.remoteReads(t, "a"); got != "a" {
+		t.Errorf("remote side got %q, want %q", got, "a")
+	}
+	term.Write([]byte("zb"))
+	if got := term.remoteReads(t, command); got != "b"+command {
+		t.Errorf("remote side got %q, want %q", got, "b"+command)
+	}
+	<-prompted
+
+	// and goes through if it isn't followed by the rest
+	term.Write([]byte("a~"))
+	term.Write([]byte("xb"))
+	if got := term.remoteReads(t, "b"); got != "a~xb" {
+		t.Errorf("remote side got %q, want %q", got, "a~xb")
+	}
+	select {
+	case <-prompted:
+		t.Errorf("OnLocalPrompt was called without the hotkey")
+	default:
+	}
+}
+
+func TestHotkeyHeldAtTransfer(t *testing.T) {
+	protocol := newFakeProtocol()
+	term, _ := hotkeyTerminal(t, "~z", protocol)
+
+	// A transfer that starts sends the start of the hotkey on first
+	term.Write([]byte("a~"))
+	term.remoteReads(t, "a")
+	io.WriteString(term.out, "\x01start")
+	<-protocol.started
+	if got := term.remoteReads(t, "~"); got != "~" {
+		t.Errorf("remote side got %q, want the held %q", got, "~")
+	}
+	close(protocol.release)
+	<-protocol.result
+
+	io.WriteString(term.out, "prompt")
+	term.appReads(t, "prompt")
+	term.Write([]byte("z"))
+	if got := term.remoteReads(t, "z"); got != "z" {
+		t.Errorf("after the transfer, remote side got %q, want %q", got, "z")
+	}
+}
+
+func TestHotkeyNone(t *testing.T) {
+	term, prompted := hotkeyTerminal(t, "")
+	term.Write([]byte("a\x00~z"))
+	if got := term.remoteReads(t, "z"); got != "a\x00~z" {
+		t.Errorf("remote side got %q, want %q", got, "a\x00~z")
+	}
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-prompted:
+		t.Errorf("OnLocalPrompt was called with the hotkey turned off")
+	default:
+	}
+}
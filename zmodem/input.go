@@ -0,0 +1,148 @@
+package zmodem
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+const (
+	// maxQueuedInput is how much input typed during a transfer is kept
+	// with QueueInput; the rest is dropped.
+	maxQueuedInput = 4096
+
+	// After an abort, output is drained until the remote side has been
+	// quiet for drainQuiet, for drainMax at most.
+	drainQuiet = 500 * time.Millisecond
+	drainMax   = 5 * time.Second
+)
+
+// Canit aborts the transfer, or a receiver still waiting for one, on the
+// other side of w: ten CANs, then backspaces over them in case a shell
+// got them.
+// This matches canit() from lrz.c.
+func Canit(w io.Writer) error {
+	_, err := w.Write([]byte{
+		CAN, CAN, CAN, CAN, CAN, CAN, CAN, CAN, CAN, CAN,
+		8, 8, 8, 8, 8, 8, 8, 8, 8, 8,
+	})
+	return err
+}
+
+// contextReader reads from the terminal for one transfer, so aborting it
+// stops a read in progress.
+type contextReader struct {
+	reader *TimeoutReader
+	ctx    context.Context
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	return r.reader.ReadContext(r.ctx, p)
+}
+
+// beginTransfer holds back input for a transfer that is starting and
+// returns its context, which the abort key cancels. The caller holds mu,
+// so input is only held back once there is a transfer to abort.
+func (t *TerminalIO) beginTransfer() context.Context {
+	ctx, cancel := context.WithCancel(t.ctx)
+	t.inZModem = true
+	t.cancel = cancel
+	t.aborted = false
+	return ctx
+}
+
+// endTransfer lets input through again once a transfer is over. After an
+// abort the rest of the transfer is drained first, so it doesn't end up on
+// the screen; otherwise the input queued during the transfer is sent.
+func (t *TerminalIO) endTransfer() {
+	t.mu.Lock()
+	cancel, aborted := t.cancel, t.aborted
+	t.cancel = nil
+	t.mu.Unlock()
+	cancel()
+
+	if aborted {
+		t.callbacks.OnError(NewError(ErrCancelled, "transfer aborted from the keyboard"), "transfer")
+		t.drain()
+	}
+
+	// Input waits until the queue is sent
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	t.mu.Lock()
+	t.inZModem = false
+	queued := t.queued
+	t.queued = nil
+	t.abortKeys = 0
+	t.mu.Unlock()
+	if len(queued) > 0 && !aborted {
+		t.logger.Debug("TerminalIO: sending %d bytes typed during the transfer", len(queued))
+		if _, err := t.writer.Write(queued); err != nil {
+			t.logger.Error("Sending queued input: %v", err)
+		}
+	}
+}
+
+// transferInput takes what is typed during a transfer, which mustn't get
+// into the transfer: it is queued with QueueInput or dropped, and the
+// abort key pressed AbortCount times in a row aborts the transfer. The
+// caller holds writeMu.
+func (t *TerminalIO) transferInput(p []byte) {
+	for _, ch := range p {
+		if t.config.AbortCount <= 0 || ch != t.config.AbortKey {
+			t.abortKeys = 0
+			continue
+		}
+		t.abortKeys++
+		if t.abortKeys >= t.config.AbortCount {
+			t.abortKeys = 0
+			t.abortTransfer()
+			return
+		}
+	}
+
+	if !t.config.QueueInput {
+		t.logger.Debug("TerminalIO: dropped %d bytes typed during the transfer", len(p))
+		return
+	}
+	if room := maxQueuedInput - len(t.queued); len(p) > room {
+		p = p[:room]
+	}
+	t.queued = append(t.queued, p...)
+}
+
+// abortTransfer cancels the running transfer and stops the remote side
+// with CANs.
+func (t *TerminalIO) abortTransfer() {
+	t.mu.Lock()
+	cancel := t.cancel
+	if cancel != nil {
+		t.aborted = true
+	}
+	t.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+
+	t.logger.Info("TerminalIO: transfer aborted from the keyboard")
+	t.queued = nil
+	cancel()
+	if err := Canit(t.writer); err != nil {
+		t.logger.Error("Cancelling the remote side: %v", err)
+	}
+}
+
+// drain reads and drops what the remote side still sends after an abort,
+// until it goes quiet.
+func (t *TerminalIO) drain() {
+	buf := make([]byte, 4096)
+	end := time.Now().Add(drainMax)
+	for time.Now().Before(end) {
+		t.reader.SetReadDeadline(time.Now().Add(drainQuiet))
+		n, err := t.reader.Read(buf)
+		if err != nil {
+			return
+		}
+		t.logger.Debug("TerminalIO: drained %d bytes", n)
+	}
+}
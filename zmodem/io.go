@@ -93,9 +93,16 @@ func (z *zmodemIO) readByteInternal() (byte, error) {
 		}
 	}
 	
-	// Read into buffer
+	// Read into buffer, letting cancellation interrupt the read if the
+	// reader can
 	z.rpos = 0
-	n, err := z.reader.Read(z.rbuf)
+	var n int
+	var err error
+	if cr, ok := z.reader.(ContextReader); ok && z.ctx != nil {
+		n, err = cr.ReadContext(z.ctx, z.rbuf)
+	} else {
+		n, err = z.reader.Read(z.rbuf)
+	}
 	if err != nil {
 		return 0, err
 	}
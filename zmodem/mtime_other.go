@@ -0,0 +1,15 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd || solaris || windows)
+
+package zmodem
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// setModTime can't set the times of an open file here, and setting them
+// by name could hit another file put in its place, so it doesn't.
+func setModTime(f *os.File, t time.Time) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,26 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd || solaris
+
+package zmodem
+
+import (
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// setModTime sets the access and modification times of the open file f
+// (futimes), so they can't land on another file put in its place.
+func setModTime(f *os.File, t time.Time) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	tv := unix.NsecToTimeval(t.UnixNano())
+	if cerr := conn.Control(func(fd uintptr) {
+		err = unix.Futimes(int(fd), []unix.Timeval{tv, tv})
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,24 @@
+package zmodem
+
+import (
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// setModTime sets the access and write times of the open file f with
+// SetFileTime.
+func setModTime(f *os.File, t time.Time) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	ft := windows.NsecToFiletime(t.UnixNano())
+	if cerr := conn.Control(func(fd uintptr) {
+		err = windows.SetFileTime(windows.Handle(fd), nil, &ft, &ft)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
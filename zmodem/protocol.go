@@ -0,0 +1,45 @@
+package zmodem
+
+import (
+	"context"
+	"io"
+)
+
+// TerminalProtocol is a file transfer protocol other than ZModem that
+// TerminalIO can detect in terminal output, such as an XMODEM or YMODEM
+// receiver asking for data. Protocols are registered with
+// WithTerminalProtocol and checked in order, after ZModem.
+type TerminalProtocol interface {
+	// Name identifies the protocol in logs.
+	Name() string
+
+	// Detect is called with each chunk of terminal output read while no
+	// transfer is running. It returns the offset in p where the transfer
+	// starts, or -1. It may keep state between calls to recognize
+	// sequences that span chunks or repeat over time.
+	Detect(p []byte) int
+
+	// Transfer runs a detected transfer. Output before the detection
+	// offset has already been passed to the application; the line's
+	// reader starts at the offset. Terminal output resumes when Transfer
+	// returns.
+	Transfer(t *TerminalTransfer) error
+}
+
+// TerminalTransfer is the line handed to a TerminalProtocol for a transfer.
+type TerminalTransfer struct {
+	Context   context.Context
+	Reader    ReaderWithTimeout
+	Writer    io.Writer
+	Config    *Config
+	Callbacks *Callbacks
+	Logger    Logger
+}
+
+// WithTerminalProtocol makes TerminalIO detect and run protocol as well as
+// ZModem. It has no effect on a Session.
+func WithTerminalProtocol(protocol TerminalProtocol) Option {
+	return func(s *Session) {
+		s.protocols = append(s.protocols, protocol)
+	}
+}
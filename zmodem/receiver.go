@@ -4,8 +4,6 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"os"
-	"strings"
 )
 
 // Receiver handles receiving files using the ZModem protocol.
@@ -33,6 +31,8 @@ type Receiver struct {
 	// State
 	zrqinitsReceived int
 	attn             []byte
+	delta            bool // Advertise delta support in ZRINIT
+	deltaActive      bool // Set to true once the sender asked for deltas
 	
 	// Context
 	ctx context.Context
@@ -49,6 +49,7 @@ type ReceiverConfig struct {
 	Timeout       int // in tenths of seconds
 	BufferSize    int
 	Attention     []byte
+	Delta         bool // Accept delta transfers (see delta.go)
 	Context       context.Context
 	Logger        Logger
 }
@@ -90,6 +91,7 @@ func NewReceiver(reader ReaderWithTimeout, writer io.Writer, config *ReceiverCon
 		timeout:      config.Timeout,
 		bufferSize:   config.BufferSize,
 		attn:         config.Attention,
+		delta:        config.Delta,
 		ctx:          config.Context,
 		logger:       config.Logger,
 	}
@@ -112,6 +114,9 @@ func (r *Receiver) SendZRINIT(frameType int) error {
 		hdr[ZF0] |= ESCCTL // TESCCTL == ESCCTL
 	}
 	hdr[ZF1] = 0
+	if r.delta {
+		hdr[ZF1] |= ZF1_CANDELTA
+	}
 	hdr[ZF2] = 0
 	hdr[ZF3] = 0
 	
@@ -131,18 +136,39 @@ func (r *Receiver) SendZRINIT(frameType int) error {
 //
 // Returns:
 //   - fileHeader: the ZFILE header data (filename + metadata)
-//   - error: any error that occurred
+//   - error: any error that occurred, io.EOF if the sender ended the session
 func (r *Receiver) WaitForZFILE() ([]byte, error) {
+	fileHeader, err := r.waitForZFILE()
+	switch err {
+	case io.EOF:
+		// A line that closed isn't the end of the session
+		err = io.ErrUnexpectedEOF
+	case errSessionFinished:
+		err = io.EOF
+	}
+	return fileHeader, err
+}
+
+// errSessionFinished is returned by waitForZFILE when the sender sent ZFIN.
+var errSessionFinished = NewError(ErrProtocol, "session finished")
+
+// waitForZFILE is WaitForZFILE, returning errSessionFinished for ZFIN.
+func (r *Receiver) waitForZFILE() ([]byte, error) {
 	maxTries := 15
 	errors := 0
 	
 	r.logger.Info("WaitForZFILE: starting (maxTries=%d)", maxTries)
 	
+	resend := true
 	for n := maxTries; n > 0 && r.zrqinitsReceived < 10; n-- {
-		// Send ZRINIT
-		if err := r.SendZRINIT(ZRINIT); err != nil {
-			return nil, err
+		// Send ZRINIT, unless the last frame was answered already
+		// ("goto again" in tryz)
+		if resend {
+			if err := r.SendZRINIT(ZRINIT); err != nil {
+				return nil, err
+			}
 		}
+		resend = true
 		
 		// Wait for response
 		r.logger.Debug("WaitForZFILE: waiting for response (try %d/%d)", maxTries-n+1, maxTries)
@@ -229,6 +255,7 @@ func (r *Receiver) WaitForZFILE() ([]byte, error) {
 		case ZSINIT:
 			// Sender is sending attention string
 			r.escapeCtrl = r.escapeCtrl || (hdr[ZF0]&TESCCTL != 0)
+			r.deltaActive = r.delta && (hdr[ZF1]&TDELTA != 0)
 			
 			attnBuf := make([]byte, ZATTNLEN)
 			bytesReceived, frameEnd, err := zrdata(r.reader, r.unescaper, attnBuf, r.use32bitCRC)
@@ -238,6 +265,7 @@ func (r *Receiver) WaitForZFILE() ([]byte, error) {
 				if err := zshhdr(r.writer, ZNAK, hdr); err != nil {
 					return nil, err
 				}
+				resend = false
 				continue
 			}
 			
@@ -251,6 +279,7 @@ func (r *Receiver) WaitForZFILE() ([]byte, error) {
 			if err := zshhdr(r.writer, ZACK, hdr); err != nil {
 				return nil, err
 			}
+			resend = false
 			continue
 			
 		case ZFREECNT:
@@ -261,6 +290,7 @@ func (r *Receiver) WaitForZFILE() ([]byte, error) {
 			if err := zshhdr(r.writer, ZACK, hdr); err != nil {
 				return nil, err
 			}
+			resend = false
 			continue
 			
 		case ZCOMMAND:
@@ -298,13 +328,13 @@ func (r *Receiver) WaitForZFILE() ([]byte, error) {
 			
 		case ZCOMPL:
 			// Transaction complete
+			resend = false
 			continue
 			
 		case ZFIN:
 			// Session finished
-			// Send OO
-			r.writer.Write([]byte("OO"))
-			return nil, NewError(ErrCancelled, "session finished")
+			r.ackBibi()
+			return nil, errSessionFinished
 			
 		case ZRINIT:
 			// Remote site is also a receiver
@@ -321,60 +351,25 @@ func (r *Receiver) WaitForZFILE() ([]byte, error) {
 	return nil, NewError(ErrTimeout, "timeout waiting for ZFILE")
 }
 
-// ParseFileHeader parses the ZFILE header data.
-// This matches procheader() from lrz.c.
-//
-// Format: filename\0size mtime mode 0 filesleft totalleft
-func ParseFileHeader(data []byte) (filename string, size int64, mtime int64, mode os.FileMode, filesLeft, totalLeft int, err error) {
-	// Find null terminator
-	nullPos := -1
-	for i, b := range data {
-		if b == 0 {
-			nullPos = i
-			break
+// ackBibi answers the sender's ZFIN and waits for its "OO".
+// This matches ackbibi() from lrz.c.
+func (r *Receiver) ackBibi() {
+	for n := 0; n < 3; n++ {
+		r.io.PurgeLine()
+		if err := zshhdr(r.writer, ZFIN, stohdr(0)); err != nil {
+			return
+		}
+		c, err := r.io.ReadByte()
+		if err == nil && c == 'O' {
+			// Eat the second O
+			r.io.ReadByte()
+			return
+		}
+		if err != nil && !IsTimeout(err) {
+			// The sender is gone
+			return
 		}
 	}
-	
-	if nullPos < 0 {
-		return "", 0, 0, 0, 0, 0, NewError(ErrInvalidFrame, "no null terminator in file header")
-	}
-	
-	filename = string(data[:nullPos])
-	
-	// Skip padding zeros
-	infoStart := nullPos + 1
-	for infoStart < len(data) && data[infoStart] == 0 {
-		infoStart++
-	}
-	
-	// Parse file info string: "size mtime mode 0 filesleft totalleft"
-	if infoStart >= len(data) {
-		// No file info - use defaults
-		return filename, 0, 0, 0644, 0, 0, nil
-	}
-	
-	infoStr := string(data[infoStart:])
-	fields := strings.Fields(infoStr)
-	
-	if len(fields) >= 1 {
-		fmt.Sscanf(fields[0], "%d", &size)
-	}
-	if len(fields) >= 2 {
-		fmt.Sscanf(fields[1], "%d", &mtime)
-	}
-	if len(fields) >= 3 {
-		var modeInt uint
-		fmt.Sscanf(fields[2], "%o", &modeInt)
-		mode = os.FileMode(modeInt)
-	}
-	if len(fields) >= 5 {
-		fmt.Sscanf(fields[4], "%d", &filesLeft)
-	}
-	if len(fields) >= 6 {
-		fmt.Sscanf(fields[5], "%d", &totalLeft)
-	}
-	
-	return filename, size, mtime, mode, filesLeft, totalLeft, nil
 }
 
 // ReceiveFile receives a file using ZModem protocol.
@@ -388,12 +383,17 @@ func (r *Receiver) ReceiveFile(file io.Writer, expectedSize int64) error {
 	errors := 0
 	maxErrors := 20
 	
+	sendPos := true
 	for {
-		// Send ZRPOS with current position
-		hdr := stohdr(uint32(bytesReceived))
-		if err := zshhdr(r.writer, ZRPOS, hdr); err != nil {
-			return err
+		// Send ZRPOS with current position, unless the last frame
+		// ended cleanly and the sender is moving on by itself
+		if sendPos {
+			hdr := stohdr(uint32(bytesReceived))
+			if err := zshhdr(r.writer, ZRPOS, hdr); err != nil {
+				return err
+			}
 		}
+		sendPos = true
 		
 		// Wait for response
 		frameType, rxHdr, err := r.getHeader(0)
@@ -432,12 +432,13 @@ func (r *Receiver) ReceiveFile(file io.Writer, expectedSize int64) error {
 			if eofPos != uint32(bytesReceived) {
 				// Wrong position - ignore and continue
 				errors = 0
+				sendPos = false
 				continue
 			}
 			
 			// File complete
 			// Send ZACK
-			hdr = stohdr(uint32(bytesReceived))
+			hdr := stohdr(uint32(bytesReceived))
 			if err := zshhdr(r.writer, ZACK, hdr); err != nil {
 				return err
 			}
@@ -461,77 +462,82 @@ func (r *Receiver) ReceiveFile(file io.Writer, expectedSize int64) error {
 				continue
 			}
 			
-			// Receive data
+			// Receive data subpackets until the frame ends
 			buf := make([]byte, r.bufferSize)
-			n, frameEnd, err := zrdata(r.reader, r.unescaper, buf, r.use32bitCRC)
-			if err != nil {
-				if errors++; errors > maxErrors {
-					return err
-				}
-				if len(r.attn) > 0 {
-					r.writer.Write(r.attn)
-				}
-				continue
-			}
-			
-			switch frameEnd {
-			case ZCAN:
-				return NewError(ErrCancelled, "sender cancelled")
-			case -1: // ERROR/CRC error
-				if errors++; errors > maxErrors {
-					return NewError(ErrCRC, "too many CRC errors")
-				}
-				if len(r.attn) > 0 {
-					r.writer.Write(r.attn)
-				}
-				continue
-			case TIMEOUT:
-				if errors++; errors > maxErrors {
-					return NewError(ErrTimeout, "timeout receiving data")
-				}
-				continue
-			case GOTCRCW:
-				// Write data and send ZACK
-				if _, err := file.Write(buf[:n]); err != nil {
-					return err
-				}
-				bytesReceived += int64(n)
-				errors = 0
-				
-				hdr = stohdr(uint32(bytesReceived))
-				if err := zshhdr(r.writer, ZACK|0x80, hdr); err != nil {
-					return err
-				}
-				continue
-			case GOTCRCQ:
-				// Write data and send ZACK, continue receiving
-				if _, err := file.Write(buf[:n]); err != nil {
-					return err
+		moreData:
+			for {
+				n, frameEnd, err := zrdata(r.reader, r.unescaper, buf, r.use32bitCRC)
+				if err != nil {
+					if errors++; errors > maxErrors {
+						return err
+					}
+					if len(r.attn) > 0 {
+						r.writer.Write(r.attn)
+					}
+					break moreData
 				}
-				bytesReceived += int64(n)
-				errors = 0
 				
-				hdr = stohdr(uint32(bytesReceived))
-				if err := zshhdr(r.writer, ZACK, hdr); err != nil {
-					return err
-				}
-				continue
-			case GOTCRCG:
-				// Write data and continue receiving (no ACK)
-				if _, err := file.Write(buf[:n]); err != nil {
-					return err
-				}
-				bytesReceived += int64(n)
-				errors = 0
-				continue
-			case GOTCRCE:
-				// Write data and wait for next header
-				if _, err := file.Write(buf[:n]); err != nil {
-					return err
+				switch frameEnd {
+				case ZCAN:
+					return NewError(ErrCancelled, "sender cancelled")
+				case -1: // ERROR/CRC error
+					if errors++; errors > maxErrors {
+						return NewError(ErrCRC, "too many CRC errors")
+					}
+					if len(r.attn) > 0 {
+						r.writer.Write(r.attn)
+					}
+					break moreData
+				case TIMEOUT:
+					if errors++; errors > maxErrors {
+						return NewError(ErrTimeout, "timeout receiving data")
+					}
+					break moreData
+				case GOTCRCW:
+					// Write data and send ZACK, then wait for next header
+					if _, err := file.Write(buf[:n]); err != nil {
+						return err
+					}
+					bytesReceived += int64(n)
+					errors = 0
+					
+					hdr := stohdr(uint32(bytesReceived))
+					if err := zshhdr(r.writer, ZACK|0x80, hdr); err != nil {
+						return err
+					}
+					sendPos = false
+					break moreData
+				case GOTCRCQ:
+					// Write data and send ZACK, continue receiving
+					if _, err := file.Write(buf[:n]); err != nil {
+						return err
+					}
+					bytesReceived += int64(n)
+					errors = 0
+					
+					hdr := stohdr(uint32(bytesReceived))
+					if err := zshhdr(r.writer, ZACK, hdr); err != nil {
+						return err
+					}
+				case GOTCRCG:
+					// Write data and continue receiving (no ACK)
+					if _, err := file.Write(buf[:n]); err != nil {
+						return err
+					}
+					bytesReceived += int64(n)
+					errors = 0
+				case GOTCRCE:
+					// Write data and wait for next header
+					if _, err := file.Write(buf[:n]); err != nil {
+						return err
+					}
+					bytesReceived += int64(n)
+					errors = 0
+					sendPos = false
+					break moreData
+				default:
+					break moreData
 				}
-				bytesReceived += int64(n)
-				errors = 0
-				continue
 			}
 			
 		default:
@@ -556,6 +562,12 @@ func (r *Receiver) getHeader(eflag int) (int, Header, error) {
 			if err == io.EOF {
 				return TIMEOUT, Header{}, NewError(ErrTimeout, "timeout")
 			}
+			if IsTimeout(err) {
+				return TIMEOUT, Header{}, err
+			}
+			if IsCarrierLost(err) {
+				return RCDO, Header{}, err
+			}
 			return 0, Header{}, err
 		}
 		
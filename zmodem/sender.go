@@ -2,10 +2,7 @@ package zmodem
 
 import (
 	"context"
-	"fmt"
 	"io"
-	"os"
-	path "path/filepath"
 	"time"
 )
 
@@ -39,8 +36,14 @@ type Sender struct {
 	znulls       int
 	attn         []byte
 	initialized  bool // Set to true after successful ZRINIT exchange
+	delta        bool // Offer delta transfers to receivers that support them
+	deltaActive  bool // Set to true once the receiver accepted delta transfers
 	logger       Logger
 
+	// File names
+	filenameCharset Charset
+	dosNames        *dosNameMapper // nil unless names are mapped to 8.3
+
 	// Progress tracking
 	callbacks        *Callbacks
 	progressInterval time.Duration
@@ -74,6 +77,11 @@ func NewSender(reader ReaderWithTimeout, writer io.Writer, config *SenderConfig)
 		logger = NoopLogger{}
 	}
 
+	var dosNames *dosNameMapper
+	if config.DOSFilenames {
+		dosNames = &dosNameMapper{}
+	}
+
 	return &Sender{
 		io:               zio,
 		writer:           frameWriter,
@@ -88,6 +96,9 @@ func NewSender(reader ReaderWithTimeout, writer io.Writer, config *SenderConfig)
 		maxBlockSize:     config.MaxBlockSize,
 		znulls:           config.ZNulls,
 		attn:             config.Attention,
+		delta:            config.Delta,
+		filenameCharset:  config.FilenameCharset,
+		dosNames:         dosNames,
 		ctx:              config.Context,
 		logger:           logger,
 		callbacks:        config.Callbacks,
@@ -106,6 +117,9 @@ type SenderConfig struct {
 	MaxBlockSize     int
 	ZNulls           int
 	Attention        []byte
+	Delta            bool    // Offer delta transfers (see delta.go)
+	FilenameCharset  Charset // Charset of file names on the wire
+	DOSFilenames     bool    // Send file names as DOS 8.3 names
 	Context          context.Context
 	Logger           Logger
 	Callbacks        *Callbacks
@@ -317,8 +331,11 @@ func (s *Sender) GetReceiverInit() error {
 // SendZSINIT sends the send-init information (attention string).
 // This matches sendzsinit() from lsz.c.
 func (s *Sender) SendZSINIT() error {
+	// Delta transfers are requested in ZSINIT, so it can't be skipped then
+	wantDelta := s.delta && s.rxflags2&ZF1_CANDELTA != 0
+
 	// Skip if no attention string and no control escaping needed
-	canSkip := len(s.attn) == 0 && (!s.escapeCtrl || (s.rxflags&TESCCTL != 0))
+	canSkip := len(s.attn) == 0 && (!s.escapeCtrl || (s.rxflags&TESCCTL != 0)) && !wantDelta
 	if canSkip {
 		// Can skip ZSINIT
 		return nil
@@ -327,6 +344,9 @@ func (s *Sender) SendZSINIT() error {
 	errors := 0
 	for {
 		hdr := stohdr(0)
+		if wantDelta {
+			hdr[ZF1] |= TDELTA
+		}
 
 		// Send attention string with null terminator
 		// Match C code: sends 1+strlen(attn) bytes, minimum 1 byte
@@ -366,6 +386,7 @@ func (s *Sender) SendZSINIT() error {
 		case ZCAN:
 			return NewError(ErrCancelled, "receiver cancelled")
 		case ZACK:
+			s.deltaActive = wantDelta
 			return nil
 		default:
 			if errors++; errors > 19 {
@@ -376,6 +397,36 @@ func (s *Sender) SendZSINIT() error {
 	}
 }
 
+// SayBibi ends the session: it sends ZFIN until the receiver answers with
+// its own ZFIN, then sends "OO".
+// This matches saybibi() from lsz.c.
+func (s *Sender) SayBibi() error {
+	for errors := 0; errors < 10; errors++ {
+		s.io.PurgeLine()
+		if err := zshhdr(s.writer, ZFIN, stohdr(0)); err != nil {
+			return err
+		}
+		frameType, _, err := s.getHeader(0)
+		switch frameType {
+		case ZFIN:
+			if _, err := s.writer.Write([]byte("OO")); err != nil {
+				return err
+			}
+			return s.writer.Flush()
+		case ZCAN:
+			return NewError(ErrCancelled, "receiver cancelled")
+		case TIMEOUT, RCDO:
+			// The receiver has the files; it just didn't say goodbye
+			s.logger.Debug("SayBibi: no ZFIN from receiver: %v", err)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return NewError(ErrProtocol, "no ZFIN from receiver")
+}
+
 // getHeader receives a header frame.
 // This matches zgethdr() from zm.c.
 // Returns frame type, header, and error.
@@ -391,6 +442,12 @@ func (s *Sender) getHeader(eflag int) (int, Header, error) {
 			if err == io.EOF {
 				return TIMEOUT, Header{}, NewError(ErrTimeout, "timeout")
 			}
+			if IsTimeout(err) {
+				return TIMEOUT, Header{}, err
+			}
+			if IsCarrierLost(err) {
+				return RCDO, Header{}, err
+			}
 			return 0, Header{}, err
 		}
 
@@ -517,14 +574,21 @@ func (s *Sender) getHeader(eflag int) (int, Header, error) {
 // This matches zsendfile() from lsz.c.
 //
 // Parameters:
-//   - filename: name of the file to send
+//   - fileHdr: the ZFILE header for the file (name, size, mode, ...)
 //   - file: the file to send
-//   - fileInfo: file metadata
-//   - fileHeader: the ZFILE header data (filename + metadata string)
-func (s *Sender) SendFile(filename string, file io.Reader, fileInfo os.FileInfo, fileHeader []byte) error {
+func (s *Sender) SendFile(fileHdr *FileHeader, file io.Reader) error {
+	wireHdr := *fileHdr
+	if s.dosNames != nil {
+		wireHdr.Name = s.dosNames.Map(fileHdr.Name)
+	}
+	fileHeader, err := wireHdr.marshal(s.filenameCharset)
+	if err != nil {
+		return err
+	}
+
 	// Initialize progress tracking
-	s.currentFilename = filename
-	s.currentFileSize = fileInfo.Size()
+	s.currentFilename = fileHdr.Name
+	s.currentFileSize = fileHdr.Size
 	s.startTime = time.Now()
 	s.lastProgressTime = s.startTime
 
@@ -536,17 +600,21 @@ func (s *Sender) SendFile(filename string, file io.Reader, fileInfo os.FileInfo,
 	hdr[ZF3] = 0
 
 	errors := 0
+	resend := true
 	for {
-		// Send ZFILE header
-		if err := zsbhdr(s.writer, ZFILE, hdr, s.use32bitCRC, 0); err != nil {
-			return err
-		}
-		s.logger.Info(FormatFrameLog("TX", ZFILE, hdr, fileHeader, len(fileHeader)))
+		if resend {
+			// Send ZFILE header
+			if err := zsbhdr(s.writer, ZFILE, hdr, s.use32bitCRC, 0); err != nil {
+				return err
+			}
+			s.logger.Info(FormatFrameLog("TX", ZFILE, hdr, fileHeader, len(fileHeader)))
 
-		// Send file header data
-		if err := zsdata(s.writer, fileHeader, ZCRCW, s.use32bitCRC); err != nil {
-			return err
+			// Send file header data
+			if err := zsdata(s.writer, fileHeader, ZCRCW, s.use32bitCRC); err != nil {
+				return err
+			}
 		}
+		resend = true
 
 		// Wait for response
 		frameType, rxHdr, err := s.getHeader(1)
@@ -560,10 +628,16 @@ func (s *Sender) SendFile(filename string, file io.Reader, fileInfo os.FileInfo,
 
 		switch frameType {
 		case ZRINIT:
-			// Discard any remaining data
+			// Discard any remaining data. A stale ZRINIT followed by
+			// another header means the ZFILE got through, so read that
+			// header instead of sending the ZFILE again
 			for {
 				c, err := s.io.ReadByte()
-				if err != nil || c == ZPAD {
+				if err != nil {
+					break
+				}
+				if c == ZPAD {
+					resend = false
 					break
 				}
 			}
@@ -588,7 +662,7 @@ func (s *Sender) SendFile(filename string, file io.Reader, fileInfo os.FileInfo,
 		case ZCRC:
 			// Receiver wants file CRC
 			// Calculate and send CRC
-			crc := s.calculateFileCRC(file, fileInfo.Size())
+			crc := s.calculateFileCRC(file, fileHdr.Size)
 			hdr = stohdr(crc)
 			if err := zsbhdr(s.writer, ZCRC, hdr, s.use32bitCRC, 0); err != nil {
 				return err
@@ -599,6 +673,16 @@ func (s *Sender) SendFile(filename string, file io.Reader, fileInfo os.FileInfo,
 			// Receiver skipped this file
 			return NewError(ErrFileSkipped, "receiver skipped file")
 
+		case ZSIGS:
+			// Receiver has an older copy and wants a delta
+			if !s.deltaActive {
+				if errors++; errors > 10 {
+					return NewError(ErrProtocol, "unexpected frame type")
+				}
+				continue
+			}
+			return s.sendFileDelta(file, fileHdr.Size, rxHdr)
+
 		case ZRPOS:
 			// Receiver wants to resume at position
 			rxpos := rclhdr(rxHdr)
@@ -611,7 +695,7 @@ func (s *Sender) SendFile(filename string, file io.Reader, fileInfo os.FileInfo,
 				}
 			}
 			// Send file data
-			return s.sendFileData(file, fileInfo.Size(), rxpos)
+			return s.sendFileData(file, fileHdr.Size, rxpos)
 
 		default:
 			if errors++; errors > 10 {
@@ -650,46 +734,6 @@ func (s *Sender) calculateFileCRC(file io.Reader, size int64) uint32 {
 	return CRC32Finalize(crc)
 }
 
-// BuildFileHeader builds the ZFILE header data string.
-// This matches the format from wctxpn() in lsz.c.
-//
-// Format: filename\0size mtime mode filesleft totalleft
-func BuildFileHeader(filename string, fileInfo os.FileInfo, filesLeft, totalLeft int) []byte {
-	// Build header: filename + null + file info
-	// Format: "filename\0size mtime mode 0 filesleft totalleft"
-
-	// Build file info string: "size mtime mode 0 filesleft totalleft"
-	// Note: size is decimal, mtime and mode are octal
-	infoStr := fmt.Sprintf("%d %o %o 0 %d %d",
-		fileInfo.Size(),
-		fileInfo.ModTime().Unix(), // Octal, matching lsz.c line 1239
-		fileInfo.Mode()&0777,      // Only permission bits, octal
-		filesLeft,
-		totalLeft)
-
-	// Allocate buffer large enough for filename + null + info
-	totalSize := len(filename) + 1 + len(infoStr)
-	buf := make([]byte, totalSize)
-	pos := 0
-
-	// Make sure we have ONLY the file name
-	_, fname := path.Split(filename)
-
-	// Copy filename
-	copy(buf[pos:], []byte(fname))
-	pos += len(fname)
-
-	// Null terminator
-	buf[pos] = 0
-	pos++
-
-	// Copy file info
-	copy(buf[pos:], []byte(infoStr))
-	pos += len(infoStr)
-
-	return buf
-}
-
 // sendFileData sends the file data frames.
 // This matches zsendfdata() from lsz.c.
 func (s *Sender) sendFileData(file io.Reader, fileSize int64, startPos uint32) error {
@@ -887,13 +931,22 @@ func (s *Sender) sendFileData(file io.Reader, fileSize int64, startPos uint32) e
 		}
 	}
 
-	// Send ZEOF
+	return s.sendEOF(file, fileSize, bytesSent)
+}
+
+// sendEOF sends ZEOF and handles the receiver's answer to it.
+// This matches the ZEOF handling at the end of zsendfdata() from lsz.c.
+func (s *Sender) sendEOF(file io.Reader, fileSize int64, bytesSent int64) error {
+	resend := true
 	for {
-		hdr := stohdr(uint32(bytesSent))
-		if err := zsbhdr(s.writer, ZEOF, hdr, s.use32bitCRC, 0); err != nil {
-			return err
+		if resend {
+			hdr := stohdr(uint32(bytesSent))
+			if err := zsbhdr(s.writer, ZEOF, hdr, s.use32bitCRC, 0); err != nil {
+				return err
+			}
+			s.logger.Info(FormatFrameLog("TX", ZEOF, hdr, nil, 0))
 		}
-		s.logger.Info(FormatFrameLog("TX", ZEOF, hdr, nil, 0))
+		resend = true
 
 		frameType, rxHdr, err := s.getHeader(0)
 		if err != nil {
@@ -917,14 +970,22 @@ func (s *Sender) sendFileData(file io.Reader, fileSize int64, startPos uint32) e
 				if _, err := seeker.Seek(int64(rxpos), io.SeekStart); err != nil {
 					return err
 				}
+			} else if int64(rxpos) != bytesSent {
+				return NewError(ErrProtocol, "cannot reposition unseekable file")
 			}
-			bytesSent = int64(rxpos)
 			return s.sendFileData(file, fileSize, uint32(rxpos))
 		case ZRINIT:
 			// New session
 			return nil
 		case ZSKIP:
 			return NewError(ErrFileSkipped, "receiver skipped")
+		case ZSIGS:
+			// Signatures resent for a duplicate ZFILE - the ZEOF is
+			// still on its way, so just drop them
+			if _, err := s.readSubpackets(int(rclhdr(rxHdr))); err != nil {
+				return err
+			}
+			resend = false
 		default:
 			return NewError(ErrProtocol, "unexpected response to ZEOF")
 		}
@@ -0,0 +1,184 @@
+package zmodem
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ServerTerminal is the server side of an interactive session, like an
+// ssh.Channel with a shell application on it, that can start transfers
+// to and from a ZModem-capable terminal (SecureCRT, Tera Term, iTerm2,
+// or gzssh and TerminalIO). The application reads and writes through the
+// ServerTerminal instead of the channel:
+//
+//	t := zmodem.NewServerTerminal(channel, zmodem.WithCallbacks(callbacks))
+//	shell := term.NewTerminal(t, "> ")
+//	...
+//	case "download":
+//		err = t.SendFiles(ctx, files)
+//	case "upload":
+//		err = t.ReceiveFiles(ctx, uploadDir)
+//
+// SendFiles starts the terminal's receiver the way sz does, and
+// ReceiveFiles asks the terminal for files the way rz does. While a
+// transfer runs, application reads wait and application output is held
+// back until it's done. Transfers run one at a time.
+type ServerTerminal struct {
+	channel io.Writer
+	reader  *TimeoutReader
+	opts    []Option
+
+	mu           sync.Mutex
+	cond         *sync.Cond
+	transferring bool
+	reading      bool            // An application Read is in the reader
+	idle         context.Context // Cancelled when a transfer starts
+	stopIdle     context.CancelFunc
+	held         []byte // Output written during a transfer
+
+	wmu sync.Mutex // Keeps application writes in order with transfers
+	tmu sync.Mutex // One transfer at a time
+}
+
+// NewServerTerminal wraps channel. The options are used for every
+// transfer.
+func NewServerTerminal(channel io.ReadWriter, opts ...Option) *ServerTerminal {
+	t := &ServerTerminal{
+		channel: channel,
+		reader:  NewTimeoutReader(channel),
+		opts:    opts,
+	}
+	t.cond = sync.NewCond(&t.mu)
+	t.idle, t.stopIdle = context.WithCancel(context.Background())
+	return t
+}
+
+// Read reads input from the terminal. It waits while a transfer runs.
+func (t *ServerTerminal) Read(p []byte) (int, error) {
+	for {
+		t.mu.Lock()
+		for t.transferring {
+			t.cond.Wait()
+		}
+		idle := t.idle
+		t.reading = true
+		t.mu.Unlock()
+
+		n, err := t.reader.ReadContext(idle, p)
+
+		t.mu.Lock()
+		t.reading = false
+		t.cond.Broadcast()
+		t.mu.Unlock()
+
+		if n == 0 && err != nil && idle.Err() != nil {
+			// A transfer took over; read again once it's done
+			continue
+		}
+		return n, err
+	}
+}
+
+// Write writes output to the terminal. During a transfer it is held back
+// and written once the transfer is done.
+func (t *ServerTerminal) Write(p []byte) (int, error) {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+
+	t.mu.Lock()
+	if t.transferring {
+		t.held = append(t.held, p...)
+		t.mu.Unlock()
+		return len(p), nil
+	}
+	t.mu.Unlock()
+	return t.channel.Write(p)
+}
+
+// SendFiles sends files to the terminal, like sz: it types "rz" for
+// terminals that start their receiver on it, and sends ZRQINIT for those
+// that start on that.
+func (t *ServerTerminal) SendFiles(ctx context.Context, files []FileInfo) error {
+	return t.transfer(ctx, func(s *Session) error {
+		if _, err := io.WriteString(t.channel, "rz\r"); err != nil {
+			return err
+		}
+		if err := zshhdr(t.channel, ZRQINIT, stohdr(0)); err != nil {
+			return err
+		}
+		return s.SendFiles(ctx, files)
+	})
+}
+
+// ReceiveFiles receives files from the terminal into dir, like rz: it
+// sends ZRINIT, which makes the terminal ask its user for files to send.
+// The names come from the user's side, so they can't leave dir, not even
+// through symlinks; files already there are overwritten. If OnFileCreate
+// is set, it creates the files instead, but dir must still exist.
+func (t *ServerTerminal) ReceiveFiles(ctx context.Context, dir string) error {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	return t.transfer(ctx, func(s *Session) error {
+		if s.callbacks.OnFileCreate == nil {
+			receiveInto(s, root, false, false)
+		}
+		return s.ReceiveFiles(ctx, 0)
+	})
+}
+
+// transfer takes the terminal over from the application, runs fn and
+// hands it back.
+func (t *ServerTerminal) transfer(ctx context.Context, fn func(*Session) error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	t.tmu.Lock()
+	defer t.tmu.Unlock()
+
+	// Stop application output, then application reads
+	t.wmu.Lock()
+	t.mu.Lock()
+	t.transferring = true
+	t.mu.Unlock()
+	t.wmu.Unlock()
+
+	t.mu.Lock()
+	t.stopIdle()
+	for t.reading {
+		t.cond.Wait()
+	}
+	t.mu.Unlock()
+
+	defer func() {
+		t.reader.SetReadDeadline(time.Time{})
+
+		t.wmu.Lock()
+		defer t.wmu.Unlock()
+		t.mu.Lock()
+		held := t.held
+		t.held = nil
+		t.transferring = false
+		t.idle, t.stopIdle = context.WithCancel(context.Background())
+		t.cond.Broadcast()
+		t.mu.Unlock()
+		if len(held) > 0 {
+			t.channel.Write(held)
+		}
+	}()
+
+	opts := append(append([]Option{}, t.opts...), WithContext(ctx))
+	session := NewSession(t.reader, t.channel, opts...)
+	err := fn(session)
+	if err != nil {
+		// Stop the terminal's side too
+		Canit(t.channel)
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+package zmodem
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServerTerminalReceive(t *testing.T) {
+	// The application's side and the user's terminal, on pipes
+	appR, termW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	termR, appW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, f := range []*os.File{appR, termW, termR, appW} {
+			f.Close()
+		}
+	}()
+	terminal := NewServerTerminal(struct {
+		io.Reader
+		io.Writer
+	}{appR, appW})
+
+	local := filepath.Join(t.TempDir(), "up.bin")
+	data := writeRandom(t, local, 20*1024)
+	info, err := os.Stat(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "up.bin"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	received := make(chan error, 1)
+	go func() {
+		received <- terminal.ReceiveFiles(ctx, dir)
+	}()
+
+	// Output while the transfer runs waits for it
+	reader := NewTimeoutReader(termR)
+	sender := NewSession(reader, termW, WithContext(ctx), WithCallbacks(&Callbacks{
+		OnFileStart: func(hdr *FileHeader) {
+			terminal.Write([]byte("hello"))
+		},
+	}))
+	if err := sender.SendFiles(ctx, []FileInfo{{Filename: local, Info: info}}); err != nil {
+		t.Fatalf("SendFiles: %v", err)
+	}
+	if err := <-received; err != nil {
+		t.Fatalf("ReceiveFiles: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "up.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("received %d bytes that differ from the %d sent", len(got), len(data))
+	}
+
+	var rest []byte
+	buf := make([]byte, 256)
+	reader.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for !bytes.Contains(rest, []byte("hello")) {
+		n, err := reader.Read(buf)
+		if err != nil {
+			t.Fatalf("held output didn't arrive: %v (got %q)", err, rest)
+		}
+		rest = append(rest, buf[:n]...)
+	}
+}
+
+func TestServerTerminalNoDir(t *testing.T) {
+	terminal := NewServerTerminal(struct {
+		io.Reader
+		io.Writer
+	}{bytes.NewReader(nil), io.Discard})
+	dir := filepath.Join(t.TempDir(), "missing")
+	if err := terminal.ReceiveFiles(context.Background(), dir); err == nil {
+		t.Errorf("ReceiveFiles into a missing directory succeeded")
+	}
+}
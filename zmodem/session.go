@@ -30,6 +30,12 @@ type Session struct {
 
 	// Logger
 	logger Logger
+
+	// Other protocols for TerminalIO to detect
+	protocols []TerminalProtocol
+
+	// How TerminalIO types rz and sz for transfers started with the hotkey
+	remote *RemoteCommand
 }
 
 // Config holds session configuration.
@@ -55,6 +61,39 @@ type Config struct {
 	// Attention string
 	Attention []byte
 
+	// Delta enables delta transfers with go-lrzsz peers (see delta.go).
+	// Receivers rebuild files directly on the local filesystem, so deltas
+	// are only used when OnFileCreate is not set.
+	Delta bool
+
+	// FilenameCharset is the charset file names use on the wire, in both
+	// directions. With CharsetAuto, received names that aren't valid
+	// UTF-8 are decoded with FilenameFallback (Latin-1 if unset), and
+	// sent names are UTF-8.
+	FilenameCharset  Charset
+	FilenameFallback Charset
+
+	// DOSFilenames sends file names as unique DOS 8.3 names, for old
+	// receivers that can't create anything else.
+	DOSFilenames bool
+
+	// Hotkey is the key that starts a transfer from the local side in
+	// TerminalIO input, like zssh's ^@ ("\x00"): it calls OnLocalPrompt
+	// and types rz or sz into the remote shell. Empty, the default, turns
+	// it off. The start of a longer sequence is held back until the next
+	// input shows whether it is the hotkey, so single keys work best.
+	Hotkey string
+
+	// Input typed into TerminalIO during a transfer would corrupt it, so
+	// it is dropped, or with QueueInput sent once the transfer is over.
+	// AbortKey typed AbortCount times in a row aborts the transfer
+	// instead, stopping the remote side with CANs like lrzsz does; an
+	// AbortCount of 0 turns it off. The default is Ctrl-X five times, as
+	// in a terminal without TerminalIO.
+	QueueInput bool
+	AbortKey   byte
+	AbortCount int
+
 	// Progress update interval
 	ProgressInterval time.Duration
 }
@@ -71,6 +110,8 @@ func DefaultConfig() *Config {
 		MaxBlockSize:     1024 * 8,
 		ZNulls:           0,
 		Attention:        []byte{0x03, 0x8E, 0}, // ^C + pause
+		AbortKey:         CAN,
+		AbortCount:       5,
 		ProgressInterval: 100 * time.Millisecond,
 	}
 }
@@ -88,7 +129,7 @@ func WithConfig(config *Config) Option {
 // WithCallbacks sets the session callbacks.
 func WithCallbacks(callbacks *Callbacks) Option {
 	return func(s *Session) {
-		s.callbacks = mergeCallbacks(callbacks)
+		s.callbacks = MergeCallbacks(callbacks)
 	}
 }
 
@@ -114,11 +155,15 @@ func NewSession(reader ReaderWithTimeout, writer io.Writer, opts ...Option) *Ses
 		config:    DefaultConfig(),
 		callbacks: defaultCallbacks(),
 		ctx:       context.Background(),
+		logger:    NoopLogger{},
 	}
 
 	for _, opt := range opts {
 		opt(s)
 	}
+	if s.logger == nil {
+		s.logger = NoopLogger{}
+	}
 
 	// Create sender and receiver (will be initialized when needed)
 	senderConfig := &SenderConfig{
@@ -131,6 +176,9 @@ func NewSession(reader ReaderWithTimeout, writer io.Writer, opts ...Option) *Ses
 		MaxBlockSize:     s.config.MaxBlockSize,
 		ZNulls:           s.config.ZNulls,
 		Attention:        s.config.Attention,
+		Delta:            s.config.Delta,
+		FilenameCharset:  s.config.FilenameCharset,
+		DOSFilenames:     s.config.DOSFilenames,
 		Context:          s.ctx,
 		Logger:           s.logger,
 		Callbacks:        s.callbacks,
@@ -144,6 +192,7 @@ func NewSession(reader ReaderWithTimeout, writer io.Writer, opts ...Option) *Ses
 		Timeout:       s.config.Timeout,
 		BufferSize:    s.config.MaxBlockSize,
 		Attention:     s.config.Attention,
+		Delta:         s.config.Delta,
 		Context:       s.ctx,
 		Logger:        s.logger,
 	}
@@ -157,17 +206,19 @@ func NewSession(reader ReaderWithTimeout, writer io.Writer, opts ...Option) *Ses
 // SendFile sends a file over the session.
 // This is a high-level wrapper around the sender implementation.
 func (s *Session) SendFile(ctx context.Context, filename string, file io.Reader, fileInfo os.FileInfo) error {
+	_, actualFileName := path.Split(filename)
+	return s.sendFile(ctx, NewFileHeader(actualFileName, fileInfo), file)
+}
+
+// sendFile sends a file with a header that's already been filled in.
+func (s *Session) sendFile(ctx context.Context, hdr *FileHeader, file io.Reader) error {
 	// Use context from session if not provided
 	if ctx == nil {
 		ctx = s.ctx
 	}
 
 	// Notify file start
-	_, actualFileName := path.Split(filename)
-	s.callbacks.OnFileStart(actualFileName, fileInfo.Size(), fileInfo.Mode())
-
-	// Build file header
-	fileHeader := BuildFileHeader(actualFileName, fileInfo, 0, 0)
+	s.callbacks.OnFileStart(hdr)
 
 	// Initialize receiver if needed (skip if already initialized)
 	if !s.sender.initialized {
@@ -178,7 +229,7 @@ func (s *Session) SendFile(ctx context.Context, filename string, file io.Reader,
 	}
 
 	// Send file
-	err := s.sender.SendFile(actualFileName, file, fileInfo, fileHeader)
+	err := s.sender.SendFile(hdr, file)
 
 	if err != nil {
 		s.callbacks.OnError(err, "send file")
@@ -186,13 +237,14 @@ func (s *Session) SendFile(ctx context.Context, filename string, file io.Reader,
 	}
 
 	// Notify file complete
-	s.callbacks.OnFileComplete(actualFileName, fileInfo.Size(), 0)
+	s.callbacks.OnFileComplete(hdr.Name, hdr.Size, 0)
 
 	return nil
 }
 
 // ReceiveFile receives a file over the session.
 // This is a high-level wrapper around the receiver implementation.
+// It returns io.EOF if the sender ended the session instead.
 func (s *Session) ReceiveFile(ctx context.Context) error {
 	// Use context from session if not provided
 	if ctx == nil {
@@ -203,6 +255,10 @@ func (s *Session) ReceiveFile(ctx context.Context) error {
 
 	// Wait for ZFILE
 	fileHeader, err := s.receiver.WaitForZFILE()
+	if err == io.EOF {
+		s.logger.Info("ReceiveFile: sender ended the session")
+		return err
+	}
 	if err != nil {
 		s.logger.Error("ReceiveFile: WaitForZFILE error: %v", err)
 		s.callbacks.OnError(err, "wait for ZFILE")
@@ -212,36 +268,70 @@ func (s *Session) ReceiveFile(ctx context.Context) error {
 	s.logger.Debug("ReceiveFile: got ZFILE header: %q", fileHeader)
 
 	// Parse file header
-	filename, size, mtime, mode, _, _, err := ParseFileHeader(fileHeader)
-	if err != nil {
-		s.logger.Error("ReceiveFile: ParseFileHeader error: %v", err)
+	hdr := &FileHeader{}
+	if err := hdr.unmarshal(fileHeader, s.config.FilenameCharset, s.config.FilenameFallback); err != nil {
+		s.logger.Error("ReceiveFile: file header error: %v", err)
 		s.callbacks.OnError(err, "parse file header")
 		return err
 	}
 
-	s.logger.Info("ReceiveFile: file=%s, size=%d, mode=%o, mtime=%d", filename, size, mode, mtime)
+	s.logger.Info("ReceiveFile: file=%s, size=%d, mode=%o, mtime=%v", hdr.Name, hdr.Size, hdr.Mode, hdr.ModTime)
 
 	// Prompt user
-	accept, err := s.callbacks.OnFilePrompt(filename, size, mode)
+	accept, err := s.callbacks.OnFilePrompt(hdr)
 	if err != nil {
 		return err
 	}
 	if !accept {
 		// Send ZSKIP
-		hdr := stohdr(0)
-		if err := zshhdr(s.writer, ZSKIP, hdr); err != nil {
+		if err := zshhdr(s.writer, ZSKIP, Header{}); err != nil {
 			return err
 		}
-		return NewError(ErrFileSkipped, filename)
+		return NewError(ErrFileSkipped, hdr.Name)
+	}
+
+	// Files created here go in the current directory, whatever path the
+	// sender gave
+	var name string
+	if s.callbacks.OnFileCreate == nil {
+		if name, err = hdr.LocalName(); err != nil {
+			s.logger.Error("ReceiveFile: %v", err)
+			if err := zshhdr(s.writer, ZSKIP, Header{}); err != nil {
+				return err
+			}
+			return NewError(ErrFileSkipped, hdr.Name)
+		}
+	}
+
+	// Rebuild from the local copy if the sender agreed to delta transfers
+	if s.receiver.deltaActive && s.callbacks.OnFileCreate == nil {
+		handled, err := s.receiveFileDelta(hdr, name)
+		if handled {
+			if err != nil {
+				s.logger.Error("ReceiveFile: delta error: %v", err)
+				s.callbacks.OnError(err, "receive file")
+				return err
+			}
+			s.callbacks.OnFileComplete(hdr.Name, hdr.Size, 0)
+			return nil
+		}
 	}
 
 	// Create file
 	var file io.Writer
 	if s.callbacks.OnFileCreate != nil {
-		file, err = s.callbacks.OnFileCreate(filename, size, mode)
+		file, err = s.callbacks.OnFileCreate(hdr)
 	} else {
-		// Default: create file
-		file, err = os.Create(filename)
+		// Default: create file with the sender's permissions
+		var f *os.File
+		if f, err = os.Create(name); err == nil {
+			file = f
+			if hdr.Mode != 0 {
+				if err = f.Chmod(hdr.Mode); err != nil {
+					f.Close()
+				}
+			}
+		}
 	}
 	if err != nil {
 		s.callbacks.OnError(err, "create file")
@@ -254,11 +344,11 @@ func (s *Session) ReceiveFile(ctx context.Context) error {
 	}
 
 	// Notify file start
-	s.callbacks.OnFileStart(filename, size, mode)
+	s.callbacks.OnFileStart(hdr)
 
 	// Receive file
-	s.logger.Info("ReceiveFile: receiving %d bytes", size)
-	err = s.receiver.ReceiveFile(file, size)
+	s.logger.Info("ReceiveFile: receiving %d bytes", hdr.Size)
+	err = s.receiver.ReceiveFile(file, hdr.Size)
 
 	if err != nil {
 		s.logger.Error("ReceiveFile: ReceiveFile error: %v", err)
@@ -273,15 +363,23 @@ func (s *Session) ReceiveFile(ctx context.Context) error {
 		Chmod(os.FileMode) error
 		SetModTime(time.Time) error
 	}); ok {
-		fileInfo.Chmod(mode)
-		fileInfo.SetModTime(time.Unix(mtime, 0))
+		if hdr.Mode != 0 {
+			fileInfo.Chmod(hdr.Mode)
+		}
+		if !hdr.ModTime.IsZero() {
+			fileInfo.SetModTime(hdr.ModTime)
+		}
 	} else if f, ok := file.(*os.File); ok {
-		os.Chmod(f.Name(), mode)
-		os.Chtimes(f.Name(), time.Unix(mtime, 0), time.Unix(mtime, 0))
+		if hdr.Mode != 0 {
+			os.Chmod(f.Name(), hdr.Mode)
+		}
+		if !hdr.ModTime.IsZero() {
+			os.Chtimes(f.Name(), hdr.ModTime, hdr.ModTime)
+		}
 	}
 
 	// Notify file complete
-	s.callbacks.OnFileComplete(filename, size, 0)
+	s.callbacks.OnFileComplete(hdr.Name, hdr.Size, 0)
 
 	return nil
 }
@@ -293,39 +391,67 @@ func (s *Session) SendFiles(ctx context.Context, files []FileInfo) error {
 		return err
 	}
 
+	// Count the batch for the receiver, as far as it's known before
+	// the files are opened
+	filesLeft := len(files)
+	var bytesLeft int64
+	for _, fileInfo := range files {
+		if fileInfo.Info != nil {
+			bytesLeft += fileInfo.Info.Size()
+		}
+	}
+
 	// Send each file
 	for _, fileInfo := range files {
 		// Open file
 		var file io.Reader
+		var info os.FileInfo
 		var err error
 		if s.callbacks.OnFileOpen != nil {
-			file, _, err = s.callbacks.OnFileOpen(fileInfo.Filename)
+			file, info, err = s.callbacks.OnFileOpen(fileInfo.Filename)
 		} else {
 			// Default: open file
 			f, err := os.Open(fileInfo.Filename)
 			if err != nil {
 				s.callbacks.OnError(err, "open file")
+				filesLeft--
 				continue
 			}
 			file = f
 			defer f.Close()
 
 			// Get file info
-			info, err := f.Stat()
+			info, err = f.Stat()
 			if err != nil {
 				s.callbacks.OnError(err, "stat file")
+				filesLeft--
 				continue
 			}
-			fileInfo.Info = info
 		}
 
 		if err != nil {
 			s.callbacks.OnError(err, "open file")
+			filesLeft--
 			continue
 		}
+		if info != nil {
+			fileInfo.Info = info
+		}
+		if fileInfo.Info == nil {
+			s.callbacks.OnError(NewError(ErrIO, "no file info for "+fileInfo.Filename), "open file")
+			filesLeft--
+			continue
+		}
+
+		_, name := path.Split(fileInfo.Filename)
+		hdr := NewFileHeader(name, fileInfo.Info)
+		hdr.FilesLeft = filesLeft
+		hdr.BytesLeft = max(bytesLeft, hdr.Size)
+		filesLeft--
+		bytesLeft -= hdr.Size
 
 		// Send file
-		if err := s.SendFile(ctx, fileInfo.Filename, file, fileInfo.Info); err != nil {
+		if err := s.sendFile(ctx, hdr, file); err != nil {
 			// Check if file was skipped
 			if zmErr, ok := err.(*Error); ok && zmErr.Type == ErrFileSkipped {
 				// File skipped by receiver, log and continue
@@ -337,7 +463,7 @@ func (s *Session) SendFiles(ctx context.Context, files []FileInfo) error {
 			// For other errors, check if we should retry
 			if s.callbacks.OnError(err, "send file") {
 				// Retry once
-				if err := s.SendFile(ctx, fileInfo.Filename, file, fileInfo.Info); err != nil {
+				if err := s.sendFile(ctx, hdr, file); err != nil {
 					return err
 				}
 			} else {
@@ -346,10 +472,12 @@ func (s *Session) SendFiles(ctx context.Context, files []FileInfo) error {
 		}
 	}
 
-	return nil
+	// End the session so the receiver exits
+	return s.sender.SayBibi()
 }
 
-// ReceiveFiles receives multiple files over the session.
+// ReceiveFiles receives multiple files over the session, until the sender
+// ends the session or maxFiles have arrived (0 for no limit).
 func (s *Session) ReceiveFiles(ctx context.Context, maxFiles int) error {
 	filesReceived := 0
 
@@ -361,9 +489,13 @@ func (s *Session) ReceiveFiles(ctx context.Context, maxFiles int) error {
 
 		// Receive file
 		err := s.ReceiveFile(ctx)
+		if err == io.EOF {
+			// Sender is done
+			break
+		}
 		if err != nil {
-			if IsCancelled(err) || err == NewError(ErrFileSkipped, "") {
-				// File skipped or cancelled - continue
+			if IsFileSkipped(err) {
+				// File skipped - continue
 				continue
 			}
 			return err
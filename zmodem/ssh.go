@@ -2,25 +2,113 @@ package zmodem
 
 import (
 	"context"
+	"errors"
 	"io"
 	"os"
-	path "path/filepath"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/ssh"
 )
 
+// RemoteCommand says how to run rz and sz on the remote host.
+type RemoteCommand struct {
+	// Path is the directory the programs are in; empty to use $PATH
+	Path string
+
+	// Receiver and Sender are the program names, like "lrz" and "lsz"
+	// where lrzsz is installed under those
+	Receiver string
+	Sender   string
+
+	// ReceiverFlags and SenderFlags go before the file names, like "-y"
+	// to overwrite or "-E" to rename existing files
+	ReceiverFlags []string
+	SenderFlags   []string
+
+	// Dir is the directory to run in, where uploads end up; empty for the
+	// login directory
+	Dir string
+}
+
+// DefaultRemoteCommand returns a RemoteCommand that runs rz and sz from
+// $PATH in the login directory.
+func DefaultRemoteCommand() *RemoteCommand {
+	return &RemoteCommand{
+		Receiver: "rz",
+		Sender:   "sz",
+	}
+}
+
+// ReceiveCommand returns the shell command line that runs the receiver.
+func (c *RemoteCommand) ReceiveCommand() string {
+	return c.commandLine(c.Receiver, c.ReceiverFlags, nil)
+}
+
+// SendCommand returns the shell command line that runs the sender for
+// files.
+func (c *RemoteCommand) SendCommand(files ...string) string {
+	return c.commandLine(c.Sender, c.SenderFlags, files)
+}
+
+// commandLine builds "cd dir && path/program flags files", quoted for a
+// POSIX shell.
+func (c *RemoteCommand) commandLine(program string, flags, files []string) string {
+	if c.Path != "" {
+		program = strings.TrimSuffix(c.Path, "/") + "/" + program
+	}
+	words := []string{shellQuote(program)}
+	for _, flag := range flags {
+		words = append(words, shellQuote(flag))
+	}
+	if len(files) > 0 {
+		// Names starting with - aren't flags
+		words = append(words, "--")
+		for _, file := range files {
+			words = append(words, shellQuote(file))
+		}
+	}
+	line := strings.Join(words, " ")
+	if c.Dir != "" {
+		line = "cd " + shellQuote(c.Dir) + " && " + line
+	}
+	return line
+}
+
+// shellQuote quotes s for a POSIX shell if it needs it.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./=+,:@%") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
 // SSHSession wraps an SSH session for ZModem transfers.
-// It manages stdin/stdout/stderr pipes and provides a high-level API.
+// Uploads run the receiver on the remote host and downloads run the
+// sender, as set by Remote. An SSH session runs one command, so an
+// SSHSession is good for one transfer.
+//
+// If the remote command fails, the transfer returns a *CommandError with
+// its exit status and the end of its stderr. There is no shell fallback
+// for hosts without rz or sz, since it takes a new SSH session per file;
+// use an SSHClient for that.
 type SSHSession struct {
 	*Session
 	sshSession *ssh.Session
 	stdin      io.WriteCloser
-	stdout     io.Reader
-	stderr     io.Reader
+	stderr     *tailBuffer
+
+	// Remote is how the remote command is run
+	Remote *RemoteCommand
+
+	// CloseTimeout is how long to wait for the remote command to exit
+	// after the transfer before closing the session
+	CloseTimeout time.Duration
 }
 
-// NewSSHSession creates a ZModem session from an SSH session.
+// NewSSHSession creates a ZModem session from an SSH session. The remote
+// command's stderr is captured for errors, and also goes to
+// sshSession.Stderr if that is set.
 func NewSSHSession(sshSession *ssh.Session, opts ...Option) (*SSHSession, error) {
 	// Get pipes
 	stdin, err := sshSession.StdinPipe()
@@ -34,98 +122,82 @@ func NewSSHSession(sshSession *ssh.Session, opts ...Option) (*SSHSession, error)
 		return nil, err
 	}
 
-	stderr, err := sshSession.StderrPipe()
-	if err != nil {
-		stdin.Close()
-		return nil, err
+	stderr := &tailBuffer{max: maxStderr}
+	if sshSession.Stderr != nil {
+		sshSession.Stderr = io.MultiWriter(stderr, sshSession.Stderr)
+	} else {
+		sshSession.Stderr = stderr
 	}
 
-	// Create a reader with timeout wrapper
-	reader := &sshReader{
-		reader:  stdout,
-		timeout: 100, // 10 seconds default
-	}
+	// SSH channels have no deadlines, so reads go through a pump
+	reader := NewTimeoutReader(stdout)
 
 	// Create underlying ZModem session
 	session := NewSession(reader, stdin, opts...)
 
 	return &SSHSession{
-		Session:    session,
-		sshSession: sshSession,
-		stdin:      stdin,
-		stdout:     stdout,
-		stderr:     stderr,
+		Session:      session,
+		sshSession:   sshSession,
+		stdin:        stdin,
+		stderr:       stderr,
+		Remote:       DefaultRemoteCommand(),
+		CloseTimeout: 10 * time.Second,
 	}, nil
 }
 
-// sshReader wraps an io.Reader to implement ReaderWithTimeout.
-type sshReader struct {
-	reader  io.Reader
-	timeout int
+// SendFiles uploads files: it runs the receiver on the remote host and
+// sends them to it.
+func (s *SSHSession) SendFiles(ctx context.Context, files []FileInfo) error {
+	return s.run(ctx, s.Remote.ReceiveCommand(), func() error {
+		return s.Session.SendFiles(ctx, files)
+	})
 }
 
-func (r *sshReader) Read(p []byte) (int, error) {
-	return r.reader.Read(p)
+// SendFile uploads a single file.
+func (s *SSHSession) SendFile(ctx context.Context, filename string, file io.Reader, fileInfo os.FileInfo) error {
+	return s.run(ctx, s.Remote.ReceiveCommand(), func() error {
+		if err := s.Session.SendFile(ctx, filename, file, fileInfo); err != nil {
+			return err
+		}
+		return s.sender.SayBibi()
+	})
 }
 
-func (r *sshReader) ReadByte() (byte, error) {
-	var buf [1]byte
-	n, err := r.reader.Read(buf[:])
-	if n == 0 {
-		return 0, err
+// ReceiveFiles downloads remoteFiles: it runs the sender on the remote
+// host for them and receives what it sends. Relative names are relative
+// to Remote.Dir.
+func (s *SSHSession) ReceiveFiles(ctx context.Context, remoteFiles ...string) error {
+	if len(remoteFiles) == 0 {
+		return NewError(ErrIO, "no files to download")
 	}
-	return buf[0], err
+	return s.run(ctx, s.Remote.SendCommand(remoteFiles...), func() error {
+		return s.Session.ReceiveFiles(ctx, 0)
+	})
 }
 
-func (r *sshReader) SetTimeout(timeout int) {
-	r.timeout = timeout
+// ReceiveFile downloads a single file.
+func (s *SSHSession) ReceiveFile(ctx context.Context, remoteFile string) error {
+	return s.ReceiveFiles(ctx, remoteFile)
 }
 
-func (r *sshReader) SetReadDeadline(t time.Time) error {
-	// SSH readers don't support deadlines directly
-	// The timeout is handled by the zmodemIO layer
-	return nil
+// SendFileWhenRemoteReceives uploads a file.
+//
+// Deprecated: Use SendFiles, which runs the remote receiver too.
+func (s *SSHSession) SendFileWhenRemoteReceives(ctx context.Context, file FileInfo) error {
+	return s.SendFiles(ctx, []FileInfo{file})
 }
 
-// SendFiles sends multiple files over SSH using ZModem.
-// This starts the remote sz command and sends files.
-func (s *SSHSession) SendFiles(ctx context.Context, files []FileInfo) error {
-	// Start remote sz command
-	if err := s.sshSession.Start("sz --zmodem"); err != nil {
-		return err
-	}
-
-	// Wait for command to finish in background
-	done := make(chan error, 1)
-	go func() {
-		done <- s.sshSession.Wait()
-	}()
-
-	// Send files
-	err := s.Session.SendFiles(ctx, files)
-
-	// Close stdin to signal completion
-	s.stdin.Close()
-
-	// Wait for command to finish
-	select {
-	case err2 := <-done:
-		if err == nil {
-			err = err2
-		}
-	case <-ctx.Done():
-		return ctx.Err()
+// run starts command on the remote host, runs the transfer and waits for
+// the command to exit. A failed command is reported over the transfer's
+// error, since its stderr usually says what went wrong.
+func (s *SSHSession) run(ctx context.Context, command string, transfer func() error) error {
+	if ctx == nil {
+		ctx = s.Session.ctx
 	}
 
-	return err
-}
-
-// ReceiveFiles receives multiple files over SSH using ZModem.
-// This starts the remote rz command and receives files.
-func (s *SSHSession) ReceiveFiles(ctx context.Context, maxFiles int) error {
-	// Start remote rz command
-	if err := s.sshSession.Start("rz --zmodem"); err != nil {
-		return err
+	s.logger.Info("SSH: running %s", command)
+	if err := s.sshSession.Start(command); err != nil {
+		return &CommandError{Command: command, ExitStatus: -1, Err: err}
 	}
 
 	// Wait for command to finish in background
@@ -134,87 +206,52 @@ func (s *SSHSession) ReceiveFiles(ctx context.Context, maxFiles int) error {
 		done <- s.sshSession.Wait()
 	}()
 
-	// Receive files
-	err := s.Session.ReceiveFiles(ctx, maxFiles)
+	err := transfer()
 
 	// Close stdin to signal completion
 	s.stdin.Close()
 
 	// Wait for command to finish
+	timer := time.NewTimer(s.CloseTimeout)
+	defer timer.Stop()
+	var waitErr error
 	select {
-	case err2 := <-done:
-		if err == nil {
-			err = err2
-		}
+	case waitErr = <-done:
+	case <-timer.C:
+		s.logger.Error("SSH: %s didn't exit, closing the session", command)
+		s.sshSession.Close()
+		waitErr = <-done
 	case <-ctx.Done():
+		s.sshSession.Close()
 		return ctx.Err()
 	}
 
+	if cerr := s.failure(command, waitErr, err); cerr != nil {
+		return cerr
+	}
 	return err
 }
 
-// SendFile sends a single file over SSH using ZModem.
-func (s *SSHSession) SendFile(ctx context.Context, filename string, file io.Reader, fileInfo os.FileInfo) error {
-	// Start remote sz command
-	if err := s.sshSession.Start("sz --zmodem"); err != nil {
-		return err
+// failure returns a *CommandError if the remote command failed. transferErr
+// is kept as the underlying error when there was one.
+func (s *SSHSession) failure(command string, waitErr, transferErr error) error {
+	if waitErr == nil {
+		return nil
 	}
-
-	// Wait for command to finish in background
-	done := make(chan error, 1)
-	go func() {
-		done <- s.sshSession.Wait()
-	}()
-
-	// Send file
-	err := s.Session.SendFile(ctx, filename, file, fileInfo)
-
-	// Close stdin to signal completion
-	s.stdin.Close()
-
-	// Wait for command to finish
-	select {
-	case err2 := <-done:
-		if err == nil {
-			err = err2
-		}
-	case <-ctx.Done():
-		return ctx.Err()
+	status := -1
+	var exitErr *ssh.ExitError
+	if errors.As(waitErr, &exitErr) {
+		status = exitErr.ExitStatus()
 	}
-
-	return err
-}
-
-// ReceiveFile receives a single file over SSH using ZModem.
-func (s *SSHSession) ReceiveFile(ctx context.Context) error {
-	// Start remote rz command
-	if err := s.sshSession.Start("rz --zmodem"); err != nil {
-		return err
+	if transferErr == nil {
+		transferErr = waitErr
 	}
-
-	// Wait for command to finish in background
-	done := make(chan error, 1)
-	go func() {
-		done <- s.sshSession.Wait()
-	}()
-
-	// Receive file
-	err := s.Session.ReceiveFile(ctx)
-
-	// Close stdin to signal completion
-	s.stdin.Close()
-
-	// Wait for command to finish
-	select {
-	case err2 := <-done:
-		if err == nil {
-			err = err2
-		}
-	case <-ctx.Done():
-		return ctx.Err()
+	return &CommandError{
+		Command:    command,
+		ExitStatus: status,
+		Stderr:     s.stderr.String(),
+		Err:        transferErr,
 	}
-
-	return err
 }
 
 // Close closes the SSH session and cleans up resources.
@@ -228,7 +265,7 @@ func (s *SSHSession) Close() error {
 	}
 
 	if s.sshSession != nil {
-		if err := s.sshSession.Close(); err != nil {
+		if err := s.sshSession.Close(); err != nil && err != io.EOF {
 			errs = append(errs, err)
 		}
 	}
@@ -240,73 +277,8 @@ func (s *SSHSession) Close() error {
 	return nil
 }
 
-// Stderr returns the stderr reader for monitoring remote command output.
-func (s *SSHSession) Stderr() io.Reader {
-	return s.stderr
-}
-
-// SendFileWhenRemoteReceives sends a file when the remote runs 'rz' (receiver).
-// This is the opposite of SendFiles - the remote is ready to receive, not send.
-// TODO: How is this different to ReceiveFile?
-func (s *SSHSession) SendFileWhenRemoteReceives(ctx context.Context, file FileInfo) error {
-	// Start remote rz command (they will receive)
-	if err := s.sshSession.Start("rz --zmodem"); err != nil {
-		return err
-	}
-
-	// Wait for command to finish in background
-	done := make(chan error, 1)
-	go func() {
-		done <- s.sshSession.Wait()
-	}()
-
-	// Initialize sender - this will wait for ZRQINIT from remote and respond with ZRINIT
-	if err := s.Session.sender.GetReceiverInit(); err != nil {
-		s.stdin.Close()
-		return err
-	}
-
-	// Open file
-	var fileReader io.Reader
-	var err error
-	if s.Session.callbacks.OnFileOpen != nil {
-		fileReader, _, err = s.Session.callbacks.OnFileOpen(file.Filename)
-	} else {
-		f, err2 := os.Open(file.Filename)
-		if err2 != nil {
-			s.stdin.Close()
-			return err2
-		}
-		fileReader = f
-		defer f.Close()
-	}
-	if err != nil {
-		s.stdin.Close()
-		return err
-	}
-
-	// Build file header
-	_, actualName := path.Split(file.Filename)
-	fileHeader := BuildFileHeader(actualName, file.Info, 0, 0)
-
-	// Send file using the sender directly
-	if err := s.Session.sender.SendFile(actualName, fileReader, file.Info, fileHeader); err != nil {
-		s.stdin.Close()
-		return err
-	}
-
-	// Close stdin to signal completion
-	s.stdin.Close()
-
-	// Wait for remote command to finish
-	select {
-	case err2 := <-done:
-		if err2 != nil {
-			return err2
-		}
-	case <-ctx.Done():
-		return ctx.Err()
-	}
-
-	return nil
+// Stderr returns the end of what the remote command wrote to stderr so
+// far.
+func (s *SSHSession) Stderr() string {
+	return s.stderr.String()
 }
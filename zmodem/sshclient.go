@@ -0,0 +1,231 @@
+package zmodem
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// TransferResult is what happened to one file in a transfer.
+type TransferResult struct {
+	// Name is the file name from the ZFILE header
+	Name string
+
+	// Size is the file size from the header, 0 if unknown
+	Size int64
+
+	// Transferred is the number of bytes that arrived, once complete
+	Transferred int64
+
+	// Duration is how long the file took
+	Duration time.Duration
+
+	// Skipped is set if the receiver skipped the file
+	Skipped bool
+
+	// Err is the error that stopped this file, if any
+	Err error
+}
+
+// SSHClient transfers files to and from hosts that have lrzsz but no SFTP
+// subsystem. Each Upload and Download runs on a fresh SSH session:
+//
+//	client := zmodem.NewSSHClient(sshClient, zmodem.WithCallbacks(callbacks))
+//	results, err := client.Upload(ctx, "build/app.tar.gz", "/tmp")
+//
+// Downloaded files are created by OnFileCreate, or in the working
+// directory if it isn't set. Hosts without rz and sz get the files
+// through the shell instead, unless Fallback is turned off.
+type SSHClient struct {
+	client *ssh.Client
+	opts   []Option
+
+	// Remote is how the remote commands are run
+	Remote *RemoteCommand
+
+	// Fallback moves files through the remote shell when rz or sz isn't
+	// installed there (see fallback.go)
+	Fallback bool
+}
+
+// NewSSHClient creates an SSHClient on client. The options are used for
+// every session.
+func NewSSHClient(client *ssh.Client, opts ...Option) *SSHClient {
+	return &SSHClient{
+		client:   client,
+		opts:     opts,
+		Remote:   DefaultRemoteCommand(),
+		Fallback: true,
+	}
+}
+
+// Upload sends localPath to remoteDir on the remote host. If localPath is
+// a directory, the regular files in it are sent, without subdirectories.
+// An empty remoteDir uses Remote.Dir.
+//
+// The results cover every file that was tried; the error is for the
+// transfer as a whole, a *CommandError if the remote receiver failed.
+func (c *SSHClient) Upload(ctx context.Context, localPath, remoteDir string) ([]TransferResult, error) {
+	files, err := uploadFiles(localPath)
+	if err != nil {
+		return nil, err
+	}
+
+	remote := *c.Remote
+	if remoteDir != "" {
+		remote.Dir = remoteDir
+	}
+	return c.transfer(ctx, &remote, func(s *SSHSession) error {
+		return s.SendFiles(ctx, files)
+	}, func(s *Session) error {
+		return c.shellUpload(ctx, s, &remote, files)
+	})
+}
+
+// Download fetches remotePaths from the remote host. Relative paths are
+// relative to Remote.Dir.
+//
+// The results cover every file the remote sender offered; the error is
+// for the transfer as a whole, a *CommandError if the remote sender
+// failed, like when a path doesn't exist.
+func (c *SSHClient) Download(ctx context.Context, remotePaths ...string) ([]TransferResult, error) {
+	return c.transfer(ctx, c.Remote, func(s *SSHSession) error {
+		return s.ReceiveFiles(ctx, remotePaths...)
+	}, func(s *Session) error {
+		return c.shellDownload(ctx, s, c.Remote, remotePaths)
+	})
+}
+
+// transfer runs fn on a new SSHSession, recording the files. If the
+// remote program is missing, fallback runs instead with the same
+// callbacks. Cancelling ctx stops the transfer, even in the middle of a
+// read.
+func (c *SSHClient) transfer(ctx context.Context, remote *RemoteCommand, fn func(*SSHSession) error, fallback func(*Session) error) ([]TransferResult, error) {
+	sshSession, err := c.client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	opts := append(append([]Option{}, c.opts...), WithContext(ctx))
+	s, err := NewSSHSession(sshSession, opts...)
+	if err != nil {
+		sshSession.Close()
+		return nil, err
+	}
+	defer s.Close()
+	s.Remote = remote
+	if reader, ok := s.reader.(*TimeoutReader); ok {
+		reader.SetContext(ctx)
+	}
+
+	rec := &resultRecorder{current: -1}
+	rec.wrap(s.callbacks)
+	err = fn(s)
+	if err != nil && c.Fallback && isMissingCommand(err) {
+		s.logger.Info("SSH: %v; using the shell fallback", err)
+		rec.results = nil
+		err = fallback(s.Session)
+	}
+	return rec.results, err
+}
+
+// uploadFiles returns the files to send for localPath.
+func uploadFiles(localPath string) ([]FileInfo, error) {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []FileInfo{{Filename: localPath, Info: info}}, nil
+	}
+
+	entries, err := os.ReadDir(localPath)
+	if err != nil {
+		return nil, err
+	}
+	var files []FileInfo
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, FileInfo{Filename: filepath.Join(localPath, entry.Name()), Info: info})
+	}
+	if len(files) == 0 {
+		return nil, NewError(ErrIO, "no files to upload in "+localPath)
+	}
+	return files, nil
+}
+
+// resultRecorder builds TransferResults from a session's callbacks.
+type resultRecorder struct {
+	results []TransferResult
+	current int // Index of the file in progress, -1 if none
+	start   time.Time
+}
+
+// wrap hooks the recorder into callbacks, which the session and its
+// sender share, calling the callbacks that were there before.
+func (r *resultRecorder) wrap(callbacks *Callbacks) {
+	onFilePrompt := callbacks.OnFilePrompt
+	callbacks.OnFilePrompt = func(hdr *FileHeader) (bool, error) {
+		accept, err := onFilePrompt(hdr)
+		if err == nil {
+			// Received files start here, since creating them can fail
+			r.add(hdr)
+			if !accept {
+				r.results[r.current].Skipped = true
+				r.current = -1
+			}
+		}
+		return accept, err
+	}
+
+	onFileStart := callbacks.OnFileStart
+	callbacks.OnFileStart = func(hdr *FileHeader) {
+		if r.current < 0 {
+			r.add(hdr)
+		}
+		onFileStart(hdr)
+	}
+
+	onFileComplete := callbacks.OnFileComplete
+	callbacks.OnFileComplete = func(filename string, bytesTransferred int64, duration time.Duration) {
+		if r.current >= 0 {
+			r.results[r.current].Transferred = bytesTransferred
+			r.results[r.current].Duration = time.Since(r.start)
+			r.current = -1
+		}
+		onFileComplete(filename, bytesTransferred, duration)
+	}
+
+	onError := callbacks.OnError
+	callbacks.OnError = func(err error, context string) bool {
+		switch {
+		case r.current >= 0:
+			if IsFileSkipped(err) {
+				r.results[r.current].Skipped = true
+			} else {
+				r.results[r.current].Err = err
+			}
+			r.results[r.current].Duration = time.Since(r.start)
+			r.current = -1
+		case context == "open file" || context == "stat file":
+			// The file never started; the error has its path
+			r.results = append(r.results, TransferResult{Err: err})
+		}
+		return onError(err, context)
+	}
+}
+
+// add starts the result for a file.
+func (r *resultRecorder) add(hdr *FileHeader) {
+	r.results = append(r.results, TransferResult{Name: hdr.Name, Size: hdr.Size})
+	r.current = len(r.results) - 1
+	r.start = time.Now()
+}
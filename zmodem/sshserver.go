@@ -0,0 +1,377 @@
+package zmodem
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ExecServer serves rz and sz in SSH servers built on
+// golang.org/x/crypto/ssh, so clients can use their usual lrzsz workflows
+// against them. It takes session channels and their exec requests:
+//
+//	server := &zmodem.ExecServer{Root: "/srv/files"}
+//	go server.Serve(ctx, channel, requests)
+//
+// "rz" receives files into Root and "sz FILE..." sends files from it,
+// also as lrz, lsz, grz and gsz with any directory in front, and after
+// "cd DIR &&" as SSHClient sends them. Paths can't leave Root, not even
+// through symlinks. Other commands fail with exit status 127, like in a
+// shell without them.
+//
+// Like grz, rz overwrites existing files unless it gets -p (protect them)
+// or -E (rename new files to NAME.1, NAME.2, ...); -y overrides -p, and
+// other flags are ignored.
+type ExecServer struct {
+	// Root is the directory files are received into and sent from
+	Root string
+
+	// Protect keeps existing files, whatever the client asks for
+	Protect bool
+
+	// Options are used for every session. Files always go through Root,
+	// so OnFileOpen and OnFileCreate are replaced.
+	Options []Option
+}
+
+// execRequest is the payload of an exec request (RFC 4254 6.5).
+type execRequest struct {
+	Command string
+}
+
+// exitStatus is the payload of an exit-status request (RFC 4254 6.10).
+type exitStatus struct {
+	Status uint32
+}
+
+// Serve handles a session channel: it waits for the exec request, runs
+// it with Handle and discards later requests. Requests for a shell or a
+// subsystem are refused; env and pty requests are accepted and ignored.
+func (s *ExecServer) Serve(ctx context.Context, channel ssh.Channel, requests <-chan *ssh.Request) error {
+	defer channel.Close()
+	for req := range requests {
+		switch req.Type {
+		case "exec":
+			go ssh.DiscardRequests(requests)
+			return s.Handle(ctx, channel, req)
+		case "env", "pty-req", "window-change":
+			if req.WantReply {
+				req.Reply(true, nil)
+			}
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+	return io.EOF
+}
+
+// Handle runs an exec request on channel and sends its exit status. It
+// returns the transfer's error, which the client also gets on stderr.
+// The channel is left open.
+func (s *ExecServer) Handle(ctx context.Context, channel ssh.Channel, req *ssh.Request) error {
+	var exec execRequest
+	if err := ssh.Unmarshal(req.Payload, &exec); err != nil {
+		req.Reply(false, nil)
+		return err
+	}
+	if req.WantReply {
+		req.Reply(true, nil)
+	}
+
+	status, err := s.run(ctx, channel, exec.Command)
+	if err != nil {
+		fmt.Fprintf(channel.Stderr(), "%v\n", err)
+	}
+	channel.CloseWrite()
+	channel.SendRequest("exit-status", false, ssh.Marshal(exitStatus{Status: uint32(status)}))
+	return err
+}
+
+// run runs command and returns its exit status.
+func (s *ExecServer) run(ctx context.Context, channel ssh.Channel, command string) (int, error) {
+	args, err := splitCommand(command)
+	if err != nil {
+		return 2, fmt.Errorf("%s: %v", command, err)
+	}
+
+	dir := "."
+	if len(args) >= 3 && args[0] == "cd" && args[2] == "&&" {
+		dir = args[1]
+		args = args[3:]
+	}
+	if len(args) == 0 {
+		return 0, nil
+	}
+
+	var receive bool
+	switch program := path.Base(args[0]); program {
+	case "rz", "lrz", "grz":
+		receive = true
+	case "sz", "lsz", "gsz":
+	default:
+		return 127, fmt.Errorf("%s: command not found", program)
+	}
+
+	root, err := s.openRoot(dir)
+	if err != nil {
+		return 1, fmt.Errorf("cd: %s: %v", dir, err)
+	}
+	defer root.Close()
+
+	reader := NewTimeoutReader(channel)
+	reader.SetContext(ctx)
+	opts := append(append([]Option{}, s.Options...), WithContext(ctx))
+	session := NewSession(reader, channel, opts...)
+
+	if receive {
+		err = s.receive(ctx, session, root, args[1:])
+	} else {
+		err = s.send(ctx, session, root, args[1:], channel.Stderr())
+	}
+	if err != nil {
+		return 1, fmt.Errorf("%s: %v", path.Base(args[0]), err)
+	}
+	return 0, nil
+}
+
+// openRoot opens dir inside Root.
+func (s *ExecServer) openRoot(dir string) (*os.Root, error) {
+	root, err := os.OpenRoot(s.Root)
+	if err != nil {
+		return nil, err
+	}
+	dir = strings.TrimPrefix(path.Clean("/"+dir), "/")
+	if dir == "" {
+		return root, nil
+	}
+	sub, err := root.OpenRoot(dir)
+	root.Close()
+	return sub, err
+}
+
+// receive runs rz with args.
+func (s *ExecServer) receive(ctx context.Context, session *Session, root *os.Root, args []string) error {
+	overwrite, protect, rename := false, false, false
+	for _, arg := range args {
+		switch arg {
+		case "-y", "--overwrite":
+			overwrite = true
+		case "-p", "--protect":
+			protect = true
+		case "-E", "--rename":
+			rename = true
+		default:
+			if strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") {
+				// Grouped flags like -yq
+				overwrite = overwrite || strings.Contains(arg, "y")
+				protect = protect || strings.Contains(arg, "p")
+				rename = rename || strings.Contains(arg, "E")
+			}
+		}
+	}
+	// Like grz, existing files are overwritten unless protected
+	protect = s.Protect || protect && !overwrite
+
+	receiveInto(session, root, protect, rename)
+	return session.ReceiveFiles(ctx, 0)
+}
+
+// receiveInto makes session create received files in root, under the
+// name the sender gave, which can't leave root, not even through
+// symlinks. Existing files are overwritten, unless protect keeps them or
+// rename puts the new file under a free name instead.
+func receiveInto(session *Session, root *os.Root, protect, rename bool) {
+	callbacks := session.callbacks
+	onFilePrompt := callbacks.OnFilePrompt
+	callbacks.OnFilePrompt = func(hdr *FileHeader) (bool, error) {
+		name, err := rootName(hdr.Name)
+		if err != nil {
+			return false, nil
+		}
+		if _, err := root.Lstat(name); err == nil {
+			switch {
+			case rename:
+				if name, err = freeName(root, name); err != nil {
+					return false, nil
+				}
+			case protect:
+				session.logger.Info("Receive: %s exists, skipping", name)
+				return false, nil
+			}
+		}
+		hdr.Name = name
+		return onFilePrompt(hdr)
+	}
+	callbacks.OnFileCreate = func(hdr *FileHeader) (io.Writer, error) {
+		mode := hdr.Mode.Perm()
+		if mode == 0 {
+			mode = 0644
+		}
+		f, err := root.OpenFile(hdr.Name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+		if err != nil {
+			return nil, err
+		}
+		return &rootFile{File: f}, nil
+	}
+}
+
+// send runs sz with args. Files that can't be sent are reported on
+// stderr and left out, like gsz does.
+func (s *ExecServer) send(ctx context.Context, session *Session, root *os.Root, args []string, stderr io.Writer) error {
+	var files []FileInfo
+	flags := true
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case flags && arg == "--":
+			flags = false
+		case flags && strings.HasPrefix(arg, "-"):
+			// Flags that take a value
+			switch arg {
+			case "-l", "-L", "-t", "-w", "-B":
+				i++
+			}
+		default:
+			name, err := rootName(arg)
+			if err != nil {
+				fmt.Fprintf(stderr, "%s: %v\n", arg, err)
+				continue
+			}
+			info, err := root.Stat(name)
+			if err == nil && !info.Mode().IsRegular() {
+				err = fmt.Errorf("not a regular file")
+			}
+			if err != nil {
+				fmt.Fprintf(stderr, "%s: %v\n", arg, err)
+				continue
+			}
+			files = append(files, FileInfo{Filename: name, Info: info})
+		}
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no files to send")
+	}
+
+	// SendFiles leaves files from OnFileOpen open
+	var opened []*os.File
+	defer func() {
+		for _, f := range opened {
+			f.Close()
+		}
+	}()
+	session.callbacks.OnFileOpen = func(filename string) (io.Reader, os.FileInfo, error) {
+		f, err := root.Open(filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		opened = append(opened, f)
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return f, info, nil
+	}
+	return session.SendFiles(ctx, files)
+}
+
+// rootName turns a name from a client into a path inside the root.
+func rootName(name string) (string, error) {
+	name = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(name)), "/")
+	if name == "" {
+		return "", fmt.Errorf("bad file name")
+	}
+	return name, nil
+}
+
+// freeName returns name with the first free suffix .1, .2, ...
+func freeName(root *os.Root, name string) (string, error) {
+	for i := 1; i < 1000; i++ {
+		candidate := name + "." + strconv.Itoa(i)
+		if _, err := root.Lstat(candidate); os.IsNotExist(err) {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("%s: no free name", name)
+}
+
+// rootFile is a received file inside a root. It isn't an *os.File to the
+// session, whose fallback would set the mode and time by path outside
+// the root.
+type rootFile struct {
+	*os.File
+}
+
+// Chmod sets the mode of the open file.
+func (f *rootFile) Chmod(mode os.FileMode) error {
+	return f.File.Chmod(mode)
+}
+
+// SetModTime sets the modification time of the open file.
+func (f *rootFile) SetModTime(t time.Time) error {
+	return setModTime(f.File, t)
+}
+
+// splitCommand splits a command line into words like a POSIX shell,
+// with single and double quotes and backslashes. "&&" is a word of its
+// own.
+func splitCommand(command string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '&' && i+1 < len(command) && command[i+1] == '&' && !inWord:
+			words = append(words, "&&")
+			i++
+		case c == '\'':
+			end := strings.IndexByte(command[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			word.WriteString(command[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case c == '"':
+			i++
+			for ; i < len(command) && command[i] != '"'; i++ {
+				if command[i] == '\\' && i+1 < len(command) && strings.IndexByte("$`\"\\\n", command[i+1]) >= 0 {
+					i++
+				}
+				word.WriteByte(command[i])
+			}
+			if i >= len(command) {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			inWord = true
+		case c == '\\' && i+1 < len(command):
+			i++
+			word.WriteByte(command[i])
+			inWord = true
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
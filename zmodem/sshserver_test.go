@@ -0,0 +1,247 @@
+package zmodem
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	mrand "math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// connPair returns the two ends of a loopback TCP connection. A net.Pipe
+// won't do: both sides of an SSH handshake write before they read, and a
+// net.Pipe write waits for the reader.
+func connPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no loopback: %v", err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	server := <-accepted
+	if server == nil {
+		t.Fatalf("Accept failed")
+	}
+	return server, client
+}
+
+// execServerClient runs server in an SSH server on one end of a
+// connection and returns an SSH client on the other.
+func execServerClient(t *testing.T, server *ExecServer) *ssh.Client {
+	t.Helper()
+	return sshServerClient(t, server.Serve)
+}
+
+// sshServerClient runs an SSH server that hands each session to serve on
+// one end of a connection and returns an SSH client on the other.
+func sshServerClient(t *testing.T, serve func(context.Context, ssh.Channel, <-chan *ssh.Request) error) *ssh.Client {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(signer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serverConn, clientConn := connPair(t)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, channels, requests, err := ssh.NewServerConn(serverConn, serverConfig)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		go ssh.DiscardRequests(requests)
+		for newChannel := range channels {
+			if newChannel.ChannelType() != "session" {
+				newChannel.Reject(ssh.UnknownChannelType, "only sessions")
+				continue
+			}
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				continue
+			}
+			go serve(ctx, channel, requests)
+		}
+	}()
+
+	conn, channels, requests, err := ssh.NewClientConn(clientConn, "pipe", &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatalf("NewClientConn: %v", err)
+	}
+	client := ssh.NewClient(conn, channels, requests)
+	t.Cleanup(func() {
+		client.Close()
+		cancel()
+		<-done
+	})
+	return client
+}
+
+// writeRandom writes n random bytes to name and returns them.
+func writeRandom(t *testing.T, name string, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	mrand.New(mrand.NewSource(int64(n))).Read(data)
+	if err := os.WriteFile(name, data, 0640); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestExecServer(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "in"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	client := NewSSHClient(execServerClient(t, &ExecServer{Root: root}))
+	client.Fallback = false
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Upload into a directory of the root
+	local := filepath.Join(t.TempDir(), "up.bin")
+	data := writeRandom(t, local, 50*1024)
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(local, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Upload(ctx, local, "in"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(root, "in", "up.bin"))
+	if err != nil {
+		t.Fatalf("uploaded file: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("uploaded %d bytes that differ from the %d sent", len(got), len(data))
+	}
+	info, err := os.Stat(filepath.Join(root, "in", "up.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(mtime) || info.Mode().Perm() != 0640 {
+		t.Errorf("uploaded file has time %v and mode %v, want %v and %v",
+			info.ModTime(), info.Mode().Perm(), mtime, os.FileMode(0640))
+	}
+
+	// Directories can't leave the root
+	if _, err := client.Upload(ctx, local, "../.."); err != nil {
+		t.Fatalf("Upload to ../..: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "up.bin")); err != nil {
+		t.Errorf("upload to ../.. didn't land in the root: %v", err)
+	}
+
+	// Download it again
+	var downloaded bytes.Buffer
+	client.opts = []Option{WithCallbacks(&Callbacks{
+		OnFileCreate: func(hdr *FileHeader) (io.Writer, error) {
+			return &downloaded, nil
+		},
+	})}
+	if _, err := client.Download(ctx, "in/up.bin"); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if !bytes.Equal(downloaded.Bytes(), data) {
+		t.Errorf("downloaded %d bytes that differ from the %d in the root", downloaded.Len(), len(data))
+	}
+}
+
+func TestExecServerRefuses(t *testing.T) {
+	root := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "secret")
+	writeRandom(t, outside, 100)
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Skipf("no symlinks: %v", err)
+	}
+	sshClient := execServerClient(t, &ExecServer{Root: root})
+	client := NewSSHClient(sshClient)
+	client.Fallback = false
+	client.opts = []Option{WithCallbacks(&Callbacks{
+		OnFileCreate: func(hdr *FileHeader) (io.Writer, error) {
+			t.Errorf("got %s from outside the root", hdr.Name)
+			return io.Discard, nil
+		},
+	})}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// A symlink out of the root isn't followed
+	var cerr *CommandError
+	if _, err := client.Download(ctx, "link"); !errors.As(err, &cerr) || cerr.ExitStatus != 1 {
+		t.Errorf("Download of a symlink out of the root returned %v, want exit status 1", err)
+	}
+
+	// Other commands aren't there
+	session, err := sshClient.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	var exitErr *ssh.ExitError
+	if err := session.Run("cat /etc/passwd"); !errors.As(err, &exitErr) || exitErr.ExitStatus() != 127 {
+		t.Errorf("cat returned %v, want exit status 127", err)
+	}
+}
+
+// TestSSHClientCancel cancels an upload to a receiver that never answers.
+func TestSSHClientCancel(t *testing.T) {
+	client := NewSSHClient(sshServerClient(t, func(ctx context.Context, channel ssh.Channel, requests <-chan *ssh.Request) error {
+		defer channel.Close()
+		go func() {
+			for req := range requests {
+				req.Reply(req.Type == "exec", nil)
+			}
+		}()
+		_, err := io.Copy(io.Discard, channel)
+		return err
+	}))
+	client.Fallback = false
+
+	local := filepath.Join(t.TempDir(), "up.bin")
+	writeRandom(t, local, 1024)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Upload(ctx, local, "")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Upload returned %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Upload didn't return after the context was done")
+	}
+}
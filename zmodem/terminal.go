@@ -5,14 +5,16 @@ import (
 	"io"
 	"os"
 	"sync"
+	"time"
 )
 
 // TerminalIO wraps an SSH reader/writer to automatically handle terminal output
 // and ZModem protocol detection. It acts as middleware - you provide the SSH
 // reader/writer and it handles everything else.
 type TerminalIO struct {
-	// Underlying I/O
-	reader io.Reader
+	// Underlying I/O. Reads go through a pump that lives as long as the
+	// TerminalIO, so transfers get deadlines without losing output
+	reader *TimeoutReader
 	writer io.Writer
 
 	// Configuration
@@ -29,6 +31,35 @@ type TerminalIO struct {
 	
 	// ZModem session (created when ZModem is detected)
 	zmodemSession *Session
+
+	// Other protocols to detect, and a transfer detected after output
+	// the application hasn't read yet
+	protocols []TerminalProtocol
+	pending   *pendingTransfer
+
+	// Transfers started with the hotkey (see hotkey.go): how to run rz
+	// and sz, the part of the hotkey held back from the last write, and
+	// files waiting for the remote rz
+	remote       *RemoteCommand
+	writeMu      sync.Mutex
+	hotkeyHeld   int
+	localPrompt  bool
+	localFiles   []string
+	localFilesAt time.Time
+
+	// Input typed during a transfer (see input.go): queued for after it,
+	// the abort keys in a row so far, and how to cancel the transfer
+	queued    []byte
+	abortKeys int
+	cancel    context.CancelFunc
+	aborted   bool
+}
+
+// pendingTransfer is a detected transfer of another protocol, with the
+// data read from the detection point on.
+type pendingTransfer struct {
+	protocol TerminalProtocol
+	data     []byte
 }
 
 // NewTerminalIO creates a new TerminalIO middleware that wraps SSH reader/writer.
@@ -49,6 +80,8 @@ func NewTerminalIO(reader io.Reader, writer io.Writer, opts ...Option) *Terminal
 	callbacks := defaultCallbacks()
 	ctx := context.Background()
 	var logger Logger = NoopLogger{}
+	var protocols []TerminalProtocol
+	remote := DefaultRemoteCommand()
 
 	// Apply options
 	for _, opt := range opts {
@@ -58,20 +91,32 @@ func NewTerminalIO(reader io.Reader, writer io.Writer, opts ...Option) *Terminal
 			config:    config,
 			callbacks: callbacks,
 			ctx:       ctx,
+			protocols: protocols,
+			remote:    remote,
 		}
 		opt(tempSession)
 		config = tempSession.config
 		callbacks = tempSession.callbacks
 		ctx = tempSession.ctx
+		protocols = tempSession.protocols
+		remote = tempSession.remote
+	}
+
+	timeoutReader, ok := reader.(*TimeoutReader)
+	if !ok {
+		timeoutReader = NewTimeoutReader(reader)
 	}
+	timeoutReader.SetContext(ctx)
 
 	termIO := &TerminalIO{
-		reader:        reader,
+		reader:        timeoutReader,
 		writer:        writer,
 		config:        config,
 		callbacks:     callbacks,
 		ctx:           ctx,
 		logger:        logger,
+		protocols:     protocols,
+		remote:        remote,
 		scanBuffer:    make([]byte, 0, 16),
 		maxScanBuffer: 16, // Keep last 16 bytes for detection
 	}
@@ -94,9 +139,10 @@ func (t *TerminalIO) TerminalReader() io.Reader {
 }
 
 // TerminalWriter returns an io.Writer that accepts terminal input.
-// Data is passed through to the underlying writer.
+// Data is passed through to the underlying writer, except for the
+// Config.Hotkey.
 func (t *TerminalIO) TerminalWriter() io.Writer {
-	return t.writer
+	return t
 }
 
 // Read implements io.Reader for TerminalIO.
@@ -126,6 +172,19 @@ func (t *TerminalIO) Read(p []byte) (int, error) {
 		}
 		// ZModem finished, continue with normal read
 	}
+
+	// Run a transfer detected in output the application has now read
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = nil
+	var ctx context.Context
+	if pending != nil {
+		ctx = t.beginTransfer()
+	}
+	t.mu.Unlock()
+	if pending != nil {
+		t.handleProtocolTransfer(ctx, pending)
+	}
 	
 	// Read directly from underlying reader (no buffering)
 	n, err := t.reader.Read(p)
@@ -141,7 +200,7 @@ func (t *TerminalIO) Read(p []byte) (int, error) {
 			if zmodemStart >= 0 {
 				// Found ZModem in current buffer!
 				t.logger.Info("ZModem sequence detected at position %d in current read: %q", zmodemStart, p[:n])
-				t.inZModem = true
+				ctx := t.beginTransfer()
 				
 				// Save the ZModem data (from detection point onwards) for the ZModem session
 				t.scanBuffer = make([]byte, n-zmodemStart)
@@ -152,7 +211,7 @@ func (t *TerminalIO) Read(p []byte) (int, error) {
 				
 				// Handle ZModem transfer synchronously
 				// This blocks the application read until transfer completes
-				t.handleZModemTransfer()
+				t.handleZModemTransfer(ctx)
 				
 				// After transfer, continue reading
 				return t.reader.Read(p)
@@ -170,7 +229,7 @@ func (t *TerminalIO) Read(p []byte) (int, error) {
 			if zmodemStart >= 0 {
 				// Found ZModem spanning boundary!
 				t.logger.Info("ZModem sequence detected at position %d spanning buffers: %q", zmodemStart, t.scanBuffer)
-				t.inZModem = true
+				ctx := t.beginTransfer()
 				
 				// Keep the ZModem data (from detection point onwards) for the ZModem session
 				t.scanBuffer = t.scanBuffer[zmodemStart:]
@@ -179,11 +238,33 @@ func (t *TerminalIO) Read(p []byte) (int, error) {
 				t.mu.Unlock()
 				
 				// Handle ZModem transfer synchronously
-				t.handleZModemTransfer()
+				t.handleZModemTransfer(ctx)
 				
 				// After transfer, continue reading
 				return t.reader.Read(p)
 			}
+
+			// Check the other protocols
+			if protocol, start := t.detectProtocol(p[:n]); protocol != nil {
+				t.logger.Info("%s transfer detected at position %d: %q", protocol.Name(), start, p[:n])
+				pending := &pendingTransfer{
+					protocol: protocol,
+					data:     append([]byte(nil), p[start:n]...),
+				}
+				if start > 0 {
+					// Pass on the output before the transfer first, it
+					// runs on the next read
+					t.pending = pending
+					t.mu.Unlock()
+					return start, nil
+				}
+				ctx := t.beginTransfer()
+				t.mu.Unlock()
+				t.handleProtocolTransfer(ctx, pending)
+
+				// After transfer, continue reading
+				return t.reader.Read(p)
+			}
 		}
 		t.mu.Unlock()
 	}
@@ -195,32 +276,33 @@ func (t *TerminalIO) Read(p []byte) (int, error) {
 	return n, err
 }
 
-// findZModemStartInBuffer looks for ZModem ZRINIT initiation sequences in a buffer.
-// Only ZRINIT (receiver init, frame type 01) should trigger automatic ZModem handling.
+// findZModemStartInBuffer looks for ZModem initiation sequences in a buffer.
+// Only ZRINIT (remote rz, frame type 01) and ZRQINIT (remote sz, frame type 00)
+// should trigger automatic ZModem handling.
 // Other frame types like ZFIN (frame type 08) should NOT trigger a new session.
 func (t *TerminalIO) findZModemStartInBuffer(buf []byte) int {
-	// Look for ZRINIT sequences only
+	// Look for ZRINIT and ZRQINIT sequences only
 	for i := 0; i < len(buf)-2; i++ {
 		if buf[i] == ZPAD {
 			// Check for ZPAD ZPAD ZDLE ZHEX (hex frame with ZDLE)
 			if i+5 < len(buf) && buf[i+1] == ZPAD && buf[i+2] == ZDLE && buf[i+3] == ZHEX {
-				// Check if frame type is ZRINIT (01)
-				if buf[i+4] == '0' && buf[i+5] == '1' {
-					t.logger.Debug("Found ZRINIT hex frame (with ZDLE) at position %d", i)
+				// Check if frame type is ZRINIT (01) or ZRQINIT (00)
+				if buf[i+4] == '0' && (buf[i+5] == '1' || buf[i+5] == '0') {
+					t.logger.Debug("Found ZRINIT/ZRQINIT hex frame (with ZDLE) at position %d", i)
 					return i
 				}
-				// Not ZRINIT - skip
+				// Not a session start - skip
 				continue
 			}
 			// Check for ZPAD ZPAD ZHEX (hex frame - ZDLE might be omitted)
 			// This handles the case where we see "**B01..." directly
 			if i+4 < len(buf) && buf[i+1] == ZPAD && buf[i+2] == ZHEX {
-				// Check if frame type is ZRINIT (01)
-				if buf[i+3] == '0' && buf[i+4] == '1' {
-					t.logger.Debug("Found ZRINIT hex frame (no ZDLE) at position %d", i)
+				// Check if frame type is ZRINIT (01) or ZRQINIT (00)
+				if buf[i+3] == '0' && (buf[i+4] == '1' || buf[i+4] == '0') {
+					t.logger.Debug("Found ZRINIT/ZRQINIT hex frame (no ZDLE) at position %d", i)
 					return i
 				}
-				// Not ZRINIT - skip
+				// Not a session start - skip
 				continue
 			}
 		}
@@ -228,6 +310,65 @@ func (t *TerminalIO) findZModemStartInBuffer(buf []byte) int {
 	return -1
 }
 
+// detectProtocol offers terminal output to the other protocols and returns
+// the first one to detect a transfer, with where it starts.
+func (t *TerminalIO) detectProtocol(buf []byte) (TerminalProtocol, int) {
+	for _, protocol := range t.protocols {
+		if start := protocol.Detect(buf); start >= 0 {
+			return protocol, start
+		}
+	}
+	return nil, -1
+}
+
+// handleProtocolTransfer runs a detected transfer of another protocol,
+// which Read began with ctx.
+func (t *TerminalIO) handleProtocolTransfer(ctx context.Context, pending *pendingTransfer) {
+	name := pending.protocol.Name()
+	t.logger.Info("Starting %s transfer handling", name)
+
+	t.sendHeld()
+	defer func() {
+		t.endTransfer()
+		t.reader.SetReadDeadline(time.Time{})
+		t.mu.Lock()
+		t.scanBuffer = t.scanBuffer[:0]
+		t.mu.Unlock()
+		t.logger.Info("%s transfer completed", name)
+	}()
+
+	var reader io.Reader = &bufferedReader{buffer: pending.data, reader: &contextReader{reader: t.reader, ctx: ctx}}
+	var writer io.Writer = t.writer
+	if _, ok := t.logger.(NoopLogger); !ok {
+		reader = NewLoggingReader(reader, t.logger, name+"-Reader")
+		writer = NewLoggingWriter(writer, t.logger, name+"-Writer")
+	}
+
+	err := pending.protocol.Transfer(&TerminalTransfer{
+		Context:   ctx,
+		Reader:    &transferReader{Reader: reader, timeout: t.reader},
+		Writer:    writer,
+		Config:    t.config,
+		Callbacks: t.callbacks,
+		Logger:    t.logger,
+	})
+	if err != nil {
+		t.logger.Error("%s transfer error: %v", name, err)
+	}
+}
+
+// transferReader is what transfers read from: the output from the
+// detection point on, then the terminal, with deadlines on the terminal's
+// pump.
+type transferReader struct {
+	io.Reader
+	timeout *TimeoutReader
+}
+
+func (r *transferReader) SetReadDeadline(t time.Time) error {
+	return r.timeout.SetReadDeadline(t)
+}
+
 // bufferedReader wraps a reader with a prepended buffer
 type bufferedReader struct {
 	buffer []byte
@@ -246,24 +387,32 @@ func (br *bufferedReader) Read(p []byte) (int, error) {
 	return br.reader.Read(p)
 }
 
-// handleZModemTransfer handles a detected ZModem transfer.
-func (t *TerminalIO) handleZModemTransfer() {
+// handleZModemTransfer handles a detected ZModem transfer, which Read
+// began with ctx.
+func (t *TerminalIO) handleZModemTransfer(ctx context.Context) {
 	t.logger.Info("Starting ZModem transfer handling")
 	
+	t.sendHeld()
 	defer func() {
+		t.endTransfer()
+		// Terminal output has no deadline
+		t.reader.SetReadDeadline(time.Time{})
 		t.mu.Lock()
-		t.inZModem = false
 		t.scanBuffer = t.scanBuffer[:0] // Clear scan buffer after transfer
 		t.mu.Unlock()
 		t.logger.Info("ZModem transfer completed")
 	}()
 
-	// Create a buffered reader that starts with the already-read ZModem data
+	// Create a buffered reader that starts with the already-read ZModem data.
+	// A remote sz's ZRQINIT is answered by the receiver's first ZRINIT;
+	// passed on, it would get a second one, which leaves the sender one
+	// answer behind for the rest of the session
 	t.mu.Lock()
+	t.scanBuffer = skipZRQINIT(t.scanBuffer)
 	buffered := &bufferedReader{
 		buffer: t.scanBuffer,
 		offset: 0,
-		reader: t.reader,
+		reader: &contextReader{reader: t.reader, ctx: ctx},
 	}
 	t.mu.Unlock()
 
@@ -276,18 +425,12 @@ func (t *TerminalIO) handleZModemTransfer() {
 		writer = NewLoggingWriter(t.writer, t.logger, "ZModem-Writer")
 	}
 
-	// Create reader with timeout wrapper
-	sshReader := &sshReader{
-		reader:  reader,
-		timeout: t.config.Timeout,
-	}
-
 	// Create ZModem session
 	t.logger.Info("Creating ZModem session")
-	t.zmodemSession = NewSession(sshReader, writer,
+	t.zmodemSession = NewSession(&transferReader{Reader: reader, timeout: t.reader}, writer,
 		WithConfig(t.config),
 		WithCallbacks(t.callbacks),
-		WithContext(t.ctx),
+		WithContext(ctx),
 		WithSessionLogger(t.logger),
 	)
 
@@ -300,7 +443,7 @@ func (t *TerminalIO) handleZModemTransfer() {
 		t.logger.Info("Remote is receiver (detected ZRINIT) - we will send files")
 		// Remote ran 'rz' - they want us to send files
 		// For now, we don't have files to send automatically, so just send ZFIN
-		// Applications should use SendFileWhenRemoteReceives for explicit sends
+		// Applications should use SSHSession.SendFiles for explicit sends
 		
 		// Remote ran 'rz' - they want files from us
 		// We need to initialize as sender and send session end (no files)
@@ -337,9 +480,10 @@ func (t *TerminalIO) handleZModemTransfer() {
 		}
 		t.logger.Info("SendZSINIT completed")
 
-		// Check if we have files to send
-		var filesToSend []string
-		if t.callbacks.OnFileList != nil {
+		// Check if we have files to send: those picked with the hotkey,
+		// or ask
+		filesToSend := t.takeLocalFiles()
+		if filesToSend == nil && t.callbacks.OnFileList != nil {
 			t.logger.Info("Asking application for files to send")
 			files, err := t.callbacks.OnFileList()
 			if err != nil {
@@ -384,7 +528,7 @@ func (t *TerminalIO) handleZModemTransfer() {
 				
 				// Send file
 				t.logger.Info("Sending file: %s (%d bytes)", filename, info.Size())
-				if err := t.zmodemSession.SendFile(t.ctx, filename, file, info); err != nil {
+				if err := t.zmodemSession.SendFile(ctx, filename, file, info); err != nil {
 					// Check if file was skipped
 					if zmErr, ok := err.(*Error); ok && zmErr.Type == ErrFileSkipped {
 						t.logger.Info("File skipped by receiver: %s", filename)
@@ -403,54 +547,25 @@ func (t *TerminalIO) handleZModemTransfer() {
 				t.logger.Info("File sent successfully: %s", filename)
 			}
 			
-			// Only send ZFIN if transfer didn't fail fatally
-			if !transferFailed {
-				t.logger.Info("All files sent, sending ZFIN")
-				hdr = stohdr(0)
-				if err := zshhdr(writer, ZFIN, hdr); err != nil {
-					t.logger.Error("Failed to send ZFIN: %v", err)
-					return
-				}
-			} else {
+			if transferFailed {
 				t.logger.Info("Transfer failed fatally, skipping ZFIN (receiver likely crashed)")
 				return
 			}
-		} else {
-			// No files to send - send ZFIN to end the session
-			t.logger.Info("No files to send, sending ZFIN")
-			hdr = stohdr(0)
-			if err := zshhdr(writer, ZFIN, hdr); err != nil {
-				t.logger.Error("Failed to send ZFIN: %v", err)
-				return
-			}
 		}
-		
-		// Wait for response from receiver and handle accordingly (match saybibi() in lsz.c)
-		t.logger.Debug("Waiting for acknowledgment from receiver")
-		frameType, _, err = sender.GetHeaderDirect()
-		if err != nil {
-			t.logger.Error("Error getting ack: %v", err)
-		} else {
-			switch frameType {
-			case ZFIN:
-				t.logger.Debug("Received ZFIN, sending 'OO'")
-				// Send "OO" to confirm end of session
-				writer.Write([]byte("OO"))
-				if flusher, ok := writer.(interface{ Flush() error }); ok {
-					flusher.Flush()
-				}
-			case ZCAN:
-				t.logger.Info("Received ZCAN from receiver (transfer cancelled)")
-				// Just return, no "OO" needed
-			default:
-				t.logger.Debug("Received frame type %d, ending session", frameType)
-			}
+
+		// End the session; the receiver may still send ZRINIT first
+		// (match saybibi() in lsz.c)
+		t.logger.Info("Sending ZFIN")
+		if err := sender.SayBibi(); err != nil {
+			t.logger.Error("Ending session: %v", err)
 		}
 		t.logger.Info("Session cleanup complete")
 	} else {
 		t.logger.Info("We are receiver - waiting for files")
+		// Files picked for an upload aren't for a later rz
+		t.takeLocalFiles()
 		// We're the receiver - receive files
-		if err := t.zmodemSession.ReceiveFiles(t.ctx, 0); err != nil {
+		if err := t.zmodemSession.ReceiveFiles(ctx, 0); err != nil {
 			t.logger.Error("ReceiveFiles error: %v", err)
 			return
 		}
@@ -458,6 +573,18 @@ func (t *TerminalIO) handleZModemTransfer() {
 	}
 }
 
+// skipZRQINIT returns buf without the ZRQINIT hex header it starts with,
+// if it does.
+func skipZRQINIT(buf []byte) []byte {
+	for _, prefix := range []string{"**\x18B00", "**B00"} {
+		if len(buf) >= len(prefix)+12 && string(buf[:len(prefix)]) == prefix {
+			// Type, then 4 header bytes and the CRC as hex
+			return buf[len(prefix)+12:]
+		}
+	}
+	return buf
+}
+
 // detectZRINIT checks if the buffer contains a ZRINIT frame
 // ZRINIT means the remote is a receiver (running 'rz') and we should be the sender
 func (t *TerminalIO) detectZRINIT(buf []byte) bool {
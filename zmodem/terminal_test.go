@@ -0,0 +1,237 @@
+package zmodem
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeProtocol is a TerminalProtocol whose transfers start at "\x01start"
+// and run until release is closed or they are aborted.
+type fakeProtocol struct {
+	started chan struct{}
+	release chan struct{}
+	result  chan error // What Transfer returned
+}
+
+func newFakeProtocol() *fakeProtocol {
+	return &fakeProtocol{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+		result:  make(chan error, 1),
+	}
+}
+
+func (p *fakeProtocol) Name() string { return "fake" }
+
+func (p *fakeProtocol) Detect(buf []byte) int {
+	return bytes.Index(buf, []byte("\x01start"))
+}
+
+func (p *fakeProtocol) Transfer(t *TerminalTransfer) error {
+	close(p.started)
+	var err error
+	select {
+	case <-p.release:
+	case <-t.Context.Done():
+		err = t.Context.Err()
+	}
+	p.result <- err
+	return err
+}
+
+// startLogger calls onStart when a transfer is being started.
+type startLogger struct {
+	NoopLogger
+	onStart func()
+}
+
+func (l startLogger) Info(format string, args ...interface{}) {
+	if strings.HasPrefix(format, "Starting ") {
+		l.onStart()
+	}
+}
+
+// testTerminal is a TerminalIO and the remote side of its terminal.
+type testTerminal struct {
+	*TerminalIO
+	out    *os.File       // Remote output, read by TerminalIO
+	in     *TimeoutReader // What TerminalIO sends to the remote side
+	output chan string    // What the application read, chunk by chunk
+}
+
+// newTestTerminal returns a TerminalIO on pipes with the application
+// reading its output. logger may be nil.
+func newTestTerminal(t *testing.T, logger Logger, opts ...Option) *testTerminal {
+	t.Helper()
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for _, f := range []*os.File{outR, outW, inR, inW} {
+			f.Close()
+		}
+	})
+
+	term := &testTerminal{
+		TerminalIO: NewTerminalIO(outR, inW, opts...),
+		out:        outW,
+		in:         NewTimeoutReader(inR),
+		output:     make(chan string, 100),
+	}
+	if logger != nil {
+		term.logger = logger
+	}
+	go func() {
+		defer close(term.output)
+		buf := make([]byte, 4096)
+		for {
+			n, err := term.Read(buf)
+			if n > 0 {
+				term.output <- string(buf[:n])
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return term
+}
+
+// remoteReads reads what was sent to the remote side until it contains s.
+func (term *testTerminal) remoteReads(t *testing.T, s string) string {
+	t.Helper()
+	var got []byte
+	buf := make([]byte, 256)
+	term.in.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for !bytes.Contains(got, []byte(s)) {
+		n, err := term.in.Read(buf)
+		if err != nil {
+			t.Fatalf("remote side didn't get %q: %v (got %q)", s, err, got)
+		}
+		got = append(got, buf[:n]...)
+	}
+	return string(got)
+}
+
+// appReads waits for the application to read output containing s.
+func (term *testTerminal) appReads(t *testing.T, s string) string {
+	t.Helper()
+	var got string
+	timeout := time.After(5 * time.Second)
+	for !strings.Contains(got, s) {
+		select {
+		case chunk, ok := <-term.output:
+			if !ok {
+				t.Fatalf("output ended before %q (got %q)", s, got)
+			}
+			got += chunk
+		case <-timeout:
+			t.Fatalf("output %q didn't arrive (got %q)", s, got)
+		}
+	}
+	return got
+}
+
+func TestTerminalInputDuringTransfer(t *testing.T) {
+	for _, queue := range []bool{false, true} {
+		config := DefaultConfig()
+		config.QueueInput = queue
+		protocol := newFakeProtocol()
+		term := newTestTerminal(t, nil, WithConfig(config), WithTerminalProtocol(protocol))
+
+		term.Write([]byte("before\r"))
+		term.remoteReads(t, "before\r")
+
+		io.WriteString(term.out, "$ \x01start")
+		if got := term.appReads(t, "$ "); got != "$ " {
+			t.Errorf("queue=%v: output before the transfer read as %q", queue, got)
+		}
+		<-protocol.started
+
+		// Abort keys that aren't in a row don't abort
+		term.Write([]byte("typed\x18\x18\x18\x18"))
+		term.Write([]byte("\r"))
+		close(protocol.release)
+		if err := <-protocol.result; err != nil {
+			t.Errorf("queue=%v: transfer ended with %v", queue, err)
+		}
+
+		// Once the transfer is over, input goes through again, after
+		// what was queued
+		io.WriteString(term.out, "prompt")
+		term.appReads(t, "prompt")
+		term.Write([]byte("after\r"))
+		got := term.remoteReads(t, "after\r")
+		want := "after\r"
+		if queue {
+			want = "typed\x18\x18\x18\x18\rafter\r"
+		}
+		if got != want {
+			t.Errorf("queue=%v: remote side got %q, want %q", queue, got, want)
+		}
+	}
+}
+
+func TestTerminalAbortKey(t *testing.T) {
+	var aborted error
+	term := newTestTerminal(t, nil, WithCallbacks(&Callbacks{
+		OnError: func(err error, context string) bool {
+			aborted = err
+			return false
+		},
+	}))
+
+	// A remote sz, which the receiver answers with ZRINIT
+	io.WriteString(term.out, "$ sz file\r\n")
+	term.appReads(t, "$ sz file\r\n")
+	io.WriteString(term.out, "**\x18B00000000000000\r\x8a\x11")
+	term.remoteReads(t, "**\x18B01")
+
+	term.Write(bytes.Repeat([]byte{CAN}, 5))
+	got := term.remoteReads(t, "\x18\x18\x18\x18\x18\x18\x18\x18\x18\x18\b\b\b\b\b\b\b\b\b\b")
+	if strings.Contains(got, "\x18\x18\x18\x18\x18\x18\x18\x18\x18\x18\x18\x18\x18\x18\x18") {
+		t.Errorf("the abort keys were sent along with the CANs: %q", got)
+	}
+
+	// Output resumes once the remote side is quiet
+	time.Sleep(2 * drainQuiet)
+	io.WriteString(term.out, "prompt")
+	term.appReads(t, "prompt")
+	if !IsCancelled(aborted) {
+		t.Errorf("OnError got %v, want a cancellation", aborted)
+	}
+}
+
+func TestTerminalAbortAtStart(t *testing.T) {
+	// Abort keys typed as the transfer starts, before it runs, still
+	// abort it
+	protocol := newFakeProtocol()
+	var term *testTerminal
+	logger := startLogger{onStart: func() {
+		term.Write(bytes.Repeat([]byte{CAN}, 5))
+	}}
+	term = newTestTerminal(t, logger, WithTerminalProtocol(protocol))
+
+	io.WriteString(term.out, "\x01start")
+	select {
+	case err := <-protocol.result:
+		if err != context.Canceled {
+			t.Errorf("transfer ended with %v, want it aborted", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the abort keys didn't abort the transfer")
+	}
+	if got := term.remoteReads(t, "\b"); !strings.HasPrefix(got, "\x18\x18\x18\x18\x18\x18\x18\x18\x18\x18\b") {
+		t.Errorf("remote side got %q, want only the CANs", got)
+	}
+}
@@ -0,0 +1,152 @@
+package zmodem
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// ContextReader is a reader whose reads can be cancelled. The protocol code
+// uses ReadContext when a reader has it, so cancelling a transfer doesn't
+// wait for data that may never come.
+type ContextReader interface {
+	ReadContext(ctx context.Context, p []byte) (int, error)
+}
+
+// TimeoutReader gives any io.Reader, like os.Stdin or an SSH channel, real
+// read deadlines and cancellation. A pump goroutine reads from the
+// underlying reader and hands the data over, so a Read can give up on a
+// deadline or a cancelled context while the pump keeps waiting. Nothing is
+// lost: what the pump reads goes to the next Read.
+//
+// The pump reads ahead by at most one buffer and stops at the first error,
+// which every later Read returns. It can't interrupt the underlying Read,
+// so it lives until that returns. Reads must not run concurrently.
+type TimeoutReader struct {
+	reader io.Reader
+	chunks chan chunk
+	start  sync.Once
+
+	mu       sync.Mutex
+	deadline time.Time
+	ctx      context.Context
+
+	pending []byte // Rest of the last chunk
+	err     error  // Error that stopped the pump
+}
+
+// chunk is one read of the pump.
+type chunk struct {
+	data []byte
+	err  error
+}
+
+// timeoutReaderBufSize is the size of the pump's buffers.
+const timeoutReaderBufSize = 32 * 1024
+
+// NewTimeoutReader wraps reader. The pump starts on the first read.
+func NewTimeoutReader(reader io.Reader) *TimeoutReader {
+	return &TimeoutReader{
+		reader: reader,
+		chunks: make(chan chunk),
+		ctx:    context.Background(),
+	}
+}
+
+// SetContext sets a context that cancels every Read, like the session's.
+func (r *TimeoutReader) SetContext(ctx context.Context) {
+	r.mu.Lock()
+	r.ctx = ctx
+	r.mu.Unlock()
+}
+
+// SetReadDeadline implements ReaderWithTimeout. Reads after the deadline
+// return a timeout Error; a zero time means no deadline.
+func (r *TimeoutReader) SetReadDeadline(t time.Time) error {
+	r.mu.Lock()
+	r.deadline = t
+	r.mu.Unlock()
+	return nil
+}
+
+// Read reads data, waiting until the deadline or until the context set with
+// SetContext is done.
+func (r *TimeoutReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	ctx := r.ctx
+	r.mu.Unlock()
+	return r.ReadContext(ctx, p)
+}
+
+// ReadContext reads data, waiting until the deadline or until ctx or the
+// context set with SetContext is done.
+func (r *TimeoutReader) ReadContext(ctx context.Context, p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if len(r.pending) > 0 {
+		n := copy(p, r.pending)
+		r.pending = r.pending[n:]
+		return n, nil
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	r.start.Do(func() { go r.pump() })
+
+	r.mu.Lock()
+	deadline := r.deadline
+	own := r.ctx
+	r.mu.Unlock()
+
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return 0, NewError(ErrTimeout, "timeout")
+		}
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case c := <-r.chunks:
+		if c.err != nil {
+			r.err = c.err
+		}
+		n := copy(p, c.data)
+		r.pending = c.data[n:]
+		if n == 0 {
+			return 0, r.err
+		}
+		return n, nil
+	case <-expired:
+		return 0, NewError(ErrTimeout, "timeout")
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-own.Done():
+		return 0, own.Err()
+	}
+}
+
+// pump reads from the underlying reader until it fails. It alternates
+// between two buffers: a chunk is only handed over once the reader is done
+// with the one before, so the buffer being filled is never in use.
+func (r *TimeoutReader) pump() {
+	var bufs [2][]byte
+	for i := 0; ; i ^= 1 {
+		if bufs[i] == nil {
+			bufs[i] = make([]byte, timeoutReaderBufSize)
+		}
+		n, err := r.reader.Read(bufs[i])
+		if n == 0 && err == nil {
+			continue
+		}
+		r.chunks <- chunk{data: bufs[i][:n], err: err}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package zmodem
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// readResult waits for a Read started with read, failing the test if it
+// doesn't return within a second.
+func readResult(t *testing.T, read func() (int, error)) (int, error) {
+	t.Helper()
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := read()
+		done <- result{n, err}
+	}()
+	select {
+	case res := <-done:
+		return res.n, res.err
+	case <-time.After(time.Second):
+		t.Fatalf("Read didn't return")
+		return 0, nil
+	}
+}
+
+func TestTimeoutReaderDeadline(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewTimeoutReader(pr)
+	buf := make([]byte, 16)
+
+	r.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	start := time.Now()
+	if _, err := readResult(t, func() (int, error) { return r.Read(buf) }); !IsTimeout(err) {
+		t.Errorf("Read returned %v, want a timeout", err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("Read timed out after %v, before the deadline", d)
+	}
+
+	// A deadline that has passed times out at once
+	r.SetReadDeadline(time.Now().Add(-time.Second))
+	if _, err := r.Read(buf); !IsTimeout(err) {
+		t.Errorf("Read past the deadline returned %v, want a timeout", err)
+	}
+}
+
+func TestTimeoutReaderContext(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := NewTimeoutReader(pr)
+	buf := make([]byte, 16)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.SetContext(ctx)
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := readResult(t, func() (int, error) { return r.Read(buf) }); !errors.Is(err, context.Canceled) {
+		t.Errorf("Read returned %v after SetContext's context was cancelled", err)
+	}
+	if _, err := r.ReadContext(context.Background(), buf); !errors.Is(err, context.Canceled) {
+		t.Errorf("ReadContext returned %v with SetContext's context cancelled", err)
+	}
+
+	// The context passed to ReadContext cancels too
+	r.SetContext(context.Background())
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := readResult(t, func() (int, error) { return r.ReadContext(ctx, buf) }); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ReadContext returned %v, want its context's error", err)
+	}
+}
+
+func TestTimeoutReaderKeepsData(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := NewTimeoutReader(pr)
+	buf := make([]byte, 3)
+
+	// The pump is waiting in the pipe's Read when this one gives up
+	r.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := r.Read(buf); !IsTimeout(err) {
+		t.Fatalf("Read returned %v, want a timeout", err)
+	}
+
+	// What it reads afterwards goes to the next Reads, in order
+	go func() {
+		io.WriteString(pw, "hello")
+		pw.Close()
+	}()
+	r.SetReadDeadline(time.Time{})
+	var got []byte
+	for {
+		n, err := readResult(t, func() (int, error) { return r.Read(buf) })
+		got = append(got, buf[:n]...)
+		if err != nil {
+			if err != io.EOF {
+				t.Errorf("Read returned %v, want io.EOF", err)
+			}
+			break
+		}
+	}
+	if string(got) != "hello" {
+		t.Errorf("read %q after the timeout, want %q", got, "hello")
+	}
+
+	// The error stays
+	if _, err := r.Read(buf); err != io.EOF {
+		t.Errorf("Read after io.EOF returned %v", err)
+	}
+}
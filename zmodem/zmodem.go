@@ -51,6 +51,8 @@ const (
 	ZFREECNT       // Request for free bytes on filesystem
 	ZCOMMAND       // Command from sending program
 	ZSTDERR        // Output to standard error, data follows
+	ZSIGS          // nonstandard, Receiver's block signatures follow (delta.go)
+	ZDELTA         // nonstandard, Delta instructions follow (delta.go)
 )
 
 // ZDLE sequences
@@ -116,6 +118,7 @@ const (
 const (
 	ZF1_CANVHDR  = 0x01 // Variable headers OK, unused in lrzsz
 	ZF1_TIMESYNC = 0x02 // nonstandard, Receiver request timesync
+	ZF1_CANDELTA = 0x04 // nonstandard, Receiver can rebuild files from deltas
 )
 
 // Parameters for ZSINIT frame
@@ -130,6 +133,11 @@ const (
 	TESC8   = 0x80 // Transmitter expects 8th bit to be escaped
 )
 
+// Bit Masks for ZSINIT flags byte ZF1
+const (
+	TDELTA = 0x04 // nonstandard, Transmitter wants delta transfers
+)
+
 // Parameters for ZFILE frame
 // Conversion options one of these in ZF0
 const (
@@ -217,6 +225,8 @@ var frametypes = []string{
 	"ZFREECNT",
 	"ZCOMMAND",
 	"ZSTDERR",
+	"ZSIGS",
+	"ZDELTA",
 }
 
 // FrameTypeName returns the human-readable name for a frame type.